@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sveatlo/night_snack/internal/events"
+	// the packages register their event types
+	_ "github.com/sveatlo/night_snack/internal/orders"
+	_ "github.com/sveatlo/night_snack/internal/restaurant"
+	_ "github.com/sveatlo/night_snack/internal/stock"
+)
+
+var (
+	Version string
+)
+
+// eventfixtures replays fixtures of every historical schema version of every registered event type
+// through the upcasters and decoders, the same way the events are loaded from the event store.
+func main() {
+	var err error
+
+	defer func() {
+		if err != nil {
+			os.Exit(1)
+		}
+	}()
+
+	// flags
+	var (
+		fixturesDir  string
+		printVersion bool
+	)
+
+	// flags parsing
+	flag.StringVar(&fixturesDir, "fixtures", "testdata/events", "path to the fixtures directory")
+	flag.BoolVar(&printVersion, "version", false, "print version and exit")
+	flag.Parse()
+	if printVersion {
+		fmt.Println("Version: ", Version)
+		return
+	}
+
+	fixtures, err := events.LoadFixtures(fixturesDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	failures := events.DefaultRegistry.ReplayFixtures(fixtures)
+	for _, failure := range failures {
+		fmt.Fprintf(os.Stderr, "FAIL %v\n", failure)
+	}
+	if len(failures) > 0 {
+		err = fmt.Errorf("%d of the fixture checks failed", len(failures))
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	fmt.Printf("ok, %d fixtures replayed\n", len(fixtures))
+}
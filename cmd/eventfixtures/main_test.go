@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sveatlo/night_snack/internal/events"
+)
+
+func TestFixtures(t *testing.T) {
+	fixtures, err := events.LoadFixtures("../../testdata/events")
+	if err != nil {
+		t.Fatalf("cannot load fixtures: %v", err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures found")
+	}
+
+	for _, failure := range events.DefaultRegistry.ReplayFixtures(fixtures) {
+		t.Error(failure)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	stdlog "log"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongo_options "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/sveatlo/night_snack/internal/database"
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/snacker/config"
+)
+
+var (
+	Version string
+)
+
+// eventmigrate converts aggregates stored as single documents with embedded events
+// to individually stored events in the configured event store.
+func main() {
+	var err error
+
+	appCtx, appCtxCancel := context.WithCancel(context.Background())
+	defer appCtxCancel()
+
+	defer func() {
+		if err != nil {
+			os.Exit(1)
+		}
+	}()
+
+	// flags
+	var (
+		configFilePath string
+		printVersion   bool
+	)
+
+	// flags parsing
+	flag.StringVar(&configFilePath, "config", "snacker.yaml", "path to config file")
+	flag.BoolVar(&printVersion, "version", false, "print version and exit")
+	flag.Parse()
+	if printVersion {
+		fmt.Println("Version: ", Version)
+		return
+	}
+
+	// configuration
+	var appConfig config.Config
+	appConfig, err = config.NewConfig(configFilePath)
+	if err != nil {
+		stdlog.Printf("app configuration failed: %s", err)
+		return
+	}
+
+	// logging
+	var log zerolog.Logger
+	{
+		var level zerolog.Level
+		level, err = zerolog.ParseLevel(appConfig.Loglevel)
+		if err != nil {
+			stdlog.Printf("parsing loglevel failed: %s", err)
+			return
+		}
+
+		zerolog.SetGlobalLevel(level)
+		log = zerolog.New(zerolog.ConsoleWriter{
+			Out:        os.Stderr,
+			TimeFormat: time.RFC3339,
+		}).With().Timestamp().Logger()
+	}
+
+	// mongo
+	mongoClient, err := mongo.NewClient(
+		mongo_options.Client().ApplyURI(appConfig.Mongo.URI),
+		mongo_options.Client().SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot create mongo client")
+		return
+	}
+	mongoConnectCtx, mongoConnectCtxCancel := context.WithTimeout(appCtx, 10*time.Second)
+	defer mongoConnectCtxCancel()
+	err = mongoClient.Connect(mongoConnectCtx)
+	if err != nil {
+		log.Error().Err(err).Msg("mongo client cannot connect")
+		return
+	}
+	defer mongoClient.Disconnect(context.Background())
+	mongo := mongoClient.Database("night_snack")
+
+	// database is needed only by the postgres store
+	var db *gorm.DB
+	if appConfig.EventStore.Type == "postgres" {
+		db, err = database.NewConnection(appConfig.Database.Host, appConfig.Database.Port, appConfig.Database.Username, nil, nil, logger.Warn)
+		if err != nil {
+			log.Error().Err(err).Msg("cannot create database connection")
+			return
+		}
+	}
+
+	eventStore, err := events.NewStore(appConfig.EventStore.Type, mongo, db)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("type", appConfig.EventStore.Type).
+			Msg("failed to create event store")
+		return
+	}
+
+	migrated, err := events.MigrateLegacyAggregates(appCtx, mongo, eventStore, log)
+	if err != nil {
+		log.Error().Err(err).Msg("migration failed")
+		return
+	}
+
+	log.Info().Int("aggregates", migrated).Str("store", appConfig.EventStore.Type).Msg("migration finished")
+}
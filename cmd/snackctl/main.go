@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongo_options "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/sveatlo/night_snack/internal/database"
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/restaurant"
+	"github.com/sveatlo/night_snack/internal/snacker"
+	"github.com/sveatlo/night_snack/internal/snacker/config"
+
+	// the domain packages register their event types
+	_ "github.com/sveatlo/night_snack/internal/orders"
+	_ "github.com/sveatlo/night_snack/internal/stock"
+)
+
+var (
+	Version string
+)
+
+const usage = `usage: snackctl [flags] <command> [arguments]
+
+commands:
+  aggregates <category>             list the aggregates of the category
+  history <category> <aggregate_id> dump the event history of the aggregate as JSON
+  replay <category> <projection>    apply the missing events of the category to the projection
+  rebuild <projection>              rebuild the read model of the projection
+  verify [restaurant_id...]         verify the restaurant write model against the event streams
+
+The replay and rebuild commands authenticate to the API by the -token or -api-key flag,
+or by the SNACKCTL_TOKEN or SNACKCTL_API_KEY environment variable.
+
+flags:
+`
+
+var errMismatch = errors.New("write model doesn't match the events")
+
+// app holds the connections shared by the commands
+type app struct {
+	ctx    context.Context
+	config config.Config
+	apiURL string
+	// token and apiKey authenticate the calls of the HTTP API, either of them can be set
+	token     string
+	apiKey    string
+	log       zerolog.Logger
+	mongo     *mongo.Database
+	db        *gorm.DB
+	store     events.Store
+	closeFunc []func()
+}
+
+// snackctl is an administration tool for the event store and the read-model projections.
+// The projections are replayed and rebuilt by the running snacker through its HTTP API,
+// so that they are not applied concurrently by two processes.
+func main() {
+	var err error
+
+	appCtx, appCtxCancel := context.WithCancel(context.Background())
+	defer appCtxCancel()
+
+	defer func() {
+		if err != nil {
+			os.Exit(1)
+		}
+	}()
+
+	// flags
+	var (
+		configFilePath string
+		apiURL         string
+		token          string
+		apiKey         string
+		printVersion   bool
+	)
+
+	// flags parsing
+	flag.StringVar(&configFilePath, "config", "snacker.yaml", "path to config file")
+	flag.StringVar(&apiURL, "api", "", "URL of the snacker HTTP API (default: derived from the config)")
+	flag.StringVar(&token, "token", os.Getenv("SNACKCTL_TOKEN"), "JWT authenticating the calls of the HTTP API")
+	flag.StringVar(&apiKey, "api-key", os.Getenv("SNACKCTL_API_KEY"), "API key authenticating the calls of the HTTP API")
+	flag.BoolVar(&printVersion, "version", false, "print version and exit")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if printVersion {
+		fmt.Println("Version: ", Version)
+		return
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		err = errors.New("no command")
+		return
+	}
+
+	// configuration
+	var appConfig config.Config
+	appConfig, err = config.NewConfig(configFilePath)
+	if err != nil {
+		stdlog.Printf("app configuration failed: %s", err)
+		return
+	}
+	if apiURL == "" {
+		apiURL = apiURLFromListenAddress(appConfig.ListenAddressHTTP)
+	}
+
+	// logging
+	var log zerolog.Logger
+	{
+		var level zerolog.Level
+		level, err = zerolog.ParseLevel(appConfig.Loglevel)
+		if err != nil {
+			stdlog.Printf("parsing loglevel failed: %s", err)
+			return
+		}
+
+		zerolog.SetGlobalLevel(level)
+		log = zerolog.New(zerolog.ConsoleWriter{
+			Out:        os.Stderr,
+			TimeFormat: time.RFC3339,
+		}).With().Timestamp().Logger()
+	}
+
+	a := &app{
+		ctx:    appCtx,
+		config: appConfig,
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		token:  token,
+		apiKey: apiKey,
+		log:    log,
+	}
+	defer a.close()
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	switch command {
+	case "aggregates":
+		err = a.requireArgs(args, 1)
+		if err == nil {
+			err = a.aggregates(args[0])
+		}
+	case "history":
+		err = a.requireArgs(args, 2)
+		if err == nil {
+			err = a.history(args[0], args[1])
+		}
+	case "replay":
+		err = a.requireArgs(args, 2)
+		if err == nil {
+			err = a.post(fmt.Sprintf("/projections/%s/replay/%s", url.PathEscape(args[1]), url.PathEscape(args[0])))
+		}
+	case "rebuild":
+		err = a.requireArgs(args, 1)
+		if err == nil {
+			err = a.post(fmt.Sprintf("/projections/%s/rebuild", url.PathEscape(args[0])))
+		}
+	case "verify":
+		err = a.verify(args)
+	default:
+		flag.Usage()
+		err = fmt.Errorf("unknown command %q", command)
+	}
+	if errors.Is(err, errMismatch) {
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("command", command).Msg("command failed")
+		return
+	}
+}
+
+func (a *app) requireArgs(args []string, n int) (err error) {
+	if len(args) != n {
+		flag.Usage()
+		err = fmt.Errorf("expected %d arguments, got %d", n, len(args))
+	}
+
+	return
+}
+
+func (a *app) close() {
+	for i := len(a.closeFunc) - 1; i >= 0; i-- {
+		a.closeFunc[i]()
+	}
+}
+
+// connectStore connects to the configured event store and the databases it needs
+func (a *app) connectStore(withDatabase bool) (err error) {
+	mongoClient, err := mongo.NewClient(
+		mongo_options.Client().ApplyURI(a.config.Mongo.URI),
+		mongo_options.Client().SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+	)
+	if err != nil {
+		err = fmt.Errorf("cannot create mongo client: %w", err)
+		return
+	}
+	mongoConnectCtx, mongoConnectCtxCancel := context.WithTimeout(a.ctx, 10*time.Second)
+	defer mongoConnectCtxCancel()
+	err = mongoClient.Connect(mongoConnectCtx)
+	if err != nil {
+		err = fmt.Errorf("mongo client cannot connect: %w", err)
+		return
+	}
+	a.closeFunc = append(a.closeFunc, func() { mongoClient.Disconnect(context.Background()) })
+	a.mongo = mongoClient.Database("night_snack")
+
+	if withDatabase || a.config.EventStore.Type == "postgres" {
+		a.db, err = database.NewConnection(a.config.Database.Host, a.config.Database.Port, a.config.Database.Username, nil, nil, logger.Warn)
+		if err != nil {
+			err = fmt.Errorf("cannot create database connection: %w", err)
+			return
+		}
+	}
+
+	a.store, err = events.NewStore(a.config.EventStore.Type, a.mongo, a.db)
+	if err != nil {
+		err = fmt.Errorf("failed to create %s event store: %w", a.config.EventStore.Type, err)
+		return
+	}
+
+	return
+}
+
+// aggregates prints the ID, version and the number of stored events of every aggregate of the category
+func (a *app) aggregates(category string) (err error) {
+	err = a.connectStore(false)
+	if err != nil {
+		return
+	}
+
+	w := os.Stdout
+	fmt.Fprintf(w, "%-36s  %7s  %6s\n", "ID", "VERSION", "EVENTS")
+	count := 0
+	err = a.store.Stream(a.ctx, category, func(aggregate events.AggregateDB) error {
+		count++
+		_, err := fmt.Fprintf(w, "%-36s  %7d  %6d\n", aggregate.ID, aggregate.Version, len(aggregate.Events))
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	a.log.Info().Str("category", category).Int("aggregates", count).Msg("aggregates listed")
+
+	return
+}
+
+// historyEvent is the JSON representation of a stored event.
+// The metadata and data are kept as relaxed extended JSON, like the event fixtures.
+type historyEvent struct {
+	Position  int64           `json:"position"`
+	Version   int             `json:"version"`
+	Type      string          `json:"type"`
+	Timestamp time.Time       `json:"timestamp"`
+	Metadata  json.RawMessage `json:"metadata"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// history prints all the stored events of the aggregate as JSON
+func (a *app) history(category, id string) (err error) {
+	err = a.connectStore(false)
+	if err != nil {
+		return
+	}
+
+	aggregate, err := a.store.Load(a.ctx, category, id)
+	if err != nil {
+		return
+	}
+	if aggregate.Version == 0 {
+		err = fmt.Errorf("no such aggregate %s/%s", category, id)
+		return
+	}
+
+	history := make([]historyEvent, len(aggregate.Events))
+	for i, eventDB := range aggregate.Events {
+		var metadata, data []byte
+		metadata, err = bson.MarshalExtJSON(eventDB.Metadata, false, false)
+		if err != nil {
+			err = fmt.Errorf("cannot encode event metadata: %w", err)
+			return
+		}
+		data, err = bson.MarshalExtJSON(eventDB.Data, false, false)
+		if err != nil {
+			err = fmt.Errorf("cannot encode event data: %w", err)
+			return
+		}
+
+		history[i] = historyEvent{
+			Position:  eventDB.Position,
+			Version:   eventDB.Version,
+			Type:      eventDB.Type,
+			Timestamp: eventDB.Timestamp,
+			Metadata:  metadata,
+			Data:      data,
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(history)
+	if err != nil {
+		err = fmt.Errorf("cannot encode history: %w", err)
+		return
+	}
+
+	return
+}
+
+// verify compares the restaurant write model with the folded event streams
+func (a *app) verify(ids []string) (err error) {
+	err = a.connectStore(true)
+	if err != nil {
+		return
+	}
+
+	// the write model is only read, so that verifying it never changes its schema
+	repo, err := restaurant.OpenWriteRepository(a.db, a.store, a.log)
+	if err != nil {
+		err = fmt.Errorf("cannot open restaurant write repository: %w", err)
+		return
+	}
+
+	var mismatches []restaurant.Mismatch
+	if len(ids) == 0 {
+		mismatches, err = repo.Verify()
+		if err != nil {
+			return
+		}
+	}
+	for _, id := range ids {
+		var restaurantMismatches []restaurant.Mismatch
+		restaurantMismatches, err = repo.VerifyRestaurant(id)
+		if err != nil {
+			err = fmt.Errorf("cannot verify restaurant %s: %w", id, err)
+			return
+		}
+		mismatches = append(mismatches, restaurantMismatches...)
+	}
+
+	for _, mismatch := range mismatches {
+		fmt.Println(mismatch)
+	}
+	if len(mismatches) > 0 {
+		a.log.Error().Int("mismatches", len(mismatches)).Msg("write model doesn't match the events")
+		err = errMismatch
+		return
+	}
+
+	a.log.Info().Msg("write model matches the events")
+
+	return
+}
+
+// post calls the snacker HTTP API and prints the response
+func (a *app) post(path string) (err error) {
+	client := &http.Client{Timeout: 10 * time.Minute}
+	req, err := http.NewRequestWithContext(a.ctx, http.MethodPost, a.apiURL+path, nil)
+	if err != nil {
+		err = fmt.Errorf("cannot create request: %w", err)
+		return
+	}
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	if a.apiKey != "" {
+		req.Header.Set(snacker.APIKeyHeader, a.apiKey)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		err = fmt.Errorf("request failed: %w", err)
+		return
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		err = fmt.Errorf("cannot read response: %w", err)
+		return
+	}
+
+	var out bytes.Buffer
+	if json.Indent(&out, body, "", "  ") != nil {
+		out.Reset()
+		out.Write(body)
+	}
+	fmt.Println(out.String())
+
+	if res.StatusCode >= 300 {
+		err = fmt.Errorf("request failed with status %s", res.Status)
+		return
+	}
+
+	return
+}
+
+// apiURLFromListenAddress returns the local URL of the HTTP API listening on addr
+func apiURLFromListenAddress(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+
+	return "http://" + addr
+}
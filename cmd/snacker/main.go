@@ -15,7 +15,6 @@ import (
 	"github.com/moderntv/cadre/registry/file"
 	"github.com/moderntv/cadre/status"
 	"github.com/nats-io/nats.go"
-	nats_protobuf "github.com/nats-io/nats.go/encoders/protobuf"
 	grpc_zerolog "github.com/rkollar/go-grpc-middleware/logging/zerolog"
 	"github.com/rs/zerolog"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -26,16 +25,28 @@ import (
 	"google.golang.org/grpc/resolver"
 	"gorm.io/gorm/logger"
 
+	"github.com/sveatlo/night_snack/internal/auth"
+	"github.com/sveatlo/night_snack/internal/customer"
 	"github.com/sveatlo/night_snack/internal/database"
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/idempotency"
 	"github.com/sveatlo/night_snack/internal/orders"
+	"github.com/sveatlo/night_snack/internal/outbox"
+	"github.com/sveatlo/night_snack/internal/projection"
 	"github.com/sveatlo/night_snack/internal/restaurant"
+	"github.com/sveatlo/night_snack/internal/saga"
 	"github.com/sveatlo/night_snack/internal/snacker"
 	"github.com/sveatlo/night_snack/internal/snacker/config"
 	"github.com/sveatlo/night_snack/internal/stock"
+	"github.com/sveatlo/night_snack/internal/stream"
+	"github.com/sveatlo/night_snack/internal/transport"
+	customer_pb "github.com/sveatlo/night_snack/proto/customer"
 	orders_pb "github.com/sveatlo/night_snack/proto/orders"
+	projection_pb "github.com/sveatlo/night_snack/proto/projection"
 	restaurant_pb "github.com/sveatlo/night_snack/proto/restaurant"
 	snacker_pb "github.com/sveatlo/night_snack/proto/snacker"
 	stock_pb "github.com/sveatlo/night_snack/proto/stock"
+	stream_pb "github.com/sveatlo/night_snack/proto/stream"
 )
 
 var (
@@ -45,6 +56,15 @@ var (
 // @title Snacker HTTP API
 // @version 1.0
 // @description Snacker is the main API component of NightSnack
+// @description Every request has to be authenticated either by a JWT ("Bearer <token>" in the Authorization header) or by an API key.
+
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+
+// @securityDefinitions.apikey APIKeyAuth
+// @in header
+// @name X-API-Key
 
 func main() {
 	var err error
@@ -166,10 +186,25 @@ func main() {
 	defer mongoClient.Disconnect(context.Background())
 	mongo := mongoClient.Database("night_snack")
 
+	// event store; the outbox relies on the events being appended within the write model transactions,
+	// which only the postgres store does, the other stores are meant for snackctl and the tests
+	if appConfig.EventStore.Type != "postgres" {
+		log.Error().Str("type", appConfig.EventStore.Type).Msg("snacker requires the postgres event store")
+		return
+	}
+	eventStore, err := events.NewStore(appConfig.EventStore.Type, mongo, db)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("type", appConfig.EventStore.Type).
+			Msg("failed to create event store")
+		return
+	}
+
 	// nats
 	var (
 		nc                  *nats.Conn
-		nec                 *nats.EncodedConn
+		eventTransport      transport.Transport
 		natsComponentStatus *status.ComponentStatus
 	)
 	{
@@ -200,9 +235,14 @@ func main() {
 			return
 		}
 
-		nec, err = nats.NewEncodedConn(nc, nats_protobuf.PROTOBUF_ENCODER)
+		eventTransport, err = transport.New(appConfig.NATS.Transport, nc, transport.JetStreamConfig{
+			MaxDeliver: appConfig.NATS.JetStream.MaxDeliver,
+			AckWait:    appConfig.NATS.JetStream.AckWait,
+			MaxAge:     appConfig.NATS.JetStream.MaxAge,
+			Replicas:   appConfig.NATS.JetStream.Replicas,
+		}, log)
 		if err != nil {
-			log.Error().Err(err).Msg("cannot create encoded connection")
+			log.Error().Err(err).Str("transport", appConfig.NATS.Transport).Msg("cannot create event transport")
 			return
 		}
 
@@ -215,6 +255,29 @@ func main() {
 		defer nc.Close()
 	}
 
+	// outbox
+	outboxRelay, err := outbox.NewRelay(eventStore, eventTransport, mongo, appConfig.Outbox.BatchSize, appConfig.Outbox.PollInterval, log)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot create outbox relay")
+		return
+	}
+	outboxRelay.SetMaxAttempts(appConfig.Outbox.MaxAttempts)
+	outboxRelay.Start()
+	defer outboxRelay.Close()
+
+	// projections
+	projectionManager, err := projection.NewManager(eventTransport, eventStore, mongo, log)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot create projection manager")
+		return
+	}
+	defer projectionManager.Close()
+	projectionManager.SetRetryPolicy(projection.RetryPolicy{
+		Attempts:       appConfig.Projections.Retry.Attempts,
+		InitialBackoff: appConfig.Projections.Retry.InitialBackoff,
+		MaxBackoff:     appConfig.Projections.Retry.MaxBackoff,
+	})
+
 	// services
 	snackerService, err := snacker.New(db, metricsRegistry, appStatus, log)
 	if err != nil {
@@ -226,7 +289,7 @@ func main() {
 		snacker_pb.RegisterSnackerServer(s, snackerService)
 	}
 
-	restaurantCommandService, err := restaurant.NewCommandService(nec, db, mongo, metricsRegistry, appStatus, log)
+	restaurantCommandService, err := restaurant.NewCommandService(eventTransport, db, eventStore, outboxRelay, appConfig.EventStore.SnapshotEvery, metricsRegistry, appStatus, log)
 	if err != nil {
 		log.Error().Err(err).Msg("cannot create new restaurant service")
 		return
@@ -236,7 +299,7 @@ func main() {
 		restaurant_pb.RegisterCommandServiceServer(s, restaurantCommandService)
 	}
 
-	restaurantQueryService, err := restaurant.NewQueryService(nec, mongo, metricsRegistry, appStatus, log)
+	restaurantQueryService, err := restaurant.NewQueryService(eventTransport, eventStore, mongo, projectionManager, metricsRegistry, appStatus, log)
 	if err != nil {
 		log.Error().Err(err).Msg("cannot create new restaurant service")
 		return
@@ -246,7 +309,17 @@ func main() {
 		restaurant_pb.RegisterQueryServiceServer(s, restaurantQueryService)
 	}
 
-	stockService, err := stock.NewService(nec, mongo, metricsRegistry, appStatus, log)
+	customerService, err := customer.NewService(db, metricsRegistry, appStatus, log)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot create new customer service")
+		return
+	}
+	defer customerService.Close()
+	customerRegistrator := func(s *grpc.Server) {
+		customer_pb.RegisterCustomerServiceServer(s, customerService)
+	}
+
+	stockService, err := stock.NewService(eventTransport, eventStore, outboxRelay, appConfig.EventStore.SnapshotEvery, mongo, projectionManager, metricsRegistry, appStatus, log)
 	if err != nil {
 		log.Error().Err(err).Msg("cannot create new restaurant service")
 		return
@@ -256,7 +329,17 @@ func main() {
 		stock_pb.RegisterStockServiceServer(s, stockService)
 	}
 
-	ordersService, err := orders.NewService(restaurantQueryService, stockService, nec, mongo, metricsRegistry, appStatus, log)
+	sagaEngine, err := saga.NewEngine(eventTransport, eventStore, outboxRelay, saga.NewMongoIndex(mongo), appConfig.Sagas.RetryInterval, log)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot create saga engine")
+		return
+	}
+	defer sagaEngine.Close()
+
+	ordersService, err := orders.NewService(restaurantQueryService, customerService, stockService, sagaEngine, orders.Pricing{
+		TaxRate:     appConfig.Orders.TaxRate,
+		DeliveryFee: appConfig.Orders.DeliveryFee,
+	}, eventTransport, eventStore, outboxRelay, mongo, projectionManager, metricsRegistry, appStatus, log)
 	if err != nil {
 		log.Error().Err(err).Msg("cannot create new restaurant service")
 		return
@@ -266,8 +349,70 @@ func main() {
 		orders_pb.RegisterOrdersServiceServer(s, ordersService)
 	}
 
+	ordersQueryService, err := orders.NewQueryService(ordersService, metricsRegistry, appStatus, log)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot create new orders query service")
+		return
+	}
+	defer ordersQueryService.Close()
+	ordersQueryRegistrator := func(s *grpc.Server) {
+		orders_pb.RegisterQueryServiceServer(s, ordersQueryService)
+	}
+
+	// the sagas are resumed once all of them are registered
+	sagaEngine.Start()
+
+	projectionService, err := projection.NewService(projectionManager, metricsRegistry, appStatus, log)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot create new projection service")
+		return
+	}
+	defer projectionService.Close()
+	projectionRegistrator := func(s *grpc.Server) {
+		projection_pb.RegisterProjectionServiceServer(s, projectionService)
+	}
+
+	streamService, err := stream.NewService(eventTransport, eventStore, restaurantQueryService, ordersService, metricsRegistry, appStatus, log)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot create new stream service")
+		return
+	}
+	defer streamService.Close()
+	streamRegistrator := func(s *grpc.Server) {
+		stream_pb.RegisterStreamServiceServer(s, streamService)
+	}
+
+	idempotencyStore, err := idempotency.NewMongoStore(mongo)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot create idempotency keys store")
+		return
+	}
+	idempotencyKeys, err := idempotency.NewKeys(idempotencyStore, appConfig.Idempotency.TTL, appConfig.Idempotency.LockTimeout, log)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot create idempotency keys")
+		return
+	}
+
+	// authentication
+	var authenticator auth.Authenticator
+	if appConfig.Auth.Enabled {
+		authenticator, err = auth.New(auth.Config{
+			JWTSecret:   appConfig.Auth.JWT.Secret,
+			JWKSFile:    appConfig.Auth.JWT.JWKSFile,
+			JWTIssuer:   appConfig.Auth.JWT.Issuer,
+			JWTAudience: appConfig.Auth.JWT.Audience,
+			APIKeys:     appConfig.Auth.APIKeys,
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("cannot create authenticator")
+			return
+		}
+	} else {
+		log.Warn().Msg("authentication is disabled, every request is allowed")
+	}
+
 	// HTTP gateway
-	gw, err := snacker.NewHTTP(snackerService, restaurantCommandService, restaurantQueryService, stockService, ordersService, log)
+	gw, err := snacker.NewHTTP(snackerService, restaurantCommandService, restaurantQueryService, customerService, stockService, ordersService, ordersQueryService, projectionManager, projectionService, streamService, idempotencyKeys, authenticator, log)
 	if err != nil {
 		log.Error().Err(err).Msg("cannot create http gateway")
 		return
@@ -293,10 +438,26 @@ func main() {
 		cadre.WithService("snacker.snacker", snackerRegistrator),
 		cadre.WithService("snacker.restaurant.command", restaurantCommandRegistrator),
 		cadre.WithService("snacker.restaurant.query", restaurantQueryRegistrator),
+		cadre.WithService("snacker.customer", customerRegistrator),
 		cadre.WithService("snacker.stock", stockRegistrator),
 		cadre.WithService("snacker.orders", ordersRegistrator),
+		cadre.WithService("snacker.orders.query", ordersQueryRegistrator),
+		cadre.WithService("snacker.projection", projectionRegistrator),
+		cadre.WithService("snacker.stream", streamRegistrator),
 		cadre.WithLoggingOptions(logOptions),
 	}
+	unaryInterceptors := []grpc.UnaryServerInterceptor{snacker.UnaryCorrelationInterceptor}
+	if authenticator != nil {
+		unaryInterceptors = append(unaryInterceptors, snacker.UnaryAuthInterceptor(authenticator))
+		grpcOptions = append(grpcOptions, cadre.WithStreamInterceptors(snacker.StreamAuthInterceptor(authenticator)))
+	}
+	// the idempotency keys are scoped by the principal, so the calls are authenticated first
+	unaryInterceptors = append(unaryInterceptors, snacker.UnaryIdempotencyInterceptor(idempotencyKeys, log,
+		"/orders.OrdersService/Create",
+		"/stock.StockService/DecreaseStock",
+		"/stock.StockService/IncreaseStock",
+	))
+	grpcOptions = append(grpcOptions, cadre.WithUnaryInterceptors(unaryInterceptors...))
 	if appConfig.ListenAddressChannelz != "" {
 		grpcOptions = append(grpcOptions, cadre.WithChannelz(appConfig.ListenAddressChannelz))
 	}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+)
+
+// APIKeyAuthenticator authenticates the machine clients by their static API keys.
+// Only the SHA-256 hashes of the keys are configured, so the configuration doesn't reveal them.
+type APIKeyAuthenticator struct {
+	keys []apiKey
+}
+
+type apiKey struct {
+	name string
+	hash []byte
+}
+
+// NewAPIKeyAuthenticator creates the authenticator of the keys given as the hex encoded SHA-256 hashes by the names of the clients
+func NewAPIKeyAuthenticator(hashes map[string]string) (a *APIKeyAuthenticator, err error) {
+	a = &APIKeyAuthenticator{}
+	for name, hexHash := range hashes {
+		var hash []byte
+		hash, err = hex.DecodeString(hexHash)
+		if err != nil || len(hash) != sha256.Size {
+			err = fmt.Errorf("API key %s is not a hex encoded SHA-256 hash", name)
+			return
+		}
+
+		a.keys = append(a.keys, apiKey{
+			name: name,
+			hash: hash,
+		})
+	}
+
+	return
+}
+
+func (a *APIKeyAuthenticator) Authenticate(ctx context.Context, credentials Credentials) (principal *Principal, err error) {
+	if credentials.APIKey == "" {
+		err = errNotApplicable
+		return
+	}
+
+	hash := sha256.Sum256([]byte(credentials.APIKey))
+	// all the keys are compared, so the time doesn't reveal which of them matched
+	name := ""
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare(hash[:], key.hash) == 1 {
+			name = key.name
+		}
+	}
+	if name == "" {
+		err = fmt.Errorf("%w: invalid API key", ErrUnauthenticated)
+		return
+	}
+
+	principal = &Principal{
+		Kind:    KindService,
+		Subject: name,
+	}
+
+	return
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func hashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	a, err := NewAPIKeyAuthenticator(map[string]string{
+		"courier_app": hashKey("courier-key"),
+		"kitchen_app": hashKey("kitchen-key"),
+	})
+	if err != nil {
+		t.Fatalf("cannot create authenticator: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		key    string
+		client string
+		err    error
+	}{
+		{"first key", "courier-key", "courier_app", nil},
+		{"second key", "kitchen-key", "kitchen_app", nil},
+		{"unknown key", "other-key", "", ErrUnauthenticated},
+		// the configured hash is not accepted as the key
+		{"hash as key", hashKey("courier-key"), "", ErrUnauthenticated},
+		{"missing key", "", "", errNotApplicable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			principal, err := a.Authenticate(context.Background(), Credentials{APIKey: test.key})
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Errorf("got %v, %v, expected %v", principal, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("valid key rejected: %v", err)
+			}
+			if principal.Kind != KindService || principal.Subject != test.client {
+				t.Errorf("got principal %+v, expected service %s", principal, test.client)
+			}
+		})
+	}
+}
+
+func TestNewAPIKeyAuthenticatorInvalidHash(t *testing.T) {
+	for _, hash := range []string{"plain-key", "abcd", hashKey("key") + "00"} {
+		_, err := NewAPIKeyAuthenticator(map[string]string{"client": hash})
+		if err == nil {
+			t.Errorf("hash %q accepted", hash)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sveatlo/night_snack/internal/events"
+)
+
+var (
+	// ErrUnauthenticated is returned when the credentials are missing or invalid
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// errNotApplicable is returned by the authenticators which don't handle the presented credentials
+	errNotApplicable = errors.New("credentials not applicable")
+)
+
+const (
+	// KindUser is the kind of the principals authenticated by a JWT
+	KindUser = "user"
+	// KindService is the kind of the machine clients authenticated by an API key
+	KindService = "service"
+)
+
+// Principal is the authenticated client on whose behalf the request is processed
+type Principal struct {
+	// Kind is either KindUser or KindService
+	Kind string
+	// Subject is the subject of the JWT or the name of the API key
+	Subject string
+}
+
+// Actor identifies the principal in the metadata of the stored events
+func (p *Principal) Actor() string {
+	return p.Kind + ":" + p.Subject
+}
+
+// Credentials are presented by the client with the request, either of them can be empty
+type Credentials struct {
+	BearerToken string
+	APIKey      string
+}
+
+// Authenticator returns the principal identified by the credentials
+type Authenticator interface {
+	Authenticate(ctx context.Context, credentials Credentials) (principal *Principal, err error)
+}
+
+// Chain authenticates the credentials by the first of its authenticators which handles them
+type Chain []Authenticator
+
+func (chain Chain) Authenticate(ctx context.Context, credentials Credentials) (principal *Principal, err error) {
+	for _, authenticator := range chain {
+		principal, err = authenticator.Authenticate(ctx, credentials)
+		if errors.Is(err, errNotApplicable) {
+			continue
+		}
+		return
+	}
+
+	err = fmt.Errorf("%w: missing credentials", ErrUnauthenticated)
+
+	return
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal, the events are stored on its behalf
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	ctx = context.WithValue(ctx, principalKey{}, principal)
+
+	return events.WithActor(ctx, principal.Actor())
+}
+
+// PrincipalFromContext returns the principal the request is processed on behalf of, nil if it is not authenticated
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+
+	return principal
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sveatlo/night_snack/internal/events"
+)
+
+// staticAuthenticator handles the credentials with its token and authenticates them as its principal
+type staticAuthenticator struct {
+	token     string
+	principal *Principal
+	err       error
+}
+
+func (a staticAuthenticator) Authenticate(ctx context.Context, credentials Credentials) (*Principal, error) {
+	if credentials.BearerToken != a.token {
+		return nil, errNotApplicable
+	}
+
+	return a.principal, a.err
+}
+
+func TestChain(t *testing.T) {
+	alice := &Principal{Kind: KindUser, Subject: "alice"}
+	bob := &Principal{Kind: KindUser, Subject: "bob"}
+	chain := Chain{
+		staticAuthenticator{token: "a", principal: alice},
+		staticAuthenticator{token: "b", err: ErrUnauthenticated},
+		staticAuthenticator{token: "b", principal: bob},
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		principal *Principal
+		err       error
+	}{
+		{"first authenticator", "a", alice, nil},
+		// the first authenticator handling the credentials decides, the following ones are not tried
+		{"rejected", "b", nil, ErrUnauthenticated},
+		{"not handled", "c", nil, ErrUnauthenticated},
+		{"no credentials", "", nil, ErrUnauthenticated},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			principal, err := chain.Authenticate(context.Background(), Credentials{BearerToken: test.token})
+			if test.err != nil && !errors.Is(err, test.err) || test.err == nil && err != nil {
+				t.Fatalf("got %v, expected %v", err, test.err)
+			}
+			if principal != test.principal {
+				t.Errorf("got principal %+v, expected %+v", principal, test.principal)
+			}
+		})
+	}
+
+	_, err := Chain{}.Authenticate(context.Background(), Credentials{BearerToken: "a"})
+	if !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("empty chain got %v, expected %v", err, ErrUnauthenticated)
+	}
+}
+
+func TestWithPrincipal(t *testing.T) {
+	ctx := context.Background()
+	if principal := PrincipalFromContext(ctx); principal != nil {
+		t.Errorf("got principal %+v from context without one", principal)
+	}
+
+	principal := &Principal{Kind: KindService, Subject: "courier_app"}
+	ctx = WithPrincipal(ctx, principal)
+	if got := PrincipalFromContext(ctx); got != principal {
+		t.Errorf("got principal %+v, expected %+v", got, principal)
+	}
+
+	metadata, err := events.NewMetadata(ctx, events.DefaultSchemaVersion)
+	if err != nil {
+		t.Fatalf("cannot create metadata: %v", err)
+	}
+	if metadata.Actor != "service:courier_app" {
+		t.Errorf("events are stored on behalf of %q, expected service:courier_app", metadata.Actor)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         Config
+		authenticators int
+	}{
+		{"nothing", Config{}, 0},
+		{"secret", Config{JWTSecret: "secret"}, 1},
+		{"API keys", Config{APIKeys: map[string]string{"client": hashKey("key")}}, 1},
+		{"both", Config{JWTSecret: "secret", APIKeys: map[string]string{"client": hashKey("key")}}, 2},
+		{"invalid API key", Config{JWTSecret: "secret", APIKeys: map[string]string{"client": "key"}}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain, err := New(test.config)
+			if test.authenticators == 0 {
+				if err == nil {
+					t.Errorf("got chain of %d authenticators, expected an error", len(chain))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("cannot create chain: %v", err)
+			}
+			if len(chain) != test.authenticators {
+				t.Errorf("got %d authenticators, expected %d", len(chain), test.authenticators)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"errors"
+)
+
+// Config selects the authenticators, the JWTs are accepted if either the secret or the JWKS file is set
+type Config struct {
+	JWTSecret   string
+	JWKSFile    string
+	JWTIssuer   string
+	JWTAudience string
+	// APIKeys maps the names of the machine clients to the hex encoded SHA-256 hashes of their keys
+	APIKeys map[string]string
+}
+
+// New returns the chain of the configured authenticators
+func New(c Config) (chain Chain, err error) {
+	if c.JWTSecret != "" || c.JWKSFile != "" {
+		var jwtAuthenticator *JWTAuthenticator
+		jwtAuthenticator, err = NewJWTAuthenticator([]byte(c.JWTSecret), c.JWKSFile, c.JWTIssuer, c.JWTAudience)
+		if err != nil {
+			return
+		}
+		chain = append(chain, jwtAuthenticator)
+	}
+	if len(c.APIKeys) > 0 {
+		var apiKeyAuthenticator *APIKeyAuthenticator
+		apiKeyAuthenticator, err = NewAPIKeyAuthenticator(c.APIKeys)
+		if err != nil {
+			return
+		}
+		chain = append(chain, apiKeyAuthenticator)
+	}
+	if len(chain) == 0 {
+		err = errors.New("no authenticator is configured")
+		return
+	}
+
+	return
+}
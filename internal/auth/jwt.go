@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	hmacMethods       = []string{"HS256", "HS384", "HS512"}
+	asymmetricMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+)
+
+// JWTAuthenticator authenticates the users by the bearer tokens signed either by the HMAC secret or by one of the keys of the JWKS.
+// The tokens have to expire and identify their subject.
+type JWTAuthenticator struct {
+	parser *jwt.Parser
+
+	secret []byte
+	// keys are the public keys of the JWKS by their IDs
+	keys map[string]interface{}
+
+	issuer   string
+	audience string
+}
+
+// NewJWTAuthenticator creates the authenticator of the tokens signed by the secret and/or the keys of the JWKS file.
+// The issuer and audience of the tokens are checked unless they are empty.
+func NewJWTAuthenticator(secret []byte, jwksFile, issuer, audience string) (a *JWTAuthenticator, err error) {
+	a = &JWTAuthenticator{
+		secret:   secret,
+		keys:     map[string]interface{}{},
+		issuer:   issuer,
+		audience: audience,
+	}
+
+	validMethods := []string{}
+	if len(secret) > 0 {
+		validMethods = append(validMethods, hmacMethods...)
+	}
+	if jwksFile != "" {
+		a.keys, err = loadJWKS(jwksFile)
+		if err != nil {
+			err = fmt.Errorf("cannot load JWKS: %w", err)
+			return
+		}
+		validMethods = append(validMethods, asymmetricMethods...)
+	}
+	if len(validMethods) == 0 {
+		err = errors.New("neither JWT secret nor JWKS is set")
+		return
+	}
+
+	// the methods are limited to the ones of the configured keys, so that an HMAC token cannot be signed by a public key
+	a.parser = &jwt.Parser{ValidMethods: validMethods}
+
+	return
+}
+
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, credentials Credentials) (principal *Principal, err error) {
+	if credentials.BearerToken == "" {
+		err = errNotApplicable
+		return
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = a.parser.ParseWithClaims(credentials.BearerToken, claims, a.key)
+	if err != nil {
+		err = fmt.Errorf("%w: invalid token: %s", ErrUnauthenticated, err)
+		return
+	}
+
+	now := time.Now().Unix()
+	switch {
+	case !claims.VerifyExpiresAt(now, true):
+		err = fmt.Errorf("%w: token has no expiration", ErrUnauthenticated)
+		return
+	case a.issuer != "" && !claims.VerifyIssuer(a.issuer, true):
+		err = fmt.Errorf("%w: invalid token issuer", ErrUnauthenticated)
+		return
+	case a.audience != "" && !claims.VerifyAudience(a.audience, true):
+		err = fmt.Errorf("%w: invalid token audience", ErrUnauthenticated)
+		return
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		err = fmt.Errorf("%w: token has no subject", ErrUnauthenticated)
+		return
+	}
+
+	principal = &Principal{
+		Kind:    KindUser,
+		Subject: subject,
+	}
+
+	return
+}
+
+// key returns the key verifying the token based on its signing method and key ID
+func (a *JWTAuthenticator) key(token *jwt.Token) (key interface{}, err error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return a.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" && len(a.keys) == 1 {
+		// a JWKS with a single key doesn't need the tokens to identify it
+		for _, key := range a.keys {
+			return key, nil
+		}
+	}
+	key, ok := a.keys[kid]
+	if !ok {
+		err = fmt.Errorf("unknown key %q", kid)
+		return
+	}
+
+	return
+}
+
+// jwk is a public key of the JWKS (RFC 7517), only the RSA and EC keys are supported
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// loadJWKS returns the signing keys of the JWKS file by their IDs
+func loadJWKS(path string) (keys map[string]interface{}, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	jwks := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	err = json.Unmarshal(data, &jwks)
+	if err != nil {
+		return
+	}
+
+	keys = map[string]interface{}{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key interface{}
+		key, err = k.publicKey()
+		if err != nil {
+			err = fmt.Errorf("key %q: %w", k.Kid, err)
+			return
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		err = errors.New("no signing keys")
+		return
+	}
+
+	return
+}
+
+func (k jwk) publicKey() (key interface{}, err error) {
+	switch k.Kty {
+	case "RSA":
+		var n, e *big.Int
+		n, err = decodeBigInt(k.N)
+		if err != nil {
+			return
+		}
+		e, err = decodeBigInt(k.E)
+		if err != nil {
+			return
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			err = errors.New("invalid RSA exponent")
+			return
+		}
+
+		key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			err = fmt.Errorf("unsupported curve %q", k.Crv)
+			return
+		}
+
+		var x, y *big.Int
+		x, err = decodeBigInt(k.X)
+		if err != nil {
+			return
+		}
+		y, err = decodeBigInt(k.Y)
+		if err != nil {
+			return
+		}
+		if !curve.IsOnCurve(x, y) {
+			err = errors.New("point is not on the curve")
+			return
+		}
+
+		key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	default:
+		err = fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+
+	return
+}
+
+func decodeBigInt(s string) (n *big.Int, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return
+	}
+	if len(b) == 0 {
+		err = errors.New("missing key parameter")
+		return
+	}
+
+	n = new(big.Int).SetBytes(b)
+
+	return
+}
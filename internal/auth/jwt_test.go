@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var testSecret = []byte("secret")
+
+// testKeys are the private keys of the test JWKS
+type testKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate EC key: %v", err)
+	}
+
+	return testKeys{rsa: rsaKey, ec: ecKey}
+}
+
+// writeJWKS writes the public keys into a JWKS file and returns its path
+func (keys testKeys) writeJWKS(t *testing.T) string {
+	encode := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+	jwks := map[string][]jwk{"keys": {
+		{Kty: "RSA", Kid: "rsa", Use: "sig", N: encode(keys.rsa.N), E: encode(big.NewInt(int64(keys.rsa.E)))},
+		{Kty: "EC", Kid: "ec", Crv: "P-256", X: encode(keys.ec.X), Y: encode(keys.ec.Y)},
+		// the encryption keys are skipped
+		{Kty: "oct", Kid: "enc", Use: "enc"},
+	}}
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatalf("cannot encode JWKS: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	err = os.WriteFile(path, data, 0o600)
+	if err != nil {
+		t.Fatalf("cannot write JWKS: %v", err)
+	}
+
+	return path
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("cannot sign token: %v", err)
+	}
+
+	return signed
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	keys := newTestKeys(t)
+	jwksFile := keys.writeJWKS(t)
+	a, err := NewJWTAuthenticator(testSecret, jwksFile, "snack-auth", "snacker")
+	if err != nil {
+		t.Fatalf("cannot create authenticator: %v", err)
+	}
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub": "alice",
+			"iss": "snack-auth",
+			"aud": "snacker",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+	with := func(key string, value interface{}) jwt.MapClaims {
+		claims := valid()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		// valid is false when the token is expected to be rejected
+		valid bool
+	}{
+		{"HMAC", sign(t, jwt.SigningMethodHS256, "", testSecret, valid()), true},
+		{"RSA", sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, valid()), true},
+		{"ECDSA", sign(t, jwt.SigningMethodES256, "ec", keys.ec, valid()), true},
+		{"wrong secret", sign(t, jwt.SigningMethodHS256, "", []byte("other"), valid()), false},
+		{"wrong key ID", sign(t, jwt.SigningMethodRS256, "ec", keys.rsa, valid()), false},
+		{"unknown key ID", sign(t, jwt.SigningMethodRS256, "other", keys.rsa, valid()), false},
+		{"missing key ID of JWKS with more keys", sign(t, jwt.SigningMethodRS256, "", keys.rsa, valid()), false},
+		{"unsigned", sign(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, valid()), false},
+		{"expired", sign(t, jwt.SigningMethodHS256, "", testSecret, with("exp", time.Now().Add(-time.Minute).Unix())), false},
+		{"not expiring", sign(t, jwt.SigningMethodHS256, "", testSecret, with("exp", nil)), false},
+		{"not yet valid", sign(t, jwt.SigningMethodHS256, "", testSecret, with("nbf", time.Now().Add(time.Hour).Unix())), false},
+		{"wrong issuer", sign(t, jwt.SigningMethodHS256, "", testSecret, with("iss", "other")), false},
+		{"missing issuer", sign(t, jwt.SigningMethodHS256, "", testSecret, with("iss", nil)), false},
+		{"wrong audience", sign(t, jwt.SigningMethodHS256, "", testSecret, with("aud", "other")), false},
+		{"missing audience", sign(t, jwt.SigningMethodHS256, "", testSecret, with("aud", nil)), false},
+		{"missing subject", sign(t, jwt.SigningMethodHS256, "", testSecret, with("sub", nil)), false},
+		{"malformed", "not.a.token", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			principal, err := a.Authenticate(context.Background(), Credentials{BearerToken: test.token})
+			if !test.valid {
+				if !errors.Is(err, ErrUnauthenticated) {
+					t.Errorf("got %v, %v, expected %v", principal, err, ErrUnauthenticated)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("valid token rejected: %v", err)
+			}
+			if principal.Kind != KindUser || principal.Subject != "alice" {
+				t.Errorf("got principal %+v, expected user alice", principal)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticatorMethods(t *testing.T) {
+	keys := newTestKeys(t)
+	claims := jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+
+	t.Run("SecretOnly", func(t *testing.T) {
+		a, err := NewJWTAuthenticator(testSecret, "", "", "")
+		if err != nil {
+			t.Fatalf("cannot create authenticator: %v", err)
+		}
+
+		_, err = a.Authenticate(context.Background(), Credentials{BearerToken: sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims)})
+		if !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("RSA token accepted without JWKS: %v", err)
+		}
+	})
+
+	t.Run("JWKSOnly", func(t *testing.T) {
+		a, err := NewJWTAuthenticator(nil, keys.writeJWKS(t), "", "")
+		if err != nil {
+			t.Fatalf("cannot create authenticator: %v", err)
+		}
+
+		// an HMAC token signed by the empty secret must not pass
+		_, err = a.Authenticate(context.Background(), Credentials{BearerToken: sign(t, jwt.SigningMethodHS256, "", []byte{}, claims)})
+		if !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("HMAC token accepted without secret: %v", err)
+		}
+	})
+
+	t.Run("SingleKeyJWKS", func(t *testing.T) {
+		encode := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+		data, _ := json.Marshal(map[string][]jwk{"keys": {
+			{Kty: "EC", Crv: "P-256", X: encode(keys.ec.X), Y: encode(keys.ec.Y)},
+		}})
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("cannot write JWKS: %v", err)
+		}
+		a, err := NewJWTAuthenticator(nil, path, "", "")
+		if err != nil {
+			t.Fatalf("cannot create authenticator: %v", err)
+		}
+
+		// the tokens don't need to identify the only key
+		_, err = a.Authenticate(context.Background(), Credentials{BearerToken: sign(t, jwt.SigningMethodES256, "", keys.ec, claims)})
+		if err != nil {
+			t.Errorf("token without key ID rejected: %v", err)
+		}
+	})
+
+	t.Run("NotApplicable", func(t *testing.T) {
+		a, err := NewJWTAuthenticator(testSecret, "", "", "")
+		if err != nil {
+			t.Fatalf("cannot create authenticator: %v", err)
+		}
+
+		_, err = a.Authenticate(context.Background(), Credentials{APIKey: "key"})
+		if !errors.Is(err, errNotApplicable) {
+			t.Errorf("got %v for credentials without token, expected %v", err, errNotApplicable)
+		}
+	})
+}
+
+func TestLoadJWKS(t *testing.T) {
+	tests := []struct {
+		name string
+		jwks string
+	}{
+		{"malformed", `{"keys": `},
+		{"no signing keys", `{"keys": [{"kty": "RSA", "kid": "enc", "use": "enc"}]}`},
+		{"unsupported key type", `{"keys": [{"kty": "oct", "kid": "k"}]}`},
+		{"unsupported curve", `{"keys": [{"kty": "EC", "kid": "k", "crv": "P-224", "x": "AQ", "y": "AQ"}]}`},
+		{"point not on curve", `{"keys": [{"kty": "EC", "kid": "k", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`},
+		{"missing RSA modulus", `{"keys": [{"kty": "RSA", "kid": "k", "e": "AQAB"}]}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "jwks.json")
+			if err := os.WriteFile(path, []byte(test.jwks), 0o600); err != nil {
+				t.Fatalf("cannot write JWKS: %v", err)
+			}
+
+			_, err := NewJWTAuthenticator(nil, path, "", "")
+			if err == nil {
+				t.Error("invalid JWKS loaded")
+			}
+		})
+	}
+
+	_, err := NewJWTAuthenticator(nil, filepath.Join(t.TempDir(), "missing.json"), "", "")
+	if err == nil {
+		t.Error("missing JWKS file loaded")
+	}
+	_, err = NewJWTAuthenticator(nil, "", "", "")
+	if err == nil {
+		t.Error("authenticator without keys created")
+	}
+}
@@ -22,10 +22,80 @@ type Base struct {
 		URI string `mapstructure:"uri"`
 	} `mapstructure:"mongo"`
 
+	EventStore struct {
+		// Type is one of mongo, postgres or memory. It selects only where the events and snapshots are stored,
+		// Mongo is required regardless of it, for the read models, projection checkpoints, dead letters,
+		// idempotency keys and sagas. snacker requires postgres, its outbox relies on the events being appended
+		// within the write model transactions; mongo and memory serve snackctl, e.g. the migration, and the tests.
+		Type string `mapstructure:"type"`
+		// SnapshotEvery is the number of events after which an aggregate snapshot is taken; 0 disables snapshots
+		SnapshotEvery int `mapstructure:"snapshot_every"`
+	} `mapstructure:"event_store"`
+
+	Projections struct {
+		Retry struct {
+			// Attempts is the number of attempts to apply an event before it is dead-lettered
+			Attempts       int           `mapstructure:"attempts"`
+			InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+			MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+		} `mapstructure:"retry"`
+	} `mapstructure:"projections"`
+
+	Outbox struct {
+		// BatchSize is the maximum number of events read from the outbox at once
+		BatchSize int `mapstructure:"batch_size"`
+		// PollInterval is the interval of checking the outbox for events saved without notifying the relay
+		PollInterval time.Duration `mapstructure:"poll_interval"`
+		// MaxAttempts is the number of attempts to publish an event before it is dead-lettered
+		MaxAttempts int `mapstructure:"max_attempts"`
+	} `mapstructure:"outbox"`
+	Sagas struct {
+		// RetryInterval is the interval of resuming the sagas which could not be finished, e.g. because a compensation failed
+		RetryInterval time.Duration `mapstructure:"retry_interval"`
+	} `mapstructure:"sagas"`
+	Orders struct {
+		// TaxRate is the tax added to the subtotal of the orders in basis points, e.g. 2100 is 21 %
+		TaxRate int64 `mapstructure:"tax_rate"`
+		// DeliveryFee is added to every order, in minor units of the currency of the order
+		DeliveryFee int64 `mapstructure:"delivery_fee"`
+	} `mapstructure:"orders"`
+	Idempotency struct {
+		// TTL is the time the responses of the requests with an idempotency key are kept for
+		TTL time.Duration `mapstructure:"ttl"`
+		// LockTimeout is the time after which a request which has not finished releases its idempotency key
+		LockTimeout time.Duration `mapstructure:"lock_timeout"`
+	} `mapstructure:"idempotency"`
+	Auth struct {
+		// Enabled rejects the HTTP requests and gRPC calls which are not authenticated
+		Enabled bool `mapstructure:"enabled"`
+		JWT     struct {
+			// Secret verifies the HMAC signed tokens, JWKSFile the RSA and ECDSA signed ones; either or both can be set
+			Secret   string `mapstructure:"secret"`
+			JWKSFile string `mapstructure:"jwks_file"`
+			// Issuer and Audience of the tokens are checked unless they are empty
+			Issuer   string `mapstructure:"issuer"`
+			Audience string `mapstructure:"audience"`
+		} `mapstructure:"jwt"`
+		// APIKeys maps the names of the machine clients to the hex encoded SHA-256 hashes of their keys
+		APIKeys map[string]string `mapstructure:"api_keys"`
+	} `mapstructure:"auth"`
+
 	NATS struct {
 		Servers       string        `mapstructure:"servers"`
 		MaxReconnects int           `mapstructure:"max_reconnects"`
 		ReconnectWait time.Duration `mapstructure:"reconnect_wait"`
+		// Transport is jetstream or core; core NATS doesn't redeliver the events and is meant for local development
+		Transport string `mapstructure:"transport"`
+
+		JetStream struct {
+			// MaxDeliver is the maximum number of delivery attempts of a single event
+			MaxDeliver int `mapstructure:"max_deliver"`
+			// AckWait is the time after which an unacknowledged event is redelivered
+			AckWait time.Duration `mapstructure:"ack_wait"`
+			// MaxAge is the time the events are kept in the streams, 0 keeps them forever
+			MaxAge   time.Duration `mapstructure:"max_age"`
+			Replicas int           `mapstructure:"replicas"`
+		} `mapstructure:"jetstream"`
 	} `mapstructure:"nats"`
 }
 
@@ -37,7 +107,28 @@ func NewCommonConfig() (c Base) {
 
 	c.Mongo.URI = "mongodb://mongo:27017"
 
+	c.EventStore.Type = "postgres"
+	c.EventStore.SnapshotEvery = 100
+
+	c.Projections.Retry.Attempts = 5
+	c.Projections.Retry.InitialBackoff = 100 * time.Millisecond
+	c.Projections.Retry.MaxBackoff = 5 * time.Second
+
+	c.Outbox.BatchSize = 100
+	c.Outbox.PollInterval = time.Second
+	c.Outbox.MaxAttempts = 5
+	c.Sagas.RetryInterval = 30 * time.Second
+	c.Idempotency.TTL = 24 * time.Hour
+	c.Idempotency.LockTimeout = time.Minute
+
+	c.Auth.Enabled = true
+
 	c.NATS.Servers = "nats://nats:4222"
+	c.NATS.Transport = "jetstream"
+	c.NATS.JetStream.MaxDeliver = 5
+	c.NATS.JetStream.AckWait = 30 * time.Second
+	c.NATS.JetStream.MaxAge = 7 * 24 * time.Hour
+	c.NATS.JetStream.Replicas = 1
 
 	return
 }
@@ -1,11 +1,51 @@
 package customer
 
+import (
+	"github.com/sveatlo/night_snack/internal/events"
+	customer_pb "github.com/sveatlo/night_snack/proto/customer"
+)
+
+// Address is a delivery address of the customer, the orders keep a copy of it
 type Address struct {
-	ID         string `gorm:"primaryKey"`
-	CustomerID string
+	ID         string `gorm:"primaryKey" bson:"_id"`
+	CustomerID string `gorm:"not null;index" bson:"customer_id"`
+
+	Name     string `bson:"name"`
+	City     string `gorm:"not null" bson:"city"`
+	Street   string `gorm:"not null" bson:"street"`
+	StreetNo string `gorm:"not null" bson:"street_no"`
+}
+
+func NewAddressFromProto(a *customer_pb.Address) *Address {
+	return &Address{
+		ID:         a.Id,
+		CustomerID: a.CustomerId,
+		Name:       a.Name,
+		City:       a.City,
+		Street:     a.Street,
+		StreetNo:   a.StreetNo,
+	}
+}
+
+// NewAddressFromData reads the address from the data of a stored event
+func NewAddressFromData(r *events.DataReader) *Address {
+	return &Address{
+		ID:         r.String("_id"),
+		CustomerID: r.String("customer_id"),
+		Name:       r.String("name"),
+		City:       r.String("city"),
+		Street:     r.String("street"),
+		StreetNo:   r.String("street_no"),
+	}
+}
 
-	Name     string
-	City     string
-	Street   string
-	StreetNo string
+func (a *Address) ToProto() *customer_pb.Address {
+	return &customer_pb.Address{
+		Id:         a.ID,
+		CustomerId: a.CustomerID,
+		Name:       a.Name,
+		City:       a.City,
+		Street:     a.Street,
+		StreetNo:   a.StreetNo,
+	}
 }
@@ -1,7 +1,27 @@
 package customer
 
+import (
+	customer_pb "github.com/sveatlo/night_snack/proto/customer"
+)
+
 type Customer struct {
 	ID        string `gorm:"primaryKey"`
-	FirstName string
-	LastName  string
+	FirstName string `gorm:"not null"`
+	LastName  string `gorm:"not null"`
+
+	Addresses []Address `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+func (c *Customer) ToProto() *customer_pb.Customer {
+	addresses := make([]*customer_pb.Address, len(c.Addresses))
+	for i := range c.Addresses {
+		addresses[i] = c.Addresses[i].ToProto()
+	}
+
+	return &customer_pb.Customer{
+		Id:        c.ID,
+		FirstName: c.FirstName,
+		LastName:  c.LastName,
+		Addresses: addresses,
+	}
 }
@@ -0,0 +1,230 @@
+package customer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+
+	"github.com/sveatlo/night_snack/internal/repository"
+)
+
+// Repository keeps the customers and their addresses in the database, they are not event sourced
+type Repository struct {
+	log zerolog.Logger
+	db  *gorm.DB
+}
+
+func NewRepository(db *gorm.DB, log zerolog.Logger) (repo *Repository, err error) {
+	repo = &Repository{
+		log: log.With().Str("component", "customer/repository").Logger(),
+		db:  db,
+	}
+
+	err = db.AutoMigrate(&Customer{}, &Address{})
+	if err != nil {
+		err = fmt.Errorf("migration failed: %w", err)
+		return
+	}
+
+	return
+}
+
+func (repo *Repository) Create(ctx context.Context, firstName, lastName string) (c *Customer, err error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		err = fmt.Errorf("cannot generate UUID: %w", err)
+		return
+	}
+
+	c = &Customer{
+		ID:        id.String(),
+		FirstName: firstName,
+		LastName:  lastName,
+		Addresses: []Address{},
+	}
+	err = repo.db.WithContext(ctx).Create(c).Error
+	if err != nil {
+		err = fmt.Errorf("cannot create persistent record: %w", err)
+		return
+	}
+
+	return
+}
+
+func (repo *Repository) Update(ctx context.Context, id, firstName, lastName string) (c *Customer, err error) {
+	err = repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) (err error) {
+		c, err = repo.get(tx, id)
+		if err != nil {
+			return
+		}
+
+		c.FirstName = firstName
+		c.LastName = lastName
+		err = tx.Model(&Customer{ID: id}).Updates(map[string]interface{}{
+			"first_name": firstName,
+			"last_name":  lastName,
+		}).Error
+		if err != nil {
+			err = fmt.Errorf("cannot update persistent record: %w", err)
+			return
+		}
+
+		return
+	})
+
+	return
+}
+
+// Delete deletes the customer along with the addresses
+func (repo *Repository) Delete(ctx context.Context, id string) (err error) {
+	err = repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) (err error) {
+		_, err = repo.get(tx, id)
+		if err != nil {
+			return
+		}
+
+		err = tx.Delete(&Address{}, "customer_id = ?", id).Error
+		if err != nil {
+			err = fmt.Errorf("cannot delete addresses: %w", err)
+			return
+		}
+		err = tx.Delete(&Customer{ID: id}).Error
+		if err != nil {
+			err = fmt.Errorf("cannot delete persistent record: %w", err)
+			return
+		}
+
+		return
+	})
+
+	return
+}
+
+// Get returns the customer along with the addresses
+func (repo *Repository) Get(ctx context.Context, id string) (c *Customer, err error) {
+	return repo.get(repo.db.WithContext(ctx), id)
+}
+
+func (repo *Repository) get(tx *gorm.DB, id string) (c *Customer, err error) {
+	c = &Customer{}
+	err = tx.Preload("Addresses", func(db *gorm.DB) *gorm.DB {
+		return db.Order("name, id")
+	}).First(c, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = fmt.Errorf("customer %s: %w", id, repository.ErrNotFound)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot find customer: %w", err)
+		return
+	}
+
+	return
+}
+
+// GetAll returns all the customers along with their addresses
+func (repo *Repository) GetAll(ctx context.Context) (customers []*Customer, err error) {
+	err = repo.db.WithContext(ctx).Preload("Addresses", func(db *gorm.DB) *gorm.DB {
+		return db.Order("name, id")
+	}).Order("last_name, first_name, id").Find(&customers).Error
+	if err != nil {
+		err = fmt.Errorf("cannot find customers: %w", err)
+		return
+	}
+
+	return
+}
+
+func (repo *Repository) CreateAddress(ctx context.Context, customerID, name, city, street, streetNo string) (a *Address, err error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		err = fmt.Errorf("cannot generate UUID: %w", err)
+		return
+	}
+
+	err = repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) (err error) {
+		_, err = repo.get(tx, customerID)
+		if err != nil {
+			return
+		}
+
+		a = &Address{
+			ID:         id.String(),
+			CustomerID: customerID,
+			Name:       name,
+			City:       city,
+			Street:     street,
+			StreetNo:   streetNo,
+		}
+		err = tx.Create(a).Error
+		if err != nil {
+			err = fmt.Errorf("cannot create persistent record: %w", err)
+			return
+		}
+
+		return
+	})
+
+	return
+}
+
+func (repo *Repository) UpdateAddress(ctx context.Context, customerID, id, name, city, street, streetNo string) (a *Address, err error) {
+	err = repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) (err error) {
+		a, err = repo.getAddress(tx, customerID, id)
+		if err != nil {
+			return
+		}
+
+		a.Name = name
+		a.City = city
+		a.Street = street
+		a.StreetNo = streetNo
+		err = tx.Save(a).Error
+		if err != nil {
+			err = fmt.Errorf("cannot update persistent record: %w", err)
+			return
+		}
+
+		return
+	})
+
+	return
+}
+
+func (repo *Repository) DeleteAddress(ctx context.Context, customerID, id string) (err error) {
+	res := repo.db.WithContext(ctx).Delete(&Address{}, "id = ? AND customer_id = ?", id, customerID)
+	if res.Error != nil {
+		err = fmt.Errorf("cannot delete persistent record: %w", res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		err = fmt.Errorf("address %s of customer %s: %w", id, customerID, repository.ErrNotFound)
+		return
+	}
+
+	return
+}
+
+// GetAddress returns the address, it has to belong to the customer
+func (repo *Repository) GetAddress(ctx context.Context, customerID, id string) (a *Address, err error) {
+	return repo.getAddress(repo.db.WithContext(ctx), customerID, id)
+}
+
+func (repo *Repository) getAddress(tx *gorm.DB, customerID, id string) (a *Address, err error) {
+	a = &Address{}
+	err = tx.First(a, "id = ? AND customer_id = ?", id, customerID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = fmt.Errorf("address %s of customer %s: %w", id, customerID, repository.ErrNotFound)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot find address: %w", err)
+		return
+	}
+
+	return
+}
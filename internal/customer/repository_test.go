@@ -0,0 +1,178 @@
+package customer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/sveatlo/night_snack/internal/repository"
+)
+
+const envTestPostgresDSN = "SNACK_TEST_POSTGRES_DSN"
+
+func newTestRepository(t *testing.T) *Repository {
+	dsn := os.Getenv(envTestPostgresDSN)
+	if dsn == "" {
+		t.Skipf("%s not set", envTestPostgresDSN)
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("cannot connect to postgres: %v", err)
+	}
+	repo, err := NewRepository(db, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("cannot create repository: %v", err)
+	}
+	err = db.Exec("TRUNCATE customers, addresses").Error
+	if err != nil {
+		t.Fatalf("cannot clean up repository: %v", err)
+	}
+
+	return repo
+}
+
+func TestCustomers(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	created, err := repo.Create(ctx, "Alice", "Smith")
+	if err != nil {
+		t.Fatalf("cannot create customer: %v", err)
+	}
+	_, err = repo.Create(ctx, "Bob", "Brown")
+	if err != nil {
+		t.Fatalf("cannot create customer: %v", err)
+	}
+
+	updated, err := repo.Update(ctx, created.ID, "Alice", "Jones")
+	if err != nil {
+		t.Fatalf("cannot update customer: %v", err)
+	}
+	if updated.LastName != "Jones" {
+		t.Errorf("update returned %+v, expected the new last name", updated)
+	}
+	c, err := repo.Get(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("cannot get customer: %v", err)
+	}
+	if c.FirstName != "Alice" || c.LastName != "Jones" || len(c.Addresses) != 0 {
+		t.Errorf("got %+v, expected Alice Jones without addresses", c)
+	}
+
+	customers, err := repo.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("cannot get customers: %v", err)
+	}
+	if len(customers) != 2 || customers[0].LastName != "Brown" || customers[1].LastName != "Jones" {
+		t.Errorf("got %+v, expected Brown and Jones ordered by last name", customers)
+	}
+}
+
+func TestCustomerNotFound(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"get", func() error { _, err := repo.Get(ctx, "missing"); return err }},
+		{"update", func() error { _, err := repo.Update(ctx, "missing", "Alice", "Smith"); return err }},
+		{"delete", func() error { return repo.Delete(ctx, "missing") }},
+		{"create address", func() error {
+			_, err := repo.CreateAddress(ctx, "missing", "home", "Brno", "Main", "1")
+			return err
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.fn(); !errors.Is(err, repository.ErrNotFound) {
+				t.Errorf("got %v, expected %v", err, repository.ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestAddresses(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	alice, err := repo.Create(ctx, "Alice", "Smith")
+	if err != nil {
+		t.Fatalf("cannot create customer: %v", err)
+	}
+	bob, err := repo.Create(ctx, "Bob", "Brown")
+	if err != nil {
+		t.Fatalf("cannot create customer: %v", err)
+	}
+	work, err := repo.CreateAddress(ctx, alice.ID, "work", "Brno", "Office", "2")
+	if err != nil {
+		t.Fatalf("cannot create address: %v", err)
+	}
+	_, err = repo.CreateAddress(ctx, alice.ID, "home", "Brno", "Main", "1")
+	if err != nil {
+		t.Fatalf("cannot create address: %v", err)
+	}
+
+	updated, err := repo.UpdateAddress(ctx, alice.ID, work.ID, "work", "Prague", "Office", "3")
+	if err != nil {
+		t.Fatalf("cannot update address: %v", err)
+	}
+	if updated.City != "Prague" || updated.StreetNo != "3" || updated.CustomerID != alice.ID {
+		t.Errorf("update returned %+v, expected the new city and number", updated)
+	}
+	c, err := repo.Get(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("cannot get customer: %v", err)
+	}
+	if len(c.Addresses) != 2 || c.Addresses[0].Name != "home" || c.Addresses[1].City != "Prague" {
+		t.Errorf("got addresses %+v, expected home and work ordered by name", c.Addresses)
+	}
+
+	// the address of another customer is not found
+	_, err = repo.GetAddress(ctx, bob.ID, work.ID)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("got %v for the address of another customer, expected %v", err, repository.ErrNotFound)
+	}
+	_, err = repo.UpdateAddress(ctx, bob.ID, work.ID, "work", "Brno", "Office", "2")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("got %v updating the address of another customer, expected %v", err, repository.ErrNotFound)
+	}
+	err = repo.DeleteAddress(ctx, bob.ID, work.ID)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("got %v deleting the address of another customer, expected %v", err, repository.ErrNotFound)
+	}
+
+	err = repo.DeleteAddress(ctx, alice.ID, work.ID)
+	if err != nil {
+		t.Fatalf("cannot delete address: %v", err)
+	}
+	_, err = repo.GetAddress(ctx, alice.ID, work.ID)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("got %v for a deleted address, expected %v", err, repository.ErrNotFound)
+	}
+
+	// the addresses are deleted along with the customer
+	err = repo.Delete(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("cannot delete customer: %v", err)
+	}
+	var left int64
+	err = repo.db.Model(&Address{}).Where("customer_id = ?", alice.ID).Count(&left).Error
+	if err != nil || left != 0 {
+		t.Errorf("%d addresses left after deleting the customer, %v", left, err)
+	}
+	_, err = repo.Get(ctx, alice.ID)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("got %v for a deleted customer, expected %v", err, repository.ErrNotFound)
+	}
+}
@@ -0,0 +1,206 @@
+package customer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/moderntv/cadre/metrics"
+	"github.com/moderntv/cadre/status"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	"github.com/sveatlo/night_snack/internal/repository"
+	customer_pb "github.com/sveatlo/night_snack/proto/customer"
+)
+
+type Service struct {
+	customer_pb.UnimplementedCustomerServiceServer
+
+	log    zerolog.Logger
+	status *status.ComponentStatus
+
+	repo *Repository
+}
+
+func NewService(db *gorm.DB, metricsRegistry *metrics.Registry, appStatus *status.Status, log zerolog.Logger) (s *Service, err error) {
+	cs, err := appStatus.Register("customer/svc")
+	if err != nil {
+		return
+	}
+
+	repo, err := NewRepository(db, log)
+	if err != nil {
+		err = fmt.Errorf("cannot create customer repository: %w", err)
+		return
+	}
+
+	s = &Service{
+		log:    log.With().Str("component", "customer/svc").Logger(),
+		status: cs,
+
+		repo: repo,
+	}
+
+	return
+}
+
+func (s *Service) Close() {}
+
+func (s *Service) Create(ctx context.Context, cmd *customer_pb.CmdCustomerCreate) (res *customer_pb.Customer, err error) {
+	err = validateName(cmd.GetFirstName(), cmd.GetLastName())
+	if err != nil {
+		return
+	}
+
+	c, err := s.repo.Create(ctx, cmd.GetFirstName(), cmd.GetLastName())
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("creation failed: %w", err))
+		return
+	}
+
+	res = c.ToProto()
+
+	return
+}
+
+func (s *Service) Update(ctx context.Context, cmd *customer_pb.CmdCustomerUpdate) (res *customer_pb.Customer, err error) {
+	err = validateName(cmd.GetFirstName(), cmd.GetLastName())
+	if err != nil {
+		return
+	}
+
+	c, err := s.repo.Update(ctx, cmd.GetId(), cmd.GetFirstName(), cmd.GetLastName())
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("update failed: %w", err))
+		return
+	}
+
+	res = c.ToProto()
+
+	return
+}
+
+// Delete deletes the customer along with the addresses, the orders keep the addresses they were delivered to
+func (s *Service) Delete(ctx context.Context, cmd *customer_pb.CmdCustomerDelete) (res *customer_pb.CustomerDeleted, err error) {
+	err = s.repo.Delete(ctx, cmd.GetId())
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("deletion failed: %w", err))
+		return
+	}
+
+	res = &customer_pb.CustomerDeleted{Id: cmd.GetId()}
+
+	return
+}
+
+func (s *Service) Get(ctx context.Context, query *customer_pb.GetCustomer) (res *customer_pb.Customer, err error) {
+	c, err := s.repo.Get(ctx, query.GetId())
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("customer query failed: %w", err))
+		return
+	}
+
+	res = c.ToProto()
+
+	return
+}
+
+func (s *Service) GetAll(ctx context.Context, query *customer_pb.GetCustomers) (res *customer_pb.Customers, err error) {
+	customers, err := s.repo.GetAll(ctx)
+	if err != nil {
+		err = fmt.Errorf("customers query failed: %w", err)
+		return
+	}
+
+	res = &customer_pb.Customers{
+		Customers: make([]*customer_pb.Customer, len(customers)),
+	}
+	for i, c := range customers {
+		res.Customers[i] = c.ToProto()
+	}
+
+	return
+}
+
+func (s *Service) CreateAddress(ctx context.Context, cmd *customer_pb.CmdAddressCreate) (res *customer_pb.Address, err error) {
+	err = validateAddress(cmd.GetCity(), cmd.GetStreet(), cmd.GetStreetNo())
+	if err != nil {
+		return
+	}
+
+	a, err := s.repo.CreateAddress(ctx, cmd.GetCustomerId(), cmd.GetName(), cmd.GetCity(), cmd.GetStreet(), cmd.GetStreetNo())
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("creation failed: %w", err))
+		return
+	}
+
+	res = a.ToProto()
+
+	return
+}
+
+func (s *Service) UpdateAddress(ctx context.Context, cmd *customer_pb.CmdAddressUpdate) (res *customer_pb.Address, err error) {
+	err = validateAddress(cmd.GetCity(), cmd.GetStreet(), cmd.GetStreetNo())
+	if err != nil {
+		return
+	}
+
+	a, err := s.repo.UpdateAddress(ctx, cmd.GetCustomerId(), cmd.GetId(), cmd.GetName(), cmd.GetCity(), cmd.GetStreet(), cmd.GetStreetNo())
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("update failed: %w", err))
+		return
+	}
+
+	res = a.ToProto()
+
+	return
+}
+
+func (s *Service) DeleteAddress(ctx context.Context, cmd *customer_pb.CmdAddressDelete) (res *customer_pb.AddressDeleted, err error) {
+	err = s.repo.DeleteAddress(ctx, cmd.GetCustomerId(), cmd.GetId())
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("deletion failed: %w", err))
+		return
+	}
+
+	res = &customer_pb.AddressDeleted{
+		Id:         cmd.GetId(),
+		CustomerId: cmd.GetCustomerId(),
+	}
+
+	return
+}
+
+// GetAddress returns the address of the customer, NotFound is returned if it belongs to another customer
+func (s *Service) GetAddress(ctx context.Context, query *customer_pb.GetAddress) (res *customer_pb.Address, err error) {
+	a, err := s.repo.GetAddress(ctx, query.GetCustomerId(), query.GetId())
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("address query failed: %w", err))
+		return
+	}
+
+	res = a.ToProto()
+
+	return
+}
+
+// validateName returns an InvalidArgument error if either of the names is blank
+func validateName(firstName, lastName string) (err error) {
+	if strings.TrimSpace(firstName) == "" || strings.TrimSpace(lastName) == "" {
+		err = grpc_status.Error(codes.InvalidArgument, "missing first or last name")
+	}
+
+	return
+}
+
+// validateAddress returns an InvalidArgument error if the address cannot be delivered to
+func validateAddress(city, street, streetNo string) (err error) {
+	if strings.TrimSpace(city) == "" || strings.TrimSpace(street) == "" || strings.TrimSpace(streetNo) == "" {
+		err = grpc_status.Error(codes.InvalidArgument, "missing city, street or street number")
+	}
+
+	return
+}
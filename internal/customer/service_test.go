@@ -0,0 +1,71 @@
+package customer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
+
+	customer_pb "github.com/sveatlo/night_snack/proto/customer"
+)
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		firstName, lastName string
+		valid               bool
+	}{
+		{"Alice", "Smith", true},
+		{"", "Smith", false},
+		{"Alice", " ", false},
+	}
+	for _, test := range tests {
+		err := validateName(test.firstName, test.lastName)
+		if valid := err == nil; valid != test.valid || (!valid && grpc_status.Code(err) != codes.InvalidArgument) {
+			t.Errorf("name %q %q validated with %v", test.firstName, test.lastName, err)
+		}
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	tests := []struct {
+		city, street, streetNo string
+		valid                  bool
+	}{
+		{"Brno", "Main", "1", true},
+		{"", "Main", "1", false},
+		{"Brno", "\t", "1", false},
+		{"Brno", "Main", "", false},
+	}
+	for _, test := range tests {
+		err := validateAddress(test.city, test.street, test.streetNo)
+		if valid := err == nil; valid != test.valid || (!valid && grpc_status.Code(err) != codes.InvalidArgument) {
+			t.Errorf("address %q %q %q validated with %v", test.city, test.street, test.streetNo, err)
+		}
+	}
+}
+
+func TestServiceStatus(t *testing.T) {
+	s := &Service{log: zerolog.Nop(), repo: newTestRepository(t)}
+	ctx := context.Background()
+
+	_, err := s.Create(ctx, &customer_pb.CmdCustomerCreate{FirstName: "Alice"})
+	if code := grpc_status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("creation without last name got %s, expected %s", code, codes.InvalidArgument)
+	}
+
+	_, err = s.Get(ctx, &customer_pb.GetCustomer{Id: "missing"})
+	if code := grpc_status.Code(err); code != codes.NotFound {
+		t.Errorf("missing customer got %s, expected %s", code, codes.NotFound)
+	}
+
+	c, err := s.Create(ctx, &customer_pb.CmdCustomerCreate{FirstName: "Alice", LastName: "Smith"})
+	if err != nil {
+		t.Fatalf("cannot create customer: %v", err)
+	}
+	_, err = s.GetAddress(ctx, &customer_pb.GetAddress{CustomerId: c.GetId(), Id: "missing"})
+	if code := grpc_status.Code(err); code != codes.NotFound {
+		t.Errorf("missing address got %s, expected %s", code, codes.NotFound)
+	}
+}
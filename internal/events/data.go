@@ -0,0 +1,176 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	bson_primitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidData is returned when the stored data of an event don't have the shape its decoder expects
+var ErrInvalidData = errors.New("invalid event data")
+
+// DataReader reads the fields of the data of a stored event for the FromData decoders.
+// A field which is missing or of another type is not read, the reader records the first such field
+// and returns it from Err. Readers of the embedded documents share the error with their parent.
+type DataReader struct {
+	data bson.M
+	path string
+	err  *error
+}
+
+func NewDataReader(data bson.M) *DataReader {
+	var err error
+	return &DataReader{
+		data: data,
+		err:  &err,
+	}
+}
+
+// Err returns the error describing the first field which could not be read, nil if all of them were read
+func (r *DataReader) Err() error {
+	return *r.err
+}
+
+// Has reports whether the field is set to a value other than null
+func (r *DataReader) Has(key string) bool {
+	return r.data[key] != nil
+}
+
+func (r *DataReader) String(key string) (value string) {
+	value, ok := r.data[key].(string)
+	if !ok {
+		r.fail(key, "string")
+	}
+
+	return
+}
+
+func (r *DataReader) Int32(key string) (value int32) {
+	value, ok := r.data[key].(int32)
+	if !ok {
+		r.fail(key, "int32")
+	}
+
+	return
+}
+
+func (r *DataReader) Int64(key string) (value int64) {
+	value, ok := r.data[key].(int64)
+	if !ok {
+		r.fail(key, "int64")
+	}
+
+	return
+}
+
+func (r *DataReader) Time(key string) (value time.Time) {
+	dateTime, ok := r.data[key].(bson_primitive.DateTime)
+	if !ok {
+		r.fail(key, "datetime")
+		return
+	}
+
+	return dateTime.Time()
+}
+
+// Strings reads an array of strings. Null is read as an empty array, it is how an empty Go slice is stored.
+func (r *DataReader) Strings(key string) (values []string) {
+	value, ok := r.data[key]
+	if ok && value == nil {
+		return []string{}
+	}
+	array, ok := value.(bson.A)
+	if !ok {
+		r.fail(key, "array")
+		return
+	}
+
+	values = make([]string, len(array))
+	for i, item := range array {
+		if values[i], ok = item.(string); !ok {
+			r.failValue(fmt.Sprintf("%s.%d", key, i), item, "string")
+			return
+		}
+	}
+
+	return
+}
+
+// Value returns the field as it was decoded from BSON, whatever its type is
+func (r *DataReader) Value(key string) (value interface{}) {
+	value, ok := r.data[key]
+	if !ok {
+		r.fail(key, "any value")
+	}
+
+	return
+}
+
+// Doc returns the reader of the embedded document. When the field is not a document,
+// the returned reader reads an empty document.
+func (r *DataReader) Doc(key string) *DataReader {
+	data, ok := r.data[key].(bson.M)
+	if !ok {
+		r.fail(key, "document")
+	}
+
+	return r.embedded(key, data)
+}
+
+// Docs returns the readers of the documents of an array. Null is read as an empty array, like in Strings.
+func (r *DataReader) Docs(key string) (docs []*DataReader) {
+	value, ok := r.data[key]
+	if ok && value == nil {
+		return []*DataReader{}
+	}
+	array, ok := value.(bson.A)
+	if !ok {
+		r.fail(key, "array")
+		return
+	}
+
+	docs = make([]*DataReader, len(array))
+	for i, item := range array {
+		itemKey := fmt.Sprintf("%s.%d", key, i)
+		data, ok := item.(bson.M)
+		if !ok {
+			r.failValue(itemKey, item, "document")
+		}
+		docs[i] = r.embedded(itemKey, data)
+	}
+
+	return
+}
+
+func (r *DataReader) embedded(key string, data bson.M) *DataReader {
+	if data == nil {
+		data = bson.M{}
+	}
+
+	return &DataReader{
+		data: data,
+		path: r.path + key + ".",
+		err:  r.err,
+	}
+}
+
+func (r *DataReader) fail(key, expected string) {
+	value, ok := r.data[key]
+	if !ok {
+		if *r.err == nil {
+			*r.err = fmt.Errorf("%w: %s%s is missing, expected %s", ErrInvalidData, r.path, key, expected)
+		}
+		return
+	}
+
+	r.failValue(key, value, expected)
+}
+
+func (r *DataReader) failValue(key string, value interface{}, expected string) {
+	if *r.err == nil {
+		*r.err = fmt.Errorf("%w: %s%s is %T, expected %s", ErrInvalidData, r.path, key, value, expected)
+	}
+}
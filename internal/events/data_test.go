@@ -0,0 +1,68 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDataReaderArrays(t *testing.T) {
+	data := bson.M{
+		"null":      nil,
+		"strings":   bson.A{"a", "b"},
+		"docs":      bson.A{bson.M{"name": "a"}, bson.M{"name": "b"}},
+		"empty":     bson.A{},
+		"not_array": "a",
+		"mixed":     bson.A{bson.M{"name": "a"}, "b"},
+	}
+
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{key: "null", valid: true},
+		{key: "empty", valid: true},
+		{key: "missing"},
+		{key: "not_array"},
+	}
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			r := NewDataReader(data)
+			strings := r.Strings(test.key)
+			if err := r.Err(); test.valid != (err == nil) || (err != nil && !errors.Is(err, ErrInvalidData)) {
+				t.Errorf("strings read with %v", err)
+			}
+			if test.valid && (strings == nil || len(strings) != 0) {
+				t.Errorf("got strings %#v, expected an empty slice", strings)
+			}
+
+			r = NewDataReader(data)
+			docs := r.Docs(test.key)
+			if err := r.Err(); test.valid != (err == nil) || (err != nil && !errors.Is(err, ErrInvalidData)) {
+				t.Errorf("documents read with %v", err)
+			}
+			if test.valid && (docs == nil || len(docs) != 0) {
+				t.Errorf("got documents %#v, expected an empty slice", docs)
+			}
+		})
+	}
+
+	r := NewDataReader(data)
+	if strings := r.Strings("strings"); len(strings) != 2 || strings[1] != "b" {
+		t.Errorf("got strings %v, expected a and b", strings)
+	}
+	docs := r.Docs("docs")
+	if len(docs) != 2 || docs[1].String("name") != "b" {
+		t.Errorf("got %d documents, expected a and b", len(docs))
+	}
+	if err := r.Err(); err != nil {
+		t.Errorf("arrays read with %v", err)
+	}
+
+	r = NewDataReader(data)
+	r.Docs("mixed")
+	if err := r.Err(); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("array with a string read as documents with %v, expected %v", err, ErrInvalidData)
+	}
+}
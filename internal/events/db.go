@@ -6,16 +6,28 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 )
 
+// AggregateDB is a stream of events of a single aggregate as loaded from the store
 type AggregateDB struct {
 	ID       string `bson:"_id"`
 	Category string
 	Version  int       `bson:"version"`
 	Events   []EventDB `bson:"events"`
+	// SnapshotVersion is the version of the snapshot the events follow, 0 when loaded without a snapshot
+	SnapshotVersion int `bson:"-"`
 }
+
+// EventDB is a single stored event
 type EventDB struct {
-	AggregateID string    `bson:"_id"`
+	// Position is the global position of the event across all aggregates.
+	// It is assigned by the store and grows monotonically.
+	Position    int64     `bson:"_id"`
+	AggregateID string    `bson:"aggregate_id"`
+	Version     int       `bson:"version"`
 	Timestamp   time.Time `bson:"timestamp"`
 	Category    string    `bson:"category"`
 	Type        string    `bson:"type"`
 	Data        bson.M    `bson:"data"`
+	Metadata    Metadata  `bson:"metadata"`
+	// Unpublished marks the events waiting in the outbox to be published
+	Unpublished bool `bson:"unpublished,omitempty"`
 }
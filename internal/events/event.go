@@ -11,4 +11,8 @@ type Event interface {
 	AggregateID() string
 	Data() bson.M
 	ToProto() proto.Message
+
+	// Metadata returns the envelope of the event, see Envelope
+	Metadata() Metadata
+	SetMetadata(Metadata)
 }
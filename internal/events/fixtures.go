@@ -0,0 +1,149 @@
+package events
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Fixture is the stored data of an event in one of the historical schema versions of its type.
+// Fixtures are kept as MongoDB extended JSON, so the BSON types of the data are preserved.
+type Fixture struct {
+	// File is the path the fixture was loaded from
+	File string `bson:"-"`
+
+	Category      string `bson:"category"`
+	Type          string `bson:"type"`
+	SchemaVersion int    `bson:"schema_version"`
+	Data          bson.M `bson:"data"`
+	// Expected is the data of the decoded event in the current schema version, it is not checked when empty
+	Expected bson.M `bson:"expected,omitempty"`
+}
+
+// LoadFixtures reads all .json files in dir and its subdirectories, each holding a single fixture
+func LoadFixtures(dir string) (fixtures []Fixture, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		fixture := Fixture{}
+		err = bson.UnmarshalExtJSON(data, true, &fixture)
+		if err != nil {
+			return fmt.Errorf("cannot decode fixture %s: %w", path, err)
+		}
+		fixture.File = path
+
+		fixtures = append(fixtures, fixture)
+
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("cannot load fixtures: %w", err)
+		return
+	}
+
+	return
+}
+
+// ReplayFixtures decodes every fixture as if it was loaded from the event store and returns all the failures.
+// Besides decoding, it verifies that:
+//   - the decoded event matches the expected data of the fixture,
+//   - the data of the decoded event can be stored and decoded again,
+//   - there is a fixture for every schema version of every registered event type.
+func (r *Registry) ReplayFixtures(fixtures []Fixture) (failures []error) {
+	covered := map[string]bool{}
+
+	for _, fixture := range fixtures {
+		err := r.replayFixture(fixture)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", fixture.File, err))
+			continue
+		}
+
+		covered[fmt.Sprintf("%s.%s.v%d", fixture.Category, fixture.Type, fixture.SchemaVersion)] = true
+	}
+
+	for _, category := range r.Categories() {
+		for _, eventType := range r.Types(category) {
+			for version := DefaultSchemaVersion; version <= eventType.SchemaVersion; version++ {
+				if !covered[fmt.Sprintf("%s.v%d", eventType.Topic(), version)] {
+					failures = append(failures, fmt.Errorf("no passing fixture of %s/%s schema version %d", category, eventType.Name(), version))
+				}
+			}
+		}
+	}
+
+	return
+}
+
+func (r *Registry) replayFixture(fixture Fixture) (err error) {
+	event, err := r.Decode(EventDB{
+		Category: fixture.Category,
+		Type:     fixture.Type,
+		Data:     fixture.Data,
+		Metadata: Metadata{
+			SchemaVersion: fixture.SchemaVersion,
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	// the data are compared as they would be loaded back from the store
+	data, err := roundTripData(event.Data())
+	if err != nil {
+		return
+	}
+
+	if len(fixture.Expected) > 0 && !reflect.DeepEqual(data, fixture.Expected) {
+		err = fmt.Errorf("decoded data differ from the expected ones:\n\tgot:      %v\n\texpected: %v", data, fixture.Expected)
+		return
+	}
+
+	eventType, err := r.Lookup(fixture.Category, fixture.Type)
+	if err != nil {
+		return
+	}
+	_, err = r.Decode(EventDB{
+		Category: fixture.Category,
+		Type:     fixture.Type,
+		Data:     data,
+		Metadata: Metadata{
+			SchemaVersion: eventType.SchemaVersion,
+		},
+	})
+	if err != nil {
+		err = fmt.Errorf("cannot decode the stored data of the decoded event: %w", err)
+		return
+	}
+
+	return
+}
+
+// roundTripData encodes and decodes the data the same way the event stores do
+func roundTripData(data bson.M) (roundTripped bson.M, err error) {
+	raw, err := bson.Marshal(data)
+	if err != nil {
+		err = fmt.Errorf("cannot encode event data: %w", err)
+		return
+	}
+
+	roundTripped = bson.M{}
+	err = bson.Unmarshal(raw, &roundTripped)
+	if err != nil {
+		err = fmt.Errorf("cannot decode event data: %w", err)
+		return
+	}
+
+	return
+}
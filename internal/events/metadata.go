@@ -0,0 +1,155 @@
+package events
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// DefaultSchemaVersion is the first schema version of every event type
+	DefaultSchemaVersion = 1
+
+	HeaderEventID       = "Nats-Msg-Id"
+	HeaderOccurredAt    = "Snack-Occurred-At"
+	HeaderCorrelationID = "Snack-Correlation-Id"
+	HeaderCausationID   = "Snack-Causation-Id"
+	HeaderActor         = "Snack-Actor"
+	HeaderSchemaVersion = "Snack-Schema-Version"
+
+	// the position and version of the stored event are added by the outbox relay
+	HeaderPosition = "Snack-Position"
+	HeaderVersion  = "Snack-Version"
+)
+
+// Metadata is the envelope of an event.
+// It is stored next to the event data and sent as NATS headers along with the published event.
+type Metadata struct {
+	EventID    string    `bson:"event_id"`
+	OccurredAt time.Time `bson:"occurred_at"`
+	// CorrelationID is shared by all the events caused by a single request
+	CorrelationID string `bson:"correlation_id"`
+	// CausationID is the ID of the request or event which directly caused the event
+	CausationID   string `bson:"causation_id"`
+	Actor         string `bson:"actor"`
+	SchemaVersion int    `bson:"schema_version"`
+}
+
+// Envelope holds the metadata of an event. It is meant to be embedded in the event structs.
+type Envelope struct {
+	metadata Metadata
+}
+
+func (e *Envelope) Metadata() Metadata            { return e.metadata }
+func (e *Envelope) SetMetadata(metadata Metadata) { e.metadata = metadata }
+
+type metadataContextKey struct{}
+
+// ContextWithMetadata returns a copy of ctx carrying the correlation ID, causation ID and actor of metadata
+func ContextWithMetadata(ctx context.Context, metadata Metadata) context.Context {
+	return context.WithValue(ctx, metadataContextKey{}, Metadata{
+		CorrelationID: metadata.CorrelationID,
+		CausationID:   metadata.CausationID,
+		Actor:         metadata.Actor,
+	})
+}
+
+// MetadataFromContext returns the metadata stored in ctx by ContextWithMetadata
+func MetadataFromContext(ctx context.Context) Metadata {
+	metadata, _ := ctx.Value(metadataContextKey{}).(Metadata)
+	return metadata
+}
+
+// WithCorrelationID returns a copy of ctx in which the request is identified by correlationID.
+// The correlation ID is also used as the causation ID of the events stored directly by the request.
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	metadata := MetadataFromContext(ctx)
+	metadata.CorrelationID = correlationID
+	metadata.CausationID = correlationID
+
+	return ContextWithMetadata(ctx, metadata)
+}
+
+// WithCausationID returns a copy of ctx in which the events are stored as caused by the command identified by causationID
+func WithCausationID(ctx context.Context, causationID string) context.Context {
+	metadata := MetadataFromContext(ctx)
+	metadata.CausationID = causationID
+
+	return ContextWithMetadata(ctx, metadata)
+}
+
+// WithActor returns a copy of ctx in which the events are stored on behalf of actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	metadata := MetadataFromContext(ctx)
+	metadata.Actor = actor
+
+	return ContextWithMetadata(ctx, metadata)
+}
+
+// CausedBy returns a copy of ctx for handling the event described by metadata.
+// Events stored within the returned context share its correlation ID and are caused by it.
+func CausedBy(ctx context.Context, metadata Metadata) context.Context {
+	return ContextWithMetadata(ctx, Metadata{
+		CorrelationID: metadata.CorrelationID,
+		CausationID:   metadata.EventID,
+		Actor:         metadata.Actor,
+	})
+}
+
+// NewMetadata creates the metadata of a new event with the data in schemaVersion stored within ctx.
+// Without a correlation ID in ctx, the event starts a new correlation.
+func NewMetadata(ctx context.Context, schemaVersion int) (metadata Metadata, err error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return
+	}
+
+	metadata = MetadataFromContext(ctx)
+	metadata.EventID = id.String()
+	metadata.OccurredAt = time.Now().UTC()
+	metadata.SchemaVersion = schemaVersion
+	if metadata.CorrelationID == "" {
+		metadata.CorrelationID = metadata.EventID
+	}
+	if metadata.CausationID == "" {
+		metadata.CausationID = metadata.CorrelationID
+	}
+
+	return
+}
+
+// Header encodes metadata as NATS message headers
+func (metadata Metadata) Header() nats.Header {
+	header := nats.Header{}
+	header.Set(HeaderEventID, metadata.EventID)
+	header.Set(HeaderOccurredAt, metadata.OccurredAt.Format(time.RFC3339Nano))
+	header.Set(HeaderCorrelationID, metadata.CorrelationID)
+	header.Set(HeaderCausationID, metadata.CausationID)
+	header.Set(HeaderActor, metadata.Actor)
+	header.Set(HeaderSchemaVersion, strconv.Itoa(metadata.SchemaVersion))
+
+	return header
+}
+
+// MetadataFromHeader decodes metadata from NATS message headers.
+// Missing or malformed values are left empty.
+func MetadataFromHeader(header nats.Header) (metadata Metadata) {
+	metadata = Metadata{
+		EventID:       header.Get(HeaderEventID),
+		CorrelationID: header.Get(HeaderCorrelationID),
+		CausationID:   header.Get(HeaderCausationID),
+		Actor:         header.Get(HeaderActor),
+		SchemaVersion: DefaultSchemaVersion,
+	}
+	if occurredAt, err := time.Parse(time.RFC3339Nano, header.Get(HeaderOccurredAt)); err == nil {
+		metadata.OccurredAt = occurredAt
+	}
+	if schemaVersion, err := strconv.Atoi(header.Get(HeaderSchemaVersion)); err == nil {
+		metadata.SchemaVersion = schemaVersion
+	}
+
+	return
+}
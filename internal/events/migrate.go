@@ -0,0 +1,137 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	legacyEventsCollection = "events"
+)
+
+// legacyAggregateDB is the format used before events were stored individually.
+// All events of an aggregate were embedded in a single document of the events collection.
+type legacyAggregateDB struct {
+	ID       string          `bson:"_id"`
+	Category string          `bson:"category"`
+	Version  int             `bson:"version"`
+	Events   []legacyEventDB `bson:"events"`
+}
+
+type legacyEventDB struct {
+	AggregateID string    `bson:"_id"`
+	Timestamp   time.Time `bson:"timestamp"`
+	Category    string    `bson:"category"`
+	Type        string    `bson:"type"`
+	Data        bson.M    `bson:"data"`
+}
+
+// MigrateLegacyAggregates copies the aggregates from the legacy events collection to store.
+//
+// Events of all aggregates are appended in the order of their timestamps, so that
+// the global positions follow the original order. Aggregates which already exist
+// in store are skipped, so the migration can be safely run again.
+// The legacy collection is left untouched.
+func MigrateLegacyAggregates(ctx context.Context, mongoDB *mongo.Database, store Store, log zerolog.Logger) (migrated int, err error) {
+	cursor, err := mongoDB.Collection(legacyEventsCollection).Find(ctx, bson.M{})
+	if err != nil {
+		err = fmt.Errorf("cannot get legacy aggregates: %w", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	type pendingEvent struct {
+		aggregate *legacyAggregateDB
+		version   int
+		event     legacyEventDB
+		// orderBy is never lower than the timestamps of the preceding events of the aggregate
+		orderBy time.Time
+	}
+	pending := []pendingEvent{}
+
+	for cursor.Next(ctx) {
+		aggregate := &legacyAggregateDB{}
+		err = cursor.Decode(aggregate)
+		if err != nil {
+			err = fmt.Errorf("cannot decode legacy aggregate: %w", err)
+			return
+		}
+
+		var existing AggregateDB
+		existing, err = store.Load(ctx, aggregate.Category, aggregate.ID)
+		if err != nil {
+			err = fmt.Errorf("cannot check aggregate %s/%s: %w", aggregate.Category, aggregate.ID, err)
+			return
+		}
+		if existing.Version > 0 {
+			log.Debug().Str("category", aggregate.Category).Str("id", aggregate.ID).Msg("aggregate already migrated")
+			continue
+		}
+
+		var orderBy time.Time
+		for i, event := range aggregate.Events {
+			if event.Timestamp.After(orderBy) {
+				orderBy = event.Timestamp
+			}
+
+			pending = append(pending, pendingEvent{
+				aggregate: aggregate,
+				version:   i,
+				event:     event,
+				orderBy:   orderBy,
+			})
+		}
+		migrated++
+	}
+	err = cursor.Err()
+	if err != nil {
+		err = fmt.Errorf("cannot read legacy aggregates: %w", err)
+		return
+	}
+
+	// stable sort keeps the order of the events of a single aggregate
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].orderBy.Before(pending[j].orderBy)
+	})
+
+	for _, p := range pending {
+		var eventID uuid.UUID
+		eventID, err = uuid.NewV4()
+		if err != nil {
+			err = fmt.Errorf("cannot generate event ID: %w", err)
+			return
+		}
+
+		eventDB := EventDB{
+			AggregateID: p.aggregate.ID,
+			Version:     p.version + 1,
+			Timestamp:   p.event.Timestamp,
+			Category:    p.aggregate.Category,
+			Type:        p.event.Type,
+			Data:        p.event.Data,
+			// legacy events were stored without metadata, only their timestamp is known
+			Metadata: Metadata{
+				EventID:       eventID.String(),
+				OccurredAt:    p.event.Timestamp,
+				SchemaVersion: DefaultSchemaVersion,
+			},
+		}
+
+		err = store.Append(ctx, p.aggregate.Category, p.aggregate.ID, []EventDB{eventDB}, p.version)
+		if err != nil {
+			err = fmt.Errorf("cannot migrate event %d of aggregate %s/%s: %w", p.version+1, p.aggregate.Category, p.aggregate.ID, err)
+			return
+		}
+	}
+
+	log.Info().Int("aggregates", migrated).Int("events", len(pending)).Msg("legacy aggregates migrated")
+
+	return
+}
@@ -0,0 +1,285 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"go.mongodb.org/mongo-driver/bson"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrUnknownEventType is returned when decoding an event whose type was not registered
+var ErrUnknownEventType = errors.New("unknown event type")
+
+// DefaultRegistry is the registry the packages register their event types to
+var DefaultRegistry = NewRegistry()
+
+// Upcaster transforms the stored data of an event from one schema version to the next one
+type Upcaster func(data bson.M) (bson.M, error)
+
+// EventType describes how a single type of events is stored and published
+type EventType struct {
+	// Event is an empty instance of the event. Its category and type identify the event type.
+	Event Event
+	// Proto is an empty instance of the message the event is published as
+	Proto proto.Message
+	// FromData decodes the event from the data stored in the event store.
+	// It fails on data of an unexpected shape, see DataReader.
+	FromData func(data bson.M) (Event, error)
+	// FromProto decodes the event from the published message
+	FromProto func(msg proto.Message) Event
+
+	// SchemaVersion is the current version of the stored data, DefaultSchemaVersion when not set.
+	// FromData only has to understand the data in this version.
+	SchemaVersion int
+	// Upcasters transform the data stored in the version of the key to the following version.
+	// There must be an upcaster for every version older than SchemaVersion.
+	Upcasters map[int]Upcaster
+}
+
+// Category returns the category of the events of the type
+func (t EventType) Category() string { return t.Event.EventCategory() }
+
+// Name returns the name of the type within its category
+func (t EventType) Name() string { return t.Event.EventType() }
+
+// Topic returns the NATS subject the events of the type are published to
+func (t EventType) Topic() string { return Topic(t.Event) }
+
+// Topic returns the NATS subject the event is published to
+func Topic(event Event) string {
+	return fmt.Sprintf("%s.%s", event.EventCategory(), event.EventType())
+}
+
+// Registry maps the stored and published events to their Go types
+type Registry struct {
+	mu    sync.RWMutex
+	types map[string]EventType
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		types: map[string]EventType{},
+	}
+}
+
+// Register adds the event type to the registry.
+// Every event type can be registered only once.
+func (r *Registry) Register(eventType EventType) (err error) {
+	if eventType.Event == nil || eventType.Proto == nil || eventType.FromData == nil || eventType.FromProto == nil {
+		err = fmt.Errorf("incomplete event type %T", eventType.Event)
+		return
+	}
+
+	if eventType.SchemaVersion == 0 {
+		eventType.SchemaVersion = DefaultSchemaVersion
+	}
+	for version := DefaultSchemaVersion; version < eventType.SchemaVersion; version++ {
+		if eventType.Upcasters[version] == nil {
+			err = fmt.Errorf("event type %s has no upcaster from schema version %d", eventType.Topic(), version)
+			return
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	topic := eventType.Topic()
+	if _, ok := r.types[topic]; ok {
+		err = fmt.Errorf("event type %s already registered", topic)
+		return
+	}
+	r.types[topic] = eventType
+
+	return
+}
+
+// MustRegister registers the event types and panics on failure. It is meant to be called from init.
+func (r *Registry) MustRegister(eventTypes ...EventType) {
+	for _, eventType := range eventTypes {
+		if err := r.Register(eventType); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Lookup returns the registered event type
+func (r *Registry) Lookup(category, name string) (eventType EventType, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	eventType, ok := r.types[fmt.Sprintf("%s.%s", category, name)]
+	if !ok {
+		err = fmt.Errorf("%w: %s/%s", ErrUnknownEventType, category, name)
+		return
+	}
+
+	return
+}
+
+// SchemaVersion returns the current schema version of the event's type.
+// Unregistered events are at DefaultSchemaVersion.
+func (r *Registry) SchemaVersion(event Event) int {
+	eventType, err := r.Lookup(event.EventCategory(), event.EventType())
+	if err != nil {
+		return DefaultSchemaVersion
+	}
+
+	return eventType.SchemaVersion
+}
+
+// Types returns the event types registered for the category ordered by name
+func (r *Registry) Types(category string) (eventTypes []EventType) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, eventType := range r.types {
+		if eventType.Category() == category {
+			eventTypes = append(eventTypes, eventType)
+		}
+	}
+	sort.Slice(eventTypes, func(i, j int) bool {
+		return eventTypes[i].Name() < eventTypes[j].Name()
+	})
+
+	return
+}
+
+// Categories returns all categories with registered event types ordered by name
+func (r *Registry) Categories() (categories []string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := map[string]bool{}
+	for _, eventType := range r.types {
+		if !seen[eventType.Category()] {
+			seen[eventType.Category()] = true
+			categories = append(categories, eventType.Category())
+		}
+	}
+	sort.Strings(categories)
+
+	return
+}
+
+// Decode decodes a stored event including its metadata.
+// Data stored in an older schema version are upcasted to the current one first.
+func (r *Registry) Decode(eventDB EventDB) (event Event, err error) {
+	eventType, err := r.Lookup(eventDB.Category, eventDB.Type)
+	if err != nil {
+		return
+	}
+
+	data, err := eventType.upcast(eventDB.Data, eventDB.Metadata.SchemaVersion)
+	if err != nil {
+		err = fmt.Errorf("cannot upcast event %s/%s version %d of aggregate %s: %w", eventDB.Category, eventDB.Type, eventDB.Version, eventDB.AggregateID, err)
+		return
+	}
+
+	metadata := eventDB.Metadata
+	metadata.SchemaVersion = eventType.SchemaVersion
+
+	event, err = eventType.FromData(data)
+	if err != nil {
+		event = nil
+		err = fmt.Errorf("cannot decode event %s/%s version %d of aggregate %s: %w", eventDB.Category, eventDB.Type, eventDB.Version, eventDB.AggregateID, err)
+		return
+	}
+	event.SetMetadata(metadata)
+
+	return
+}
+
+// upcast transforms data stored in schemaVersion to the current schema version.
+// Events stored before the versioning was introduced have no schema version and are at DefaultSchemaVersion.
+func (t EventType) upcast(data bson.M, schemaVersion int) (upcasted bson.M, err error) {
+	if schemaVersion == 0 {
+		schemaVersion = DefaultSchemaVersion
+	}
+	if schemaVersion > t.SchemaVersion {
+		err = fmt.Errorf("schema version %d is newer than the supported version %d", schemaVersion, t.SchemaVersion)
+		return
+	}
+
+	upcasted = data
+	for version := schemaVersion; version < t.SchemaVersion; version++ {
+		upcasted, err = t.Upcasters[version](upcasted)
+		if err != nil {
+			err = fmt.Errorf("from schema version %d: %w", version, err)
+			return
+		}
+	}
+
+	return
+}
+
+// DecodeMessage decodes a published event including the metadata from the message headers
+func (r *Registry) DecodeMessage(msg *nats.Msg) (event Event, err error) {
+	r.mu.RLock()
+	eventType, ok := r.types[msg.Subject]
+	r.mu.RUnlock()
+	if !ok {
+		err = fmt.Errorf("%w: %s", ErrUnknownEventType, msg.Subject)
+		return
+	}
+
+	eventPb := eventType.Proto.ProtoReflect().New().Interface()
+	err = proto.Unmarshal(msg.Data, eventPb)
+	if err != nil {
+		err = fmt.Errorf("cannot decode message %s: %w", msg.Subject, err)
+		return
+	}
+
+	event = eventType.FromProto(eventPb)
+	event.SetMetadata(MetadataFromHeader(msg.Header))
+
+	return
+}
+
+// EncodeMessage encodes the event as the message published to its topic.
+// The metadata are sent in the message headers, including the event ID subscribers can deduplicate the messages by.
+func EncodeMessage(event Event) (msg *nats.Msg, err error) {
+	data, err := proto.Marshal(event.ToProto())
+	if err != nil {
+		err = fmt.Errorf("cannot encode event %s: %w", Topic(event), err)
+		return
+	}
+
+	msg = &nats.Msg{
+		Subject: Topic(event),
+		Header:  event.Metadata().Header(),
+		Data:    data,
+	}
+
+	return
+}
+
+// EncodeStoredMessage encodes the stored event like EncodeMessage and adds its position and version to the headers
+func EncodeStoredMessage(eventDB EventDB, event Event) (msg *nats.Msg, err error) {
+	msg, err = EncodeMessage(event)
+	if err != nil {
+		return
+	}
+
+	msg.Header.Set(HeaderPosition, strconv.FormatInt(eventDB.Position, 10))
+	msg.Header.Set(HeaderVersion, strconv.Itoa(eventDB.Version))
+
+	return
+}
+
+// PositionFromHeader returns the position and version of the published event, zeros when not present
+func PositionFromHeader(header nats.Header) (position int64, version int) {
+	position, _ = strconv.ParseInt(header.Get(HeaderPosition), 10, 64)
+	version, _ = strconv.Atoi(header.Get(HeaderVersion))
+
+	return
+}
+
+// Register adds the event types to DefaultRegistry and panics on failure
+func Register(eventTypes ...EventType) {
+	DefaultRegistry.MustRegister(eventTypes...)
+}
@@ -0,0 +1,119 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type testEvent struct {
+	Envelope
+
+	ID    string
+	N     int32
+	Notes []string
+}
+
+func (e *testEvent) EventCategory() string  { return "test" }
+func (e *testEvent) EventType() string      { return "happened" }
+func (e *testEvent) AggregateID() string    { return e.ID }
+func (e *testEvent) ToProto() proto.Message { return &emptypb.Empty{} }
+func (e *testEvent) Data() bson.M {
+	return bson.M{"id": e.ID, "n": e.N, "notes": e.Notes}
+}
+
+func newTestRegistry(t *testing.T) *Registry {
+	registry := NewRegistry()
+	err := registry.Register(EventType{
+		Event: &testEvent{},
+		Proto: &emptypb.Empty{},
+		FromData: func(data bson.M) (Event, error) {
+			r := NewDataReader(data)
+			e := &testEvent{
+				ID:    r.String("id"),
+				N:     r.Int32("n"),
+				Notes: r.Strings("notes"),
+			}
+
+			return e, r.Err()
+		},
+		FromProto:     func(msg proto.Message) Event { return &testEvent{} },
+		SchemaVersion: 2,
+		Upcasters: map[int]Upcaster{
+			1: func(data bson.M) (bson.M, error) {
+				data["notes"] = bson.A{}
+				return data, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot register event type: %v", err)
+	}
+
+	return registry
+}
+
+func TestDecode(t *testing.T) {
+	registry := newTestRegistry(t)
+
+	event, err := registry.Decode(EventDB{
+		Category: "test",
+		Type:     "happened",
+		Data:     bson.M{"id": "a", "n": int32(3)},
+		Metadata: Metadata{EventID: "event", SchemaVersion: 1},
+	})
+	if err != nil {
+		t.Fatalf("cannot decode: %v", err)
+	}
+	decoded := event.(*testEvent)
+	if decoded.ID != "a" || decoded.N != 3 || decoded.Notes == nil || len(decoded.Notes) != 0 {
+		t.Fatalf("decoded %+v", decoded)
+	}
+	if metadata := decoded.Metadata(); metadata.EventID != "event" || metadata.SchemaVersion != 2 {
+		t.Fatalf("decoded metadata %+v", metadata)
+	}
+}
+
+func TestDecodeInvalidData(t *testing.T) {
+	registry := newTestRegistry(t)
+
+	cases := []struct {
+		name string
+		data bson.M
+	}{
+		{"missing field", bson.M{"id": "a", "notes": bson.A{}}},
+		{"wrong type", bson.M{"id": "a", "n": "3", "notes": bson.A{}}},
+		{"wrong item type", bson.M{"id": "a", "n": int32(3), "notes": bson.A{"x", int32(1)}}},
+	}
+	for _, c := range cases {
+		event, err := registry.Decode(EventDB{
+			Category: "test",
+			Type:     "happened",
+			Data:     c.data,
+			Metadata: Metadata{SchemaVersion: 2},
+		})
+		if !errors.Is(err, ErrInvalidData) {
+			t.Errorf("%s: expected invalid data, got %v", c.name, err)
+		}
+		if event != nil {
+			t.Errorf("%s: decoded %+v despite the error", c.name, event)
+		}
+	}
+}
+
+func TestDecodeNewerSchemaVersion(t *testing.T) {
+	registry := newTestRegistry(t)
+
+	_, err := registry.Decode(EventDB{
+		Category: "test",
+		Type:     "happened",
+		Data:     bson.M{"id": "a", "n": int32(3), "notes": bson.A{}},
+		Metadata: Metadata{SchemaVersion: 3},
+	})
+	if err == nil {
+		t.Fatal("decoded data of a newer schema version")
+	}
+}
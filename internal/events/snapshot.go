@@ -0,0 +1,77 @@
+package events
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Snapshot is the state of an aggregate at a given version
+type Snapshot struct {
+	Category    string    `bson:"category"`
+	AggregateID string    `bson:"aggregate_id"`
+	Version     int       `bson:"version"`
+	Timestamp   time.Time `bson:"timestamp"`
+	// Schema is the fingerprint of the state type, see SchemaOf
+	Schema string `bson:"schema"`
+	// State is the BSON-encoded state of the aggregate
+	State []byte `bson:"state"`
+}
+
+// SchemaOf returns a fingerprint of the type of v.
+// It changes whenever a field of the type (or of any nested type) is added,
+// removed, renamed, retyped or gets different tags, so a snapshot saved with
+// an older shape of the state is never loaded into the new one.
+func SchemaOf(v interface{}) string {
+	b := &strings.Builder{}
+	describeType(b, reflect.TypeOf(v), map[reflect.Type]bool{})
+
+	sum := sha1.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func describeType(b *strings.Builder, t reflect.Type, seen map[reflect.Type]bool) {
+	if t == nil {
+		b.WriteString("nil")
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		b.WriteString("*")
+		describeType(b, t.Elem(), seen)
+	case reflect.Slice:
+		b.WriteString("[]")
+		describeType(b, t.Elem(), seen)
+	case reflect.Array:
+		fmt.Fprintf(b, "[%d]", t.Len())
+		describeType(b, t.Elem(), seen)
+	case reflect.Map:
+		b.WriteString("map[")
+		describeType(b, t.Key(), seen)
+		b.WriteString("]")
+		describeType(b, t.Elem(), seen)
+	case reflect.Struct:
+		// named structs are described once to break recursive types
+		if t.Name() != "" {
+			fmt.Fprintf(b, "%s.%s", t.PkgPath(), t.Name())
+			if seen[t] {
+				return
+			}
+			seen[t] = true
+		}
+		b.WriteString("{")
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fmt.Fprintf(b, "%s `%s` ", f.Name, f.Tag)
+			describeType(b, f.Type, seen)
+			b.WriteString(";")
+		}
+		b.WriteString("}")
+	default:
+		b.WriteString(t.String())
+	}
+}
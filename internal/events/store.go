@@ -0,0 +1,144 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"gorm.io/gorm"
+)
+
+// ErrConcurrencyConflict is returned by Store.Append when the aggregate is no
+// longer at the expected version.
+var ErrConcurrencyConflict = errors.New("concurrency conflict")
+
+// Store is a persistent append-only storage of aggregate events.
+type Store interface {
+	// Append adds events to the end of the aggregate stream.
+	// It fails with ErrConcurrencyConflict if the aggregate is not at expectedVersion.
+	Append(ctx context.Context, category, aggregateID string, events []EventDB, expectedVersion int) error
+	// Load returns the whole aggregate stream.
+	// An aggregate without events is returned with version 0.
+	Load(ctx context.Context, category, aggregateID string) (AggregateDB, error)
+	// LoadFrom returns the aggregate with only the events after version.
+	// version has to be at most the version of the aggregate, e.g. the version of its snapshot.
+	LoadFrom(ctx context.Context, category, aggregateID string, version int) (AggregateDB, error)
+	// Stream calls fn for every aggregate in category.
+	Stream(ctx context.Context, category string, fn func(AggregateDB) error) error
+	// ReadAll calls fn for every event of every category stored after the global position, in order.
+	// An event is passed only when no event with a lower position can become visible afterwards,
+	// so the readers can continue after the position of the last passed event.
+	ReadAll(ctx context.Context, after int64, fn func(EventDB) error) error
+
+	// ReadUnpublished calls fn for at most limit events stored as unpublished, in the order of their positions.
+	ReadUnpublished(ctx context.Context, limit int, fn func(EventDB) error) error
+	// MarkPublished removes the events at the positions from the outbox.
+	MarkPublished(ctx context.Context, positions ...int64) error
+
+	// SaveSnapshot stores the snapshot, replacing the previous snapshot of the aggregate.
+	SaveSnapshot(ctx context.Context, snapshot Snapshot) error
+	// LoadSnapshot returns the latest snapshot of the aggregate.
+	// found is false when there is no snapshot.
+	LoadSnapshot(ctx context.Context, category, aggregateID string) (snapshot Snapshot, found bool, err error)
+}
+
+// WithTransaction returns a context making the stores which share the database with db
+// append the events within the transaction tx. Other stores ignore it.
+func WithTransaction(ctx context.Context, tx *gorm.DB) context.Context {
+	return context.WithValue(ctx, transactionKey{}, tx)
+}
+
+// TransactionFromContext returns the transaction set by WithTransaction
+func TransactionFromContext(ctx context.Context) (tx *gorm.DB, ok bool) {
+	tx, ok = ctx.Value(transactionKey{}).(*gorm.DB)
+	return
+}
+
+type transactionKey struct{}
+
+// SharesTransactions reports whether the store appends the events within the transactions of db passed by WithTransaction
+func SharesTransactions(store Store, db *gorm.DB) bool {
+	s, ok := store.(interface{ SharesDatabase(db *gorm.DB) bool })
+
+	return ok && s.SharesDatabase(db)
+}
+
+// NewStore creates a store of the given type - mongo, postgres or memory.
+// mongoDB and db are used only by the store of the respective type.
+// Only the events and snapshots are kept in the store, the memory store doesn't outlive the process and is meant for the tests.
+func NewStore(storeType string, mongoDB *mongo.Database, db *gorm.DB) (store Store, err error) {
+	switch storeType {
+	case "mongo":
+		store, err = NewMongoStore(mongoDB)
+	case "postgres":
+		store, err = NewPostgresStore(db)
+	case "memory":
+		store = NewMemoryStore()
+	default:
+		err = fmt.Errorf("unknown event store type: %s", storeType)
+	}
+
+	return
+}
+
+func snapshotKey(category, aggregateID string) string {
+	return category + "/" + aggregateID
+}
+
+func emptyAggregate(category, aggregateID string) AggregateDB {
+	return AggregateDB{
+		ID:       aggregateID,
+		Category: category,
+		Version:  0,
+		Events:   []EventDB{},
+	}
+}
+
+// loadedFrom returns the aggregate loaded by LoadFrom from the events starting with the one at version.
+// The event at version is loaded only to get the version of the aggregate in the same query as the events
+// which follow it. The events after a missing version are left out, they were appended after the query started.
+func loadedFrom(category, aggregateID string, version int, eventsDB []EventDB) (aggregate AggregateDB) {
+	aggregate = emptyAggregate(category, aggregateID)
+	if version < 0 {
+		version = 0
+	}
+
+	next := version
+	for _, eventDB := range eventsDB {
+		if eventDB.Version == version && version > 0 {
+			aggregate.Version = version
+			continue
+		}
+		if eventDB.Version != next+1 {
+			break
+		}
+
+		aggregate.Events = append(aggregate.Events, eventDB)
+		aggregate.Version = eventDB.Version
+		next = eventDB.Version
+	}
+
+	return
+}
+
+// collectAggregate adds eventDB to the aggregate being collected from an ordered stream of events.
+// When eventDB belongs to another aggregate, fn is called with the finished one.
+func collectAggregate(aggregate *AggregateDB, eventDB EventDB, fn func(AggregateDB) error) (*AggregateDB, error) {
+	if aggregate != nil && aggregate.ID != eventDB.AggregateID {
+		err := fn(*aggregate)
+		if err != nil {
+			return nil, err
+		}
+		aggregate = nil
+	}
+	if aggregate == nil {
+		a := emptyAggregate(eventDB.Category, eventDB.AggregateID)
+		aggregate = &a
+	}
+
+	aggregate.Events = append(aggregate.Events, eventDB)
+	aggregate.Version = eventDB.Version
+
+	return aggregate, nil
+}
@@ -0,0 +1,254 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	_ Store = &MemoryStore{}
+)
+
+// MemoryStore keeps events in memory. It is meant for tests and local runs.
+//
+// Events are stored BSON-encoded so that the loaded data have the same shape
+// as if they were loaded from the database.
+type MemoryStore struct {
+	mu sync.RWMutex
+	// log holds all the events, the index is the position of the event - 1
+	log [][]byte
+	// aggregates maps aggregate key to the positions of its events
+	aggregates map[string][]int64
+	// order in which the aggregates were created
+	streams []memoryStream
+	// snapshots are kept by snapshotKey
+	snapshots map[string]Snapshot
+	// unpublished holds the positions of the events waiting in the outbox
+	unpublished map[int64]bool
+}
+
+type memoryStream struct {
+	category    string
+	aggregateID string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		log:        [][]byte{},
+		aggregates: map[string][]int64{},
+		streams:    []memoryStream{},
+		snapshots:  map[string]Snapshot{},
+
+		unpublished: map[int64]bool{},
+	}
+}
+
+func (s *MemoryStore) key(category, aggregateID string) string {
+	return category + "/" + aggregateID
+}
+
+func (s *MemoryStore) Append(ctx context.Context, category, aggregateID string, eventsDB []EventDB, expectedVersion int) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := s.key(category, aggregateID)
+	positions, ok := s.aggregates[key]
+	if len(positions) != expectedVersion {
+		err = fmt.Errorf("aggregate %s/%s is at version %d, not %d: %w", category, aggregateID, len(positions), expectedVersion, ErrConcurrencyConflict)
+		return
+	}
+
+	encoded := make([][]byte, len(eventsDB))
+	for i, eventDB := range eventsDB {
+		eventDB.Position = int64(len(s.log) + i + 1)
+		eventDB.Category = category
+		eventDB.AggregateID = aggregateID
+		eventDB.Version = expectedVersion + i + 1
+
+		encoded[i], err = bson.Marshal(eventDB)
+		if err != nil {
+			err = fmt.Errorf("cannot encode event: %w", err)
+			return
+		}
+	}
+
+	for i, e := range encoded {
+		s.log = append(s.log, e)
+		positions = append(positions, int64(len(s.log)))
+		if eventsDB[i].Unpublished {
+			s.unpublished[int64(len(s.log))] = true
+		}
+	}
+	s.aggregates[key] = positions
+	if !ok {
+		s.streams = append(s.streams, memoryStream{category: category, aggregateID: aggregateID})
+	}
+
+	return
+}
+
+func (s *MemoryStore) Load(ctx context.Context, category, aggregateID string) (aggregate AggregateDB, err error) {
+	return s.LoadFrom(ctx, category, aggregateID, 0)
+}
+
+func (s *MemoryStore) LoadFrom(ctx context.Context, category, aggregateID string, version int) (aggregate AggregateDB, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.loadAggregate(category, aggregateID, version)
+}
+
+func (s *MemoryStore) Stream(ctx context.Context, category string, fn func(AggregateDB) error) (err error) {
+	s.mu.RLock()
+	aggregates := []AggregateDB{}
+	for _, stream := range s.streams {
+		if stream.category != category {
+			continue
+		}
+
+		var aggregate AggregateDB
+		aggregate, err = s.loadAggregate(category, stream.aggregateID, 0)
+		if err != nil {
+			s.mu.RUnlock()
+			return
+		}
+		aggregates = append(aggregates, aggregate)
+	}
+	s.mu.RUnlock()
+
+	for _, aggregate := range aggregates {
+		err = fn(aggregate)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (s *MemoryStore) ReadAll(ctx context.Context, after int64, fn func(EventDB) error) (err error) {
+	if after < 0 {
+		after = 0
+	}
+
+	s.mu.RLock()
+	eventsDB := []EventDB{}
+	for position := after + 1; position <= int64(len(s.log)); position++ {
+		var eventDB EventDB
+		eventDB, err = s.decode(position)
+		if err != nil {
+			s.mu.RUnlock()
+			return
+		}
+		eventsDB = append(eventsDB, eventDB)
+	}
+	s.mu.RUnlock()
+
+	for _, eventDB := range eventsDB {
+		err = fn(eventDB)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (s *MemoryStore) ReadUnpublished(ctx context.Context, limit int, fn func(EventDB) error) (err error) {
+	s.mu.RLock()
+	positions := make([]int64, 0, len(s.unpublished))
+	for position := range s.unpublished {
+		positions = append(positions, position)
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i] < positions[j] })
+	if len(positions) > limit {
+		positions = positions[:limit]
+	}
+
+	eventsDB := make([]EventDB, len(positions))
+	for i, position := range positions {
+		eventsDB[i], err = s.decode(position)
+		if err != nil {
+			s.mu.RUnlock()
+			return
+		}
+		eventsDB[i].Unpublished = true
+	}
+	s.mu.RUnlock()
+
+	for _, eventDB := range eventsDB {
+		err = fn(eventDB)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (s *MemoryStore) MarkPublished(ctx context.Context, positions ...int64) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, position := range positions {
+		delete(s.unpublished, position)
+	}
+
+	return
+}
+
+func (s *MemoryStore) SaveSnapshot(ctx context.Context, snapshot Snapshot) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := make([]byte, len(snapshot.State))
+	copy(state, snapshot.State)
+	snapshot.State = state
+	s.snapshots[snapshotKey(snapshot.Category, snapshot.AggregateID)] = snapshot
+
+	return
+}
+
+func (s *MemoryStore) LoadSnapshot(ctx context.Context, category, aggregateID string) (snapshot Snapshot, found bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot, found = s.snapshots[snapshotKey(category, aggregateID)]
+	return
+}
+
+// loadAggregate must be called with at least the read lock held
+func (s *MemoryStore) loadAggregate(category, aggregateID string, version int) (aggregate AggregateDB, err error) {
+	aggregate = emptyAggregate(category, aggregateID)
+
+	positions := s.aggregates[s.key(category, aggregateID)]
+	aggregate.Version = len(positions)
+	if version < 0 {
+		version = 0
+	}
+
+	for i := version; i < len(positions); i++ {
+		var eventDB EventDB
+		eventDB, err = s.decode(positions[i])
+		if err != nil {
+			return
+		}
+		aggregate.Events = append(aggregate.Events, eventDB)
+	}
+
+	return
+}
+
+func (s *MemoryStore) decode(position int64) (eventDB EventDB, err error) {
+	err = bson.Unmarshal(s.log[position-1], &eventDB)
+	if err != nil {
+		err = fmt.Errorf("cannot decode event: %w", err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,346 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	_ Store = &MongoStore{}
+)
+
+const (
+	mongoEventsCollection    = "event_log"
+	mongoCountersCollection  = "counters"
+	mongoSnapshotsCollection = "snapshots"
+
+	// mongoGapTimeout is the time after which the missing positions are considered lost by appends which failed
+	mongoGapTimeout = 10 * time.Second
+)
+
+// MongoStore keeps every event as a separate document of the event_log collection.
+// The global position of the event is used as the document ID.
+//
+// Appending multiple events at once is not atomic, a conflict in the middle of
+// the batch leaves the already inserted events in place.
+//
+// The positions are allocated before the events are inserted, so an event can become visible
+// before the events with lower positions. ReadAll stops at such a gap until the missing events appear,
+// or until mongoGapTimeout after the event following the gap was stored, when the append is considered failed.
+type MongoStore struct {
+	eventsCollection    *mongo.Collection
+	countersCollection  *mongo.Collection
+	snapshotsCollection *mongo.Collection
+}
+
+// mongoEvent is the stored event along with the time it was stored at, which is used to resolve the gaps between positions
+type mongoEvent struct {
+	EventDB  `bson:",inline"`
+	StoredAt time.Time `bson:"stored_at,omitempty"`
+}
+
+func NewMongoStore(mongoDB *mongo.Database) (s *MongoStore, err error) {
+	s = &MongoStore{
+		eventsCollection:    mongoDB.Collection(mongoEventsCollection),
+		countersCollection:  mongoDB.Collection(mongoCountersCollection),
+		snapshotsCollection: mongoDB.Collection(mongoSnapshotsCollection),
+	}
+
+	// the index guards against two events with the same version
+	// and is used to load the aggregate streams
+	_, err = s.eventsCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "category", Value: 1}, {Key: "aggregate_id", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("stream"),
+	})
+	if err != nil {
+		err = fmt.Errorf("cannot create events index: %w", err)
+		return
+	}
+
+	// the index is used to trace all the events caused by a single request
+	_, err = s.eventsCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "metadata.correlation_id", Value: 1}},
+		Options: options.Index().SetName("correlation"),
+	})
+	if err != nil {
+		err = fmt.Errorf("cannot create correlation index: %w", err)
+		return
+	}
+
+	// the index holds only the events waiting in the outbox
+	_, err = s.eventsCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "unpublished", Value: 1}, {Key: "_id", Value: 1}},
+		Options: options.Index().SetName("outbox").SetPartialFilterExpression(bson.M{"unpublished": true}),
+	})
+	if err != nil {
+		err = fmt.Errorf("cannot create outbox index: %w", err)
+		return
+	}
+
+	return
+}
+
+func (s *MongoStore) Append(ctx context.Context, category, aggregateID string, eventsDB []EventDB, expectedVersion int) (err error) {
+	if len(eventsDB) == 0 {
+		return
+	}
+
+	// the conflicts are checked before allocating the positions, so that the positions are rarely lost
+	var last EventDB
+	err = s.eventsCollection.FindOne(
+		ctx,
+		bson.M{"category": category, "aggregate_id": aggregateID},
+		options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}).SetProjection(bson.M{"version": 1}),
+	).Decode(&last)
+	if err != nil && err != mongo.ErrNoDocuments {
+		err = fmt.Errorf("cannot check aggregate version: %w", err)
+		return
+	}
+	err = nil
+	if last.Version != expectedVersion {
+		err = fmt.Errorf("aggregate %s/%s is at version %d, not %d: %w", category, aggregateID, last.Version, expectedVersion, ErrConcurrencyConflict)
+		return
+	}
+
+	lastPosition, err := s.allocatePositions(ctx, len(eventsDB))
+	if err != nil {
+		return
+	}
+
+	storedAt := time.Now()
+	docs := make([]interface{}, len(eventsDB))
+	for i, eventDB := range eventsDB {
+		eventDB.Position = lastPosition - int64(len(eventsDB)-1-i)
+		eventDB.Category = category
+		eventDB.AggregateID = aggregateID
+		eventDB.Version = expectedVersion + i + 1
+		docs[i] = mongoEvent{EventDB: eventDB, StoredAt: storedAt}
+	}
+
+	_, err = s.eventsCollection.InsertMany(ctx, docs)
+	if mongo.IsDuplicateKeyError(err) {
+		err = fmt.Errorf("aggregate %s/%s is no longer at version %d: %w", category, aggregateID, expectedVersion, ErrConcurrencyConflict)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot insert events: %w", err)
+		return
+	}
+
+	return
+}
+
+// allocatePositions reserves n global positions and returns the last one
+func (s *MongoStore) allocatePositions(ctx context.Context, n int) (last int64, err error) {
+	res := s.countersCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": mongoEventsCollection},
+		bson.M{"$inc": bson.M{"seq": int64(n)}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	)
+	if res.Err() != nil {
+		err = fmt.Errorf("cannot allocate event positions: %w", res.Err())
+		return
+	}
+
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err = res.Decode(&counter)
+	if err != nil {
+		err = fmt.Errorf("cannot decode event positions: %w", err)
+		return
+	}
+
+	last = counter.Seq
+	return
+}
+
+func (s *MongoStore) Load(ctx context.Context, category, aggregateID string) (aggregate AggregateDB, err error) {
+	return s.LoadFrom(ctx, category, aggregateID, 0)
+}
+
+func (s *MongoStore) LoadFrom(ctx context.Context, category, aggregateID string, version int) (aggregate AggregateDB, err error) {
+	// the version of the aggregate is taken from the same query as the events, see loadedFrom
+	cursor, err := s.eventsCollection.Find(
+		ctx,
+		bson.M{"category": category, "aggregate_id": aggregateID, "version": bson.M{"$gte": version}},
+		options.Find().SetSort(bson.D{{Key: "version", Value: 1}}),
+	)
+	if err != nil {
+		err = fmt.Errorf("cannot load aggregate from DB: %w", err)
+		return
+	}
+
+	var eventsDB []EventDB
+	err = cursor.All(ctx, &eventsDB)
+	if err != nil {
+		err = fmt.Errorf("cannot decode events: %w", err)
+		return
+	}
+	aggregate = loadedFrom(category, aggregateID, version, eventsDB)
+
+	return
+}
+
+func (s *MongoStore) Stream(ctx context.Context, category string, fn func(AggregateDB) error) (err error) {
+	cursor, err := s.eventsCollection.Find(
+		ctx,
+		bson.M{"category": category},
+		options.Find().SetSort(bson.D{{Key: "aggregate_id", Value: 1}, {Key: "version", Value: 1}}),
+	)
+	if err != nil {
+		err = fmt.Errorf("cannot get events from store: %w", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var aggregate *AggregateDB
+	for cursor.Next(ctx) {
+		var eventDB EventDB
+		err = cursor.Decode(&eventDB)
+		if err != nil {
+			err = fmt.Errorf("cannot decode event: %w", err)
+			return
+		}
+
+		aggregate, err = collectAggregate(aggregate, eventDB, fn)
+		if err != nil {
+			return
+		}
+	}
+	err = cursor.Err()
+	if err != nil {
+		return
+	}
+
+	if aggregate != nil {
+		err = fn(*aggregate)
+	}
+
+	return
+}
+
+func (s *MongoStore) ReadAll(ctx context.Context, after int64, fn func(EventDB) error) (err error) {
+	cursor, err := s.eventsCollection.Find(
+		ctx,
+		bson.M{"_id": bson.M{"$gt": after}},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		err = fmt.Errorf("cannot get events from store: %w", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	next := after + 1
+	for cursor.Next(ctx) {
+		var event mongoEvent
+		err = cursor.Decode(&event)
+		if err != nil {
+			err = fmt.Errorf("cannot decode event: %w", err)
+			return
+		}
+
+		// the events stored before the store time was recorded have it zero and are never waited for
+		if event.Position != next && time.Since(event.StoredAt) < mongoGapTimeout {
+			// the events at the missing positions may still be being inserted
+			return
+		}
+		next = event.Position + 1
+
+		err = fn(event.EventDB)
+		if err != nil {
+			return
+		}
+	}
+
+	return cursor.Err()
+}
+
+func (s *MongoStore) ReadUnpublished(ctx context.Context, limit int, fn func(EventDB) error) (err error) {
+	cursor, err := s.eventsCollection.Find(
+		ctx,
+		bson.M{"unpublished": true},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		err = fmt.Errorf("cannot get unpublished events from store: %w", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var eventDB EventDB
+		err = cursor.Decode(&eventDB)
+		if err != nil {
+			err = fmt.Errorf("cannot decode event: %w", err)
+			return
+		}
+
+		err = fn(eventDB)
+		if err != nil {
+			return
+		}
+	}
+
+	return cursor.Err()
+}
+
+func (s *MongoStore) MarkPublished(ctx context.Context, positions ...int64) (err error) {
+	if len(positions) == 0 {
+		return
+	}
+
+	_, err = s.eventsCollection.UpdateMany(
+		ctx,
+		bson.M{"_id": bson.M{"$in": positions}},
+		bson.M{"$unset": bson.M{"unpublished": ""}},
+	)
+	if err != nil {
+		err = fmt.Errorf("cannot mark events as published: %w", err)
+		return
+	}
+
+	return
+}
+
+func (s *MongoStore) SaveSnapshot(ctx context.Context, snapshot Snapshot) (err error) {
+	_, err = s.snapshotsCollection.ReplaceOne(
+		ctx,
+		bson.M{"_id": snapshotKey(snapshot.Category, snapshot.AggregateID)},
+		snapshot,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		err = fmt.Errorf("cannot save snapshot: %w", err)
+		return
+	}
+
+	return
+}
+
+func (s *MongoStore) LoadSnapshot(ctx context.Context, category, aggregateID string) (snapshot Snapshot, found bool, err error) {
+	res := s.snapshotsCollection.FindOne(ctx, bson.M{"_id": snapshotKey(category, aggregateID)})
+	if res.Err() == mongo.ErrNoDocuments {
+		return
+	}
+	if res.Err() != nil {
+		err = fmt.Errorf("cannot load snapshot: %w", res.Err())
+		return
+	}
+
+	err = res.Decode(&snapshot)
+	if err != nil {
+		err = fmt.Errorf("cannot decode snapshot: %w", err)
+		return
+	}
+	found = true
+
+	return
+}
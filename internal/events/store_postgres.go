@@ -0,0 +1,434 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"go.mongodb.org/mongo-driver/bson"
+	"gorm.io/gorm"
+)
+
+var (
+	_ Store = &PostgresStore{}
+)
+
+const (
+	pgUniqueViolation      = "23505"
+	pgSerializationFailure = "40001"
+)
+
+// PostgresStore keeps every event as a row of the events table.
+// It works with both PostgreSQL and CockroachDB.
+//
+// The global positions are taken from the counter in the event_positions table when the events
+// are appended. The counter row stays locked until the appending transaction ends, so the positions
+// are consecutive and the events become visible in the order of their positions.
+type PostgresStore struct {
+	db *gorm.DB
+}
+
+type eventRecord struct {
+	Position    int64     `gorm:"primaryKey;autoIncrement:false"`
+	Category    string    `gorm:"not null;uniqueIndex:idx_events_stream,priority:1"`
+	AggregateID string    `gorm:"not null;uniqueIndex:idx_events_stream,priority:2"`
+	Version     int       `gorm:"not null;uniqueIndex:idx_events_stream,priority:3"`
+	Timestamp   time.Time `gorm:"not null"`
+	Type        string    `gorm:"not null"`
+	// Data are BSON-encoded to keep the same types as the other stores
+	Data []byte
+
+	// metadata columns are nullable or defaulted so that they can be added to an existing table
+	EventID       string `gorm:"not null;default:'';index"`
+	OccurredAt    *time.Time
+	CorrelationID string `gorm:"not null;default:'';index"`
+	CausationID   string `gorm:"not null;default:''"`
+	Actor         string `gorm:"not null;default:''"`
+	SchemaVersion int    `gorm:"not null;default:0"`
+
+	// the events stored before the outbox was introduced are considered published
+	Unpublished bool `gorm:"not null;default:false;index:idx_events_outbox,where:unpublished"`
+}
+
+func (eventRecord) TableName() string { return "events" }
+
+// positionRecord is the counter of the global positions
+type positionRecord struct {
+	Name     string `gorm:"primaryKey"`
+	Position int64  `gorm:"not null"`
+}
+
+func (positionRecord) TableName() string { return "event_positions" }
+
+const postgresPositionCounter = "events"
+
+type snapshotRecord struct {
+	Category    string    `gorm:"primaryKey"`
+	AggregateID string    `gorm:"primaryKey"`
+	Version     int       `gorm:"not null"`
+	Timestamp   time.Time `gorm:"not null"`
+	Schema      string    `gorm:"not null"`
+	State       []byte
+}
+
+func (snapshotRecord) TableName() string { return "snapshots" }
+
+// SharesDatabase reports whether the store appends the events within the transactions of db
+func (s *PostgresStore) SharesDatabase(db *gorm.DB) bool {
+	return db != nil && s.db.Config == db.Config
+}
+
+func NewPostgresStore(db *gorm.DB) (s *PostgresStore, err error) {
+	err = db.AutoMigrate(&eventRecord{}, &snapshotRecord{}, &positionRecord{})
+	if err != nil {
+		err = fmt.Errorf("migration failed: %w", err)
+		return
+	}
+
+	// the counter continues from the events stored before it was introduced
+	err = db.Exec(
+		"INSERT INTO event_positions (name, position) SELECT ?, COALESCE(MAX(position), 0) FROM events ON CONFLICT DO NOTHING",
+		postgresPositionCounter,
+	).Error
+	if err != nil {
+		err = fmt.Errorf("cannot create position counter: %w", err)
+		return
+	}
+
+	s = &PostgresStore{
+		db: db,
+	}
+
+	return
+}
+
+func (s *PostgresStore) Append(ctx context.Context, category, aggregateID string, eventsDB []EventDB, expectedVersion int) (err error) {
+	records := make([]eventRecord, len(eventsDB))
+	for i, eventDB := range eventsDB {
+		var data []byte
+		data, err = bson.Marshal(eventDB.Data)
+		if err != nil {
+			err = fmt.Errorf("cannot encode event data: %w", err)
+			return
+		}
+
+		records[i] = eventRecord{
+			Category:    category,
+			AggregateID: aggregateID,
+			Version:     expectedVersion + i + 1,
+			Timestamp:   eventDB.Timestamp,
+			Type:        eventDB.Type,
+			Data:        data,
+
+			EventID:       eventDB.Metadata.EventID,
+			CorrelationID: eventDB.Metadata.CorrelationID,
+			CausationID:   eventDB.Metadata.CausationID,
+			Actor:         eventDB.Metadata.Actor,
+			SchemaVersion: eventDB.Metadata.SchemaVersion,
+
+			Unpublished: eventDB.Unpublished,
+		}
+		if !eventDB.Metadata.OccurredAt.IsZero() {
+			occurredAt := eventDB.Metadata.OccurredAt
+			records[i].OccurredAt = &occurredAt
+		}
+	}
+
+	err = s.dbFor(ctx).Transaction(func(tx *gorm.DB) (err error) {
+		var version int
+		err = tx.Model(&eventRecord{}).
+			Select("COALESCE(MAX(version), 0)").
+			Where("category = ? AND aggregate_id = ?", category, aggregateID).
+			Scan(&version).Error
+		if err != nil {
+			err = fmt.Errorf("cannot get aggregate version: %w", err)
+			return
+		}
+		if version != expectedVersion {
+			err = fmt.Errorf("aggregate %s/%s is at version %d, not %d: %w", category, aggregateID, version, expectedVersion, ErrConcurrencyConflict)
+			return
+		}
+
+		if len(records) == 0 {
+			return
+		}
+
+		// the counter row is locked until the transaction ends,
+		// so no other events can get a lower position and be committed later
+		var last int64
+		res := tx.Raw(
+			"UPDATE event_positions SET position = position + ? WHERE name = ? RETURNING position",
+			len(records), postgresPositionCounter,
+		).Scan(&last)
+		if res.Error != nil {
+			err = fmt.Errorf("cannot allocate event positions: %w", res.Error)
+			return
+		}
+		if res.RowsAffected == 0 {
+			err = fmt.Errorf("cannot allocate event positions: counter %s not found", postgresPositionCounter)
+			return
+		}
+		for i := range records {
+			records[i].Position = last - int64(len(records)-1-i)
+		}
+
+		err = tx.Create(&records).Error
+		if err != nil {
+			err = fmt.Errorf("cannot insert events: %w", err)
+			return
+		}
+
+		return
+	})
+	if err != nil && !errors.Is(err, ErrConcurrencyConflict) && isConflictError(err) {
+		err = fmt.Errorf("aggregate %s/%s was modified concurrently: %w", category, aggregateID, ErrConcurrencyConflict)
+	}
+
+	return
+}
+
+func (s *PostgresStore) Load(ctx context.Context, category, aggregateID string) (aggregate AggregateDB, err error) {
+	return s.LoadFrom(ctx, category, aggregateID, 0)
+}
+
+func (s *PostgresStore) LoadFrom(ctx context.Context, category, aggregateID string, version int) (aggregate AggregateDB, err error) {
+	// the version of the aggregate is taken from the same query as the events, see loadedFrom
+	var records []eventRecord
+	err = s.db.WithContext(ctx).
+		Where("category = ? AND aggregate_id = ? AND version >= ?", category, aggregateID, version).
+		Order("version").
+		Find(&records).Error
+	if err != nil {
+		err = fmt.Errorf("cannot load aggregate from DB: %w", err)
+		return
+	}
+
+	eventsDB := make([]EventDB, len(records))
+	for i, record := range records {
+		eventsDB[i], err = record.toEventDB()
+		if err != nil {
+			return
+		}
+	}
+	aggregate = loadedFrom(category, aggregateID, version, eventsDB)
+
+	return
+}
+
+func (s *PostgresStore) Stream(ctx context.Context, category string, fn func(AggregateDB) error) (err error) {
+	rows, err := s.db.WithContext(ctx).Model(&eventRecord{}).
+		Where("category = ?", category).
+		Order("aggregate_id, version").
+		Rows()
+	if err != nil {
+		err = fmt.Errorf("cannot get events from store: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	var aggregate *AggregateDB
+	for rows.Next() {
+		var record eventRecord
+		err = s.db.ScanRows(rows, &record)
+		if err != nil {
+			err = fmt.Errorf("cannot scan event: %w", err)
+			return
+		}
+
+		var eventDB EventDB
+		eventDB, err = record.toEventDB()
+		if err != nil {
+			return
+		}
+
+		aggregate, err = collectAggregate(aggregate, eventDB, fn)
+		if err != nil {
+			return
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	if aggregate != nil {
+		err = fn(*aggregate)
+	}
+
+	return
+}
+
+func (s *PostgresStore) ReadAll(ctx context.Context, after int64, fn func(EventDB) error) (err error) {
+	rows, err := s.db.WithContext(ctx).Model(&eventRecord{}).
+		Where("position > ?", after).
+		Order("position").
+		Rows()
+	if err != nil {
+		err = fmt.Errorf("cannot get events from store: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record eventRecord
+		err = s.db.ScanRows(rows, &record)
+		if err != nil {
+			err = fmt.Errorf("cannot scan event: %w", err)
+			return
+		}
+
+		var eventDB EventDB
+		eventDB, err = record.toEventDB()
+		if err != nil {
+			return
+		}
+
+		err = fn(eventDB)
+		if err != nil {
+			return
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *PostgresStore) ReadUnpublished(ctx context.Context, limit int, fn func(EventDB) error) (err error) {
+	var records []eventRecord
+	err = s.db.WithContext(ctx).
+		Where("unpublished").
+		Order("position").
+		Limit(limit).
+		Find(&records).Error
+	if err != nil {
+		err = fmt.Errorf("cannot get unpublished events from store: %w", err)
+		return
+	}
+
+	for _, record := range records {
+		var eventDB EventDB
+		eventDB, err = record.toEventDB()
+		if err != nil {
+			return
+		}
+
+		err = fn(eventDB)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (s *PostgresStore) MarkPublished(ctx context.Context, positions ...int64) (err error) {
+	if len(positions) == 0 {
+		return
+	}
+
+	err = s.db.WithContext(ctx).Model(&eventRecord{}).
+		Where("position IN ?", positions).
+		Update("unpublished", false).Error
+	if err != nil {
+		err = fmt.Errorf("cannot mark events as published: %w", err)
+		return
+	}
+
+	return
+}
+
+func (s *PostgresStore) SaveSnapshot(ctx context.Context, snapshot Snapshot) (err error) {
+	err = s.db.WithContext(ctx).Save(&snapshotRecord{
+		Category:    snapshot.Category,
+		AggregateID: snapshot.AggregateID,
+		Version:     snapshot.Version,
+		Timestamp:   snapshot.Timestamp,
+		Schema:      snapshot.Schema,
+		State:       snapshot.State,
+	}).Error
+	if err != nil {
+		err = fmt.Errorf("cannot save snapshot: %w", err)
+		return
+	}
+
+	return
+}
+
+func (s *PostgresStore) LoadSnapshot(ctx context.Context, category, aggregateID string) (snapshot Snapshot, found bool, err error) {
+	var records []snapshotRecord
+	err = s.db.WithContext(ctx).
+		Where("category = ? AND aggregate_id = ?", category, aggregateID).
+		Limit(1).
+		Find(&records).Error
+	if err != nil {
+		err = fmt.Errorf("cannot load snapshot: %w", err)
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	r := records[0]
+	snapshot = Snapshot{
+		Category:    r.Category,
+		AggregateID: r.AggregateID,
+		Version:     r.Version,
+		Timestamp:   r.Timestamp,
+		Schema:      r.Schema,
+		State:       r.State,
+	}
+	found = true
+
+	return
+}
+
+func (r *eventRecord) toEventDB() (eventDB EventDB, err error) {
+	eventDB = EventDB{
+		Position:    r.Position,
+		AggregateID: r.AggregateID,
+		Version:     r.Version,
+		Timestamp:   r.Timestamp,
+		Category:    r.Category,
+		Type:        r.Type,
+		Data:        bson.M{},
+		Unpublished: r.Unpublished,
+		Metadata: Metadata{
+			EventID:       r.EventID,
+			CorrelationID: r.CorrelationID,
+			CausationID:   r.CausationID,
+			Actor:         r.Actor,
+			SchemaVersion: r.SchemaVersion,
+		},
+	}
+	if r.OccurredAt != nil {
+		eventDB.Metadata.OccurredAt = r.OccurredAt.UTC()
+	}
+
+	err = bson.Unmarshal(r.Data, &eventDB.Data)
+	if err != nil {
+		err = fmt.Errorf("cannot decode event data: %w", err)
+		return
+	}
+
+	return
+}
+
+// dbFor returns the transaction of ctx, if any, so that the events are appended together with the write model
+func (s *PostgresStore) dbFor(ctx context.Context) *gorm.DB {
+	if tx, ok := TransactionFromContext(ctx); ok {
+		return tx.WithContext(ctx)
+	}
+
+	return s.db.WithContext(ctx)
+}
+
+// isConflictError reports whether err is caused by a concurrent write to the same aggregate
+func isConflictError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	return pgErr.Code == pgUniqueViolation || pgErr.Code == pgSerializationFailure
+}
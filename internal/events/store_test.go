@@ -0,0 +1,578 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongo_options "go.mongodb.org/mongo-driver/mongo/options"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The stores backed by a database are tested only when the database is given by the environment.
+// The tests remove all the events stored in it, so it must not be used for anything else.
+const (
+	envTestPostgresDSN = "SNACK_TEST_POSTGRES_DSN"
+	envTestMongoURI    = "SNACK_TEST_MONGO_URI"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return NewMemoryStore()
+	})
+
+	if SharesTransactions(NewMemoryStore(), &gorm.DB{Config: &gorm.Config{}}) {
+		t.Error("memory store shares the database transactions")
+	}
+}
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv(envTestPostgresDSN)
+	if dsn == "" {
+		t.Skipf("%s not set", envTestPostgresDSN)
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("cannot connect to postgres: %v", err)
+	}
+
+	testStore(t, func(t *testing.T) Store {
+		// the first store creates the tables, the second one the position counter of the emptied store
+		_, err := NewPostgresStore(db)
+		if err != nil {
+			t.Fatalf("cannot create store: %v", err)
+		}
+		err = db.Exec("TRUNCATE events, snapshots, event_positions").Error
+		if err != nil {
+			t.Fatalf("cannot clean up store: %v", err)
+		}
+		store, err := NewPostgresStore(db)
+		if err != nil {
+			t.Fatalf("cannot create store: %v", err)
+		}
+
+		return store
+	})
+
+	t.Run("SharesTransactions", func(t *testing.T) {
+		store, err := NewPostgresStore(db)
+		if err != nil {
+			t.Fatalf("cannot create store: %v", err)
+		}
+		other, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			t.Fatalf("cannot connect to postgres: %v", err)
+		}
+
+		if !SharesTransactions(store, db.Session(&gorm.Session{})) {
+			t.Error("store doesn't share the transactions of its database")
+		}
+		if SharesTransactions(store, other) {
+			t.Error("store shares the transactions of another connection")
+		}
+	})
+}
+
+func TestMongoStore(t *testing.T) {
+	uri := os.Getenv(envTestMongoURI)
+	if uri == "" {
+		t.Skipf("%s not set", envTestMongoURI)
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, mongo_options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("cannot connect to mongo: %v", err)
+	}
+	defer client.Disconnect(ctx)
+
+	testStore(t, func(t *testing.T) Store {
+		db := client.Database(fmt.Sprintf("night_snack_test_%d", time.Now().UnixNano()))
+		t.Cleanup(func() { db.Drop(context.Background()) })
+
+		store, err := NewMongoStore(db)
+		if err != nil {
+			t.Fatalf("cannot create store: %v", err)
+		}
+
+		return store
+	})
+}
+
+// testStore checks that the store behaves as described by the Store interface.
+// newStore has to return an empty store.
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	t.Run("Append", func(t *testing.T) { testStoreAppend(t, newStore(t)) })
+	t.Run("Conflict", func(t *testing.T) { testStoreConflict(t, newStore(t)) })
+	t.Run("ConcurrentAppend", func(t *testing.T) { testStoreConcurrentAppend(t, newStore(t)) })
+	t.Run("LoadFrom", func(t *testing.T) { testStoreLoadFrom(t, newStore(t)) })
+	t.Run("Stream", func(t *testing.T) { testStoreStream(t, newStore(t)) })
+	t.Run("ReadAll", func(t *testing.T) { testStoreReadAll(t, newStore(t)) })
+	t.Run("ReadAllWhileAppending", func(t *testing.T) { testStoreReadAllWhileAppending(t, newStore(t)) })
+	t.Run("Outbox", func(t *testing.T) { testStoreOutbox(t, newStore(t)) })
+	t.Run("Snapshots", func(t *testing.T) { testStoreSnapshots(t, newStore(t)) })
+}
+
+func newTestEvents(n int) []EventDB {
+	eventsDB := make([]EventDB, n)
+	for i := range eventsDB {
+		occurredAt := time.Now().UTC().Truncate(time.Millisecond)
+		eventsDB[i] = EventDB{
+			Timestamp: occurredAt,
+			Type:      "happened",
+			Data:      bson.M{"n": int32(i), "note": fmt.Sprintf("event %d", i)},
+			Metadata: Metadata{
+				EventID:       fmt.Sprintf("event-%d-%d", occurredAt.UnixNano(), i),
+				OccurredAt:    occurredAt,
+				CorrelationID: "correlation",
+				CausationID:   "causation",
+				Actor:         "user:test",
+				SchemaVersion: DefaultSchemaVersion,
+			},
+			Unpublished: true,
+		}
+	}
+
+	return eventsDB
+}
+
+func mustAppend(t *testing.T, store Store, category, aggregateID string, n, expectedVersion int) {
+	t.Helper()
+
+	err := store.Append(context.Background(), category, aggregateID, newTestEvents(n), expectedVersion)
+	if err != nil {
+		t.Fatalf("cannot append to %s/%s: %v", category, aggregateID, err)
+	}
+}
+
+func testStoreAppend(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	aggregate, err := store.Load(ctx, "test", "missing")
+	if err != nil {
+		t.Fatalf("cannot load missing aggregate: %v", err)
+	}
+	if aggregate.Version != 0 || len(aggregate.Events) != 0 {
+		t.Fatalf("missing aggregate is at version %d with %d events", aggregate.Version, len(aggregate.Events))
+	}
+
+	appended := newTestEvents(3)
+	err = store.Append(ctx, "test", "a", appended[:2], 0)
+	if err != nil {
+		t.Fatalf("cannot append: %v", err)
+	}
+	err = store.Append(ctx, "test", "a", appended[2:], 2)
+	if err != nil {
+		t.Fatalf("cannot append: %v", err)
+	}
+
+	aggregate, err = store.Load(ctx, "test", "a")
+	if err != nil {
+		t.Fatalf("cannot load: %v", err)
+	}
+	if aggregate.ID != "a" || aggregate.Category != "test" || aggregate.Version != 3 || len(aggregate.Events) != 3 {
+		t.Fatalf("loaded %s/%s at version %d with %d events", aggregate.Category, aggregate.ID, aggregate.Version, len(aggregate.Events))
+	}
+
+	var lastPosition int64
+	for i, eventDB := range aggregate.Events {
+		if eventDB.Version != i+1 || eventDB.AggregateID != "a" || eventDB.Category != "test" || eventDB.Type != "happened" {
+			t.Fatalf("event %d loaded as %s/%s %s version %d", i, eventDB.Category, eventDB.AggregateID, eventDB.Type, eventDB.Version)
+		}
+		if eventDB.Position <= lastPosition {
+			t.Fatalf("event %d is at position %d after %d", i, eventDB.Position, lastPosition)
+		}
+		lastPosition = eventDB.Position
+
+		if !eventDB.Timestamp.Equal(appended[i].Timestamp) {
+			t.Fatalf("event %d has timestamp %s instead of %s", i, eventDB.Timestamp, appended[i].Timestamp)
+		}
+		if n, _ := eventDB.Data["n"].(int32); n != int32(i) {
+			t.Fatalf("event %d has data %v", i, eventDB.Data)
+		}
+		metadata, expected := eventDB.Metadata, appended[i].Metadata
+		if !metadata.OccurredAt.Equal(expected.OccurredAt) {
+			t.Fatalf("event %d occurred at %s instead of %s", i, metadata.OccurredAt, expected.OccurredAt)
+		}
+		metadata.OccurredAt = expected.OccurredAt
+		if metadata != expected {
+			t.Fatalf("event %d has metadata %+v instead of %+v", i, metadata, expected)
+		}
+	}
+}
+
+func testStoreConflict(t *testing.T, store Store) {
+	ctx := context.Background()
+	mustAppend(t, store, "test", "a", 2, 0)
+
+	for _, expectedVersion := range []int{0, 1, 3} {
+		err := store.Append(ctx, "test", "a", newTestEvents(1), expectedVersion)
+		if !errors.Is(err, ErrConcurrencyConflict) {
+			t.Fatalf("append at version %d: expected conflict, got %v", expectedVersion, err)
+		}
+	}
+
+	aggregate, err := store.Load(ctx, "test", "a")
+	if err != nil {
+		t.Fatalf("cannot load: %v", err)
+	}
+	if aggregate.Version != 2 || len(aggregate.Events) != 2 {
+		t.Fatalf("conflicting appends changed the aggregate to version %d with %d events", aggregate.Version, len(aggregate.Events))
+	}
+
+	// the versions are per aggregate
+	mustAppend(t, store, "test", "b", 1, 0)
+	mustAppend(t, store, "other", "a", 1, 0)
+}
+
+func testStoreConcurrentAppend(t *testing.T, store Store) {
+	ctx := context.Background()
+	mustAppend(t, store, "test", "a", 1, 0)
+
+	const writers = 8
+	errs := make([]error, writers)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = store.Append(ctx, "test", "a", newTestEvents(2), 1)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	appended := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			appended++
+		case !errors.Is(err, ErrConcurrencyConflict):
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if appended != 1 {
+		t.Fatalf("%d concurrent appends succeeded, expected exactly 1", appended)
+	}
+
+	aggregate, err := store.Load(ctx, "test", "a")
+	if err != nil {
+		t.Fatalf("cannot load: %v", err)
+	}
+	if aggregate.Version != 3 || len(aggregate.Events) != 3 {
+		t.Fatalf("aggregate is at version %d with %d events, expected 3 and 3", aggregate.Version, len(aggregate.Events))
+	}
+}
+
+func testStoreLoadFrom(t *testing.T, store Store) {
+	ctx := context.Background()
+	mustAppend(t, store, "test", "a", 5, 0)
+
+	for version := 0; version <= 5; version++ {
+		aggregate, err := store.LoadFrom(ctx, "test", "a", version)
+		if err != nil {
+			t.Fatalf("cannot load from version %d: %v", version, err)
+		}
+		if aggregate.Version != 5 {
+			t.Fatalf("loaded from version %d at version %d, expected 5", version, aggregate.Version)
+		}
+		if len(aggregate.Events) != 5-version {
+			t.Fatalf("loaded %d events from version %d, expected %d", len(aggregate.Events), version, 5-version)
+		}
+		for i, eventDB := range aggregate.Events {
+			if eventDB.Version != version+i+1 {
+				t.Fatalf("event %d loaded from version %d has version %d", i, version, eventDB.Version)
+			}
+		}
+	}
+}
+
+func testStoreStream(t *testing.T, store Store) {
+	mustAppend(t, store, "test", "a", 2, 0)
+	mustAppend(t, store, "other", "x", 1, 0)
+	mustAppend(t, store, "test", "b", 3, 0)
+	mustAppend(t, store, "test", "a", 1, 2)
+
+	versions := map[string]int{}
+	err := store.Stream(context.Background(), "test", func(aggregate AggregateDB) error {
+		if _, ok := versions[aggregate.ID]; ok {
+			return fmt.Errorf("aggregate %s streamed twice", aggregate.ID)
+		}
+		if len(aggregate.Events) != aggregate.Version {
+			return fmt.Errorf("aggregate %s streamed at version %d with %d events", aggregate.ID, aggregate.Version, len(aggregate.Events))
+		}
+		for i, eventDB := range aggregate.Events {
+			if eventDB.Category != "test" || eventDB.AggregateID != aggregate.ID || eventDB.Version != i+1 {
+				return fmt.Errorf("aggregate %s streamed with event %s/%s version %d", aggregate.ID, eventDB.Category, eventDB.AggregateID, eventDB.Version)
+			}
+		}
+		versions[aggregate.ID] = aggregate.Version
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot stream: %v", err)
+	}
+	if len(versions) != 2 || versions["a"] != 3 || versions["b"] != 3 {
+		t.Fatalf("streamed aggregates at versions %v", versions)
+	}
+
+	failure := errors.New("failure")
+	err = store.Stream(context.Background(), "test", func(aggregate AggregateDB) error { return failure })
+	if !errors.Is(err, failure) {
+		t.Fatalf("stream didn't return the error of fn: %v", err)
+	}
+}
+
+func testStoreReadAll(t *testing.T, store Store) {
+	ctx := context.Background()
+	mustAppend(t, store, "test", "a", 2, 0)
+	mustAppend(t, store, "other", "x", 1, 0)
+	mustAppend(t, store, "test", "a", 1, 2)
+
+	var read []EventDB
+	err := store.ReadAll(ctx, 0, func(eventDB EventDB) error {
+		read = append(read, eventDB)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot read all: %v", err)
+	}
+
+	expected := []struct {
+		category string
+		version  int
+	}{{"test", 1}, {"test", 2}, {"other", 1}, {"test", 3}}
+	if len(read) != len(expected) {
+		t.Fatalf("read %d events, expected %d", len(read), len(expected))
+	}
+	for i, eventDB := range read {
+		if eventDB.Category != expected[i].category || eventDB.Version != expected[i].version {
+			t.Fatalf("event %d read as %s version %d", i, eventDB.Category, eventDB.Version)
+		}
+		if i > 0 && eventDB.Position <= read[i-1].Position {
+			t.Fatalf("event %d read at position %d after %d", i, eventDB.Position, read[i-1].Position)
+		}
+	}
+
+	after := read[1].Position
+	var positions []int64
+	err = store.ReadAll(ctx, after, func(eventDB EventDB) error {
+		positions = append(positions, eventDB.Position)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot read all: %v", err)
+	}
+	if len(positions) != 2 || positions[0] != read[2].Position || positions[1] != read[3].Position {
+		t.Fatalf("read positions %v after %d", positions, after)
+	}
+}
+
+// testStoreReadAllWhileAppending reads the events like a projection, continuing after the last read position,
+// while they are being appended. No event may be skipped.
+func testStoreReadAllWhileAppending(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	const (
+		writers = 4
+		appends = 10
+	)
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(aggregateID string) {
+			defer wg.Done()
+			for version := 0; version < appends; version++ {
+				err := store.Append(ctx, "test", aggregateID, newTestEvents(1), version)
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(fmt.Sprintf("aggregate-%d", i))
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	read := map[string]int{}
+	var checkpoint int64
+	readNew := func() {
+		t.Helper()
+
+		err := store.ReadAll(ctx, checkpoint, func(eventDB EventDB) error {
+			if eventDB.Version != read[eventDB.AggregateID]+1 {
+				return fmt.Errorf("read version %d of %s after version %d", eventDB.Version, eventDB.AggregateID, read[eventDB.AggregateID])
+			}
+			read[eventDB.AggregateID] = eventDB.Version
+			checkpoint = eventDB.Position
+
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("cannot read all: %v", err)
+		}
+	}
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		readNew()
+	}
+
+	close(errs)
+	for err := range errs {
+		t.Fatalf("cannot append: %v", err)
+	}
+	for i := 0; i < writers; i++ {
+		aggregateID := fmt.Sprintf("aggregate-%d", i)
+		if read[aggregateID] != appends {
+			t.Fatalf("read %d events of %s, expected %d", read[aggregateID], aggregateID, appends)
+		}
+	}
+}
+
+func testStoreOutbox(t *testing.T, store Store) {
+	ctx := context.Background()
+	mustAppend(t, store, "test", "a", 3, 0)
+	published := newTestEvents(1)
+	published[0].Unpublished = false
+	err := store.Append(ctx, "test", "b", published, 0)
+	if err != nil {
+		t.Fatalf("cannot append: %v", err)
+	}
+
+	readUnpublished := func(limit int) (positions []int64) {
+		t.Helper()
+
+		err := store.ReadUnpublished(ctx, limit, func(eventDB EventDB) error {
+			if !eventDB.Unpublished || eventDB.AggregateID != "a" {
+				return fmt.Errorf("read %s as unpublished", eventDB.AggregateID)
+			}
+			positions = append(positions, eventDB.Position)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("cannot read unpublished: %v", err)
+		}
+
+		return
+	}
+
+	positions := readUnpublished(2)
+	if len(positions) != 2 || positions[0] >= positions[1] {
+		t.Fatalf("read unpublished positions %v", positions)
+	}
+
+	err = store.MarkPublished(ctx, positions...)
+	if err != nil {
+		t.Fatalf("cannot mark published: %v", err)
+	}
+	remaining := readUnpublished(10)
+	if len(remaining) != 1 || remaining[0] <= positions[1] {
+		t.Fatalf("remaining unpublished positions %v", remaining)
+	}
+
+	err = store.MarkPublished(ctx, remaining...)
+	if err != nil {
+		t.Fatalf("cannot mark published: %v", err)
+	}
+	if remaining = readUnpublished(10); len(remaining) != 0 {
+		t.Fatalf("remaining unpublished positions %v", remaining)
+	}
+}
+
+func testStoreSnapshots(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	_, found, err := store.LoadSnapshot(ctx, "test", "a")
+	if err != nil || found {
+		t.Fatalf("missing snapshot loaded: found %t, error %v", found, err)
+	}
+
+	for version := 1; version <= 2; version++ {
+		state, err := bson.Marshal(bson.M{"n": int32(version)})
+		if err != nil {
+			t.Fatalf("cannot encode state: %v", err)
+		}
+		err = store.SaveSnapshot(ctx, Snapshot{
+			Category:    "test",
+			AggregateID: "a",
+			Version:     version,
+			Timestamp:   time.Now().UTC().Truncate(time.Millisecond),
+			Schema:      "schema",
+			State:       state,
+		})
+		if err != nil {
+			t.Fatalf("cannot save snapshot: %v", err)
+		}
+	}
+
+	snapshot, found, err := store.LoadSnapshot(ctx, "test", "a")
+	if err != nil || !found {
+		t.Fatalf("snapshot not loaded: found %t, error %v", found, err)
+	}
+	if snapshot.Category != "test" || snapshot.AggregateID != "a" || snapshot.Version != 2 || snapshot.Schema != "schema" {
+		t.Fatalf("loaded snapshot %+v", snapshot)
+	}
+	var state struct {
+		N int32 `bson:"n"`
+	}
+	err = bson.Unmarshal(snapshot.State, &state)
+	if err != nil || state.N != 2 {
+		t.Fatalf("loaded snapshot state %v, error %v", state, err)
+	}
+
+	_, found, err = store.LoadSnapshot(ctx, "test", "b")
+	if err != nil || found {
+		t.Fatalf("snapshot of another aggregate loaded: found %t, error %v", found, err)
+	}
+}
+
+func TestLoadedFrom(t *testing.T) {
+	stored := func(versions ...int) (eventsDB []EventDB) {
+		for _, version := range versions {
+			eventsDB = append(eventsDB, EventDB{Version: version})
+		}
+		return
+	}
+
+	cases := []struct {
+		name     string
+		version  int
+		stored   []EventDB
+		expected int
+		events   int
+	}{
+		{"missing aggregate", 0, nil, 0, 0},
+		{"whole aggregate", 0, stored(1, 2, 3), 3, 3},
+		{"after snapshot", 2, stored(2, 3, 4), 4, 2},
+		{"at snapshot", 3, stored(3), 3, 0},
+		{"appended during the query", 1, stored(1, 2, 4), 2, 1},
+	}
+	for _, c := range cases {
+		aggregate := loadedFrom("test", "a", c.version, c.stored)
+		if aggregate.Version != c.expected || len(aggregate.Events) != c.events {
+			t.Errorf("%s: loaded at version %d with %d events, expected %d and %d", c.name, aggregate.Version, len(aggregate.Events), c.expected, c.events)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+)
+
+var (
+	// ErrKeyReused is returned when the key was used by a request with a different fingerprint
+	ErrKeyReused = errors.New("idempotency key was used for a different request")
+	// ErrInProgress is returned when the request with the key has not finished yet
+	ErrInProgress = errors.New("request with the idempotency key is in progress")
+	// ErrReservationLost is returned when the request held the key for longer than the lock timeout and another request reserved it
+	ErrReservationLost = errors.New("idempotency key reservation was lost")
+)
+
+// Record is a request identified by its idempotency key, along with its result once it has completed
+type Record struct {
+	// Scope separates the keys of different operations, e.g. the gRPC method
+	Scope string `bson:"scope"`
+	Key   string `bson:"key"`
+	// Fingerprint identifies the content of the request
+	Fingerprint string `bson:"fingerprint"`
+	// Token identifies the reservation of the key, only the request holding it completes or releases the record
+	Token string `bson:"token"`
+
+	// Completed is false while the request is being processed
+	Completed  bool   `bson:"completed"`
+	StatusCode int    `bson:"status_code,omitempty"`
+	Response   []byte `bson:"response,omitempty"`
+
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Store persists the records of the requests
+type Store interface {
+	// Reserve stores the record unless there is an unexpired record with the same scope and key, which is returned instead
+	Reserve(ctx context.Context, record Record) (existing *Record, err error)
+	// Complete stores the result of the record reserved with the token and extends its expiration.
+	// ErrReservationLost is returned when the record is not reserved with the token anymore.
+	Complete(ctx context.Context, scope, key, token string, statusCode int, response []byte, expiresAt time.Time) error
+	// Release removes the record reserved with the token so that the request can be retried with the same key
+	Release(ctx context.Context, scope, key, token string) error
+}
+
+// Reservation is the key reserved for a request by Begin
+type Reservation struct {
+	Scope string
+	Key   string
+	Token string
+}
+
+// Keys makes the requests with the same idempotency key and content to be processed only once.
+//
+// Only the successful results are kept, a failed request releases its key so that it can be retried.
+// A request which never finished, e.g. because of a crash, holds its key for the lock timeout.
+type Keys struct {
+	log   zerolog.Logger
+	store Store
+
+	// ttl is the time the results are kept for
+	ttl time.Duration
+	// lockTimeout is the time a request in progress holds its key for
+	lockTimeout time.Duration
+}
+
+func NewKeys(store Store, ttl, lockTimeout time.Duration, log zerolog.Logger) (k *Keys, err error) {
+	if ttl <= 0 {
+		err = fmt.Errorf("invalid idempotency key TTL: %s", ttl)
+		return
+	}
+	if lockTimeout <= 0 {
+		err = fmt.Errorf("invalid idempotency key lock timeout: %s", lockTimeout)
+		return
+	}
+
+	k = &Keys{
+		log:   log.With().Str("component", "idempotency/keys").Logger(),
+		store: store,
+
+		ttl:         ttl,
+		lockTimeout: lockTimeout,
+	}
+
+	return
+}
+
+// Begin reserves the key for the request. If a request with the same key and fingerprint has completed,
+// its record is returned and the request must not be processed again.
+// ErrKeyReused or ErrInProgress is returned when the key is used by another request.
+func (k *Keys) Begin(ctx context.Context, scope, key, fingerprint string) (reservation Reservation, completed *Record, err error) {
+	token, err := uuid.NewV4()
+	if err != nil {
+		err = fmt.Errorf("cannot generate reservation token: %w", err)
+		return
+	}
+
+	existing, err := k.store.Reserve(ctx, Record{
+		Scope:       scope,
+		Key:         key,
+		Fingerprint: fingerprint,
+		Token:       token.String(),
+		ExpiresAt:   time.Now().Add(k.lockTimeout),
+	})
+	if err != nil {
+		err = fmt.Errorf("cannot reserve idempotency key: %w", err)
+		return
+	}
+	if existing == nil {
+		reservation = Reservation{
+			Scope: scope,
+			Key:   key,
+			Token: token.String(),
+		}
+		return
+	}
+
+	switch {
+	case existing.Fingerprint != fingerprint:
+		err = fmt.Errorf("%w: %s", ErrKeyReused, key)
+	case !existing.Completed:
+		err = fmt.Errorf("%w: %s", ErrInProgress, key)
+	default:
+		k.log.Debug().Str("scope", scope).Str("key", key).Msg("replaying completed request")
+		completed = existing
+	}
+
+	return
+}
+
+// Complete stores the result of the request, it is returned for the repeated requests until the TTL expires
+func (k *Keys) Complete(ctx context.Context, reservation Reservation, statusCode int, response []byte) (err error) {
+	err = k.store.Complete(ctx, reservation.Scope, reservation.Key, reservation.Token, statusCode, response, time.Now().Add(k.ttl))
+	if err != nil {
+		err = fmt.Errorf("cannot store result of request with idempotency key %s: %w", reservation.Key, err)
+	}
+
+	return
+}
+
+// Release frees the key of a failed request, the key reserved by another request in the meantime is kept
+func (k *Keys) Release(ctx context.Context, reservation Reservation) (err error) {
+	err = k.store.Release(ctx, reservation.Scope, reservation.Key, reservation.Token)
+	if err != nil {
+		err = fmt.Errorf("cannot release idempotency key %s: %w", reservation.Key, err)
+	}
+
+	return
+}
+
+// Fingerprint returns the hash of the parts of a request
+func Fingerprint(parts ...[]byte) string {
+	h := sha256.New()
+	for _, part := range parts {
+		// the length keeps the parts from running into each other
+		fmt.Fprintf(h, "%d:", len(part))
+		h.Write(part)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,202 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongo_options "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const envTestMongoURI = "SNACK_TEST_MONGO_URI"
+
+func TestMemoryStore(t *testing.T) {
+	testKeys(t, func(t *testing.T) Store {
+		return NewMemoryStore()
+	})
+}
+
+func TestMongoStore(t *testing.T) {
+	uri := os.Getenv(envTestMongoURI)
+	if uri == "" {
+		t.Skipf("%s not set", envTestMongoURI)
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, mongo_options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("cannot connect to mongo: %v", err)
+	}
+	defer client.Disconnect(ctx)
+
+	testKeys(t, func(t *testing.T) Store {
+		db := client.Database(fmt.Sprintf("night_snack_test_%d", time.Now().UnixNano()))
+		t.Cleanup(func() { db.Drop(context.Background()) })
+
+		store, err := NewMongoStore(db)
+		if err != nil {
+			t.Fatalf("cannot create store: %v", err)
+		}
+
+		return store
+	})
+}
+
+// testKeys checks the keys backed by the store created by newStore
+func testKeys(t *testing.T, newStore func(t *testing.T) Store) {
+	newKeys := func(t *testing.T, lockTimeout time.Duration) *Keys {
+		keys, err := NewKeys(newStore(t), time.Hour, lockTimeout, zerolog.Nop())
+		if err != nil {
+			t.Fatalf("cannot create keys: %v", err)
+		}
+
+		return keys
+	}
+	ctx := context.Background()
+
+	t.Run("Replay", func(t *testing.T) {
+		keys := newKeys(t, time.Minute)
+
+		reservation, completed, err := keys.Begin(ctx, "scope", "key", "a")
+		if err != nil || completed != nil {
+			t.Fatalf("first request got %v, %v, expected to be processed", completed, err)
+		}
+		err = keys.Complete(ctx, reservation, 201, []byte("response"))
+		if err != nil {
+			t.Fatalf("cannot complete request: %v", err)
+		}
+
+		_, completed, err = keys.Begin(ctx, "scope", "key", "a")
+		if err != nil {
+			t.Fatalf("repeated request failed: %v", err)
+		}
+		if completed == nil || completed.StatusCode != 201 || !bytes.Equal(completed.Response, []byte("response")) {
+			t.Fatalf("repeated request got %+v, expected the stored response", completed)
+		}
+
+		// the keys of different scopes don't collide
+		_, completed, err = keys.Begin(ctx, "other", "key", "b")
+		if err != nil || completed != nil {
+			t.Errorf("request of another scope got %v, %v, expected to be processed", completed, err)
+		}
+	})
+
+	t.Run("KeyReused", func(t *testing.T) {
+		keys := newKeys(t, time.Minute)
+
+		reservation, _, err := keys.Begin(ctx, "scope", "key", "a")
+		if err != nil {
+			t.Fatalf("first request failed: %v", err)
+		}
+		err = keys.Complete(ctx, reservation, 200, []byte("response"))
+		if err != nil {
+			t.Fatalf("cannot complete request: %v", err)
+		}
+
+		_, _, err = keys.Begin(ctx, "scope", "key", "b")
+		if !errors.Is(err, ErrKeyReused) {
+			t.Errorf("request with a different fingerprint got %v, expected %v", err, ErrKeyReused)
+		}
+	})
+
+	t.Run("InProgress", func(t *testing.T) {
+		keys := newKeys(t, time.Minute)
+
+		_, _, err := keys.Begin(ctx, "scope", "key", "a")
+		if err != nil {
+			t.Fatalf("first request failed: %v", err)
+		}
+
+		_, _, err = keys.Begin(ctx, "scope", "key", "a")
+		if !errors.Is(err, ErrInProgress) {
+			t.Errorf("concurrent request got %v, expected %v", err, ErrInProgress)
+		}
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		keys := newKeys(t, time.Minute)
+
+		reservation, _, err := keys.Begin(ctx, "scope", "key", "a")
+		if err != nil {
+			t.Fatalf("first request failed: %v", err)
+		}
+		err = keys.Release(ctx, reservation)
+		if err != nil {
+			t.Fatalf("cannot release key: %v", err)
+		}
+
+		// the failed request can be retried, even with another body
+		reservation, completed, err := keys.Begin(ctx, "scope", "key", "b")
+		if err != nil || completed != nil {
+			t.Fatalf("retried request got %v, %v, expected to be processed", completed, err)
+		}
+		err = keys.Complete(ctx, reservation, 200, []byte("response"))
+		if err != nil {
+			t.Fatalf("cannot complete request: %v", err)
+		}
+
+		// a completed request is not released
+		err = keys.Release(ctx, reservation)
+		if err != nil {
+			t.Fatalf("cannot release key: %v", err)
+		}
+		_, completed, err = keys.Begin(ctx, "scope", "key", "b")
+		if err != nil || completed == nil {
+			t.Errorf("repeated request got %v, %v, expected the stored response", completed, err)
+		}
+	})
+
+	t.Run("LockTimeout", func(t *testing.T) {
+		keys := newKeys(t, 100*time.Millisecond)
+
+		stale, _, err := keys.Begin(ctx, "scope", "key", "a")
+		if err != nil {
+			t.Fatalf("first request failed: %v", err)
+		}
+		time.Sleep(150 * time.Millisecond)
+
+		// the request which didn't finish in time doesn't hold the key anymore
+		reservation, completed, err := keys.Begin(ctx, "scope", "key", "a")
+		if err != nil || completed != nil {
+			t.Fatalf("request after the lock timeout got %v, %v, expected to be processed", completed, err)
+		}
+
+		// and it cannot release nor complete the key of the request which reserved it afterwards
+		err = keys.Release(ctx, stale)
+		if err != nil {
+			t.Fatalf("cannot release key: %v", err)
+		}
+		err = keys.Complete(ctx, stale, 500, []byte("stale"))
+		if !errors.Is(err, ErrReservationLost) {
+			t.Errorf("stale request completed the key: %v, expected %v", err, ErrReservationLost)
+		}
+		_, _, err = keys.Begin(ctx, "scope", "key", "a")
+		if !errors.Is(err, ErrInProgress) {
+			t.Fatalf("repeated request got %v, expected %v", err, ErrInProgress)
+		}
+
+		err = keys.Complete(ctx, reservation, 200, []byte("response"))
+		if err != nil {
+			t.Fatalf("cannot complete request: %v", err)
+		}
+		_, completed, err = keys.Begin(ctx, "scope", "key", "a")
+		if err != nil || completed == nil || string(completed.Response) != "response" {
+			t.Errorf("repeated request got %v, %v, expected the response of the second request", completed, err)
+		}
+	})
+}
+
+func TestFingerprint(t *testing.T) {
+	if Fingerprint([]byte("ab"), []byte("c")) == Fingerprint([]byte("a"), []byte("bc")) {
+		t.Error("fingerprints of differently split parts are equal")
+	}
+	if Fingerprint([]byte("a")) != Fingerprint([]byte("a")) {
+		t.Error("fingerprints of the same parts differ")
+	}
+}
@@ -0,0 +1,68 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	_ Store = &MemoryStore{}
+)
+
+// MemoryStore keeps the records in memory of a single process, the expired records are replaced on reservation
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: map[string]Record{},
+	}
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, record Record) (existing *Record, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := recordID(record.Scope, record.Key)
+	if stored, ok := s.records[id]; ok && stored.ExpiresAt.After(time.Now()) {
+		existing = &stored
+		return
+	}
+	s.records[id] = record
+
+	return
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, scope, key, token string, statusCode int, response []byte, expiresAt time.Time) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := recordID(scope, key)
+	record, ok := s.records[id]
+	if !ok || record.Token != token || record.Completed {
+		err = ErrReservationLost
+		return
+	}
+	record.Completed = true
+	record.StatusCode = statusCode
+	record.Response = append([]byte{}, response...)
+	record.ExpiresAt = expiresAt
+	s.records[id] = record
+
+	return
+}
+
+func (s *MemoryStore) Release(ctx context.Context, scope, key, token string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := recordID(scope, key)
+	if record, ok := s.records[id]; ok && record.Token == token && !record.Completed {
+		delete(s.records, id)
+	}
+
+	return
+}
@@ -0,0 +1,107 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	_ Store = &MongoStore{}
+)
+
+const mongoKeysCollection = "idempotency_keys"
+
+// MongoStore keeps the records in the idempotency_keys collection, the expired ones are removed by a TTL index.
+// As the TTL monitor runs only periodically, the expired records which were not removed yet are ignored.
+type MongoStore struct {
+	keysCollection *mongo.Collection
+}
+
+type mongoRecord struct {
+	ID     string `bson:"_id"`
+	Record `bson:",inline"`
+}
+
+func NewMongoStore(mongoDB *mongo.Database) (s *MongoStore, err error) {
+	s = &MongoStore{
+		keysCollection: mongoDB.Collection(mongoKeysCollection),
+	}
+
+	_, err = s.keysCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0).SetName("expiration"),
+	})
+	if err != nil {
+		err = fmt.Errorf("cannot create idempotency keys index: %w", err)
+		return
+	}
+
+	return
+}
+
+func (s *MongoStore) Reserve(ctx context.Context, record Record) (existing *Record, err error) {
+	id := recordID(record.Scope, record.Key)
+	// the second attempt follows the removal of an expired record
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err = s.keysCollection.InsertOne(ctx, mongoRecord{ID: id, Record: record})
+		if err == nil || !mongo.IsDuplicateKeyError(err) {
+			return
+		}
+
+		stored := mongoRecord{}
+		err = s.keysCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&stored)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if stored.ExpiresAt.After(time.Now()) {
+			existing = &stored.Record
+			return
+		}
+
+		_, err = s.keysCollection.DeleteOne(ctx, bson.M{"_id": id, "expires_at": stored.ExpiresAt})
+		if err != nil {
+			return
+		}
+	}
+
+	err = fmt.Errorf("idempotency key %s is being reserved concurrently", record.Key)
+
+	return
+}
+
+func (s *MongoStore) Complete(ctx context.Context, scope, key, token string, statusCode int, response []byte, expiresAt time.Time) (err error) {
+	res, err := s.keysCollection.UpdateOne(ctx, bson.M{"_id": recordID(scope, key), "token": token, "completed": false}, bson.M{"$set": bson.M{
+		"completed":   true,
+		"status_code": statusCode,
+		"response":    response,
+		"expires_at":  expiresAt,
+	}})
+	if err != nil {
+		return
+	}
+	if res.MatchedCount == 0 {
+		err = ErrReservationLost
+		return
+	}
+
+	return
+}
+
+func (s *MongoStore) Release(ctx context.Context, scope, key, token string) (err error) {
+	_, err = s.keysCollection.DeleteOne(ctx, bson.M{"_id": recordID(scope, key), "token": token, "completed": false})
+
+	return
+}
+
+func recordID(scope, key string) string {
+	return scope + " " + key
+}
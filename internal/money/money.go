@@ -0,0 +1,93 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/sveatlo/night_snack/internal/events"
+	money_pb "github.com/sveatlo/night_snack/proto/money"
+)
+
+// DefaultCurrency is the currency of unset prices and of the menu items created before the prices were introduced
+const DefaultCurrency = "EUR"
+
+var (
+	// ErrInvalidCurrency is returned for currencies which are not ISO 4217 codes
+	ErrInvalidCurrency = errors.New("invalid currency")
+	// ErrCurrencyMismatch is returned when adding amounts of different currencies
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+)
+
+var currencyRegexp = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Money is an amount in the minor units of the currency, e.g. cents
+type Money struct {
+	Amount   int64  `bson:"amount" json:"amount"`
+	Currency string `bson:"currency" json:"currency"`
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// NewFromProto returns the money from m. Unset money is zero in the default currency.
+func NewFromProto(m *money_pb.Money) Money {
+	if m == nil {
+		return New(0, DefaultCurrency)
+	}
+
+	return New(m.GetAmount(), m.GetCurrency())
+}
+
+// NewFromData reads the money from the data of a stored event
+func NewFromData(r *events.DataReader) Money {
+	return New(r.Int64("amount"), r.String("currency"))
+}
+
+func (m Money) ToProto() *money_pb.Money {
+	return &money_pb.Money{
+		Amount:   m.Amount,
+		Currency: m.Currency,
+	}
+}
+
+// Validate checks that the currency is an ISO 4217 code
+func (m Money) Validate() error {
+	if !currencyRegexp.MatchString(m.Currency) {
+		return fmt.Errorf("%w: %q", ErrInvalidCurrency, m.Currency)
+	}
+
+	return nil
+}
+
+// Add returns the sum of the amounts, which have to be in the same currency
+func (m Money) Add(other Money) (sum Money, err error) {
+	if m.Currency != other.Currency {
+		err = fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+		return
+	}
+
+	sum = New(m.Amount+other.Amount, m.Currency)
+
+	return
+}
+
+// Mul returns the amount multiplied by n
+func (m Money) Mul(n int64) Money {
+	return New(m.Amount*n, m.Currency)
+}
+
+// BasisPoints returns the given basis points (hundredths of a percent) of the amount, rounded half away from zero
+func (m Money) BasisPoints(bp int64) Money {
+	product := m.Amount * bp
+	if product < 0 {
+		return New((product-5000)/10000, m.Currency)
+	}
+
+	return New((product+5000)/10000, m.Currency)
+}
+
+func (m Money) String() string {
+	return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+}
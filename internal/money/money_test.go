@@ -0,0 +1,47 @@
+package money
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	sum, err := New(1250, "EUR").Add(New(300, "EUR"))
+	if err != nil || sum != New(1550, "EUR") {
+		t.Errorf("got %s, %v, expected 1550 EUR", sum, err)
+	}
+
+	_, err = New(1250, "EUR").Add(New(300, "USD"))
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("got %v, expected %v", err, ErrCurrencyMismatch)
+	}
+}
+
+func TestBasisPoints(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		bp       int64
+		expected int64
+	}{
+		{2500, 2100, 525},
+		{30, 1500, 5},
+		{29, 1500, 4},
+		{-30, 1500, -5},
+		{-29, 1500, -4},
+		{1000, 0, 0},
+	}
+	for _, test := range tests {
+		if result := New(test.amount, "EUR").BasisPoints(test.bp); result != New(test.expected, "EUR") {
+			t.Errorf("%d bp of %d is %s, expected %d EUR", test.bp, test.amount, result, test.expected)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for currency, valid := range map[string]bool{"EUR": true, "CZK": true, "eur": false, "EURO": false, "": false} {
+		err := New(100, currency).Validate()
+		if valid != (err == nil) || (!valid && !errors.Is(err, ErrInvalidCurrency)) {
+			t.Errorf("currency %q validated with %v", currency, err)
+		}
+	}
+}
@@ -4,61 +4,167 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"google.golang.org/protobuf/proto"
 
+	"github.com/sveatlo/night_snack/internal/customer"
 	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/money"
 	"github.com/sveatlo/night_snack/internal/restaurant"
 	orders_pb "github.com/sveatlo/night_snack/proto/orders"
-	restaurant_pb "github.com/sveatlo/night_snack/proto/restaurant"
 )
 
 var (
 	_ events.Event = &EventOrderCreated{}
 )
 
+func init() {
+	events.Register(
+		events.EventType{
+			Event:         &EventOrderCreated{},
+			Proto:         &orders_pb.OrderCreated{},
+			FromData:      func(data bson.M) (events.Event, error) { return EventOrderCreatedFromData(data) },
+			FromProto:     func(msg proto.Message) events.Event { return EventOrderCreatedFromProto(msg.(*orders_pb.OrderCreated)) },
+			SchemaVersion: 4,
+			Upcasters: map[int]events.Upcaster{
+				// the items were ordered one piece each without any notes or modifiers
+				1: func(data bson.M) (bson.M, error) {
+					items, _ := data["items"].(bson.A)
+					lines := bson.A{}
+					for _, item := range items {
+						lines = append(lines, bson.M{
+							"item":      item,
+							"quantity":  int32(1),
+							"note":      "",
+							"modifiers": bson.A{},
+						})
+					}
+					delete(data, "items")
+					data["lines"] = lines
+
+					return data, nil
+				},
+				// the menu items had no prices and the orders had no totals
+				2: func(data bson.M) (bson.M, error) {
+					zero := func() bson.M { return bson.M{"amount": int64(0), "currency": money.DefaultCurrency} }
+					lines, _ := data["lines"].(bson.A)
+					for _, line := range lines {
+						lineData, _ := line.(bson.M)
+						if item, ok := lineData["item"].(bson.M); ok {
+							item["price"] = zero()
+						}
+					}
+					data["totals"] = bson.M{
+						"subtotal":     zero(),
+						"tax":          zero(),
+						"delivery_fee": zero(),
+						"total":        zero(),
+					}
+
+					return data, nil
+				},
+				// the orders were not linked to the customers and had no delivery address
+				3: func(data bson.M) (bson.M, error) {
+					data["customer_id"] = ""
+					data["delivery_address"] = nil
+
+					return data, nil
+				},
+			},
+		},
+		events.EventType{
+			Event:    &EventStatusUpdated{},
+			Proto:    &orders_pb.StatusUpdated{},
+			FromData: func(data bson.M) (events.Event, error) { return EventStatusUpdatedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventStatusUpdatedFromProto(msg.(*orders_pb.StatusUpdated))
+			},
+			SchemaVersion: 2,
+			Upcasters: map[int]events.Upcaster{
+				// the previous status was not recorded in v1
+				1: func(data bson.M) (bson.M, error) {
+					data["previous_status"] = ""
+					return data, nil
+				},
+			},
+		},
+		events.EventType{
+			Event:    &EventOrderCancelled{},
+			Proto:    &orders_pb.OrderCancelled{},
+			FromData: func(data bson.M) (events.Event, error) { return EventOrderCancelledFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventOrderCancelledFromProto(msg.(*orders_pb.OrderCancelled))
+			},
+		},
+	)
+}
+
 type EventOrderCreated struct {
+	events.Envelope `bson:"-" json:"-"`
+
 	ID         string
 	Restaurant *restaurant.Restaurant
-	Items      []*restaurant.MenuItem
+	Lines      []*Line
 	Status     string
+	Totals     Totals
+	CustomerID string
+	// DeliveryAddress is the address of the customer at the time of ordering, nil if none was given
+	DeliveryAddress *customer.Address
 }
 
 func EventOrderCreatedFromProto(cmd *orders_pb.OrderCreated) *EventOrderCreated {
-	items := make([]*restaurant.MenuItem, len(cmd.Items))
-	for i, item := range cmd.Items {
-		items[i] = restaurant.NewMenuItemFromProto(item)
+	lines := make([]*Line, len(cmd.Lines))
+	for i, line := range cmd.Lines {
+		lines[i] = NewLineFromProto(line)
 	}
 
-	return &EventOrderCreated{
+	event := &EventOrderCreated{
 		ID:         cmd.Id,
 		Status:     cmd.Status.String(),
 		Restaurant: restaurant.NewRestaurantFromProto(cmd.Restaurant),
-		Items:      items,
+		Lines:      lines,
+		Totals:     NewTotalsFromProto(cmd.Totals),
+		CustomerID: cmd.CustomerId,
 	}
+	if cmd.DeliveryAddress != nil {
+		event.DeliveryAddress = customer.NewAddressFromProto(cmd.DeliveryAddress)
+	}
+
+	return event
 }
 
-func EventOrderCreatedFromData(data bson.M) *EventOrderCreated {
-	rData := data["restaurant"].(bson.M)
-	r := &restaurant.Restaurant{
-		ID:   rData["_id"].(string),
-		Name: rData["name"].(string),
+func EventOrderCreatedFromData(data bson.M) (e *EventOrderCreated, err error) {
+	r := events.NewDataReader(data)
+	restaurantData := r.Doc("restaurant")
+	e = &EventOrderCreated{
+		ID:     r.String("id"),
+		Status: r.String("status"),
+		Restaurant: &restaurant.Restaurant{
+			ID:   restaurantData.String("_id"),
+			Name: restaurantData.String("name"),
+		},
+		Lines:      []*Line{},
+		Totals:     newTotalsFromData(r.Doc("totals")),
+		CustomerID: r.String("customer_id"),
 	}
-	items := []*restaurant.MenuItem{}
-	itemsData := data["items"].(bson.A)
-	for _, itemDataR := range itemsData {
-		itemData := itemDataR.(bson.M)
-		item := &restaurant.MenuItem{
-			ID:             itemData["_id"].(string),
-			MenuCategoryID: itemData["category_id"].(string),
-			Name:           itemData["name"].(string),
-			Description:    itemData["description"].(string),
-		}
-		items = append(items, item)
+	for _, lineData := range r.Docs("lines") {
+		itemData := lineData.Doc("item")
+		e.Lines = append(e.Lines, &Line{
+			Item: &restaurant.MenuItem{
+				ID:             itemData.String("_id"),
+				MenuCategoryID: itemData.String("category_id"),
+				Name:           itemData.String("name"),
+				Description:    itemData.String("description"),
+				Price:          money.NewFromData(itemData.Doc("price")),
+			},
+			Quantity:  lineData.Int32("quantity"),
+			Note:      lineData.String("note"),
+			Modifiers: lineData.Strings("modifiers"),
+		})
 	}
-	return &EventOrderCreated{
-		ID:         data["id"].(string),
-		Status:     data["status"].(string),
-		Restaurant: r,
-		Items:      items,
+	if r.Has("delivery_address") {
+		e.DeliveryAddress = customer.NewAddressFromData(r.Doc("delivery_address"))
 	}
+	err = r.Err()
+
+	return
 }
 
 func (e *EventOrderCreated) EventCategory() string { return "order" }
@@ -69,40 +175,61 @@ func (e *EventOrderCreated) Data() bson.M {
 		"id":         e.ID,
 		"status":     e.Status,
 		"restaurant": e.Restaurant,
-		"items":      e.Items,
+		"lines":      e.Lines,
+		"totals":     e.Totals,
+
+		"customer_id":      e.CustomerID,
+		"delivery_address": e.DeliveryAddress,
 	}
 }
 func (e *EventOrderCreated) ToProto() proto.Message {
-	items := make([]*restaurant_pb.MenuItem, len(e.Items))
-	for i, mi := range e.Items {
-		items[i] = mi.ToProto()
+	lines := make([]*orders_pb.OrderItem, len(e.Lines))
+	for i, line := range e.Lines {
+		lines[i] = line.ToProto()
 	}
 
-	return &orders_pb.OrderCreated{
+	msg := &orders_pb.OrderCreated{
 		Id:         e.ID,
 		Restaurant: e.Restaurant.ToProto(),
-		Items:      items,
+		Lines:      lines,
 		Status:     orders_pb.OrderStatus(orders_pb.OrderStatus_value[e.Status]),
+		Totals:     e.Totals.ToProto(),
+		CustomerId: e.CustomerID,
 	}
+	if e.DeliveryAddress != nil {
+		msg.DeliveryAddress = e.DeliveryAddress.ToProto()
+	}
+
+	return msg
 }
 
 type EventStatusUpdated struct {
+	events.Envelope `bson:"-" json:"-"`
+
 	ID     string
 	Status string
+	// PreviousStatus is empty for the events stored before it was recorded
+	PreviousStatus string
 }
 
 func EventStatusUpdatedFromProto(cmd *orders_pb.StatusUpdated) *EventStatusUpdated {
 	return &EventStatusUpdated{
-		ID:     cmd.Id,
-		Status: cmd.Status.String(),
+		ID:             cmd.Id,
+		Status:         cmd.Status.String(),
+		PreviousStatus: cmd.PreviousStatus.String(),
 	}
 }
 
-func EventStatusUpdatedFromData(data bson.M) *EventStatusUpdated {
-	return &EventStatusUpdated{
-		ID:     data["id"].(string),
-		Status: data["status"].(string),
+func EventStatusUpdatedFromData(data bson.M) (e *EventStatusUpdated, err error) {
+	r := events.NewDataReader(data)
+	e = &EventStatusUpdated{
+		ID:             r.String("id"),
+		Status:         r.String("status"),
+		PreviousStatus: r.String("previous_status"),
 	}
+	err = r.Err()
+
+	return
 }
 
 func (e *EventStatusUpdated) EventCategory() string { return "order" }
@@ -110,13 +237,61 @@ func (e *EventStatusUpdated) EventType() string     { return "statusupdated" }
 func (e *EventStatusUpdated) AggregateID() string   { return e.ID }
 func (e *EventStatusUpdated) Data() bson.M {
 	return bson.M{
-		"id":     e.ID,
-		"status": e.Status,
+		"id":              e.ID,
+		"status":          e.Status,
+		"previous_status": e.PreviousStatus,
 	}
 }
 func (e *EventStatusUpdated) ToProto() proto.Message {
 	return &orders_pb.StatusUpdated{
-		Id:     e.ID,
-		Status: orders_pb.OrderStatus(orders_pb.OrderStatus_value[e.Status]),
+		Id:             e.ID,
+		Status:         orders_pb.OrderStatus(orders_pb.OrderStatus_value[e.Status]),
+		PreviousStatus: orders_pb.OrderStatus(orders_pb.OrderStatus_value[e.PreviousStatus]),
+	}
+}
+
+type EventOrderCancelled struct {
+	events.Envelope `bson:"-" json:"-"`
+
+	ID             string
+	Reason         string
+	PreviousStatus string
+}
+
+func EventOrderCancelledFromProto(cmd *orders_pb.OrderCancelled) *EventOrderCancelled {
+	return &EventOrderCancelled{
+		ID:             cmd.Id,
+		Reason:         cmd.Reason.String(),
+		PreviousStatus: cmd.PreviousStatus.String(),
+	}
+}
+
+func EventOrderCancelledFromData(data bson.M) (e *EventOrderCancelled, err error) {
+	r := events.NewDataReader(data)
+	e = &EventOrderCancelled{
+		ID:             r.String("id"),
+		Reason:         r.String("reason"),
+		PreviousStatus: r.String("previous_status"),
+	}
+	err = r.Err()
+
+	return
+}
+
+func (e *EventOrderCancelled) EventCategory() string { return "order" }
+func (e *EventOrderCancelled) EventType() string     { return "cancelled" }
+func (e *EventOrderCancelled) AggregateID() string   { return e.ID }
+func (e *EventOrderCancelled) Data() bson.M {
+	return bson.M{
+		"id":              e.ID,
+		"reason":          e.Reason,
+		"previous_status": e.PreviousStatus,
+	}
+}
+func (e *EventOrderCancelled) ToProto() proto.Message {
+	return &orders_pb.OrderCancelled{
+		Id:             e.ID,
+		Reason:         orders_pb.CancellationReason(orders_pb.CancellationReason_value[e.Reason]),
+		PreviousStatus: orders_pb.OrderStatus(orders_pb.OrderStatus_value[e.PreviousStatus]),
 	}
 }
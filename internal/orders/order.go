@@ -1,15 +1,79 @@
 package orders
 
 import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sveatlo/night_snack/internal/customer"
 	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/repository"
 	"github.com/sveatlo/night_snack/internal/restaurant"
+	orders_pb "github.com/sveatlo/night_snack/proto/orders"
 )
 
+// ErrIllegalTransition is returned when the order cannot move from its status to the requested one
+var ErrIllegalTransition = fmt.Errorf("illegal status transition: %w", repository.ErrFailedPrecondition)
+
+// statusTransitions lists the statuses an order can move to from each status.
+// DELIVERED, CANCELLED and REJECTED are final.
+var statusTransitions = map[orders_pb.OrderStatus][]orders_pb.OrderStatus{
+	orders_pb.OrderStatus_RECEIVED:         {orders_pb.OrderStatus_PROCESSING, orders_pb.OrderStatus_REJECTED, orders_pb.OrderStatus_CANCELLED},
+	orders_pb.OrderStatus_PROCESSING:       {orders_pb.OrderStatus_READY_FOR_PICKUP, orders_pb.OrderStatus_DELIVERY, orders_pb.OrderStatus_CANCELLED},
+	orders_pb.OrderStatus_READY_FOR_PICKUP: {orders_pb.OrderStatus_DELIVERY, orders_pb.OrderStatus_DELIVERED, orders_pb.OrderStatus_CANCELLED},
+	orders_pb.OrderStatus_DELIVERY:         {orders_pb.OrderStatus_DELIVERED},
+}
+
 type Order struct {
 	ID         string                 `bson:"_id"`
 	Status     string                 `bson:"status"`
 	Restaurant *restaurant.Restaurant `bson:"restaurant"`
-	Items      []*restaurant.MenuItem `bson:"items"`
+	Lines      []*Line                `bson:"lines"`
+	Totals     Totals                 `bson:"totals"`
+	CreatedAt  time.Time              `bson:"created_at"`
+	UpdatedAt  time.Time              `bson:"updated_at"`
+	CustomerID string                 `bson:"customer_id"`
+	// DeliveryAddress is the address of the customer at the time of ordering
+	DeliveryAddress *customer.Address `bson:"delivery_address,omitempty"`
+	// Transitions records every status change of the order
+	Transitions []StatusTransition `bson:"transitions,omitempty"`
+	// CancellationReason is set only for cancelled orders
+	CancellationReason string `bson:"cancellation_reason,omitempty"`
+}
+
+// Line is an ordered menu item along with its quantity, note and the chosen modifiers
+type Line struct {
+	Item      *restaurant.MenuItem `bson:"item"`
+	Quantity  int32                `bson:"quantity"`
+	Note      string               `bson:"note"`
+	Modifiers []string             `bson:"modifiers"`
+}
+
+func NewLineFromProto(line *orders_pb.OrderItem) *Line {
+	return &Line{
+		Item:      restaurant.NewMenuItemFromProto(line.GetItem()),
+		Quantity:  line.GetQuantity(),
+		Note:      line.GetNote(),
+		Modifiers: append([]string{}, line.GetModifiers()...),
+	}
+}
+
+func (l *Line) ToProto() *orders_pb.OrderItem {
+	return &orders_pb.OrderItem{
+		Item:      l.Item.ToProto(),
+		Quantity:  l.Quantity,
+		Note:      l.Note,
+		Modifiers: l.Modifiers,
+	}
+}
+
+// StatusTransition records who changed the status of the order and when
+type StatusTransition struct {
+	From  string    `bson:"from"`
+	To    string    `bson:"to"`
+	Actor string    `bson:"actor"`
+	At    time.Time `bson:"at"`
 }
 
 func NewFromEvents(events []events.Event) (s *Order) {
@@ -28,8 +92,162 @@ func (s *Order) ApplyEvent(event events.Event) {
 		s.ID = e.ID
 		s.Status = e.Status
 		s.Restaurant = e.Restaurant
-		s.Items = e.Items
+		s.Lines = e.Lines
+		s.Totals = e.Totals
+		s.CustomerID = e.CustomerID
+		s.DeliveryAddress = e.DeliveryAddress
+		s.CreatedAt = e.Metadata().OccurredAt
 	case *EventStatusUpdated:
-		s.Status = e.Status
+		s.transition(e.Status, e.Metadata())
+	case *EventOrderCancelled:
+		s.transition(orders_pb.OrderStatus_CANCELLED.String(), e.Metadata())
+		s.CancellationReason = e.Reason
+	}
+	s.UpdatedAt = event.Metadata().OccurredAt
+}
+
+func (s *Order) transition(status string, metadata events.Metadata) {
+	s.Transitions = append(s.Transitions, StatusTransition{
+		From:  s.Status,
+		To:    status,
+		Actor: metadata.Actor,
+		At:    metadata.OccurredAt,
+	})
+	s.Status = status
+}
+
+// CanTransitionTo reports whether the order can move from its current status to status
+func (s *Order) CanTransitionTo(status orders_pb.OrderStatus) bool {
+	current, ok := orders_pb.OrderStatus_value[s.Status]
+	if !ok {
+		return false
+	}
+
+	for _, next := range statusTransitions[orders_pb.OrderStatus(current)] {
+		if next == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UpdateStatus returns the event moving the order to status, or ErrIllegalTransition
+func (s *Order) UpdateStatus(status orders_pb.OrderStatus) (event *EventStatusUpdated, err error) {
+	if status == orders_pb.OrderStatus_CANCELLED {
+		// cancelling returns the items to the stock
+		err = fmt.Errorf("%w: orders can be cancelled only by the cancel command", ErrIllegalTransition)
+		return
+	}
+	if !s.CanTransitionTo(status) {
+		err = fmt.Errorf("%w: from %s to %s", ErrIllegalTransition, s.Status, status)
+		return
+	}
+
+	event = &EventStatusUpdated{
+		ID:             s.ID,
+		Status:         status.String(),
+		PreviousStatus: s.Status,
+	}
+
+	return
+}
+
+// IsCancelled reports whether the order has been cancelled
+func (s *Order) IsCancelled() bool {
+	return s.Status == orders_pb.OrderStatus_CANCELLED.String()
+}
+
+// Cancel returns the event cancelling the order, or ErrIllegalTransition if it cannot be cancelled in its status
+func (s *Order) Cancel(reason orders_pb.CancellationReason) (event *EventOrderCancelled, err error) {
+	if !s.CanTransitionTo(orders_pb.OrderStatus_CANCELLED) {
+		err = fmt.Errorf("%w: order cannot be cancelled in status %s", ErrIllegalTransition, s.Status)
+		return
+	}
+
+	event = &EventOrderCancelled{
+		ID:             s.ID,
+		Reason:         reason.String(),
+		PreviousStatus: s.Status,
+	}
+
+	return
+}
+
+// Cancellation returns the event which cancelled the order, nil if it isn't cancelled
+func (s *Order) Cancellation() (event *EventOrderCancelled) {
+	if !s.IsCancelled() {
+		return
+	}
+
+	event = &EventOrderCancelled{
+		ID:     s.ID,
+		Reason: s.CancellationReason,
+	}
+	if len(s.Transitions) > 0 {
+		event.PreviousStatus = s.Transitions[len(s.Transitions)-1].From
+	}
+
+	return
+}
+
+// IsRejected reports whether the order has been rejected
+func (s *Order) IsRejected() bool {
+	return s.Status == orders_pb.OrderStatus_REJECTED.String()
+}
+
+// Rejection returns the event which rejected the order, nil if it isn't rejected
+func (s *Order) Rejection() (event *EventStatusUpdated) {
+	if !s.IsRejected() {
+		return
+	}
+
+	event = &EventStatusUpdated{
+		ID:     s.ID,
+		Status: s.Status,
+	}
+	if len(s.Transitions) > 0 {
+		event.PreviousStatus = s.Transitions[len(s.Transitions)-1].From
+	}
+
+	return
+}
+
+func (s *Order) ToProto() *orders_pb.Order {
+	lines := make([]*orders_pb.OrderItem, len(s.Lines))
+	for i, line := range s.Lines {
+		lines[i] = line.ToProto()
+	}
+
+	o := &orders_pb.Order{
+		Id:        s.ID,
+		Lines:     lines,
+		Totals:    s.Totals.ToProto(),
+		Status:    orders_pb.OrderStatus(orders_pb.OrderStatus_value[s.Status]),
+		CreatedAt: timestamppb.New(s.CreatedAt),
+		UpdatedAt: timestamppb.New(s.UpdatedAt),
+
+		CancellationReason: orders_pb.CancellationReason(orders_pb.CancellationReason_value[s.CancellationReason]),
+		CustomerId:         s.CustomerID,
+	}
+	if s.Restaurant != nil {
+		o.Restaurant = s.Restaurant.ToProto()
+	}
+	if s.DeliveryAddress != nil {
+		o.DeliveryAddress = s.DeliveryAddress.ToProto()
+	}
+	for _, transition := range s.Transitions {
+		o.Transitions = append(o.Transitions, transition.ToProto())
+	}
+
+	return o
+}
+
+func (t *StatusTransition) ToProto() *orders_pb.StatusTransition {
+	return &orders_pb.StatusTransition{
+		From:  orders_pb.OrderStatus(orders_pb.OrderStatus_value[t.From]),
+		To:    orders_pb.OrderStatus(orders_pb.OrderStatus_value[t.To]),
+		Actor: t.Actor,
+		At:    timestamppb.New(t.At),
 	}
 }
@@ -0,0 +1,145 @@
+package orders
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
+
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/repository"
+	orders_pb "github.com/sveatlo/night_snack/proto/orders"
+)
+
+func TestUpdateStatus(t *testing.T) {
+	tests := []struct {
+		from    orders_pb.OrderStatus
+		to      orders_pb.OrderStatus
+		allowed bool
+	}{
+		{orders_pb.OrderStatus_RECEIVED, orders_pb.OrderStatus_PROCESSING, true},
+		{orders_pb.OrderStatus_RECEIVED, orders_pb.OrderStatus_REJECTED, true},
+		{orders_pb.OrderStatus_RECEIVED, orders_pb.OrderStatus_READY_FOR_PICKUP, false},
+		{orders_pb.OrderStatus_RECEIVED, orders_pb.OrderStatus_DELIVERED, false},
+		{orders_pb.OrderStatus_PROCESSING, orders_pb.OrderStatus_READY_FOR_PICKUP, true},
+		{orders_pb.OrderStatus_PROCESSING, orders_pb.OrderStatus_DELIVERY, true},
+		{orders_pb.OrderStatus_PROCESSING, orders_pb.OrderStatus_REJECTED, false},
+		{orders_pb.OrderStatus_PROCESSING, orders_pb.OrderStatus_RECEIVED, false},
+		{orders_pb.OrderStatus_READY_FOR_PICKUP, orders_pb.OrderStatus_DELIVERY, true},
+		{orders_pb.OrderStatus_READY_FOR_PICKUP, orders_pb.OrderStatus_DELIVERED, true},
+		{orders_pb.OrderStatus_READY_FOR_PICKUP, orders_pb.OrderStatus_PROCESSING, false},
+		{orders_pb.OrderStatus_DELIVERY, orders_pb.OrderStatus_DELIVERED, true},
+		{orders_pb.OrderStatus_DELIVERY, orders_pb.OrderStatus_READY_FOR_PICKUP, false},
+		{orders_pb.OrderStatus_DELIVERED, orders_pb.OrderStatus_DELIVERY, false},
+		{orders_pb.OrderStatus_DELIVERED, orders_pb.OrderStatus_DELIVERED, false},
+		{orders_pb.OrderStatus_REJECTED, orders_pb.OrderStatus_PROCESSING, false},
+		{orders_pb.OrderStatus_CANCELLED, orders_pb.OrderStatus_PROCESSING, false},
+		// cancelling is left to the cancel command, which returns the items to the stock
+		{orders_pb.OrderStatus_RECEIVED, orders_pb.OrderStatus_CANCELLED, false},
+		{orders_pb.OrderStatus_PROCESSING, orders_pb.OrderStatus_CANCELLED, false},
+	}
+	for _, test := range tests {
+		t.Run(test.from.String()+" to "+test.to.String(), func(t *testing.T) {
+			order := &Order{ID: "order", Status: test.from.String()}
+
+			event, err := order.UpdateStatus(test.to)
+			if !test.allowed {
+				if !errors.Is(err, ErrIllegalTransition) {
+					t.Fatalf("got %v, %v, expected %v", event, err, ErrIllegalTransition)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("transition failed: %v", err)
+			}
+			if event.ID != "order" || event.Status != test.to.String() || event.PreviousStatus != test.from.String() {
+				t.Errorf("unexpected event %+v", event)
+			}
+		})
+	}
+}
+
+func TestCancel(t *testing.T) {
+	tests := []struct {
+		from    orders_pb.OrderStatus
+		allowed bool
+	}{
+		{orders_pb.OrderStatus_RECEIVED, true},
+		{orders_pb.OrderStatus_PROCESSING, true},
+		{orders_pb.OrderStatus_READY_FOR_PICKUP, true},
+		{orders_pb.OrderStatus_DELIVERY, false},
+		{orders_pb.OrderStatus_DELIVERED, false},
+		{orders_pb.OrderStatus_REJECTED, false},
+		{orders_pb.OrderStatus_CANCELLED, false},
+	}
+	for _, test := range tests {
+		t.Run(test.from.String(), func(t *testing.T) {
+			order := &Order{ID: "order", Status: test.from.String()}
+
+			event, err := order.Cancel(orders_pb.CancellationReason_CUSTOMER_REQUEST)
+			if !test.allowed {
+				if !errors.Is(err, ErrIllegalTransition) {
+					t.Fatalf("got %v, %v, expected %v", event, err, ErrIllegalTransition)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("cancellation failed: %v", err)
+			}
+			if event.PreviousStatus != test.from.String() || event.Reason != orders_pb.CancellationReason_CUSTOMER_REQUEST.String() {
+				t.Errorf("unexpected event %+v", event)
+			}
+		})
+	}
+}
+
+func TestIllegalTransitionStatus(t *testing.T) {
+	delivered := &Order{ID: "order", Status: orders_pb.OrderStatus_DELIVERED.String()}
+
+	_, updateErr := delivered.UpdateStatus(orders_pb.OrderStatus_PROCESSING)
+	_, cancelErr := delivered.Cancel(orders_pb.CancellationReason_CUSTOMER_REQUEST)
+	for _, err := range []error{updateErr, cancelErr} {
+		// the HTTP gateway responds with 409 Conflict to FailedPrecondition
+		if code := grpc_status.Code(repository.StatusFromError(err)); code != codes.FailedPrecondition {
+			t.Errorf("%v converted to %s, expected %s", err, code, codes.FailedPrecondition)
+		}
+	}
+}
+
+func TestOrderTransitions(t *testing.T) {
+	at := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	withMetadata := func(event events.Event, actor string, minutes int) events.Event {
+		event.SetMetadata(events.Metadata{Actor: actor, OccurredAt: at.Add(time.Duration(minutes) * time.Minute)})
+		return event
+	}
+
+	order := NewFromEvents([]events.Event{
+		withMetadata(&EventOrderCreated{ID: "order", Status: orders_pb.OrderStatus_RECEIVED.String()}, "user:alice", 0),
+		withMetadata(&EventStatusUpdated{ID: "order", Status: orders_pb.OrderStatus_PROCESSING.String()}, "service:kitchen", 5),
+		withMetadata(&EventOrderCancelled{ID: "order", Reason: orders_pb.CancellationReason_CUSTOMER_REQUEST.String()}, "user:alice", 7),
+	})
+
+	if !order.IsCancelled() || order.CancellationReason != orders_pb.CancellationReason_CUSTOMER_REQUEST.String() {
+		t.Fatalf("order in %s with reason %q, expected cancelled on request", order.Status, order.CancellationReason)
+	}
+	expected := []StatusTransition{
+		{From: "RECEIVED", To: "PROCESSING", Actor: "service:kitchen", At: at.Add(5 * time.Minute)},
+		{From: "PROCESSING", To: "CANCELLED", Actor: "user:alice", At: at.Add(7 * time.Minute)},
+	}
+	if len(order.Transitions) != len(expected) {
+		t.Fatalf("got transitions %+v, expected %+v", order.Transitions, expected)
+	}
+	for i, transition := range expected {
+		if order.Transitions[i] != transition {
+			t.Errorf("transition %d is %+v, expected %+v", i, order.Transitions[i], transition)
+		}
+	}
+	if cancellation := order.Cancellation(); cancellation.PreviousStatus != "PROCESSING" {
+		t.Errorf("cancellation from %s, expected PROCESSING", cancellation.PreviousStatus)
+	}
+	if !order.UpdatedAt.Equal(at.Add(7 * time.Minute)) {
+		t.Errorf("order updated at %s, expected the cancellation time", order.UpdatedAt)
+	}
+}
@@ -0,0 +1,149 @@
+package orders
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
+
+	"github.com/sveatlo/night_snack/internal/customer"
+	"github.com/sveatlo/night_snack/internal/restaurant"
+	"github.com/sveatlo/night_snack/internal/saga"
+	restaurant_pb "github.com/sveatlo/night_snack/proto/restaurant"
+	stock_pb "github.com/sveatlo/night_snack/proto/stock"
+)
+
+// placementSaga is the name of the saga placing the orders, the ID of the saga is the ID of the order
+const placementSaga = "order_placement"
+
+// placementState is the state of the order placement saga
+type placementState struct {
+	RestaurantID string `bson:"restaurant_id"`
+	// Lines identify the items by their IDs until the restaurant confirms the order, the whole menu items are stored afterwards
+	Lines []*Line `bson:"lines"`
+	// ItemIDs are the items of the sagas started before the order lines, one piece each
+	ItemIDs []string `bson:"item_ids,omitempty"`
+	// CustomerID and DeliveryAddress are empty for the orders without a customer
+	CustomerID      string            `bson:"customer_id,omitempty"`
+	DeliveryAddress *customer.Address `bson:"delivery_address,omitempty"`
+
+	// Restaurant is set once the restaurant confirms the order
+	Restaurant *restaurant.Restaurant `bson:"restaurant,omitempty"`
+}
+
+// lines returns the lines of the order, including the ones of the sagas started with item IDs
+func (state *placementState) lines() []*Line {
+	if len(state.Lines) == 0 {
+		for _, itemID := range state.ItemIDs {
+			state.Lines = append(state.Lines, &Line{
+				Item:      &restaurant.MenuItem{ID: itemID},
+				Quantity:  1,
+				Modifiers: []string{},
+			})
+		}
+		state.ItemIDs = nil
+	}
+
+	return state.Lines
+}
+
+// placementDefinition drives a new order through reserving the stock, confirming it with the restaurant and creating it.
+// Every line is reserved in a single decrease with its own operation ID, so the reservations are neither repeated
+// when the saga is resumed nor released unless they were made.
+func (s *Service) placementDefinition() saga.Definition {
+	return saga.Definition{
+		Name:     placementSaga,
+		NewState: func() interface{} { return &placementState{} },
+		Steps: []saga.Step{
+			{Name: "reserve_stock", Action: s.reserveStock, Compensate: s.releaseStock},
+			{Name: "confirm_restaurant", Action: s.confirmRestaurant},
+			{Name: "create_order", Action: s.createOrder},
+		},
+	}
+}
+
+func (s *Service) reserveStock(ctx context.Context, placement *saga.Saga) (err error) {
+	state := placement.State.(*placementState)
+	for i, line := range state.lines() {
+		_, err = s.stockService.DecreaseStock(ctx, &stock_pb.CmdDecreaseStock{
+			ItemId:      line.Item.ID,
+			N:           line.Quantity,
+			OperationId: reservationID(placement.ID, i),
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (s *Service) releaseStock(ctx context.Context, placement *saga.Saga) (err error) {
+	state := placement.State.(*placementState)
+
+	return s.releaseLines(ctx, placement.ID, state.lines())
+}
+
+// reservationID is the operation ID of the stock decrease reserving the line of the order.
+// The order is placed by the saga with the ID of the order.
+func reservationID(orderID string, line int) string {
+	return saga.OperationID(orderID, "reserve", line)
+}
+
+// confirmRestaurant checks that the ordered items are on the menu of the restaurant and stores them in the lines
+func (s *Service) confirmRestaurant(ctx context.Context, placement *saga.Saga) (err error) {
+	state := placement.State.(*placementState)
+	res, err := s.restaurantQueryService.Get(ctx, &restaurant_pb.GetRestaurant{
+		Id: state.RestaurantID,
+	})
+	if err != nil {
+		return
+	}
+
+	lines := state.lines()
+	menu, err := checkMenu(res, lines)
+	if err != nil {
+		return
+	}
+	for _, line := range lines {
+		line.Item = menu[line.Item.ID]
+	}
+
+	state.Restaurant = restaurant.NewRestaurantFromProto(res)
+
+	return
+}
+
+// checkMenu returns the menu of the restaurant by the IDs of the items,
+// or an InvalidArgument error if any of the ordered items is not on it
+func checkMenu(res *restaurant_pb.Restaurant, lines []*Line) (menu map[string]*restaurant.MenuItem, err error) {
+	menu = map[string]*restaurant.MenuItem{}
+	for _, category := range res.GetCategories() {
+		for _, item := range category.GetItems() {
+			menu[item.GetId()] = restaurant.NewMenuItemFromProto(item)
+		}
+	}
+
+	for _, line := range lines {
+		if _, ok := menu[line.Item.ID]; !ok {
+			err = grpc_status.Errorf(codes.InvalidArgument, "item %s is not on the menu of restaurant %s", line.Item.ID, res.GetId())
+			return
+		}
+	}
+
+	return
+}
+
+// createOrder creates the order with its totals computed from the confirmed lines
+func (s *Service) createOrder(ctx context.Context, placement *saga.Saga) (err error) {
+	state := placement.State.(*placementState)
+	totals, err := s.pricing.Totals(state.lines())
+	if err != nil {
+		err = grpc_status.Errorf(codes.InvalidArgument, "cannot compute order totals: %s", err)
+		return
+	}
+
+	_, err = s.repo.CreateOrder(ctx, placement.ID, state.Restaurant, state.CustomerID, state.DeliveryAddress, state.lines(), totals)
+
+	return
+}
@@ -0,0 +1,127 @@
+package orders
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
+
+	money_pb "github.com/sveatlo/night_snack/proto/money"
+	orders_pb "github.com/sveatlo/night_snack/proto/orders"
+	restaurant_pb "github.com/sveatlo/night_snack/proto/restaurant"
+)
+
+func TestLinesFromCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		cmd   *orders_pb.CmdCreateOrder
+		lines []Line
+		ok    bool
+	}{
+		{
+			name: "lines",
+			cmd: &orders_pb.CmdCreateOrder{Lines: []*orders_pb.OrderLine{
+				{ItemId: "pizza", Quantity: 2, Note: "no onion", Modifiers: []string{"extra cheese"}},
+				{ItemId: "cola", Quantity: 1},
+			}},
+			lines: []Line{{Quantity: 2, Note: "no onion"}, {Quantity: 1}},
+			ok:    true,
+		},
+		{
+			name:  "unset quantity is one piece",
+			cmd:   &orders_pb.CmdCreateOrder{Lines: []*orders_pb.OrderLine{{ItemId: "pizza"}}},
+			lines: []Line{{Quantity: 1}},
+			ok:    true,
+		},
+		{
+			name:  "deprecated item IDs follow the lines",
+			cmd:   &orders_pb.CmdCreateOrder{Lines: []*orders_pb.OrderLine{{ItemId: "pizza", Quantity: 3}}, ItemIds: []string{"cola", "cola"}},
+			lines: []Line{{Quantity: 3}, {Quantity: 1}, {Quantity: 1}},
+			ok:    true,
+		},
+		{
+			name: "negative quantity",
+			cmd:  &orders_pb.CmdCreateOrder{Lines: []*orders_pb.OrderLine{{ItemId: "pizza", Quantity: -1}}},
+		},
+		{
+			name: "no lines",
+			cmd:  &orders_pb.CmdCreateOrder{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines, err := linesFromCommand(test.cmd)
+			if !test.ok {
+				if err == nil {
+					t.Fatalf("got lines %v, expected an error", lines)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("cannot get lines: %v", err)
+			}
+
+			if len(lines) != len(test.lines) {
+				t.Fatalf("got %d lines, expected %d", len(lines), len(test.lines))
+			}
+			ordered := append([]*orders_pb.OrderLine{}, test.cmd.GetLines()...)
+			for _, itemID := range test.cmd.GetItemIds() {
+				ordered = append(ordered, &orders_pb.OrderLine{ItemId: itemID})
+			}
+			for i, line := range lines {
+				if line.Item.ID != ordered[i].GetItemId() || line.Quantity != test.lines[i].Quantity || line.Note != test.lines[i].Note {
+					t.Errorf("line %d is %s x%d %q, expected %s x%d %q", i, line.Item.ID, line.Quantity, line.Note, ordered[i].GetItemId(), test.lines[i].Quantity, test.lines[i].Note)
+				}
+				if len(line.Modifiers) != len(ordered[i].GetModifiers()) {
+					t.Errorf("line %d has modifiers %v, expected %v", i, line.Modifiers, ordered[i].GetModifiers())
+				}
+			}
+		})
+	}
+}
+
+func TestCheckMenu(t *testing.T) {
+	res := &restaurant_pb.Restaurant{
+		Id: "restaurant",
+		Categories: []*restaurant_pb.MenuCategory{
+			{Id: "mains", Items: []*restaurant_pb.MenuItem{
+				{Id: "pizza", Name: "Pizza", Price: &money_pb.Money{Amount: 1250, Currency: "EUR"}},
+			}},
+			{Id: "drinks", Items: []*restaurant_pb.MenuItem{
+				{Id: "cola", Name: "Cola", Price: &money_pb.Money{Amount: 300, Currency: "EUR"}},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		itemIDs []string
+		ok      bool
+	}{
+		{"items of all categories", []string{"pizza", "cola"}, true},
+		{"unknown item", []string{"pizza", "burger"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := &placementState{ItemIDs: test.itemIDs}
+
+			menu, err := checkMenu(res, state.lines())
+			if !test.ok {
+				if grpc_status.Code(err) != codes.InvalidArgument {
+					t.Fatalf("got %v, expected %s", err, codes.InvalidArgument)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("menu check failed: %v", err)
+			}
+
+			if price := menu["pizza"].Price; price.Amount != 1250 || price.Currency != "EUR" {
+				t.Errorf("pizza priced at %s, expected 1250 EUR", price)
+			}
+			if price := menu["cola"].Price; price.Amount != 300 || price.Currency != "EUR" {
+				t.Errorf("cola priced at %s, expected 300 EUR", price)
+			}
+		})
+	}
+}
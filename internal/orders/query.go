@@ -0,0 +1,166 @@
+package orders
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	orders_pb "github.com/sveatlo/night_snack/proto/orders"
+)
+
+const (
+	// DefaultListLimit is the number of orders on a page when no limit is requested
+	DefaultListLimit = 50
+	// MaxListLimit is the maximum number of orders on a page
+	MaxListLimit = 200
+)
+
+// ErrInvalidCursor is returned for cursors which were not returned by List with the same sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListFilter selects the listed orders, the zero fields are ignored
+type ListFilter struct {
+	RestaurantID string
+	Statuses     []string
+	CustomerID   string
+	// CreatedFrom includes the orders created at or after it
+	CreatedFrom time.Time
+	// CreatedUntil includes the orders created before it
+	CreatedUntil time.Time
+}
+
+func NewListFilterFromProto(query *orders_pb.ListOrders) (filter ListFilter) {
+	filter = ListFilter{
+		RestaurantID: query.GetRestaurantId(),
+		CustomerID:   query.GetCustomerId(),
+	}
+	for _, status := range query.GetStatuses() {
+		filter.Statuses = append(filter.Statuses, status.String())
+	}
+	if query.GetCreatedFrom() != nil {
+		filter.CreatedFrom = query.GetCreatedFrom().AsTime()
+	}
+	if query.GetCreatedUntil() != nil {
+		filter.CreatedUntil = query.GetCreatedUntil().AsTime()
+	}
+
+	return
+}
+
+func (f ListFilter) query() bson.M {
+	query := bson.M{}
+	if f.RestaurantID != "" {
+		query["restaurant._id"] = f.RestaurantID
+	}
+	if len(f.Statuses) > 0 {
+		query["status"] = bson.M{"$in": f.Statuses}
+	}
+	if f.CustomerID != "" {
+		query["customer_id"] = f.CustomerID
+	}
+
+	createdAt := bson.M{}
+	if !f.CreatedFrom.IsZero() {
+		createdAt["$gte"] = f.CreatedFrom
+	}
+	if !f.CreatedUntil.IsZero() {
+		createdAt["$lt"] = f.CreatedUntil
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	return query
+}
+
+// Page selects a page of the listed orders
+type Page struct {
+	Sort orders_pb.OrderSort
+	// Limit is the maximum number of orders on the page, DefaultListLimit is used when it is not positive
+	Limit int
+	// Cursor is the next cursor returned with the previous page, the first page is returned when it is empty
+	Cursor string
+}
+
+func (p Page) limit() int {
+	switch {
+	case p.Limit <= 0:
+		return DefaultListLimit
+	case p.Limit > MaxListLimit:
+		return MaxListLimit
+	}
+
+	return p.Limit
+}
+
+// sortField returns the field the orders are sorted by and the sort direction
+func (p Page) sortField() (field string, direction int) {
+	switch p.Sort {
+	case orders_pb.OrderSort_CREATED_AT_ASC:
+		return "created_at", 1
+	case orders_pb.OrderSort_UPDATED_AT_DESC:
+		return "updated_at", -1
+	case orders_pb.OrderSort_UPDATED_AT_ASC:
+		return "updated_at", 1
+	}
+
+	return "created_at", -1
+}
+
+// cursor points right after the last order of a page.
+// The orders with the same time are ordered by their IDs, so that none of them is skipped or repeated.
+type cursor struct {
+	Sort string    `bson:"s"`
+	At   time.Time `bson:"t"`
+	ID   string    `bson:"id"`
+}
+
+func newCursor(sort orders_pb.OrderSort, o *Order) (encoded string, err error) {
+	c := cursor{Sort: sort.String(), At: o.CreatedAt, ID: o.ID}
+	if field, _ := (Page{Sort: sort}).sortField(); field == "updated_at" {
+		c.At = o.UpdatedAt
+	}
+
+	data, err := bson.Marshal(c)
+	if err != nil {
+		err = fmt.Errorf("cannot encode cursor: %w", err)
+		return
+	}
+	encoded = base64.RawURLEncoding.EncodeToString(data)
+
+	return
+}
+
+// after returns the query matching the orders after the cursor of the page
+func (p Page) after() (query bson.M, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrInvalidCursor, err)
+		return
+	}
+	c := cursor{}
+	err = bson.Unmarshal(data, &c)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrInvalidCursor, err)
+		return
+	}
+	if c.Sort != p.Sort.String() {
+		err = fmt.Errorf("%w: cursor of sort %s used with sort %s", ErrInvalidCursor, c.Sort, p.Sort)
+		return
+	}
+
+	field, direction := p.sortField()
+	op := "$gt"
+	if direction < 0 {
+		op = "$lt"
+	}
+	query = bson.M{"$or": bson.A{
+		bson.M{field: bson.M{op: c.At}},
+		bson.M{field: c.At, "_id": bson.M{op: c.ID}},
+	}}
+
+	return
+}
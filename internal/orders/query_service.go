@@ -0,0 +1,98 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/moderntv/cadre/metrics"
+	"github.com/moderntv/cadre/status"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
+
+	"github.com/sveatlo/night_snack/internal/repository"
+	orders_pb "github.com/sveatlo/night_snack/proto/orders"
+)
+
+// QueryService reads the orders from the read model projected by the orders service
+type QueryService struct {
+	orders_pb.UnimplementedQueryServiceServer
+
+	log    zerolog.Logger
+	status *status.ComponentStatus
+
+	repo *Repository
+}
+
+func NewQueryService(ordersService *Service, metricsRegistry *metrics.Registry, appStatus *status.Status, log zerolog.Logger) (s *QueryService, err error) {
+	cs, err := appStatus.Register("order/query_svc")
+	if err != nil {
+		return
+	}
+
+	s = &QueryService{
+		log:    log.With().Str("component", "order/query_svc").Logger(),
+		status: cs,
+
+		repo: ordersService.repo,
+	}
+
+	return
+}
+
+func (s *QueryService) Close() {}
+
+func (s *QueryService) Get(ctx context.Context, query *orders_pb.GetOrder) (res *orders_pb.Order, err error) {
+	order, err := s.repo.Get(ctx, query.GetId())
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("order query failed: %w", err))
+		return
+	}
+
+	res = order.ToProto()
+
+	return
+}
+
+// List returns a page of the orders matching the filter
+func (s *QueryService) List(ctx context.Context, query *orders_pb.ListOrders) (res *orders_pb.Orders, err error) {
+	if query.GetLimit() < 0 {
+		err = grpc_status.Errorf(codes.InvalidArgument, "negative limit %d", query.GetLimit())
+		return
+	}
+	if _, ok := orders_pb.OrderSort_name[int32(query.GetSort())]; !ok {
+		err = grpc_status.Errorf(codes.InvalidArgument, "unknown sort %d", query.GetSort())
+		return
+	}
+	for _, orderStatus := range query.GetStatuses() {
+		if _, ok := orders_pb.OrderStatus_name[int32(orderStatus)]; !ok {
+			err = grpc_status.Errorf(codes.InvalidArgument, "unknown status %d", orderStatus)
+			return
+		}
+	}
+
+	orders, nextCursor, err := s.repo.List(ctx, NewListFilterFromProto(query), Page{
+		Sort:   query.GetSort(),
+		Limit:  int(query.GetLimit()),
+		Cursor: query.GetCursor(),
+	})
+	if errors.Is(err, ErrInvalidCursor) {
+		err = grpc_status.Error(codes.InvalidArgument, err.Error())
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("orders query failed: %w", err)
+		return
+	}
+
+	res = &orders_pb.Orders{
+		Orders:     []*orders_pb.Order{},
+		NextCursor: nextCursor,
+	}
+	for _, order := range orders {
+		res.Orders = append(res.Orders, order.ToProto())
+	}
+
+	return
+}
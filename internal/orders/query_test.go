@@ -0,0 +1,155 @@
+package orders
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongo_options "go.mongodb.org/mongo-driver/mongo/options"
+
+	orders_pb "github.com/sveatlo/night_snack/proto/orders"
+)
+
+const envTestMongoURI = "SNACK_TEST_MONGO_URI"
+
+func TestCursor(t *testing.T) {
+	at := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	o := &Order{ID: "b", CreatedAt: at, UpdatedAt: at.Add(time.Hour)}
+
+	tests := []struct {
+		sort  orders_pb.OrderSort
+		field string
+		op    string
+		at    time.Time
+	}{
+		{orders_pb.OrderSort_CREATED_AT_DESC, "created_at", "$lt", at},
+		{orders_pb.OrderSort_CREATED_AT_ASC, "created_at", "$gt", at},
+		{orders_pb.OrderSort_UPDATED_AT_DESC, "updated_at", "$lt", at.Add(time.Hour)},
+		{orders_pb.OrderSort_UPDATED_AT_ASC, "updated_at", "$gt", at.Add(time.Hour)},
+	}
+	for _, test := range tests {
+		t.Run(test.sort.String(), func(t *testing.T) {
+			encoded, err := newCursor(test.sort, o)
+			if err != nil {
+				t.Fatalf("cannot encode cursor: %v", err)
+			}
+
+			query, err := Page{Sort: test.sort, Cursor: encoded}.after()
+			if err != nil {
+				t.Fatalf("cannot decode cursor: %v", err)
+			}
+			// the orders at the same time follow by their IDs
+			expected := bson.M{"$or": bson.A{
+				bson.M{test.field: bson.M{test.op: test.at}},
+				bson.M{test.field: test.at, "_id": bson.M{test.op: "b"}},
+			}}
+			if !reflect.DeepEqual(query, expected) {
+				t.Errorf("got query %v, expected %v", query, expected)
+			}
+		})
+	}
+}
+
+func TestInvalidCursor(t *testing.T) {
+	at := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	ascending, err := newCursor(orders_pb.OrderSort_CREATED_AT_ASC, &Order{ID: "a", CreatedAt: at})
+	if err != nil {
+		t.Fatalf("cannot encode cursor: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "not a cursor!"},
+		{"not bson", base64.RawURLEncoding.EncodeToString([]byte("cursor"))},
+		{"other sort", ascending},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Page{Sort: orders_pb.OrderSort_CREATED_AT_DESC, Cursor: test.cursor}.after()
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("got %v, expected %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestPageLimit(t *testing.T) {
+	for limit, expected := range map[int]int{-1: DefaultListLimit, 0: DefaultListLimit, 10: 10, MaxListLimit + 1: MaxListLimit} {
+		if l := (Page{Limit: limit}).limit(); l != expected {
+			t.Errorf("limit %d used as %d, expected %d", limit, l, expected)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	uri := os.Getenv(envTestMongoURI)
+	if uri == "" {
+		t.Skipf("%s not set", envTestMongoURI)
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, mongo_options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("cannot connect to mongo: %v", err)
+	}
+	db := client.Database(fmt.Sprintf("night_snack_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	repo := &Repository{log: zerolog.Nop(), ordersCollection: db.Collection("orders")}
+
+	// three of the orders are created at the same time, so the pages split them
+	at := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	created := map[string]time.Time{"a": at, "b": at.Add(time.Minute), "c": at.Add(time.Minute), "d": at.Add(time.Minute), "e": at.Add(2 * time.Minute)}
+	for id, createdAt := range created {
+		_, err = repo.ordersCollection.InsertOne(ctx, &Order{ID: id, Status: "RECEIVED", CreatedAt: createdAt, UpdatedAt: createdAt})
+		if err != nil {
+			t.Fatalf("cannot insert order: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		sort   orders_pb.OrderSort
+		filter ListFilter
+		pages  [][]string
+	}{
+		{"descending", orders_pb.OrderSort_CREATED_AT_DESC, ListFilter{}, [][]string{{"e", "d"}, {"c", "b"}, {"a"}}},
+		{"ascending", orders_pb.OrderSort_CREATED_AT_ASC, ListFilter{}, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		{"full last page", orders_pb.OrderSort_CREATED_AT_DESC, ListFilter{CreatedFrom: at.Add(time.Minute)}, [][]string{{"e", "d"}, {"c", "b"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page := Page{Sort: test.sort, Limit: 2}
+			for i, expected := range test.pages {
+				orders, next, err := repo.List(ctx, test.filter, page)
+				if err != nil {
+					t.Fatalf("page %d: cannot list orders: %v", i, err)
+				}
+
+				ids := []string{}
+				for _, o := range orders {
+					ids = append(ids, o.ID)
+				}
+				if !reflect.DeepEqual(ids, expected) {
+					t.Errorf("page %d has %v, expected %v", i, ids, expected)
+				}
+				if last := i == len(test.pages)-1; last != (next == "") {
+					t.Fatalf("page %d has next cursor %q", i, next)
+				}
+				page.Cursor = next
+			}
+		})
+	}
+}
@@ -5,16 +5,18 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/gofrs/uuid"
-	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"github.com/sveatlo/night_snack/internal/customer"
 	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/outbox"
+	"github.com/sveatlo/night_snack/internal/projection"
 	"github.com/sveatlo/night_snack/internal/repository"
 	"github.com/sveatlo/night_snack/internal/restaurant"
+	"github.com/sveatlo/night_snack/internal/transport"
 	orders_pb "github.com/sveatlo/night_snack/proto/orders"
 )
 
@@ -22,104 +24,90 @@ type Repository struct {
 	*repository.Base
 
 	log              zerolog.Logger
-	eventsCollection *mongo.Collection
 	ordersCollection *mongo.Collection
 }
 
-func NewRepository(nc *nats.EncodedConn, mongoDB *mongo.Database, log zerolog.Logger) (repo *Repository, err error) {
+func NewRepository(eventTransport transport.Transport, store events.Store, relay *outbox.Relay, mongoDB *mongo.Database, projections *projection.Manager, log zerolog.Logger) (repo *Repository, err error) {
 	log = log.With().Str("component", "order/repository").Logger()
-	base, err := repository.NewBase(nc, mongoDB, log)
+	base, err := repository.NewBase(eventTransport, store, log)
 	if err != nil {
 		return
 	}
+	base.EnableOutbox(relay)
 
 	repo = &Repository{
 		Base: base,
 
 		log:              log,
 		ordersCollection: mongoDB.Collection("orders"),
-		eventsCollection: mongoDB.Collection("events"),
 	}
 
-	err = repo.ordersCollection.Drop(context.Background())
+	err = repo.ensureIndexes(context.Background())
 	if err != nil {
 		return
 	}
 
-	err = repo.loadFromEventsStore()
+	err = projections.Register(projection.Projection{
+		Name:       "orders",
+		Categories: []string{"order"},
+		Apply:      repo.handleEvent,
+		Reset:      repo.reset,
+	})
 	if err != nil {
-		err = fmt.Errorf("cannot retrieve events from event store: %w", err)
+		err = fmt.Errorf("cannot register orders projection: %w", err)
 		return
 	}
 
-	_, err = nc.Subscribe(repo.GetTopic(&EventOrderCreated{}), repo.handleEventOrderCreated)
+	return
+}
+
+// ensureIndexes creates the indexes of the orders read model used by List
+func (repo *Repository) ensureIndexes(ctx context.Context) (err error) {
+	_, err = repo.ordersCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "updated_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "restaurant._id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
 	if err != nil {
-		err = fmt.Errorf("cannot create subscription for EventStockIncreased: %w", err)
+		err = fmt.Errorf("cannot create orders indexes: %w", err)
 		return
 	}
-	_, err = nc.Subscribe(repo.GetTopic(&EventStatusUpdated{}), repo.handleEventStatusUpdated)
+
+	return
+}
+
+// reset drops the orders read model along with its indexes and creates the indexes again
+func (repo *Repository) reset(ctx context.Context) (err error) {
+	err = repo.ordersCollection.Drop(ctx)
 	if err != nil {
-		err = fmt.Errorf("cannot create subscription for EventStatusUpdated: %w", err)
 		return
 	}
 
-	return
+	return repo.ensureIndexes(ctx)
 }
 
-func (repo *Repository) SaveEvents(aggregateID string, aggregateEvents []events.Event, originalVersion int) (err error) {
-	return repo.Base.SaveEvents("order", aggregateID, aggregateEvents, originalVersion)
+func (repo *Repository) SaveEvents(ctx context.Context, aggregateID string, aggregateEvents []events.Event, originalVersion int) (err error) {
+	return repo.Base.SaveEvents(ctx, "order", aggregateID, aggregateEvents, originalVersion)
 }
 
 func (repo *Repository) LoadAggregate(id string) (aggregate events.AggregateDB, err error) {
 	return repo.Base.LoadAggregate("order", id)
 }
 
-func (repo *Repository) loadFromEventsStore() (err error) {
-	var aggregates []events.AggregateDB
-	{
-		var cursor *mongo.Cursor
-		cursor, err = repo.eventsCollection.Find(context.Background(), bson.M{"category": "order"})
-		if err != nil {
-			err = fmt.Errorf("cannot get events from store: %w", err)
-			return
-		}
-		cursor.All(context.Background(), &aggregates)
-	}
-
-	repo.log.Trace().Interface("events", aggregates).Msg("loaded events from store")
-
-	for _, aggregate := range aggregates {
-		for _, eventDB := range aggregate.Events {
-			var event events.Event
-
-			switch eventDB.Type {
-			case "created":
-				event = EventOrderCreatedFromData(eventDB.Data)
-			case "statusupdated":
-				event = EventStatusUpdatedFromData(eventDB.Data)
-			default:
-				err = fmt.Errorf("unknown event for order: %v", eventDB.Type)
-				return
-			}
-
-			err = repo.applyEvent(event)
-			if err != nil {
-				break
-			}
-		}
-	}
-
-	return
+func (repo *Repository) handleEvent(ctx context.Context, event events.Event, version int) error {
+	return repo.applyEvent(ctx, event, version)
 }
 
-func (repo *Repository) applyEvent(event events.Event) (err error) {
+func (repo *Repository) applyEvent(ctx context.Context, event events.Event, version int) (err error) {
 	repo.log.Trace().
 		Str("event", event.EventType()).
 		Str("id", event.AggregateID()).
 		Interface("data", event.Data()).
 		Msg("applying event")
 	defer func() {
-		if err != nil {
+		if err != nil && !errors.Is(err, projection.ErrAlreadyApplied) {
 			repo.log.Error().
 				Err(err).
 				Str("event", event.EventType()).
@@ -131,9 +119,11 @@ func (repo *Repository) applyEvent(event events.Event) (err error) {
 
 	switch e := event.(type) {
 	case *EventOrderCreated:
-		err = repo.applyEventOrderCreated(e)
+		err = repo.applyEventToOrder(ctx, e.ID, version, e)
 	case *EventStatusUpdated:
-		err = repo.applyEventStatusUpdated(e)
+		err = repo.applyEventToOrder(ctx, e.ID, version, e)
+	case *EventOrderCancelled:
+		err = repo.applyEventToOrder(ctx, e.ID, version, e)
 
 	default:
 		err = errors.New("event not supported")
@@ -142,105 +132,222 @@ func (repo *Repository) applyEvent(event events.Event) (err error) {
 	return
 }
 
-func (repo *Repository) CreateOrder(ctx context.Context, restaurant *restaurant.Restaurant, items []*restaurant.MenuItem) (event *EventOrderCreated, err error) {
-	id, err := uuid.NewV4()
+// CreateOrder creates the order with the ID. If the order exists already, its creation event is returned.
+func (repo *Repository) CreateOrder(ctx context.Context, id string, restaurant *restaurant.Restaurant, customerID string, deliveryAddress *customer.Address, lines []*Line, totals Totals) (event *EventOrderCreated, err error) {
+	aggregate, err := repo.LoadAggregate(id)
 	if err != nil {
-		err = fmt.Errorf("cannot generate UUID: %w", err)
 		return
 	}
-
-	aggregate, err := repo.LoadAggregate(id.String())
-	if err != nil {
-		return
+	if aggregate.Version > 0 {
+		return repo.created(aggregate)
 	}
 
 	event = &EventOrderCreated{
-		ID:         id.String(),
+		ID:         id,
 		Restaurant: restaurant,
-		Items:      items,
+		Lines:      lines,
+		Totals:     totals,
 		Status:     orders_pb.OrderStatus_RECEIVED.String(),
-	}
 
-	err = repo.SaveEvents(aggregate.ID, []events.Event{event}, aggregate.Version)
-	if err != nil {
-		return
+		CustomerID:      customerID,
+		DeliveryAddress: deliveryAddress,
 	}
 
-	err = repo.Publish(event)
-	if err != nil {
-		return
-	}
+	err = repo.SaveEvents(ctx, aggregate.ID, []events.Event{event}, aggregate.Version)
 
 	return
 }
 
-func (repo *Repository) UpdateStatus(ctx context.Context, id string, status *orders_pb.OrderStatus) (event *EventStatusUpdated, err error) {
+// GetCreated returns the event the order was created by
+func (repo *Repository) GetCreated(ctx context.Context, id string) (event *EventOrderCreated, err error) {
 	aggregate, err := repo.LoadAggregate(id)
 	if err != nil {
 		return
 	}
 	if aggregate.Version == 0 {
-		err = fmt.Errorf("no such aggregate")
+		err = fmt.Errorf("order %s: %w", id, repository.ErrNotFound)
 		return
 	}
 
-	event = &EventStatusUpdated{
-		ID:     id,
-		Status: status.String(),
-	}
+	return repo.created(aggregate)
+}
 
-	err = repo.SaveEvents(aggregate.ID, []events.Event{event}, aggregate.Version)
+func (repo *Repository) created(aggregate events.AggregateDB) (event *EventOrderCreated, err error) {
+	aggregateEvents, err := repo.DecodeEvents(aggregate)
 	if err != nil {
 		return
 	}
 
-	err = repo.Publish(event)
-	if err != nil {
-		return
+	event, ok := aggregateEvents[0].(*EventOrderCreated)
+	if !ok {
+		err = fmt.Errorf("order %s starts with %s", aggregate.ID, aggregateEvents[0].EventType())
 	}
 
 	return
 }
 
-func (repo *Repository) handleEventOrderCreated(eventPb *orders_pb.OrderCreated) error {
-	return repo.applyEventOrderCreated(EventOrderCreatedFromProto(eventPb))
+// UpdateStatus moves the order to the status. The lines are returned only for a rejected order
+// so that the caller returns them to the stock, for an already rejected order the original rejection
+// is returned along with the lines.
+func (repo *Repository) UpdateStatus(ctx context.Context, id string, status *orders_pb.OrderStatus) (event *EventStatusUpdated, lines []*Line, err error) {
+	err = repository.RetryOnConflict(func() (err error) {
+		aggregate, err := repo.LoadAggregate(id)
+		if err != nil {
+			return
+		}
+		if aggregate.Version == 0 {
+			err = fmt.Errorf("order %s: %w", id, repository.ErrNotFound)
+			return
+		}
+
+		aggregateEvents, err := repo.DecodeEvents(aggregate)
+		if err != nil {
+			return
+		}
+
+		order := NewFromEvents(aggregateEvents)
+		if *status == orders_pb.OrderStatus_REJECTED && order.IsRejected() {
+			event, lines = order.Rejection(), order.Lines
+			return
+		}
+
+		event, err = order.UpdateStatus(*status)
+		if err != nil {
+			return
+		}
+
+		err = repo.SaveEvents(ctx, aggregate.ID, []events.Event{event}, aggregate.Version)
+		if err != nil {
+			return
+		}
+		if *status == orders_pb.OrderStatus_REJECTED {
+			lines = order.Lines
+		}
+
+		return
+	})
+
+	return
 }
 
-func (repo *Repository) applyEventOrderCreated(event *EventOrderCreated) (err error) {
-	o := &Order{}
-	o.ApplyEvent(event)
+// Cancel cancels the order and returns its lines, so that the caller returns them to the stock.
+// For an already cancelled order the original cancellation is returned along with the lines.
+func (repo *Repository) Cancel(ctx context.Context, id string, reason orders_pb.CancellationReason) (event *EventOrderCancelled, lines []*Line, err error) {
+	err = repository.RetryOnConflict(func() (err error) {
+		aggregate, err := repo.LoadAggregate(id)
+		if err != nil {
+			return
+		}
+		if aggregate.Version == 0 {
+			err = fmt.Errorf("order %s: %w", id, repository.ErrNotFound)
+			return
+		}
+
+		aggregateEvents, err := repo.DecodeEvents(aggregate)
+		if err != nil {
+			return
+		}
+
+		order := NewFromEvents(aggregateEvents)
+		if order.IsCancelled() {
+			event, lines = order.Cancellation(), order.Lines
+			return
+		}
+
+		event, err = order.Cancel(reason)
+		if err != nil {
+			return
+		}
+
+		err = repo.SaveEvents(ctx, aggregate.ID, []events.Event{event}, aggregate.Version)
+		if err != nil {
+			return
+		}
+		lines = order.Lines
+
+		return
+	})
+
+	return
+}
 
-	_, err = repo.ordersCollection.InsertOne(context.Background(), o)
+// GetAsOf folds the order from its events up to the point given by asOf
+func (repo *Repository) GetAsOf(ctx context.Context, id string, asOf repository.AsOf) (order *Order, err error) {
+	aggregate, err := repo.LoadAggregateAsOf("order", id, asOf)
 	if err != nil {
 		return
 	}
+	if aggregate.Version == 0 {
+		err = fmt.Errorf("order %s: %w", id, repository.ErrNotFound)
+		return
+	}
+
+	aggregateEvents, err := repo.DecodeEvents(aggregate)
+	if err != nil {
+		return
+	}
+	order = NewFromEvents(aggregateEvents)
 
 	return
 }
 
-func (repo *Repository) handleEventStatusUpdated(eventPb *orders_pb.StatusUpdated) error {
-	return repo.applyEventStatusUpdated(EventStatusUpdatedFromProto(eventPb))
+// Get returns the order from the read model
+func (repo *Repository) Get(ctx context.Context, id string) (order *Order, err error) {
+	order = &Order{}
+	err = repo.ordersCollection.FindOne(ctx, bson.M{"_id": id}).Decode(order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = fmt.Errorf("order %s: %w", id, repository.ErrNotFound)
+	}
+
+	return
 }
 
-func (repo *Repository) applyEventStatusUpdated(event *EventStatusUpdated) (err error) {
-	o := &Order{}
-	res := repo.ordersCollection.FindOne(context.Background(), bson.M{"_id": event.ID})
-	err = res.Err()
-	if err != nil && err != mongo.ErrNoDocuments {
-		return
-	} else if err == nil {
-		res.Decode(&o)
+// List returns the page of the orders matching the filter from the read model.
+// The next cursor is empty when there are no more orders.
+func (repo *Repository) List(ctx context.Context, filter ListFilter, page Page) (orders []*Order, nextCursor string, err error) {
+	query := filter.query()
+	if page.Cursor != "" {
+		var after bson.M
+		after, err = page.after()
+		if err != nil {
+			return
+		}
+		query = bson.M{"$and": bson.A{query, after}}
 	}
 
-	o.ApplyEvent(event)
-
-	_, err = repo.ordersCollection.UpdateOne(context.Background(), bson.M{"_id": event.ID}, bson.M{"$set": o}, options.Update().SetUpsert(true))
+	field, direction := page.sortField()
+	limit := page.limit()
+	// one more order tells whether there is a next page
+	opts := options.Find().
+		SetSort(bson.D{{Key: field, Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(limit + 1))
+	cur, err := repo.ordersCollection.Find(ctx, query, opts)
 	if err != nil {
 		return
 	}
+
+	orders = []*Order{}
+	err = cur.All(ctx, &orders)
 	if err != nil {
 		return
 	}
 
+	if len(orders) > limit {
+		orders = orders[:limit]
+		nextCursor, err = newCursor(page.Sort, orders[limit-1])
+	}
+
 	return
 }
+
+func (repo *Repository) applyEventToOrder(ctx context.Context, id string, version int, event events.Event) (err error) {
+	o := &Order{}
+	err = repo.ordersCollection.FindOne(ctx, bson.M{"_id": id}).Decode(o)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return
+	}
+
+	o.ApplyEvent(event)
+
+	return projection.Save(ctx, repo.ordersCollection, id, version, o)
+}
@@ -2,16 +2,27 @@ package orders
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
+	"github.com/gofrs/uuid"
 	"github.com/moderntv/cadre/metrics"
 	"github.com/moderntv/cadre/status"
-	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
 	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
 
+	"github.com/sveatlo/night_snack/internal/customer"
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/outbox"
+	"github.com/sveatlo/night_snack/internal/projection"
+	"github.com/sveatlo/night_snack/internal/repository"
 	"github.com/sveatlo/night_snack/internal/restaurant"
+	"github.com/sveatlo/night_snack/internal/saga"
 	"github.com/sveatlo/night_snack/internal/stock"
+	"github.com/sveatlo/night_snack/internal/transport"
+	customer_pb "github.com/sveatlo/night_snack/proto/customer"
 	orders_pb "github.com/sveatlo/night_snack/proto/orders"
 	restaurant_pb "github.com/sveatlo/night_snack/proto/restaurant"
 	stock_pb "github.com/sveatlo/night_snack/proto/stock"
@@ -24,17 +35,20 @@ type Service struct {
 	status *status.ComponentStatus
 
 	restaurantQueryService *restaurant.QueryService
+	customerService        *customer.Service
 	stockService           *stock.Service
+	sagas                  *saga.Engine
+	pricing                Pricing
 	repo                   *Repository
 }
 
-func NewService(restaurantQueryService *restaurant.QueryService, stockService *stock.Service, nec *nats.EncodedConn, mongo *mongo.Database, metricsRegistry *metrics.Registry, appStatus *status.Status, log zerolog.Logger) (c *Service, err error) {
+func NewService(restaurantQueryService *restaurant.QueryService, customerService *customer.Service, stockService *stock.Service, sagas *saga.Engine, pricing Pricing, eventTransport transport.Transport, store events.Store, relay *outbox.Relay, mongo *mongo.Database, projections *projection.Manager, metricsRegistry *metrics.Registry, appStatus *status.Status, log zerolog.Logger) (c *Service, err error) {
 	cs, err := appStatus.Register("order/svc")
 	if err != nil {
 		return
 	}
 
-	repo, err := NewRepository(nec, mongo, log)
+	repo, err := NewRepository(eventTransport, store, relay, mongo, projections, log)
 	if err != nil {
 		err = fmt.Errorf("cannot create order repository: %w", err)
 	}
@@ -44,82 +58,161 @@ func NewService(restaurantQueryService *restaurant.QueryService, stockService *s
 		status: cs,
 
 		restaurantQueryService: restaurantQueryService,
+		customerService:        customerService,
 		stockService:           stockService,
+		sagas:                  sagas,
+		pricing:                pricing,
 		repo:                   repo,
 	}
 
+	err = sagas.Register(c.placementDefinition())
+	if err != nil {
+		err = fmt.Errorf("cannot register order placement saga: %w", err)
+		return
+	}
+
 	return
 }
 
 func (s *Service) Close() {}
 
+// Create checks the ordered items against the current menu of the restaurant and places the order
+// by running the order placement saga. The saga snapshots the items once the restaurant confirms the order.
 func (s *Service) Create(ctx context.Context, cmd *orders_pb.CmdCreateOrder) (event *orders_pb.OrderCreated, err error) {
-	// try inventory
-	reservedItemsIDs := []string{}
-	allAvailable := true
-	for _, itemID := range cmd.ItemIds {
-		_, err = s.stockService.DecreaseStock(ctx, &stock_pb.CmdDecreaseStock{
-			ItemId: itemID,
-			N:      1,
-		})
-		if err != nil {
-			allAvailable = false
-			break
-		}
-
-		reservedItemsIDs = append(reservedItemsIDs, itemID)
+	id, err := uuid.NewV4()
+	if err != nil {
+		err = fmt.Errorf("cannot generate UUID: %w", err)
+		return
 	}
-	if !allAvailable {
-		err = s.releaseReservedItems(ctx, reservedItemsIDs...)
-		if err != nil {
-			return
-		}
-		err = fmt.Errorf("cannot create order: some items are not in stock")
+
+	lines, err := linesFromCommand(cmd)
+	if err != nil {
+		err = grpc_status.Error(codes.InvalidArgument, err.Error())
 		return
 	}
 
-	var (
-		r     = &restaurant.Restaurant{}
-		items = []*restaurant.MenuItem{}
-	)
 	res, err := s.restaurantQueryService.Get(ctx, &restaurant_pb.GetRestaurant{
-		Id: cmd.RestaurantId,
+		Id: cmd.GetRestaurantId(),
 	})
 	if err != nil {
-		errRelease := s.releaseReservedItems(ctx, reservedItemsIDs...)
-		if errRelease != nil {
-			err = fmt.Errorf("cannot release items after query failed: %w", err)
-		}
+		return
+	}
+	_, err = checkMenu(res, lines)
+	if err != nil {
 		return
 	}
 
-	r = restaurant.NewRestaurantFromProto(res)
-	r.MenuCategories = nil
+	deliveryAddress, err := s.deliveryAddress(ctx, cmd.GetCustomerId(), cmd.GetDeliveryAddressId())
+	if err != nil {
+		return
+	}
 
-	for _, category := range res.Categories {
-		for _, item := range category.Items {
-			items = append(items, restaurant.NewMenuItemFromProto(item))
+	_, err = s.sagas.Run(ctx, placementSaga, id.String(), &placementState{
+		RestaurantID:    cmd.GetRestaurantId(),
+		Lines:           lines,
+		CustomerID:      cmd.GetCustomerId(),
+		DeliveryAddress: deliveryAddress,
+	})
+	var abortedErr *saga.AbortedError
+	if errors.As(err, &abortedErr) {
+		// the status of the failed step is kept, e.g. Aborted for a conflicting stock update
+		if _, ok := grpc_status.FromError(abortedErr.Err); ok {
+			err = abortedErr.Err
+			return
 		}
+		err = grpc_status.Errorf(codes.FailedPrecondition, "cannot create order: %s", abortedErr.Err)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot create order: %w", err)
+		return
 	}
 
-	e, err := s.repo.CreateOrder(ctx, r, items)
+	created, err := s.repo.GetCreated(ctx, id.String())
 	if err != nil {
-		errRelease := s.releaseReservedItems(ctx, reservedItemsIDs...)
-		if errRelease != nil {
-			err = fmt.Errorf("cannot release items after query failed: %w", err)
-		}
+		err = repository.StatusFromError(err)
 		return
 	}
 
-	event = e.ToProto().(*orders_pb.OrderCreated)
+	event = created.ToProto().(*orders_pb.OrderCreated)
+
+	return
+}
+
+// deliveryAddress checks that the customer exists and returns the delivery address, which has to be one of the addresses
+// of the customer. The orders without a customer have no delivery address.
+func (s *Service) deliveryAddress(ctx context.Context, customerID, addressID string) (address *customer.Address, err error) {
+	switch {
+	case customerID == "" && addressID == "":
+		return
+	case customerID == "":
+		err = grpc_status.Error(codes.InvalidArgument, "delivery address requires customer")
+		return
+	case addressID == "":
+		_, err = s.customerService.Get(ctx, &customer_pb.GetCustomer{Id: customerID})
+		return
+	}
+
+	res, err := s.customerService.GetAddress(ctx, &customer_pb.GetAddress{
+		Id:         addressID,
+		CustomerId: customerID,
+	})
+	if err != nil {
+		return
+	}
+	address = customer.NewAddressFromProto(res)
 
 	return
 }
 
+// linesFromCommand returns the lines of the order to be created, the items are identified only by their IDs.
+// The deprecated item IDs are ordered one piece each.
+func linesFromCommand(cmd *orders_pb.CmdCreateOrder) (lines []*Line, err error) {
+	for _, line := range cmd.GetLines() {
+		quantity := line.GetQuantity()
+		if quantity == 0 {
+			quantity = 1
+		}
+		if quantity < 0 {
+			err = fmt.Errorf("invalid quantity %d of item %s", quantity, line.GetItemId())
+			return
+		}
+
+		lines = append(lines, &Line{
+			Item:      &restaurant.MenuItem{ID: line.GetItemId()},
+			Quantity:  quantity,
+			Note:      line.GetNote(),
+			Modifiers: append([]string{}, line.GetModifiers()...),
+		})
+	}
+	for _, itemID := range cmd.GetItemIds() {
+		lines = append(lines, &Line{
+			Item:      &restaurant.MenuItem{ID: itemID},
+			Quantity:  1,
+			Modifiers: []string{},
+		})
+	}
+	if len(lines) == 0 {
+		err = fmt.Errorf("order has no lines")
+		return
+	}
+
+	return
+}
+
+// UpdateStatus moves the order to the status. The items of a rejected order are returned to the stock,
+// rejecting the order again only retries returning them.
 func (s *Service) UpdateStatus(ctx context.Context, cmd *orders_pb.CmdUpdateStatus) (res *orders_pb.StatusUpdated, err error) {
-	event, err := s.repo.UpdateStatus(ctx, cmd.GetId(), cmd.GetStatus().Enum())
+	event, lines, err := s.repo.UpdateStatus(ctx, cmd.GetId(), cmd.GetStatus().Enum())
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("status update failed: %w", err))
+		return
+	}
+
+	err = s.releaseLines(ctx, cmd.GetId(), lines)
 	if err != nil {
-		err = fmt.Errorf("incrase failed: %w", err)
+		s.log.Error().Err(err).Str("order_id", cmd.GetId()).Msg("cannot return items of rejected order to stock")
+		err = fmt.Errorf("order rejected, but its items were not returned to stock: %w", err)
 		return
 	}
 
@@ -128,14 +221,55 @@ func (s *Service) UpdateStatus(ctx context.Context, cmd *orders_pb.CmdUpdateStat
 	return
 }
 
-func (s *Service) releaseReservedItems(ctx context.Context, reservedItemsIDs ...string) (err error) {
-	for _, itemID := range reservedItemsIDs {
-		_, err = s.stockService.IncreaseStock(ctx, &stock_pb.CmdIncreaseStock{
-			ItemId: itemID,
-			N:      1,
+// Cancel cancels the order and returns its items to the stock.
+// Cancelling the order again only retries returning the items, each reservation is released once.
+func (s *Service) Cancel(ctx context.Context, cmd *orders_pb.CmdCancelOrder) (res *orders_pb.OrderCancelled, err error) {
+	if _, ok := orders_pb.CancellationReason_name[int32(cmd.GetReason())]; !ok {
+		err = grpc_status.Errorf(codes.InvalidArgument, "unknown cancellation reason %d", cmd.GetReason())
+		return
+	}
+
+	event, lines, err := s.repo.Cancel(ctx, cmd.GetId(), cmd.GetReason())
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("order cancellation failed: %w", err))
+		return
+	}
+
+	err = s.releaseLines(ctx, cmd.GetId(), lines)
+	if err != nil {
+		s.log.Error().Err(err).Str("order_id", cmd.GetId()).Msg("cannot return items of cancelled order to stock")
+		err = fmt.Errorf("order cancelled, but its items were not returned to stock: %w", err)
+		return
+	}
+
+	res = event.ToProto().(*orders_pb.OrderCancelled)
+
+	return
+}
+
+// GetAsOf returns the order as it was at the given time and/or version
+func (s *Service) GetAsOf(ctx context.Context, cmd *orders_pb.GetOrderAsOf) (res *orders_pb.Order, err error) {
+	order, err := s.repo.GetAsOf(ctx, cmd.GetId(), repository.NewAsOf(cmd.GetTimestamp(), cmd.GetVersion()))
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("order history query failed: %w", err))
+		return
+	}
+
+	res = order.ToProto()
+
+	return
+}
+
+// releaseLines returns the quantities reserved for the lines of the order to the stock.
+// Every reservation is released only once, so releasing the lines again is harmless.
+func (s *Service) releaseLines(ctx context.Context, orderID string, lines []*Line) (err error) {
+	for i, line := range lines {
+		_, err = s.stockService.ReleaseStock(ctx, &stock_pb.CmdReleaseStock{
+			ItemId:      line.Item.ID,
+			OperationId: reservationID(orderID, i),
 		})
 		if err != nil {
-			err = fmt.Errorf("fatal error: %v", err)
+			err = fmt.Errorf("cannot return %d of %s to stock: %w", line.Quantity, line.Item.ID, err)
 			return
 		}
 	}
@@ -0,0 +1,81 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/money"
+	orders_pb "github.com/sveatlo/night_snack/proto/orders"
+)
+
+// Pricing configures the charges added to the price of the ordered items
+type Pricing struct {
+	// TaxRate is in basis points of the subtotal, e.g. 2100 is 21 %
+	TaxRate int64
+	// DeliveryFee is in minor units of the currency of the order
+	DeliveryFee int64
+}
+
+// Totals are computed when the order is created, so that later changes of the prices or the pricing don't change them
+type Totals struct {
+	// Subtotal is the sum of the prices of the lines
+	Subtotal    money.Money `bson:"subtotal"`
+	Tax         money.Money `bson:"tax"`
+	DeliveryFee money.Money `bson:"delivery_fee"`
+	Total       money.Money `bson:"total"`
+}
+
+// Totals computes the totals of the order from its lines. All the items have to be priced in the same currency.
+func (p Pricing) Totals(lines []*Line) (totals Totals, err error) {
+	if len(lines) == 0 {
+		err = errors.New("order has no lines")
+		return
+	}
+
+	currency := lines[0].Item.Price.Currency
+	subtotal := money.New(0, currency)
+	for _, line := range lines {
+		subtotal, err = subtotal.Add(line.Item.Price.Mul(int64(line.Quantity)))
+		if err != nil {
+			err = fmt.Errorf("item %s: %w", line.Item.ID, err)
+			return
+		}
+	}
+
+	totals = Totals{
+		Subtotal:    subtotal,
+		Tax:         subtotal.BasisPoints(p.TaxRate),
+		DeliveryFee: money.New(p.DeliveryFee, currency),
+	}
+	totals.Total = money.New(totals.Subtotal.Amount+totals.Tax.Amount+totals.DeliveryFee.Amount, currency)
+
+	return
+}
+
+func NewTotalsFromProto(totals *orders_pb.OrderTotals) Totals {
+	return Totals{
+		Subtotal:    money.NewFromProto(totals.GetSubtotal()),
+		Tax:         money.NewFromProto(totals.GetTax()),
+		DeliveryFee: money.NewFromProto(totals.GetDeliveryFee()),
+		Total:       money.NewFromProto(totals.GetTotal()),
+	}
+}
+
+func newTotalsFromData(r *events.DataReader) Totals {
+	return Totals{
+		Subtotal:    money.NewFromData(r.Doc("subtotal")),
+		Tax:         money.NewFromData(r.Doc("tax")),
+		DeliveryFee: money.NewFromData(r.Doc("delivery_fee")),
+		Total:       money.NewFromData(r.Doc("total")),
+	}
+}
+
+func (t Totals) ToProto() *orders_pb.OrderTotals {
+	return &orders_pb.OrderTotals{
+		Subtotal:    t.Subtotal.ToProto(),
+		Tax:         t.Tax.ToProto(),
+		DeliveryFee: t.DeliveryFee.ToProto(),
+		Total:       t.Total.ToProto(),
+	}
+}
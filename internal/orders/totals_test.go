@@ -0,0 +1,87 @@
+package orders
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sveatlo/night_snack/internal/money"
+	"github.com/sveatlo/night_snack/internal/restaurant"
+)
+
+func pricedLine(itemID string, amount int64, currency string, quantity int32) *Line {
+	return &Line{
+		Item:     &restaurant.MenuItem{ID: itemID, Price: money.New(amount, currency)},
+		Quantity: quantity,
+	}
+}
+
+func TestTotals(t *testing.T) {
+	tests := []struct {
+		name     string
+		pricing  Pricing
+		lines    []*Line
+		subtotal int64
+		tax      int64
+		total    int64
+		err      error
+	}{
+		{
+			name:     "quantities",
+			lines:    []*Line{pricedLine("pizza", 1250, "EUR", 2), pricedLine("cola", 300, "EUR", 3)},
+			subtotal: 3400,
+			total:    3400,
+		},
+		{
+			name:     "tax and delivery fee",
+			pricing:  Pricing{TaxRate: 2100, DeliveryFee: 250},
+			lines:    []*Line{pricedLine("pizza", 1250, "EUR", 2)},
+			subtotal: 2500,
+			tax:      525,
+			total:    3275,
+		},
+		{
+			name:     "tax rounded half up",
+			pricing:  Pricing{TaxRate: 1500},
+			lines:    []*Line{pricedLine("cola", 10, "EUR", 1), pricedLine("water", 20, "EUR", 1)},
+			subtotal: 30,
+			tax:      5,
+			total:    35,
+		},
+		{
+			name:  "currency mismatch",
+			lines: []*Line{pricedLine("pizza", 1250, "EUR", 1), pricedLine("cola", 300, "USD", 1)},
+			err:   money.ErrCurrencyMismatch,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			totals, err := test.pricing.Totals(test.lines)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got %v, expected %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("cannot compute totals: %v", err)
+			}
+
+			expected := Totals{
+				Subtotal:    money.New(test.subtotal, "EUR"),
+				Tax:         money.New(test.tax, "EUR"),
+				DeliveryFee: money.New(test.pricing.DeliveryFee, "EUR"),
+				Total:       money.New(test.total, "EUR"),
+			}
+			if totals != expected {
+				t.Errorf("got totals %+v, expected %+v", totals, expected)
+			}
+		})
+	}
+}
+
+func TestTotalsWithoutLines(t *testing.T) {
+	_, err := Pricing{}.Totals(nil)
+	if err == nil {
+		t.Error("totals of an order without lines computed")
+	}
+}
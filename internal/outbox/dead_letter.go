@@ -0,0 +1,48 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/sveatlo/night_snack/internal/events"
+)
+
+// DeadLetter is an event which could not be published even after all the attempts.
+// The event is marked published, so the relay continues with the following ones.
+type DeadLetter struct {
+	ID       int64          `bson:"_id" json:"id"`
+	Event    events.EventDB `bson:"event" json:"event"`
+	Error    string         `bson:"error" json:"error"`
+	Attempts int            `bson:"attempts" json:"attempts"`
+	FailedAt time.Time      `bson:"failed_at" json:"failed_at"`
+}
+
+// deadLetter stores the event which failed to be published and removes it from the outbox
+func (r *Relay) deadLetter(ctx context.Context, eventDB events.EventDB, cause error) (err error) {
+	deadLetter := DeadLetter{
+		ID:       eventDB.Position,
+		Event:    eventDB,
+		Error:    cause.Error(),
+		Attempts: r.attempts[eventDB.Position],
+		FailedAt: time.Now(),
+	}
+
+	_, err = r.deadLettersCollection.ReplaceOne(ctx, bson.M{"_id": deadLetter.ID}, deadLetter, options.Replace().SetUpsert(true))
+	if err != nil {
+		err = fmt.Errorf("cannot store dead letter: %w", err)
+		return
+	}
+
+	r.log.Error().
+		Err(cause).
+		Int64("position", eventDB.Position).
+		Str("event_id", eventDB.Metadata.EventID).
+		Int("attempts", deadLetter.Attempts).
+		Msg("event dead-lettered")
+
+	return
+}
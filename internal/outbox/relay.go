@@ -0,0 +1,210 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/transport"
+)
+
+// DefaultMaxAttempts is the number of attempts to publish an event before it is dead-lettered, unless set otherwise
+const DefaultMaxAttempts = 5
+
+// Relay publishes the events waiting in the outbox of the event store.
+//
+// The events are stored as unpublished together with the aggregate changes and the relay
+// publishes them in the order of their positions, marking them published afterwards.
+// Publishing is at-least-once: an event published right before a crash or published by the relays
+// of multiple processes is delivered again with the same event ID, which the subscribers deduplicate on.
+//
+// An event which cannot be published even after maxAttempts relay runs is moved to the dead letters,
+// so that it doesn't block the events after it. Failures caused by the transport being unavailable
+// are not counted, they would dead-letter every event waiting in the outbox during an outage.
+type Relay struct {
+	log       zerolog.Logger
+	store     events.Store
+	transport transport.Transport
+	registry  *events.Registry
+
+	deadLettersCollection *mongo.Collection
+
+	batchSize    int
+	pollInterval time.Duration
+	maxAttempts  int
+	// attempts counts the failed attempts to publish the events by their positions, it is used only by run
+	attempts map[int64]int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	wake   chan struct{}
+}
+
+func NewRelay(store events.Store, eventTransport transport.Transport, mongoDB *mongo.Database, batchSize int, pollInterval time.Duration, log zerolog.Logger) (r *Relay, err error) {
+	if batchSize <= 0 {
+		err = fmt.Errorf("invalid outbox batch size: %d", batchSize)
+		return
+	}
+	if pollInterval <= 0 {
+		err = fmt.Errorf("invalid outbox poll interval: %s", pollInterval)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r = &Relay{
+		log:       log.With().Str("component", "outbox/relay").Logger(),
+		store:     store,
+		transport: eventTransport,
+		registry:  events.DefaultRegistry,
+
+		deadLettersCollection: mongoDB.Collection("outbox_dead_letters"),
+
+		batchSize:    batchSize,
+		pollInterval: pollInterval,
+		maxAttempts:  DefaultMaxAttempts,
+		attempts:     map[int64]int{},
+
+		ctx:    ctx,
+		cancel: cancel,
+		wake:   make(chan struct{}, 1),
+	}
+
+	return
+}
+
+// SetMaxAttempts sets the number of attempts to publish an event before it is dead-lettered.
+// It has to be called before Start.
+func (r *Relay) SetMaxAttempts(n int) {
+	r.maxAttempts = n
+}
+
+// Start runs the relay in the background until Close is called
+func (r *Relay) Start() {
+	r.wg.Add(1)
+	go r.run()
+}
+
+// Close stops the relay. The events which were not published yet stay in the outbox.
+func (r *Relay) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// Notify makes the relay publish the pending events without waiting for the next poll.
+// It should be called after the events are committed to the store.
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Relay) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := r.relay(r.ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			r.log.Error().Err(err).Msg("cannot relay events")
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-r.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publishes the pending events batch by batch until the outbox is empty.
+// It stops at the first failure, so the events are never published out of order,
+// unless the failing event is dead-lettered.
+func (r *Relay) relay(ctx context.Context) (err error) {
+	for {
+		relayed := 0
+		err = r.store.ReadUnpublished(ctx, r.batchSize, func(eventDB events.EventDB) (err error) {
+			err = r.publish(eventDB)
+			if err != nil && r.exhausted(eventDB, err) {
+				err = r.deadLetter(ctx, eventDB, err)
+			}
+			if err != nil {
+				return
+			}
+
+			err = r.store.MarkPublished(ctx, eventDB.Position)
+			if err != nil {
+				return
+			}
+
+			delete(r.attempts, eventDB.Position)
+			relayed++
+			return
+		})
+		if err != nil || relayed < r.batchSize {
+			return
+		}
+	}
+}
+
+// exhausted counts the failed attempt to publish the event and reports whether it should be dead-lettered
+func (r *Relay) exhausted(eventDB events.EventDB, err error) bool {
+	if transportUnavailable(err) {
+		return false
+	}
+
+	r.attempts[eventDB.Position]++
+	if r.attempts[eventDB.Position] < r.maxAttempts {
+		r.log.Warn().Err(err).Int64("position", eventDB.Position).Int("attempt", r.attempts[eventDB.Position]).Msg("event publishing failed, retrying")
+		return false
+	}
+
+	return true
+}
+
+// transportUnavailable reports whether the publishing failed because NATS could not be reached
+func transportUnavailable(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, nats.ErrTimeout) ||
+		errors.Is(err, nats.ErrNoResponders) ||
+		errors.Is(err, nats.ErrNoStreamResponse) ||
+		errors.Is(err, nats.ErrConnectionClosed) ||
+		errors.Is(err, nats.ErrConnectionDraining) ||
+		errors.Is(err, nats.ErrConnectionReconnecting) ||
+		errors.Is(err, nats.ErrDisconnected) ||
+		errors.Is(err, nats.ErrNoServers)
+}
+
+func (r *Relay) publish(eventDB events.EventDB) (err error) {
+	event, err := r.registry.Decode(eventDB)
+	if err != nil {
+		return
+	}
+
+	msg, err := events.EncodeStoredMessage(eventDB, event)
+	if err != nil {
+		return
+	}
+
+	err = r.transport.Publish(msg)
+	if err != nil {
+		err = fmt.Errorf("cannot publish event %d: %w", eventDB.Position, err)
+		return
+	}
+
+	r.log.Debug().Str("subject", msg.Subject).Int64("position", eventDB.Position).Str("event_id", event.Metadata().EventID).Msg("event published")
+
+	return
+}
@@ -0,0 +1,145 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/sveatlo/night_snack/internal/events"
+)
+
+// ErrUnknownDeadLetter is returned for operations with a dead letter which doesn't exist
+var ErrUnknownDeadLetter = errors.New("unknown dead letter")
+
+// DeadLetter is an event which could not be applied to a projection even after all the retries.
+// The projection skips the event and continues with the following ones.
+type DeadLetter struct {
+	ID         string         `bson:"_id" json:"id"`
+	Projection string         `bson:"projection" json:"projection"`
+	Event      events.EventDB `bson:"event" json:"event"`
+	Error      string         `bson:"error" json:"error"`
+	Attempts   int            `bson:"attempts" json:"attempts"`
+	FailedAt   time.Time      `bson:"failed_at" json:"failed_at"`
+}
+
+func deadLetterID(projection string, position int64) string {
+	return fmt.Sprintf("%s-%d", projection, position)
+}
+
+// DeadLetters returns the dead letters of the projection ordered by the positions of their events.
+// The dead letters of all projections are returned when projection is empty.
+func (m *Manager) DeadLetters(ctx context.Context, projection string) (deadLetters []DeadLetter, err error) {
+	filter := bson.M{}
+	if projection != "" {
+		filter["projection"] = projection
+	}
+
+	cursor, err := m.deadLettersCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "event._id", Value: 1}}))
+	if err != nil {
+		err = fmt.Errorf("cannot get dead letters: %w", err)
+		return
+	}
+
+	deadLetters = []DeadLetter{}
+	err = cursor.All(ctx, &deadLetters)
+	if err != nil {
+		err = fmt.Errorf("cannot decode dead letters: %w", err)
+		return
+	}
+
+	return
+}
+
+// DeadLetter returns a single dead letter
+func (m *Manager) DeadLetter(ctx context.Context, id string) (deadLetter DeadLetter, err error) {
+	err = m.deadLettersCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&deadLetter)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = fmt.Errorf("%w: %s", ErrUnknownDeadLetter, id)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot load dead letter: %w", err)
+		return
+	}
+
+	return
+}
+
+// Redrive applies the event of the dead letter to its projection again and removes the dead letter on success.
+// The events of the aggregate which followed it were dead-lettered too and have to be re-driven after it.
+func (m *Manager) Redrive(ctx context.Context, id string) (err error) {
+	deadLetter, err := m.DeadLetter(ctx, id)
+	if err != nil {
+		return
+	}
+
+	r, err := m.runner(deadLetter.Projection)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, applyErr := m.apply(ctx, r, deadLetter.Event)
+	if applyErr != nil {
+		_, err = m.deadLettersCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": id},
+			bson.M{
+				"$set": bson.M{"error": applyErr.Error(), "failed_at": time.Now()},
+				"$inc": bson.M{"attempts": 1},
+			},
+		)
+		if err != nil {
+			err = fmt.Errorf("cannot update dead letter: %w", err)
+			return
+		}
+
+		err = fmt.Errorf("cannot redrive dead letter %s: %w", id, applyErr)
+		return
+	}
+
+	_, err = m.deadLettersCollection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		err = fmt.Errorf("cannot remove dead letter: %w", err)
+		return
+	}
+
+	m.log.Info().Str("projection", deadLetter.Projection).Str("dead_letter", id).Msg("dead letter redriven")
+
+	return
+}
+
+// deadLetter stores the event which failed to be applied to the projection
+func (m *Manager) deadLetter(ctx context.Context, r *runner, eventDB events.EventDB, attempts int, cause error) (err error) {
+	deadLetter := DeadLetter{
+		ID:         deadLetterID(r.projection.Name, eventDB.Position),
+		Projection: r.projection.Name,
+		Event:      eventDB,
+		Error:      cause.Error(),
+		Attempts:   attempts,
+		FailedAt:   time.Now(),
+	}
+
+	_, err = m.deadLettersCollection.ReplaceOne(ctx, bson.M{"_id": deadLetter.ID}, deadLetter, options.Replace().SetUpsert(true))
+	if err != nil {
+		err = fmt.Errorf("cannot store dead letter: %w", err)
+		return
+	}
+
+	m.log.Error().
+		Err(cause).
+		Str("projection", r.projection.Name).
+		Int64("position", eventDB.Position).
+		Str("event_id", eventDB.Metadata.EventID).
+		Int("attempts", attempts).
+		Msg("event dead-lettered")
+
+	return
+}
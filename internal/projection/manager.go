@@ -0,0 +1,419 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/transport"
+)
+
+const (
+	// pollInterval is the interval of catching up without being notified about new events
+	pollInterval = 5 * time.Second
+)
+
+var (
+	// ErrUnknownProjection is returned for operations with a projection which was not registered
+	ErrUnknownProjection = errors.New("unknown projection")
+	// ErrUnhandledCategory is returned when replaying a category the projection is not built from
+	ErrUnhandledCategory = errors.New("category not handled by projection")
+)
+
+// Manager keeps the registered projections up to date with the event store.
+//
+// Every projection stores the global position of the last applied event as its checkpoint
+// and continues from it after restart. Published events only wake the projections up;
+// the events themselves are always read from the store in the order of their positions,
+// so missed messages are caught up on the next notification or poll.
+//
+// The read models record the version of the last event of the aggregate applied to them in the same write
+// as the update, so an event delivered again is not applied twice and the events of an aggregate are applied in order.
+// Read models built before they recorded the versions have to be rebuilt. A failing event is retried according
+// to the retry policy and then stored as a dead letter, which can be inspected and re-driven later.
+type Manager struct {
+	log       zerolog.Logger
+	transport transport.Transport
+	store     events.Store
+	registry  *events.Registry
+
+	checkpointsCollection *mongo.Collection
+	deadLettersCollection *mongo.Collection
+
+	retryPolicy RetryPolicy
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.RWMutex
+	runners map[string]*runner
+}
+
+// runner runs a single projection
+type runner struct {
+	projection Projection
+	// mu serializes catching up and rebuilding
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+func NewManager(eventTransport transport.Transport, store events.Store, mongoDB *mongo.Database, log zerolog.Logger) (m *Manager, err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	m = &Manager{
+		log:       log.With().Str("component", "projection/manager").Logger(),
+		transport: eventTransport,
+		store:     store,
+		registry:  events.DefaultRegistry,
+
+		checkpointsCollection: mongoDB.Collection("projections"),
+		deadLettersCollection: mongoDB.Collection("projection_dead_letters"),
+
+		retryPolicy: DefaultRetryPolicy,
+
+		ctx:    ctx,
+		cancel: cancel,
+
+		runners: map[string]*runner{},
+	}
+
+	_, err = m.deadLettersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "projection", Value: 1}, {Key: "event._id", Value: 1}},
+	})
+	if err != nil {
+		err = fmt.Errorf("cannot create dead letters index: %w", err)
+		return
+	}
+
+	return
+}
+
+// SetRetryPolicy sets the retry policy of the failing events
+func (m *Manager) SetRetryPolicy(policy RetryPolicy) {
+	m.retryPolicy = policy
+}
+
+// Close stops all projections
+func (m *Manager) Close() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// Register catches the projection up with the event store and keeps it up to date afterwards.
+// Only the events stored after the projection's checkpoint are applied.
+func (m *Manager) Register(projection Projection) (err error) {
+	r := &runner{
+		projection: projection,
+		wake:       make(chan struct{}, 1),
+	}
+
+	m.mu.Lock()
+	if _, ok := m.runners[projection.Name]; ok {
+		m.mu.Unlock()
+		err = fmt.Errorf("projection %s already registered", projection.Name)
+		return
+	}
+	m.runners[projection.Name] = r
+	m.mu.Unlock()
+
+	err = m.catchUp(m.ctx, r)
+	if err != nil {
+		err = fmt.Errorf("cannot catch up projection %s: %w", projection.Name, err)
+		return
+	}
+
+	for _, category := range projection.Categories {
+		durable := fmt.Sprintf("projection_%s_%s", projection.Name, category)
+		err = m.transport.Subscribe(fmt.Sprintf("%s.*", category), durable, func(msg *nats.Msg) error {
+			select {
+			case r.wake <- struct{}{}:
+			default:
+			}
+
+			return nil
+		})
+		if err != nil {
+			err = fmt.Errorf("cannot create subscription for %s: %w", category, err)
+			return
+		}
+	}
+
+	m.wg.Add(1)
+	go m.run(r)
+
+	return
+}
+
+// Rebuild resets the projection and applies all the stored events again
+func (m *Manager) Rebuild(ctx context.Context, name string) (err error) {
+	r, err := m.runner(name)
+	if err != nil {
+		return
+	}
+
+	err = m.reset(ctx, r)
+	if err != nil {
+		return
+	}
+
+	m.log.Info().Str("projection", name).Msg("rebuilding projection")
+
+	err = m.catchUp(ctx, r)
+	if err != nil {
+		err = fmt.Errorf("cannot rebuild projection %s: %w", name, err)
+		return
+	}
+
+	return
+}
+
+// Replay applies the stored events of the category which the projection has not applied yet,
+// e.g. the events skipped as dead letters. Already applied events are skipped by the read model,
+// so it is not reset. The dead letters of the replayed events are removed.
+func (m *Manager) Replay(ctx context.Context, name, category string) (replayed int, err error) {
+	r, err := m.runner(name)
+	if err != nil {
+		return
+	}
+	if !r.projection.handles(category) {
+		err = fmt.Errorf("%w: %s/%s", ErrUnhandledCategory, name, category)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m.log.Info().Str("projection", name).Str("category", category).Msg("replaying category")
+
+	err = m.store.ReadAll(ctx, 0, func(eventDB events.EventDB) (err error) {
+		if eventDB.Category != category {
+			return
+		}
+
+		applied, _, err := m.applyWithRetry(ctx, r, eventDB)
+		if err != nil {
+			return
+		}
+		if applied {
+			replayed++
+		}
+
+		_, err = m.deadLettersCollection.DeleteOne(ctx, bson.M{"_id": deadLetterID(name, eventDB.Position)})
+		if err != nil {
+			err = fmt.Errorf("cannot remove dead letter: %w", err)
+			return
+		}
+
+		return
+	})
+	if err != nil {
+		err = fmt.Errorf("cannot replay %s into projection %s: %w", category, name, err)
+		return
+	}
+
+	return
+}
+
+// Checkpoints returns the checkpoints of all registered projections ordered by name
+func (m *Manager) Checkpoints(ctx context.Context) (checkpoints []Checkpoint, err error) {
+	m.mu.RLock()
+	names := make([]string, 0, len(m.runners))
+	for name := range m.runners {
+		names = append(names, name)
+	}
+	m.mu.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		var checkpoint Checkpoint
+		checkpoint, err = m.loadCheckpoint(ctx, name)
+		if err != nil {
+			return
+		}
+
+		checkpoints = append(checkpoints, checkpoint)
+	}
+
+	return
+}
+
+// reset removes the read model of the projection, its checkpoint and dead letters
+func (m *Manager) reset(ctx context.Context, r *runner) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err = r.projection.Reset(ctx)
+	if err == nil {
+		_, err = m.deadLettersCollection.DeleteMany(ctx, bson.M{"projection": r.projection.Name})
+	}
+	if err == nil {
+		err = m.saveCheckpoint(ctx, r.projection.Name, 0)
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot reset projection %s: %w", r.projection.Name, err)
+		return
+	}
+
+	return
+}
+
+func (m *Manager) runner(name string) (r *runner, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.runners[name]
+	if !ok {
+		err = fmt.Errorf("%w: %s", ErrUnknownProjection, name)
+		return
+	}
+
+	return
+}
+
+func (m *Manager) run(r *runner) {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-r.wake:
+		case <-ticker.C:
+		}
+
+		err := m.catchUp(m.ctx, r)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			m.log.Error().Err(err).Str("projection", r.projection.Name).Msg("projection cannot catch up")
+		}
+	}
+}
+
+// catchUp applies the events stored after the checkpoint of the projection.
+// The checkpoint is saved after every applied or dead-lettered event.
+func (m *Manager) catchUp(ctx context.Context, r *runner) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	checkpoint, err := m.loadCheckpoint(ctx, r.projection.Name)
+	if err != nil {
+		return
+	}
+
+	saved, position := checkpoint.Position, checkpoint.Position
+	err = m.store.ReadAll(ctx, checkpoint.Position, func(eventDB events.EventDB) (err error) {
+		position = eventDB.Position
+		if !r.projection.handles(eventDB.Category) {
+			return
+		}
+
+		_, attempts, err := m.applyWithRetry(ctx, r, eventDB)
+		if err != nil && ctx.Err() == nil {
+			err = m.deadLetter(ctx, r, eventDB, attempts, err)
+		}
+		if err != nil {
+			return
+		}
+
+		saved = position
+		return m.saveCheckpoint(ctx, r.projection.Name, position)
+	})
+	if err != nil {
+		return
+	}
+
+	// skip the events of other categories for good
+	if position > saved {
+		err = m.saveCheckpoint(ctx, r.projection.Name, position)
+	}
+
+	return
+}
+
+// applyWithRetry applies the event until it succeeds or the attempts of the retry policy are exhausted
+func (m *Manager) applyWithRetry(ctx context.Context, r *runner, eventDB events.EventDB) (applied bool, attempts int, err error) {
+	for attempts = 1; ; attempts++ {
+		applied, err = m.apply(ctx, r, eventDB)
+		if err == nil || attempts >= m.retryPolicy.Attempts {
+			return
+		}
+
+		backoff := m.retryPolicy.backoff(attempts)
+		m.log.Warn().Err(err).Str("projection", r.projection.Name).Int64("position", eventDB.Position).Int("attempt", attempts).Dur("backoff", backoff).Msg("event application failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// apply applies the event to the projection, it reports false for an event the read model contains already
+func (m *Manager) apply(ctx context.Context, r *runner, eventDB events.EventDB) (applied bool, err error) {
+	event, err := m.registry.Decode(eventDB)
+	if err != nil {
+		return
+	}
+
+	err = r.projection.Apply(events.CausedBy(ctx, event.Metadata()), event, eventDB.Version)
+	if errors.Is(err, ErrAlreadyApplied) {
+		m.log.Debug().Str("projection", r.projection.Name).Int64("position", eventDB.Position).Msg("event already applied")
+		err = nil
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot apply event %d: %w", eventDB.Position, err)
+		return
+	}
+	applied = true
+
+	return
+}
+
+func (m *Manager) loadCheckpoint(ctx context.Context, name string) (checkpoint Checkpoint, err error) {
+	err = m.checkpointsCollection.FindOne(ctx, bson.M{"_id": name}).Decode(&checkpoint)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		checkpoint = Checkpoint{Name: name}
+		err = nil
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot load checkpoint: %w", err)
+		return
+	}
+
+	return
+}
+
+func (m *Manager) saveCheckpoint(ctx context.Context, name string, position int64) (err error) {
+	_, err = m.checkpointsCollection.ReplaceOne(
+		ctx,
+		bson.M{"_id": name},
+		Checkpoint{
+			Name:      name,
+			Position:  position,
+			UpdatedAt: time.Now(),
+		},
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		err = fmt.Errorf("cannot save checkpoint: %w", err)
+		return
+	}
+
+	return
+}
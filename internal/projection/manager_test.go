@@ -0,0 +1,270 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongo_options "go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/transport"
+)
+
+const envTestMongoURI = "SNACK_TEST_MONGO_URI"
+
+type testEvent struct {
+	events.Envelope
+
+	ID string
+}
+
+func (e *testEvent) EventCategory() string  { return "test" }
+func (e *testEvent) EventType() string      { return "happened" }
+func (e *testEvent) AggregateID() string    { return e.ID }
+func (e *testEvent) Data() bson.M           { return bson.M{"id": e.ID} }
+func (e *testEvent) ToProto() proto.Message { return &emptypb.Empty{} }
+
+// nopTransport delivers nothing, the manager catches up by polling the store
+type nopTransport struct{}
+
+func (nopTransport) Publish(msg *nats.Msg) error { return nil }
+func (nopTransport) Subscribe(subject, durable string, handler transport.Handler) error {
+	return nil
+}
+func (nopTransport) Listen(subject string, handler func(msg *nats.Msg)) (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+// countingProjection counts the applications of every event and fails the events in failing.
+// Like the read models, it keeps the version of the last event of every aggregate applied to it.
+type countingProjection struct {
+	mu       sync.Mutex
+	applied  map[string]int
+	versions map[string]int
+	failing  map[string]bool
+}
+
+func (p *countingProjection) projection() Projection {
+	return Projection{
+		Name:       "counting",
+		Categories: []string{"test"},
+		Apply: func(ctx context.Context, event events.Event, version int) error {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			id := event.AggregateID()
+			if p.failing[id] {
+				return fmt.Errorf("event %s failed", id)
+			}
+			if p.versions[id] >= version {
+				return ErrAlreadyApplied
+			}
+			p.applied[id]++
+			p.versions[id] = version
+
+			return nil
+		},
+		Reset: func(ctx context.Context) error {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			p.applied = map[string]int{}
+			p.versions = map[string]int{}
+
+			return nil
+		},
+	}
+}
+
+func (p *countingProjection) count(id string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.applied[id]
+}
+
+func (p *countingProjection) setFailing(id string, failing bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failing[id] = failing
+}
+
+func newTestManager(t *testing.T, ids ...string) (*Manager, *countingProjection) {
+	uri := os.Getenv(envTestMongoURI)
+	if uri == "" {
+		t.Skipf("%s not set", envTestMongoURI)
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, mongo_options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("cannot connect to mongo: %v", err)
+	}
+	db := client.Database(fmt.Sprintf("night_snack_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	registry := events.NewRegistry()
+	registry.MustRegister(events.EventType{
+		Event: &testEvent{},
+		Proto: &emptypb.Empty{},
+		FromData: func(data bson.M) (events.Event, error) {
+			r := events.NewDataReader(data)
+			e := &testEvent{ID: r.String("id")}
+			return e, r.Err()
+		},
+		FromProto: func(msg proto.Message) events.Event { return &testEvent{} },
+	})
+
+	store := events.NewMemoryStore()
+	for _, id := range ids {
+		err = store.Append(ctx, "test", id, []events.EventDB{{
+			AggregateID: id,
+			Version:     1,
+			Timestamp:   time.Now(),
+			Category:    "test",
+			Type:        "happened",
+			Data:        bson.M{"id": id},
+			Metadata:    events.Metadata{EventID: "event-" + id, SchemaVersion: events.DefaultSchemaVersion},
+		}}, 0)
+		if err != nil {
+			t.Fatalf("cannot append event: %v", err)
+		}
+	}
+
+	m, err := NewManager(nopTransport{}, store, db, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("cannot create manager: %v", err)
+	}
+	t.Cleanup(m.Close)
+	m.registry = registry
+	m.SetRetryPolicy(RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	p := &countingProjection{applied: map[string]int{}, versions: map[string]int{}, failing: map[string]bool{}}
+
+	return m, p
+}
+
+func TestManagerAppliesEventsOnce(t *testing.T) {
+	m, p := newTestManager(t, "a", "b")
+	ctx := context.Background()
+
+	err := m.Register(p.projection())
+	if err != nil {
+		t.Fatalf("cannot register projection: %v", err)
+	}
+
+	// a lost checkpoint makes the manager read the applied events again
+	err = m.saveCheckpoint(ctx, "counting", 0)
+	if err != nil {
+		t.Fatalf("cannot save checkpoint: %v", err)
+	}
+	r, err := m.runner("counting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.catchUp(ctx, r)
+	if err != nil {
+		t.Fatalf("cannot catch up: %v", err)
+	}
+
+	replayed, err := m.Replay(ctx, "counting", "test")
+	if err != nil {
+		t.Fatalf("cannot replay: %v", err)
+	}
+	if replayed != 0 {
+		t.Errorf("replayed %d events, expected none", replayed)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		if n := p.count(id); n != 1 {
+			t.Errorf("event %s applied %d times, expected once", id, n)
+		}
+	}
+
+	checkpoints, err := m.Checkpoints(ctx)
+	if err != nil {
+		t.Fatalf("cannot get checkpoints: %v", err)
+	}
+	if len(checkpoints) != 1 || checkpoints[0].Position != 2 {
+		t.Errorf("unexpected checkpoints %+v, expected counting at 2", checkpoints)
+	}
+}
+
+func TestManagerDeadLetters(t *testing.T) {
+	m, p := newTestManager(t, "a", "b")
+	ctx := context.Background()
+
+	p.setFailing("a", true)
+	err := m.Register(p.projection())
+	if err != nil {
+		t.Fatalf("cannot register projection: %v", err)
+	}
+
+	if n := p.count("b"); n != 1 {
+		t.Errorf("event following the failing one applied %d times, expected once", n)
+	}
+
+	deadLetters, err := m.DeadLetters(ctx, "counting")
+	if err != nil {
+		t.Fatalf("cannot get dead letters: %v", err)
+	}
+	if len(deadLetters) != 1 {
+		t.Fatalf("got %d dead letters, expected 1", len(deadLetters))
+	}
+	deadLetter := deadLetters[0]
+	if deadLetter.Event.AggregateID != "a" || deadLetter.Attempts != 3 {
+		t.Errorf("unexpected dead letter of %s after %d attempts, expected a after 3", deadLetter.Event.AggregateID, deadLetter.Attempts)
+	}
+
+	err = m.Redrive(ctx, deadLetter.ID)
+	if err == nil {
+		t.Fatal("redrive of a still failing event succeeded")
+	}
+	deadLetter, err = m.DeadLetter(ctx, deadLetter.ID)
+	if err != nil {
+		t.Fatalf("cannot get dead letter: %v", err)
+	}
+	if deadLetter.Attempts != 4 {
+		t.Errorf("dead letter has %d attempts after a failed redrive, expected 4", deadLetter.Attempts)
+	}
+
+	p.setFailing("a", false)
+	err = m.Redrive(ctx, deadLetter.ID)
+	if err != nil {
+		t.Fatalf("cannot redrive: %v", err)
+	}
+	if n := p.count("a"); n != 1 {
+		t.Errorf("redriven event applied %d times, expected once", n)
+	}
+
+	_, err = m.DeadLetter(ctx, deadLetter.ID)
+	if !errors.Is(err, ErrUnknownDeadLetter) {
+		t.Errorf("got %v for a redriven dead letter, expected %v", err, ErrUnknownDeadLetter)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{Attempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, d := range expected {
+		if backoff := policy.backoff(i + 1); backoff != d {
+			t.Errorf("backoff after attempt %d is %s, expected %s", i+1, backoff, d)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package projection
+
+import (
+	"context"
+	"time"
+
+	"github.com/sveatlo/night_snack/internal/events"
+)
+
+// Projection is a read model built from the events of some categories
+type Projection struct {
+	// Name identifies the projection and its checkpoint
+	Name string
+	// Categories are the categories of the events the projection is built from
+	Categories []string
+	// Apply updates the read model with a single event at the version of its aggregate.
+	// The event may be delivered again, the read model records the version along with the update, see Save,
+	// and ErrAlreadyApplied is returned for an event it contains already.
+	Apply func(ctx context.Context, event events.Event, version int) error
+	// Reset removes the read model before it is rebuilt from the beginning
+	Reset func(ctx context.Context) error
+}
+
+func (p *Projection) handles(category string) bool {
+	for _, c := range p.Categories {
+		if c == category {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RetryPolicy controls how many times a failing event is applied before it is dead-lettered
+type RetryPolicy struct {
+	// Attempts is the number of attempts to apply a single event
+	Attempts int
+	// InitialBackoff is the delay after the first failed attempt, it is doubled after every following one
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is used by the managers unless set otherwise
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:       5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+// backoff returns the delay after the failed attempt, counted from 1
+func (p RetryPolicy) backoff(attempt int) (d time.Duration) {
+	d = p.InitialBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	return
+}
+
+// Checkpoint is the position of the last event applied to a projection
+type Checkpoint struct {
+	Name      string    `bson:"_id" json:"name"`
+	Position  int64     `bson:"position" json:"position"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,98 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// VersionField holds the version of the last event applied to a read model document of an aggregate
+const VersionField = "_version"
+
+var (
+	// ErrAlreadyApplied is returned by the projections for an event their read model already contains
+	ErrAlreadyApplied = errors.New("event already applied")
+	// ErrOutOfOrder is returned when the read model document is not at the version preceding the event's,
+	// i.e. an event of the aggregate before it was not applied
+	ErrOutOfOrder = errors.New("event out of order")
+)
+
+// Save replaces the read model document of the aggregate with doc, provided that the document is at the version
+// preceding the event's. The update and the version of the event are stored in a single write, so an event
+// delivered again is never applied twice. The document is created by the first event of the aggregate.
+func Save(ctx context.Context, collection *mongo.Collection, id string, version int, doc interface{}) (err error) {
+	replacement, err := withVersion(doc, version)
+	if err != nil {
+		return
+	}
+
+	res, err := collection.ReplaceOne(ctx, bson.M{"_id": id, VersionField: version - 1}, replacement, options.Replace().SetUpsert(version == 1))
+	if mongo.IsDuplicateKeyError(err) {
+		// the document created by the first event exists already
+		return checkVersion(ctx, collection, id, version, false)
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot save %s: %w", id, err)
+		return
+	}
+	if res.MatchedCount == 0 && res.UpsertedCount == 0 {
+		return checkVersion(ctx, collection, id, version, false)
+	}
+
+	return
+}
+
+// Delete removes the read model document of the aggregate, provided that the document is at the version preceding the event's
+func Delete(ctx context.Context, collection *mongo.Collection, id string, version int) (err error) {
+	res, err := collection.DeleteOne(ctx, bson.M{"_id": id, VersionField: version - 1})
+	if err != nil {
+		err = fmt.Errorf("cannot delete %s: %w", id, err)
+		return
+	}
+	if res.DeletedCount == 0 {
+		return checkVersion(ctx, collection, id, version, true)
+	}
+
+	return
+}
+
+// checkVersion tells why the write of the event at the version matched no document
+func checkVersion(ctx context.Context, collection *mongo.Collection, id string, version int, deleting bool) (err error) {
+	var current struct {
+		Version int `bson:"_version"`
+	}
+	err = collection.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{VersionField: 1})).Decode(&current)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments) && deleting:
+		return ErrAlreadyApplied
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return fmt.Errorf("%w: no document %s for the event at version %d", ErrOutOfOrder, id, version)
+	case err != nil:
+		return fmt.Errorf("cannot check version of %s: %w", id, err)
+	case current.Version >= version:
+		return ErrAlreadyApplied
+	}
+
+	return fmt.Errorf("%w: document %s at version %d, event at version %d", ErrOutOfOrder, id, current.Version, version)
+}
+
+// withVersion encodes the document along with the version
+func withVersion(doc interface{}, version int) (versioned bson.D, err error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		err = fmt.Errorf("cannot encode document: %w", err)
+		return
+	}
+	err = bson.Unmarshal(raw, &versioned)
+	if err != nil {
+		err = fmt.Errorf("cannot decode document: %w", err)
+		return
+	}
+	versioned = append(versioned, bson.E{Key: VersionField, Value: version})
+
+	return
+}
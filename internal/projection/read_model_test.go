@@ -0,0 +1,87 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongo_options "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type testDocument struct {
+	ID string `bson:"_id"`
+	N  int    `bson:"n"`
+}
+
+func newTestCollection(t *testing.T) *mongo.Collection {
+	uri := os.Getenv(envTestMongoURI)
+	if uri == "" {
+		t.Skipf("%s not set", envTestMongoURI)
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, mongo_options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("cannot connect to mongo: %v", err)
+	}
+	db := client.Database(fmt.Sprintf("night_snack_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	return db.Collection("documents")
+}
+
+func TestSaveAndDelete(t *testing.T) {
+	collection := newTestCollection(t)
+	ctx := context.Background()
+
+	// the steps run in order against the same document
+	steps := []struct {
+		name    string
+		version int
+		delete  bool
+		err     error
+		n       int
+	}{
+		{name: "first event creates the document", version: 1, n: 1},
+		{name: "first event delivered again", version: 1, err: ErrAlreadyApplied, n: 1},
+		{name: "following event", version: 2, n: 2},
+		{name: "event after a missing one", version: 4, err: ErrOutOfOrder, n: 2},
+		{name: "following event delivered again", version: 2, err: ErrAlreadyApplied, n: 2},
+		{name: "event after the missing one is applied", version: 3, n: 3},
+		{name: "deletion out of order", version: 5, delete: true, err: ErrOutOfOrder, n: 3},
+		{name: "deletion", version: 4, delete: true},
+		{name: "deletion delivered again", version: 4, delete: true, err: ErrAlreadyApplied},
+		{name: "event of the deleted document", version: 5, err: ErrOutOfOrder},
+	}
+	for _, step := range steps {
+		var err error
+		if step.delete {
+			err = Delete(ctx, collection, "a", step.version)
+		} else {
+			err = Save(ctx, collection, "a", step.version, testDocument{ID: "a", N: step.version})
+		}
+		if !errors.Is(err, step.err) {
+			t.Fatalf("%s: got %v, expected %v", step.name, err, step.err)
+		}
+
+		var stored testDocument
+		err = collection.FindOne(ctx, bson.M{"_id": "a"}).Decode(&stored)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			err = nil
+		}
+		if err != nil {
+			t.Fatalf("%s: cannot load document: %v", step.name, err)
+		}
+		if stored.N != step.n {
+			t.Errorf("%s: document holds %d, expected %d", step.name, stored.N, step.n)
+		}
+	}
+}
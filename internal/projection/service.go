@@ -0,0 +1,124 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/moderntv/cadre/metrics"
+	"github.com/moderntv/cadre/status"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	projection_pb "github.com/sveatlo/night_snack/proto/projection"
+)
+
+// Service exposes the dead letters of the projections
+type Service struct {
+	projection_pb.UnimplementedProjectionServiceServer
+
+	log    zerolog.Logger
+	status *status.ComponentStatus
+
+	manager *Manager
+}
+
+func NewService(manager *Manager, metricsRegistry *metrics.Registry, appStatus *status.Status, log zerolog.Logger) (s *Service, err error) {
+	cs, err := appStatus.Register("projection/svc")
+	if err != nil {
+		return
+	}
+
+	s = &Service{
+		log:    log.With().Str("component", "projection/svc").Logger(),
+		status: cs,
+
+		manager: manager,
+	}
+
+	return
+}
+
+func (s *Service) Close() {}
+
+func (s *Service) GetDeadLetters(ctx context.Context, query *projection_pb.GetDeadLetters) (res *projection_pb.DeadLetters, err error) {
+	deadLetters, err := s.manager.DeadLetters(ctx, query.GetProjection())
+	if err != nil {
+		err = fmt.Errorf("dead letters query failed: %w", err)
+		return
+	}
+
+	res = &projection_pb.DeadLetters{
+		DeadLetters: make([]*projection_pb.DeadLetter, len(deadLetters)),
+	}
+	for i, deadLetter := range deadLetters {
+		res.DeadLetters[i], err = deadLetter.ToProto()
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (s *Service) GetDeadLetter(ctx context.Context, query *projection_pb.GetDeadLetter) (res *projection_pb.DeadLetter, err error) {
+	deadLetter, err := s.manager.DeadLetter(ctx, query.GetId())
+	if err != nil {
+		err = statusFromError(fmt.Errorf("dead letter query failed: %w", err))
+		return
+	}
+
+	return deadLetter.ToProto()
+}
+
+func (s *Service) RedriveDeadLetter(ctx context.Context, cmd *projection_pb.CmdRedriveDeadLetter) (res *projection_pb.DeadLetterRedriven, err error) {
+	err = s.manager.Redrive(ctx, cmd.GetId())
+	if err != nil {
+		err = statusFromError(fmt.Errorf("redrive failed: %w", err))
+		return
+	}
+
+	res = &projection_pb.DeadLetterRedriven{
+		Id: cmd.GetId(),
+	}
+
+	return
+}
+
+// ToProto converts the dead letter including the data of its event
+func (d *DeadLetter) ToProto() (deadLetter *projection_pb.DeadLetter, err error) {
+	data, err := bson.MarshalExtJSON(d.Event.Data, false, false)
+	if err != nil {
+		err = fmt.Errorf("cannot encode event data: %w", err)
+		return
+	}
+
+	deadLetter = &projection_pb.DeadLetter{
+		Id:         d.ID,
+		Projection: d.Projection,
+		Error:      d.Error,
+		Attempts:   int32(d.Attempts),
+		FailedAt:   timestamppb.New(d.FailedAt),
+
+		Position:    d.Event.Position,
+		EventId:     d.Event.Metadata.EventID,
+		Category:    d.Event.Category,
+		Type:        d.Event.Type,
+		AggregateId: d.Event.AggregateID,
+		Version:     int32(d.Event.Version),
+		Data:        string(data),
+	}
+
+	return
+}
+
+func statusFromError(err error) error {
+	if errors.Is(err, ErrUnknownDeadLetter) || errors.Is(err, ErrUnknownProjection) {
+		return grpc_status.Error(codes.NotFound, err.Error())
+	}
+
+	return err
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sveatlo/night_snack/internal/events"
+)
+
+// AsOf is the point in the history of an aggregate it is folded at.
+// The zero value includes all the events.
+type AsOf struct {
+	// Time excludes the events which occurred after it, ignored when zero
+	Time time.Time
+	// Version excludes the events with a higher version, ignored when 0
+	Version int
+}
+
+// NewAsOf creates the point from the fields of a query, timestamp may be nil
+func NewAsOf(timestamp *timestamppb.Timestamp, version int32) (asOf AsOf) {
+	if timestamp != nil {
+		asOf.Time = timestamp.AsTime()
+	}
+	asOf.Version = int(version)
+
+	return
+}
+
+// Includes reports whether the event happened at or before the point
+func (a AsOf) Includes(eventDB events.EventDB) bool {
+	if !a.Time.IsZero() && eventDB.Timestamp.After(a.Time) {
+		return false
+	}
+	if a.Version > 0 && eventDB.Version > a.Version {
+		return false
+	}
+
+	return true
+}
+
+// LoadAggregateAsOf loads the events of the aggregate up to the point given by asOf.
+// Snapshots are not used, the aggregate is always folded from its first event.
+// The version of the returned aggregate is the version of its last included event.
+func (repo *Base) LoadAggregateAsOf(category, id string, asOf AsOf) (aggregate events.AggregateDB, err error) {
+	aggregate, err = repo.LoadAggregate(category, id)
+	if err != nil {
+		return
+	}
+
+	included := 0
+	for _, eventDB := range aggregate.Events {
+		// the history is cut at the first excluded event to keep a consistent prefix of the stream
+		if !asOf.Includes(eventDB) {
+			break
+		}
+		included++
+	}
+	aggregate.Events = aggregate.Events[:included]
+	aggregate.Version = 0
+	if included > 0 {
+		aggregate.Version = aggregate.Events[included-1].Version
+	}
+
+	return
+}
@@ -5,104 +5,205 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
 	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/outbox"
+	"github.com/sveatlo/night_snack/internal/transport"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Base struct {
-	log zerolog.Logger
-	nc  *nats.EncodedConn
-
-	eventsCollection *mongo.Collection
+	log       zerolog.Logger
+	transport transport.Transport
+	store     events.Store
+	registry  *events.Registry
+	// relay publishes the saved events, nil when the events are only saved
+	relay *outbox.Relay
+
+	// snapshotEvery is the number of events after which a new snapshot is taken, 0 disables snapshots
+	snapshotEvery int
 }
 
-func NewBase(nc *nats.EncodedConn, mongoDB *mongo.Database, log zerolog.Logger) (b *Base, err error) {
+func NewBase(eventTransport transport.Transport, store events.Store, log zerolog.Logger) (b *Base, err error) {
 	b = &Base{
-		log:              log,
-		nc:               nc,
-		eventsCollection: mongoDB.Collection("events"),
+		log:       log,
+		transport: eventTransport,
+		store:     store,
+		registry:  events.DefaultRegistry,
 	}
 	return
 }
 
+// EnableSnapshots makes SnapshotIfDue take a snapshot after every n events
+func (repo *Base) EnableSnapshots(n int) {
+	repo.snapshotEvery = n
+}
+
+// EnableOutbox makes SaveEvents notify the relay about the saved events
+func (repo *Base) EnableOutbox(relay *outbox.Relay) {
+	repo.relay = relay
+}
+
+// NotifyOutbox makes the relay publish the saved events right away.
+// It has to be called after committing a transaction the events were saved within.
+func (repo *Base) NotifyOutbox() {
+	if repo.relay != nil {
+		repo.relay.Notify()
+	}
+}
+
 func (repo *Base) GetTopic(event events.Event) string {
-	return fmt.Sprintf("%s.%s", event.EventCategory(), event.EventType())
+	return events.Topic(event)
 }
 
-func (repo *Base) Publish(event events.Event) error {
-	repo.log.Debug().Interface("proto", event.ToProto()).Str("proto-type", fmt.Sprintf("%T", event.ToProto())).Msg("publishing proto event")
-	return repo.nc.Publish(repo.GetTopic(event), event.ToProto())
+// DecodeEvents decodes the stored events of the aggregate
+func (repo *Base) DecodeEvents(aggregate events.AggregateDB) (aggregateEvents []events.Event, err error) {
+	aggregateEvents = make([]events.Event, len(aggregate.Events))
+	for i, eventDB := range aggregate.Events {
+		aggregateEvents[i], err = repo.registry.Decode(eventDB)
+		if err != nil {
+			return
+		}
+	}
+
+	return
 }
 
 func (repo *Base) getAggregateDBModel(event events.Event, version int) events.EventDB {
+	metadata := event.Metadata()
+
 	return events.EventDB{
 		AggregateID: event.AggregateID(),
-		Timestamp:   time.Now(),
+		Version:     version,
+		Timestamp:   metadata.OccurredAt,
 		Category:    event.EventCategory(),
 		Type:        event.EventType(),
 		Data:        event.Data(),
+		Metadata:    metadata,
+		Unpublished: true,
 	}
 }
 
 func (repo *Base) LoadAggregate(category, id string) (aggregate events.AggregateDB, err error) {
-	res := repo.eventsCollection.FindOne(context.Background(), bson.M{"_id": id, "category": category})
-	if res.Err() == mongo.ErrNoDocuments {
-		aggregate = events.AggregateDB{
-			ID:       id,
-			Category: category,
-			Version:  0,
-			Events:   []events.EventDB{},
-		}
+	aggregate, err = repo.store.Load(context.Background(), category, id)
+	if err != nil {
+		err = fmt.Errorf("cannot load aggregate from store: %w", err)
 		return
 	}
-	if res.Err() != nil {
-		err = fmt.Errorf("cannot load aggregate from DB: %w", res.Err())
+
+	return
+}
+
+// LoadAggregateSnapshot decodes the latest snapshot of the aggregate into state
+// and returns the aggregate with only the events which follow the snapshot.
+// If there is no snapshot or it was taken with a different schema of state,
+// state is left untouched and all the events are returned.
+func (repo *Base) LoadAggregateSnapshot(category, id string, state interface{}) (aggregate events.AggregateDB, err error) {
+	snapshot, found, err := repo.store.LoadSnapshot(context.Background(), category, id)
+	if err != nil {
+		err = fmt.Errorf("cannot load snapshot from store: %w", err)
 		return
 	}
+	if !found || snapshot.Schema != events.SchemaOf(state) {
+		return repo.LoadAggregate(category, id)
+	}
 
-	res.Decode(&aggregate)
+	err = bson.Unmarshal(snapshot.State, state)
+	if err != nil {
+		err = fmt.Errorf("cannot decode snapshot: %w", err)
+		return
+	}
+
+	aggregate, err = repo.store.LoadFrom(context.Background(), category, id, snapshot.Version)
+	if err != nil {
+		err = fmt.Errorf("cannot load aggregate from store: %w", err)
+		return
+	}
+	aggregate.SnapshotVersion = snapshot.Version
 
 	return
 }
 
-func (repo *Base) SaveEvents(eventCategory, aggregateID string, aggregateEvents []events.Event, originalVersion int) (err error) {
-	eventsDB := make([]events.EventDB, len(aggregateEvents))
+// SaveSnapshot stores state as the snapshot of the aggregate at version
+func (repo *Base) SaveSnapshot(category, id string, version int, state interface{}) (err error) {
+	data, err := bson.Marshal(state)
+	if err != nil {
+		err = fmt.Errorf("cannot encode snapshot: %w", err)
+		return
+	}
 
-	for i, event := range aggregateEvents {
-		eventsDB[i] = repo.getAggregateDBModel(event, originalVersion+i)
+	err = repo.store.SaveSnapshot(context.Background(), events.Snapshot{
+		Category:    category,
+		AggregateID: id,
+		Version:     version,
+		Timestamp:   time.Now(),
+		Schema:      events.SchemaOf(state),
+		State:       data,
+	})
+	if err != nil {
+		err = fmt.Errorf("cannot save snapshot to store: %w", err)
+		return
 	}
 
-	// Either insert a new aggregate or append to an existing.
-	if originalVersion == 0 {
-		aggregate := events.AggregateDB{
-			ID:       aggregateID,
-			Category: eventCategory,
-			Version:  len(eventsDB),
-			Events:   eventsDB,
-		}
+	return
+}
 
-		_, err = repo.eventsCollection.InsertOne(context.Background(), aggregate)
-		if err != nil {
-			err = fmt.Errorf("cannot insert new aggregate: %w", err)
-			return err
-		}
-	} else {
-		query := bson.M{"_id": aggregateID, "version": originalVersion}
-		repo.eventsCollection.UpdateOne(
-			context.Background(),
-			query,
-			bson.M{
-				"$push": bson.M{"events": bson.M{"$each": eventsDB}},
-				"$inc":  bson.M{"version": len(eventsDB)},
-			},
-		)
+// SnapshotIfDue saves state as a new snapshot when at least snapshotEvery events
+// were stored since the last one. Failures are only logged - snapshots are an optimization.
+func (repo *Base) SnapshotIfDue(category, id string, version, snapshotVersion int, state interface{}) {
+	if repo.snapshotEvery <= 0 || version-snapshotVersion < repo.snapshotEvery {
+		return
+	}
+
+	err := repo.SaveSnapshot(category, id, version, state)
+	if err != nil {
+		repo.log.Warn().Err(err).Str("category", category).Str("id", id).Int("version", version).Msg("snapshot failed")
+	}
+}
+
+// StreamAggregates calls fn for every aggregate of the category stored in the event store
+func (repo *Base) StreamAggregates(category string, fn func(events.AggregateDB) error) (err error) {
+	err = repo.store.Stream(context.Background(), category, fn)
+	if err != nil {
+		err = fmt.Errorf("cannot get events from store: %w", err)
+		return
+	}
+
+	return
+}
+
+// SaveEvents stamps the events with metadata derived from ctx and appends them to the aggregate.
+// The events are published by the outbox relay afterwards. When ctx carries a transaction
+// (see events.WithTransaction), the caller has to call NotifyOutbox once it is committed.
+func (repo *Base) SaveEvents(ctx context.Context, eventCategory, aggregateID string, aggregateEvents []events.Event, originalVersion int) (err error) {
+	eventsDB := make([]events.EventDB, len(aggregateEvents))
+
+	for i, event := range aggregateEvents {
+		var metadata events.Metadata
+		metadata, err = events.NewMetadata(ctx, repo.registry.SchemaVersion(event))
 		if err != nil {
-			err = fmt.Errorf("cannot add event to aggregate: %w", err)
-			return err
+			err = fmt.Errorf("cannot create event metadata: %w", err)
+			return
 		}
+		event.SetMetadata(metadata)
+
+		eventsDB[i] = repo.getAggregateDBModel(event, originalVersion+i+1)
+	}
+
+	storeCtx := context.Background()
+	tx, inTransaction := events.TransactionFromContext(ctx)
+	if inTransaction {
+		storeCtx = events.WithTransaction(storeCtx, tx)
+	}
+
+	err = repo.store.Append(storeCtx, eventCategory, aggregateID, eventsDB, originalVersion)
+	if err != nil {
+		err = fmt.Errorf("cannot save events: %w", err)
+		return
+	}
+
+	if !inTransaction {
+		repo.NotifyOutbox()
 	}
 
 	return
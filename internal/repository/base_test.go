@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/sveatlo/night_snack/internal/events"
+)
+
+type testEvent struct {
+	events.Envelope
+
+	ID string
+}
+
+func (e *testEvent) EventCategory() string  { return "test" }
+func (e *testEvent) EventType() string      { return "happened" }
+func (e *testEvent) AggregateID() string    { return e.ID }
+func (e *testEvent) Data() bson.M           { return bson.M{"id": e.ID} }
+func (e *testEvent) ToProto() proto.Message { return &emptypb.Empty{} }
+
+func newTestBase(t *testing.T) (*Base, events.Store) {
+	store := events.NewMemoryStore()
+	base, err := NewBase(nil, store, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("cannot create repository: %v", err)
+	}
+
+	return base, store
+}
+
+func TestSaveEventsConcurrentConflict(t *testing.T) {
+	base, store := newTestBase(t)
+	ctx := context.Background()
+
+	const writers = 10
+	errs := make([]error, writers)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = base.SaveEvents(ctx, "test", "a", []events.Event{&testEvent{ID: "a"}}, 0)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	saved := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			saved++
+		case !errors.Is(err, ErrConcurrencyConflict):
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if saved != 1 {
+		t.Fatalf("%d writers saved at version 0, expected exactly 1", saved)
+	}
+
+	aggregate, err := store.Load(ctx, "test", "a")
+	if err != nil {
+		t.Fatalf("cannot load aggregate: %v", err)
+	}
+	if aggregate.Version != 1 || len(aggregate.Events) != 1 {
+		t.Fatalf("aggregate is at version %d with %d events, expected 1 and 1", aggregate.Version, len(aggregate.Events))
+	}
+}
+
+func TestRetryOnConflictReloads(t *testing.T) {
+	base, store := newTestBase(t)
+	ctx := context.Background()
+
+	// every conflicting writer lost to another one, so each needs at most writers attempts
+	const writers = conflictRetries
+	errs := make([]error, writers)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = RetryOnConflict(func() (err error) {
+				aggregate, err := base.LoadAggregate("test", "a")
+				if err != nil {
+					return
+				}
+
+				return base.SaveEvents(ctx, "test", "a", []events.Event{&testEvent{ID: "a"}}, aggregate.Version)
+			})
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("writer %d failed: %v", i, err)
+		}
+	}
+
+	aggregate, err := store.Load(ctx, "test", "a")
+	if err != nil {
+		t.Fatalf("cannot load aggregate: %v", err)
+	}
+	if aggregate.Version != writers {
+		t.Fatalf("aggregate is at version %d, expected %d", aggregate.Version, writers)
+	}
+	for i, eventDB := range aggregate.Events {
+		if eventDB.Version != i+1 {
+			t.Fatalf("event %d has version %d", i, eventDB.Version)
+		}
+	}
+}
+
+func TestRetryOnConflictGivesUp(t *testing.T) {
+	attempts := 0
+	err := RetryOnConflict(func() error {
+		attempts++
+		return ErrConcurrencyConflict
+	})
+	if !errors.Is(err, ErrConcurrencyConflict) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	if attempts != conflictRetries {
+		t.Fatalf("made %d attempts, expected %d", attempts, conflictRetries)
+	}
+
+	attempts = 0
+	failure := errors.New("failure")
+	err = RetryOnConflict(func() error {
+		attempts++
+		return failure
+	})
+	if !errors.Is(err, failure) || attempts != 1 {
+		t.Fatalf("other errors must not be retried, got %v after %d attempts", err, attempts)
+	}
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
+
+	"github.com/sveatlo/night_snack/internal/events"
+)
+
+const (
+	// conflictRetries is the number of attempts made by RetryOnConflict
+	conflictRetries = 5
+)
+
+// ErrConcurrencyConflict is returned when events are appended to an aggregate
+// whose version has changed since it was loaded.
+var ErrConcurrencyConflict = events.ErrConcurrencyConflict
+
+// ErrNotFound is returned when the aggregate does not exist
+var ErrNotFound = errors.New("aggregate not found")
+
+// ErrFailedPrecondition is returned when the aggregate is not in a state the command can be applied in
+var ErrFailedPrecondition = errors.New("failed precondition")
+
+// RetryOnConflict runs fn until it succeeds, fails with an error other than
+// ErrConcurrencyConflict or the retries are exhausted.
+// fn is expected to reload the aggregate on every run.
+func RetryOnConflict(fn func() error) (err error) {
+	for i := 0; i < conflictRetries; i++ {
+		err = fn()
+		if !errors.Is(err, ErrConcurrencyConflict) {
+			return
+		}
+	}
+
+	return
+}
+
+// StatusFromError converts known repository errors to gRPC status errors.
+// Unknown errors are returned as they are.
+func StatusFromError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, ErrConcurrencyConflict) {
+		return grpc_status.Error(codes.Aborted, err.Error())
+	}
+	if errors.Is(err, ErrNotFound) {
+		return grpc_status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, ErrFailedPrecondition) {
+		return grpc_status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	return err
+}
@@ -6,11 +6,17 @@ import (
 
 	"github.com/moderntv/cadre/metrics"
 	"github.com/moderntv/cadre/status"
-	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
-	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
 	"gorm.io/gorm"
 
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/money"
+	"github.com/sveatlo/night_snack/internal/outbox"
+	"github.com/sveatlo/night_snack/internal/repository"
+	"github.com/sveatlo/night_snack/internal/transport"
+	money_pb "github.com/sveatlo/night_snack/proto/money"
 	restaurant_pb "github.com/sveatlo/night_snack/proto/restaurant"
 )
 
@@ -23,13 +29,13 @@ type CommandService struct {
 	repo *WriteRepository
 }
 
-func NewCommandService(nec *nats.EncodedConn, db *gorm.DB, mongo *mongo.Database, metricsRegistry *metrics.Registry, appStatus *status.Status, log zerolog.Logger) (c *CommandService, err error) {
+func NewCommandService(eventTransport transport.Transport, db *gorm.DB, store events.Store, relay *outbox.Relay, snapshotEvery int, metricsRegistry *metrics.Registry, appStatus *status.Status, log zerolog.Logger) (c *CommandService, err error) {
 	cs, err := appStatus.Register("restaurant/command_svc")
 	if err != nil {
 		return
 	}
 
-	repo, err := NewWriteRepository(nec, db, mongo, log)
+	repo, err := NewWriteRepository(eventTransport, db, store, relay, snapshotEvery, log)
 	if err != nil {
 		err = fmt.Errorf("cannot create restaurant repository: %w", err)
 	}
@@ -49,7 +55,7 @@ func (s *CommandService) Close() {}
 func (s *CommandService) Create(ctx context.Context, cmd *restaurant_pb.CmdRestaurantCreate) (res *restaurant_pb.RestaurantCreated, err error) {
 	event, err := s.repo.Create(ctx, cmd.GetName())
 	if err != nil {
-		err = fmt.Errorf("creation failed: %w", err)
+		err = repository.StatusFromError(fmt.Errorf("creation failed: %w", err))
 		return
 	}
 
@@ -61,7 +67,7 @@ func (s *CommandService) Create(ctx context.Context, cmd *restaurant_pb.CmdResta
 func (s *CommandService) Update(ctx context.Context, cmd *restaurant_pb.CmdRestaurantUpdate) (res *restaurant_pb.RestaurantUpdated, err error) {
 	event, err := s.repo.Update(ctx, cmd.GetId(), cmd.GetName())
 	if err != nil {
-		err = fmt.Errorf("update failed: %w", err)
+		err = repository.StatusFromError(fmt.Errorf("update failed: %w", err))
 		return
 	}
 
@@ -73,7 +79,7 @@ func (s *CommandService) Update(ctx context.Context, cmd *restaurant_pb.CmdResta
 func (s *CommandService) Delete(ctx context.Context, cmd *restaurant_pb.CmdRestaurantDelete) (res *restaurant_pb.RestaurantDeleted, err error) {
 	event, err := s.repo.Delete(ctx, cmd.GetId())
 	if err != nil {
-		err = fmt.Errorf("deletion failed: %w", err)
+		err = repository.StatusFromError(fmt.Errorf("deletion failed: %w", err))
 		return
 	}
 
@@ -85,7 +91,7 @@ func (s *CommandService) Delete(ctx context.Context, cmd *restaurant_pb.CmdResta
 func (s *CommandService) CreateMenuCategory(ctx context.Context, cmd *restaurant_pb.CmdMenuCategoryCreate) (res *restaurant_pb.MenuCategoryCreated, err error) {
 	event, err := s.repo.CreateMenuCategory(ctx, cmd.GetRestaurantId(), cmd.GetName())
 	if err != nil {
-		err = fmt.Errorf("creation failed: %w", err)
+		err = repository.StatusFromError(fmt.Errorf("creation failed: %w", err))
 		return
 	}
 
@@ -97,7 +103,7 @@ func (s *CommandService) CreateMenuCategory(ctx context.Context, cmd *restaurant
 func (s *CommandService) UpdateMenuCategory(ctx context.Context, cmd *restaurant_pb.CmdMenuCategoryUpdate) (res *restaurant_pb.MenuCategoryUpdated, err error) {
 	event, err := s.repo.UpdateMenuCategory(ctx, cmd.GetId(), cmd.GetName())
 	if err != nil {
-		err = fmt.Errorf("update failed: %w", err)
+		err = repository.StatusFromError(fmt.Errorf("update failed: %w", err))
 		return
 	}
 
@@ -109,7 +115,7 @@ func (s *CommandService) UpdateMenuCategory(ctx context.Context, cmd *restaurant
 func (s *CommandService) DeleteMenuCategory(ctx context.Context, cmd *restaurant_pb.CmdMenuCategoryDelete) (res *restaurant_pb.MenuCategoryDeleted, err error) {
 	event, err := s.repo.DeleteMenuCategory(ctx, cmd.GetId())
 	if err != nil {
-		err = fmt.Errorf("delete failed: %w", err)
+		err = repository.StatusFromError(fmt.Errorf("delete failed: %w", err))
 		return
 	}
 
@@ -119,9 +125,14 @@ func (s *CommandService) DeleteMenuCategory(ctx context.Context, cmd *restaurant
 }
 
 func (s *CommandService) CreateMenuItem(ctx context.Context, cmd *restaurant_pb.CmdMenuItemCreate) (res *restaurant_pb.MenuItemCreated, err error) {
-	event, err := s.repo.CreateMenuItem(ctx, cmd.GetRestaurantId(), cmd.GetCategoryId(), cmd.GetName(), cmd.GetDescription())
+	price, err := priceFromProto(cmd.GetPrice())
 	if err != nil {
-		err = fmt.Errorf("creation failed: %w", err)
+		return
+	}
+
+	event, err := s.repo.CreateMenuItem(ctx, cmd.GetRestaurantId(), cmd.GetCategoryId(), cmd.GetName(), cmd.GetDescription(), price)
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("creation failed: %w", err))
 		return
 	}
 
@@ -133,7 +144,7 @@ func (s *CommandService) CreateMenuItem(ctx context.Context, cmd *restaurant_pb.
 func (s *CommandService) UpdateMenuItem(ctx context.Context, cmd *restaurant_pb.CmdMenuItemUpdate) (res *restaurant_pb.MenuItemUpdated, err error) {
 	event, err := s.repo.UpdateMenuItem(ctx, cmd.GetRestaurantId(), cmd.GetCategoryId(), cmd.GetId(), cmd.GetName(), cmd.GetDescription())
 	if err != nil {
-		err = fmt.Errorf("creation failed: %w", err)
+		err = repository.StatusFromError(fmt.Errorf("creation failed: %w", err))
 		return
 	}
 
@@ -142,10 +153,32 @@ func (s *CommandService) UpdateMenuItem(ctx context.Context, cmd *restaurant_pb.
 	return
 }
 
+// ChangeMenuItemPrice changes the price of the menu item, the already placed orders keep their prices
+func (s *CommandService) ChangeMenuItemPrice(ctx context.Context, cmd *restaurant_pb.CmdMenuItemChangePrice) (res *restaurant_pb.MenuItemPriceChanged, err error) {
+	if cmd.GetPrice() == nil {
+		err = grpc_status.Error(codes.InvalidArgument, "missing price")
+		return
+	}
+	price, err := priceFromProto(cmd.GetPrice())
+	if err != nil {
+		return
+	}
+
+	event, err := s.repo.ChangeMenuItemPrice(ctx, cmd.GetRestaurantId(), cmd.GetCategoryId(), cmd.GetId(), price)
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("price change failed: %w", err))
+		return
+	}
+
+	res = event.ToProto().(*restaurant_pb.MenuItemPriceChanged)
+
+	return
+}
+
 func (s *CommandService) DeleteMenuItem(ctx context.Context, cmd *restaurant_pb.CmdMenuItemDelete) (res *restaurant_pb.MenuItemDeleted, err error) {
 	event, err := s.repo.DeleteMenuItem(ctx, cmd.GetRestaurantId(), cmd.GetId())
 	if err != nil {
-		err = fmt.Errorf("deletion failed: %w", err)
+		err = repository.StatusFromError(fmt.Errorf("deletion failed: %w", err))
 		return
 	}
 
@@ -153,3 +186,29 @@ func (s *CommandService) DeleteMenuItem(ctx context.Context, cmd *restaurant_pb.
 
 	return
 }
+
+// Snapshot takes an on-demand snapshot of the restaurant aggregate
+func (s *CommandService) Snapshot(ctx context.Context, id string) (err error) {
+	err = s.repo.Snapshot(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("snapshot failed: %w", err)
+	}
+
+	return
+}
+
+// priceFromProto returns the price of a menu item, or an InvalidArgument error if it is negative or its currency is unknown
+func priceFromProto(p *money_pb.Money) (price money.Money, err error) {
+	price = money.NewFromProto(p)
+	if price.Amount < 0 {
+		err = grpc_status.Errorf(codes.InvalidArgument, "negative price %s", price)
+		return
+	}
+	err = price.Validate()
+	if err != nil {
+		err = grpc_status.Error(codes.InvalidArgument, err.Error())
+		return
+	}
+
+	return
+}
@@ -4,10 +4,10 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
-	bson_primitive "go.mongodb.org/mongo-driver/bson/primitive"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/money"
 	restaurant_pb "github.com/sveatlo/night_snack/proto/restaurant"
 )
 
@@ -22,10 +22,106 @@ var (
 
 	_ events.Event = &EventMenuItemCreated{}
 	_ events.Event = &EventMenuItemUpdated{}
+	_ events.Event = &EventMenuItemPriceChanged{}
 	_ events.Event = &EventMenuItemDeleted{}
 )
 
+func init() {
+	events.Register(
+		events.EventType{
+			Event:    &EventCreated{},
+			Proto:    &restaurant_pb.RestaurantCreated{},
+			FromData: func(data bson.M) (events.Event, error) { return EventCreatedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventCreatedFromProto(msg.(*restaurant_pb.RestaurantCreated))
+			},
+		},
+		events.EventType{
+			Event:    &EventUpdated{},
+			Proto:    &restaurant_pb.RestaurantUpdated{},
+			FromData: func(data bson.M) (events.Event, error) { return EventUpdatedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventUpdatedFromProto(msg.(*restaurant_pb.RestaurantUpdated))
+			},
+		},
+		events.EventType{
+			Event:    &EventDeleted{},
+			Proto:    &restaurant_pb.RestaurantDeleted{},
+			FromData: func(data bson.M) (events.Event, error) { return EventDeletedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventDeletedFromProto(msg.(*restaurant_pb.RestaurantDeleted))
+			},
+		},
+		events.EventType{
+			Event:    &EventMenuCategoryCreated{},
+			Proto:    &restaurant_pb.MenuCategoryCreated{},
+			FromData: func(data bson.M) (events.Event, error) { return EventMenuCategoryCreatedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventMenuCategoryCreatedFromProto(msg.(*restaurant_pb.MenuCategoryCreated))
+			},
+		},
+		events.EventType{
+			Event:    &EventMenuCategoryUpdated{},
+			Proto:    &restaurant_pb.MenuCategoryUpdated{},
+			FromData: func(data bson.M) (events.Event, error) { return EventMenuCategoryUpdatedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventMenuCategoryUpdatedFromProto(msg.(*restaurant_pb.MenuCategoryUpdated))
+			},
+		},
+		events.EventType{
+			Event:    &EventMenuCategoryDeleted{},
+			Proto:    &restaurant_pb.MenuCategoryDeleted{},
+			FromData: func(data bson.M) (events.Event, error) { return EventMenuCategoryDeletedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventMenuCategoryDeletedFromProto(msg.(*restaurant_pb.MenuCategoryDeleted))
+			},
+		},
+		events.EventType{
+			Event:    &EventMenuItemCreated{},
+			Proto:    &restaurant_pb.MenuItemCreated{},
+			FromData: func(data bson.M) (events.Event, error) { return EventMenuItemCreatedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventMenuItemCreatedFromProto(msg.(*restaurant_pb.MenuItemCreated))
+			},
+			SchemaVersion: 2,
+			Upcasters: map[int]events.Upcaster{
+				// the menu items had no prices
+				1: func(data bson.M) (bson.M, error) {
+					data["price"] = bson.M{"amount": int64(0), "currency": money.DefaultCurrency}
+					return data, nil
+				},
+			},
+		},
+		events.EventType{
+			Event:    &EventMenuItemUpdated{},
+			Proto:    &restaurant_pb.MenuItemUpdated{},
+			FromData: func(data bson.M) (events.Event, error) { return EventMenuItemUpdatedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventMenuItemUpdatedFromProto(msg.(*restaurant_pb.MenuItemUpdated))
+			},
+		},
+		events.EventType{
+			Event:    &EventMenuItemPriceChanged{},
+			Proto:    &restaurant_pb.MenuItemPriceChanged{},
+			FromData: func(data bson.M) (events.Event, error) { return EventMenuItemPriceChangedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventMenuItemPriceChangedFromProto(msg.(*restaurant_pb.MenuItemPriceChanged))
+			},
+		},
+		events.EventType{
+			Event:    &EventMenuItemDeleted{},
+			Proto:    &restaurant_pb.MenuItemDeleted{},
+			FromData: func(data bson.M) (events.Event, error) { return EventMenuItemDeletedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventMenuItemDeletedFromProto(msg.(*restaurant_pb.MenuItemDeleted))
+			},
+		},
+	)
+}
+
 type EventCreated struct {
+	events.Envelope `bson:"-" json:"-"`
+
 	ID   string `bson:"id,omitempty" json:"id,omitempty"`
 	Name string `bson:"name,omitempty" json:"name,omitempty"`
 }
@@ -37,11 +133,15 @@ func EventCreatedFromProto(cmd *restaurant_pb.RestaurantCreated) *EventCreated {
 	}
 }
 
-func EventCreatedFromData(data bson.M) *EventCreated {
-	return &EventCreated{
-		ID:   data["id"].(string),
-		Name: data["name"].(string),
+func EventCreatedFromData(data bson.M) (e *EventCreated, err error) {
+	r := events.NewDataReader(data)
+	e = &EventCreated{
+		ID:   r.String("id"),
+		Name: r.String("name"),
 	}
+	err = r.Err()
+
+	return
 }
 
 func (e *EventCreated) EventCategory() string { return "restaurant" }
@@ -61,6 +161,8 @@ func (e *EventCreated) ToProto() proto.Message {
 }
 
 type EventUpdated struct {
+	events.Envelope `bson:"-" json:"-"`
+
 	ID   string `bson:"id,omitempty" json:"id,omitempty"`
 	Name string `bson:"name,omitempty" json:"name,omitempty"`
 }
@@ -72,11 +174,15 @@ func EventUpdatedFromProto(cmd *restaurant_pb.RestaurantUpdated) *EventUpdated {
 	}
 }
 
-func EventUpdatedFromData(data bson.M) *EventUpdated {
-	return &EventUpdated{
-		ID:   data["id"].(string),
-		Name: data["name"].(string),
+func EventUpdatedFromData(data bson.M) (e *EventUpdated, err error) {
+	r := events.NewDataReader(data)
+	e = &EventUpdated{
+		ID:   r.String("id"),
+		Name: r.String("name"),
 	}
+	err = r.Err()
+
+	return
 }
 
 func (e *EventUpdated) EventCategory() string { return "restaurant" }
@@ -96,6 +202,8 @@ func (e *EventUpdated) ToProto() proto.Message {
 }
 
 type EventDeleted struct {
+	events.Envelope `bson:"-" json:"-"`
+
 	ID        string    `bson:"id,omitempty" json:"id,omitempty"`
 	DeletedAt time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
 }
@@ -106,11 +214,15 @@ func EventDeletedFromProto(cmd *restaurant_pb.RestaurantDeleted) *EventDeleted {
 	}
 }
 
-func EventDeletedFromData(data bson.M) *EventDeleted {
-	return &EventDeleted{
-		ID:        data["id"].(string),
-		DeletedAt: (data["deleted_at"].(bson_primitive.DateTime)).Time(),
+func EventDeletedFromData(data bson.M) (e *EventDeleted, err error) {
+	r := events.NewDataReader(data)
+	e = &EventDeleted{
+		ID:        r.String("id"),
+		DeletedAt: r.Time("deleted_at"),
 	}
+	err = r.Err()
+
+	return
 }
 
 func (e *EventDeleted) EventCategory() string { return "restaurant" }
@@ -129,6 +241,8 @@ func (e *EventDeleted) ToProto() proto.Message {
 }
 
 type EventMenuCategoryCreated struct {
+	events.Envelope `bson:"-" json:"-"`
+
 	ID           string `bson:"id,omitempty" json:"id,omitempty"`
 	RestaurantID string `bson:"restaurant_id,omitempty" json:"restaurant_id,omitempty"`
 	Name         string `bson:"name,omitempty" json:"name,omitempty"`
@@ -142,12 +256,16 @@ func EventMenuCategoryCreatedFromProto(cmd *restaurant_pb.MenuCategoryCreated) *
 	}
 }
 
-func EventMenuCategoryCreatedFromData(data bson.M) *EventMenuCategoryCreated {
-	return &EventMenuCategoryCreated{
-		ID:           data["id"].(string),
-		RestaurantID: data["restaurant_id"].(string),
-		Name:         data["name"].(string),
+func EventMenuCategoryCreatedFromData(data bson.M) (e *EventMenuCategoryCreated, err error) {
+	r := events.NewDataReader(data)
+	e = &EventMenuCategoryCreated{
+		ID:           r.String("id"),
+		RestaurantID: r.String("restaurant_id"),
+		Name:         r.String("name"),
 	}
+	err = r.Err()
+
+	return
 }
 
 func (e *EventMenuCategoryCreated) EventCategory() string { return "restaurant" }
@@ -169,6 +287,8 @@ func (e *EventMenuCategoryCreated) ToProto() proto.Message {
 }
 
 type EventMenuCategoryUpdated struct {
+	events.Envelope `bson:"-" json:"-"`
+
 	ID           string `bson:"id,omitempty" json:"id,omitempty"`
 	RestaurantID string `bson:"restaurant_id,omitempty" json:"restaurant_id,omitempty"`
 	Name         string `bson:"name,omitempty" json:"name,omitempty"`
@@ -182,12 +302,16 @@ func EventMenuCategoryUpdatedFromProto(cmd *restaurant_pb.MenuCategoryUpdated) *
 	}
 }
 
-func EventMenuCategoryUpdatedFromData(data bson.M) *EventMenuCategoryUpdated {
-	return &EventMenuCategoryUpdated{
-		ID:           data["id"].(string),
-		RestaurantID: data["restaurant_id"].(string),
-		Name:         data["name"].(string),
+func EventMenuCategoryUpdatedFromData(data bson.M) (e *EventMenuCategoryUpdated, err error) {
+	r := events.NewDataReader(data)
+	e = &EventMenuCategoryUpdated{
+		ID:           r.String("id"),
+		RestaurantID: r.String("restaurant_id"),
+		Name:         r.String("name"),
 	}
+	err = r.Err()
+
+	return
 }
 
 func (e *EventMenuCategoryUpdated) EventCategory() string { return "restaurant" }
@@ -209,6 +333,8 @@ func (e *EventMenuCategoryUpdated) ToProto() proto.Message {
 }
 
 type EventMenuCategoryDeleted struct {
+	events.Envelope `bson:"-" json:"-"`
+
 	ID           string `bson:"id,omitempty" json:"id,omitempty"`
 	RestaurantID string `bson:"restaurant_id,omitempty" json:"restaurant_id,omitempty"`
 }
@@ -220,11 +346,15 @@ func EventMenuCategoryDeletedFromProto(cmd *restaurant_pb.MenuCategoryDeleted) *
 	}
 }
 
-func EventMenuCategoryDeletedFromData(data bson.M) *EventMenuCategoryDeleted {
-	return &EventMenuCategoryDeleted{
-		ID:           data["id"].(string),
-		RestaurantID: data["restaurant_id"].(string),
+func EventMenuCategoryDeletedFromData(data bson.M) (e *EventMenuCategoryDeleted, err error) {
+	r := events.NewDataReader(data)
+	e = &EventMenuCategoryDeleted{
+		ID:           r.String("id"),
+		RestaurantID: r.String("restaurant_id"),
 	}
+	err = r.Err()
+
+	return
 }
 
 func (e *EventMenuCategoryDeleted) EventCategory() string { return "restaurant" }
@@ -244,11 +374,14 @@ func (e *EventMenuCategoryDeleted) ToProto() proto.Message {
 }
 
 type EventMenuItemCreated struct {
-	ID           string `bson:"id,omitempty" json:"id,omitempty"`
-	RestaurantID string `bson:"restaurant_id,omitempty" json:"restaurant_id,omitempty"`
-	CategoryID   string `bson:"category_id,omitempty" json:"category_id,omitempty"`
-	Name         string `bson:"name,omitempty" json:"name,omitempty"`
-	Description  string `bson:"description,omitempty" json:"description,omitempty"`
+	events.Envelope `bson:"-" json:"-"`
+
+	ID           string      `bson:"id,omitempty" json:"id,omitempty"`
+	RestaurantID string      `bson:"restaurant_id,omitempty" json:"restaurant_id,omitempty"`
+	CategoryID   string      `bson:"category_id,omitempty" json:"category_id,omitempty"`
+	Name         string      `bson:"name,omitempty" json:"name,omitempty"`
+	Description  string      `bson:"description,omitempty" json:"description,omitempty"`
+	Price        money.Money `bson:"price" json:"price"`
 }
 
 func EventMenuItemCreatedFromProto(cmd *restaurant_pb.MenuItemCreated) *EventMenuItemCreated {
@@ -258,17 +391,23 @@ func EventMenuItemCreatedFromProto(cmd *restaurant_pb.MenuItemCreated) *EventMen
 		CategoryID:   cmd.GetCategoryId(),
 		Name:         cmd.GetName(),
 		Description:  cmd.GetDescription(),
+		Price:        money.NewFromProto(cmd.GetPrice()),
 	}
 }
 
-func EventMenuItemCreatedFromData(data bson.M) *EventMenuItemCreated {
-	return &EventMenuItemCreated{
-		ID:           data["id"].(string),
-		RestaurantID: data["restaurant_id"].(string),
-		CategoryID:   data["category_id"].(string),
-		Name:         data["name"].(string),
-		Description:  data["description"].(string),
+func EventMenuItemCreatedFromData(data bson.M) (e *EventMenuItemCreated, err error) {
+	r := events.NewDataReader(data)
+	e = &EventMenuItemCreated{
+		ID:           r.String("id"),
+		RestaurantID: r.String("restaurant_id"),
+		CategoryID:   r.String("category_id"),
+		Name:         r.String("name"),
+		Description:  r.String("description"),
+		Price:        money.NewFromData(r.Doc("price")),
 	}
+	err = r.Err()
+
+	return
 }
 
 func (e *EventMenuItemCreated) EventCategory() string { return "restaurant" }
@@ -281,6 +420,7 @@ func (e *EventMenuItemCreated) Data() bson.M {
 		"category_id":   e.CategoryID,
 		"name":          e.Name,
 		"description":   e.Description,
+		"price":         e.Price,
 	}
 }
 func (e *EventMenuItemCreated) ToProto() proto.Message {
@@ -290,10 +430,13 @@ func (e *EventMenuItemCreated) ToProto() proto.Message {
 		CategoryId:   e.CategoryID,
 		Name:         e.Name,
 		Description:  e.Description,
+		Price:        e.Price.ToProto(),
 	}
 }
 
 type EventMenuItemUpdated struct {
+	events.Envelope `bson:"-" json:"-"`
+
 	ID           string `bson:"id,omitempty" json:"id,omitempty"`
 	RestaurantID string `bson:"restaurant_id,omitempty" json:"restaurant_id,omitempty"`
 	CategoryID   string `bson:"category_id,omitempty" json:"category_id,omitempty"`
@@ -311,14 +454,18 @@ func EventMenuItemUpdatedFromProto(cmd *restaurant_pb.MenuItemUpdated) *EventMen
 	}
 }
 
-func EventMenuItemUpdatedFromData(data bson.M) *EventMenuItemUpdated {
-	return &EventMenuItemUpdated{
-		ID:           data["id"].(string),
-		RestaurantID: data["restaurant_id"].(string),
-		CategoryID:   data["category_id"].(string),
-		Name:         data["name"].(string),
-		Description:  data["description"].(string),
+func EventMenuItemUpdatedFromData(data bson.M) (e *EventMenuItemUpdated, err error) {
+	r := events.NewDataReader(data)
+	e = &EventMenuItemUpdated{
+		ID:           r.String("id"),
+		RestaurantID: r.String("restaurant_id"),
+		CategoryID:   r.String("category_id"),
+		Name:         r.String("name"),
+		Description:  r.String("description"),
 	}
+	err = r.Err()
+
+	return
 }
 
 func (e *EventMenuItemUpdated) EventCategory() string { return "restaurant" }
@@ -343,7 +490,65 @@ func (e *EventMenuItemUpdated) ToProto() proto.Message {
 	}
 }
 
+type EventMenuItemPriceChanged struct {
+	events.Envelope `bson:"-" json:"-"`
+
+	ID            string      `bson:"id,omitempty" json:"id,omitempty"`
+	RestaurantID  string      `bson:"restaurant_id,omitempty" json:"restaurant_id,omitempty"`
+	CategoryID    string      `bson:"category_id,omitempty" json:"category_id,omitempty"`
+	Price         money.Money `bson:"price" json:"price"`
+	PreviousPrice money.Money `bson:"previous_price" json:"previous_price"`
+}
+
+func EventMenuItemPriceChangedFromProto(cmd *restaurant_pb.MenuItemPriceChanged) *EventMenuItemPriceChanged {
+	return &EventMenuItemPriceChanged{
+		ID:            cmd.GetId(),
+		RestaurantID:  cmd.GetRestaurantId(),
+		CategoryID:    cmd.GetCategoryId(),
+		Price:         money.NewFromProto(cmd.GetPrice()),
+		PreviousPrice: money.NewFromProto(cmd.GetPreviousPrice()),
+	}
+}
+
+func EventMenuItemPriceChangedFromData(data bson.M) (e *EventMenuItemPriceChanged, err error) {
+	r := events.NewDataReader(data)
+	e = &EventMenuItemPriceChanged{
+		ID:            r.String("id"),
+		RestaurantID:  r.String("restaurant_id"),
+		CategoryID:    r.String("category_id"),
+		Price:         money.NewFromData(r.Doc("price")),
+		PreviousPrice: money.NewFromData(r.Doc("previous_price")),
+	}
+	err = r.Err()
+
+	return
+}
+
+func (e *EventMenuItemPriceChanged) EventCategory() string { return "restaurant" }
+func (e *EventMenuItemPriceChanged) EventType() string     { return "menuitempricechanged" }
+func (e *EventMenuItemPriceChanged) AggregateID() string   { return e.RestaurantID }
+func (e *EventMenuItemPriceChanged) Data() bson.M {
+	return bson.M{
+		"id":             e.ID,
+		"restaurant_id":  e.RestaurantID,
+		"category_id":    e.CategoryID,
+		"price":          e.Price,
+		"previous_price": e.PreviousPrice,
+	}
+}
+func (e *EventMenuItemPriceChanged) ToProto() proto.Message {
+	return &restaurant_pb.MenuItemPriceChanged{
+		Id:            e.ID,
+		RestaurantId:  e.RestaurantID,
+		CategoryId:    e.CategoryID,
+		Price:         e.Price.ToProto(),
+		PreviousPrice: e.PreviousPrice.ToProto(),
+	}
+}
+
 type EventMenuItemDeleted struct {
+	events.Envelope `bson:"-" json:"-"`
+
 	ID           string `bson:"id,omitempty" json:"id,omitempty"`
 	RestaurantID string `bson:"restaurant_id,omitempty" json:"restaurant_id,omitempty"`
 	CategoryID   string `bson:"category_id,omitempty" json:"category_id,omitempty"`
@@ -357,12 +562,16 @@ func EventMenuItemDeletedFromProto(cmd *restaurant_pb.MenuItemDeleted) *EventMen
 	}
 }
 
-func EventMenuItemDeletedFromData(data bson.M) *EventMenuItemDeleted {
-	return &EventMenuItemDeleted{
-		ID:           data["id"].(string),
-		RestaurantID: data["restaurant_id"].(string),
-		CategoryID:   data["category_id"].(string),
+func EventMenuItemDeletedFromData(data bson.M) (e *EventMenuItemDeleted, err error) {
+	r := events.NewDataReader(data)
+	e = &EventMenuItemDeleted{
+		ID:           r.String("id"),
+		RestaurantID: r.String("restaurant_id"),
+		CategoryID:   r.String("category_id"),
 	}
+	err = r.Err()
+
+	return
 }
 
 func (e *EventMenuItemDeleted) EventCategory() string { return "restaurant" }
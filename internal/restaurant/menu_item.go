@@ -1,6 +1,7 @@
 package restaurant
 
 import (
+	"github.com/sveatlo/night_snack/internal/money"
 	restaurant_pb "github.com/sveatlo/night_snack/proto/restaurant"
 )
 
@@ -8,8 +9,9 @@ type MenuItem struct {
 	ID             string `gorm:"primaryKey" bson:"_id"`
 	MenuCategoryID string `gorm:"not null" bson:"category_id"`
 
-	Name        string `gorm:"not null;uniqueIndex" bson:"name"`
-	Description string `gorm:"null" bson:"description"`
+	Name        string      `gorm:"not null;uniqueIndex" bson:"name"`
+	Description string      `gorm:"null" bson:"description"`
+	Price       money.Money `gorm:"embedded;embeddedPrefix:price_" bson:"price"`
 }
 
 func NewMenuItemFromProto(mi *restaurant_pb.MenuItem) *MenuItem {
@@ -17,6 +19,7 @@ func NewMenuItemFromProto(mi *restaurant_pb.MenuItem) *MenuItem {
 		ID:          mi.Id,
 		Name:        mi.Name,
 		Description: mi.Description,
+		Price:       money.NewFromProto(mi.Price),
 	}
 }
 
@@ -25,5 +28,6 @@ func (mi *MenuItem) ToProto() *restaurant_pb.MenuItem {
 		Id:          mi.ID,
 		Name:        mi.Name,
 		Description: mi.Description,
+		Price:       mi.Price.ToProto(),
 	}
 }
@@ -6,10 +6,13 @@ import (
 
 	"github.com/moderntv/cadre/metrics"
 	"github.com/moderntv/cadre/status"
-	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
 	"go.mongodb.org/mongo-driver/mongo"
 
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/projection"
+	"github.com/sveatlo/night_snack/internal/repository"
+	"github.com/sveatlo/night_snack/internal/transport"
 	restaurant_pb "github.com/sveatlo/night_snack/proto/restaurant"
 )
 
@@ -22,13 +25,13 @@ type QueryService struct {
 	repo *ReadRepository
 }
 
-func NewQueryService(nec *nats.EncodedConn, mongoDB *mongo.Database, metricsRegistry *metrics.Registry, appStatus *status.Status, log zerolog.Logger) (c *QueryService, err error) {
+func NewQueryService(eventTransport transport.Transport, store events.Store, mongoDB *mongo.Database, projections *projection.Manager, metricsRegistry *metrics.Registry, appStatus *status.Status, log zerolog.Logger) (c *QueryService, err error) {
 	cs, err := appStatus.Register("restaurant/query_svc")
 	if err != nil {
 		return
 	}
 
-	repo, err := NewReadRepository(nec, mongoDB, log)
+	repo, err := NewReadRepository(eventTransport, store, mongoDB, projections, log)
 	if err != nil {
 		err = fmt.Errorf("cannot create restaurant repository: %w", err)
 	}
@@ -57,6 +60,32 @@ func (s *QueryService) Get(ctx context.Context, cmd *restaurant_pb.GetRestaurant
 	return
 }
 
+// GetAsOf returns the restaurant as it was at the given time and/or version
+func (s *QueryService) GetAsOf(ctx context.Context, cmd *restaurant_pb.GetRestaurantAsOf) (res *restaurant_pb.Restaurant, err error) {
+	restaurant, err := s.repo.GetAsOf(ctx, cmd.GetId(), repository.NewAsOf(cmd.GetTimestamp(), cmd.GetVersion()))
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("restaurant history query failed: %w", err))
+		return
+	}
+
+	res = restaurant.ToProto()
+
+	return
+}
+
+// GetByMenuItem returns the restaurant whose menu contains the item
+func (s *QueryService) GetByMenuItem(ctx context.Context, itemID string) (res *restaurant_pb.Restaurant, err error) {
+	restaurant, err := s.repo.GetByMenuItem(ctx, itemID)
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("restaurants query failed: %w", err))
+		return
+	}
+
+	res = restaurant.ToProto()
+
+	return
+}
+
 func (s *QueryService) GetAll(ctx context.Context, cmd *restaurant_pb.GetRestaurants) (res *restaurant_pb.Restaurants, err error) {
 	restaurants, err := s.repo.GetAll(ctx)
 	if err != nil {
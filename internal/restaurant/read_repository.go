@@ -5,11 +5,11 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
 	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/projection"
 	"github.com/sveatlo/night_snack/internal/repository"
-	restaurant_pb "github.com/sveatlo/night_snack/proto/restaurant"
+	"github.com/sveatlo/night_snack/internal/transport"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"gorm.io/gorm"
@@ -18,100 +18,50 @@ import (
 type ReadRepository struct {
 	*repository.Base
 
-	log zerolog.Logger
-	nc  *nats.EncodedConn
-	db  *gorm.DB
+	log            zerolog.Logger
+	eventTransport transport.Transport
+	db             *gorm.DB
 
 	restaurantsCollection *mongo.Collection
-	eventsCollection      *mongo.Collection
 }
 
-func NewReadRepository(nc *nats.EncodedConn, mongoDB *mongo.Database, log zerolog.Logger) (repo *ReadRepository, err error) {
+func NewReadRepository(eventTransport transport.Transport, store events.Store, mongoDB *mongo.Database, projections *projection.Manager, log zerolog.Logger) (repo *ReadRepository, err error) {
 	log = log.With().Str("component", "restaurant/read_repository").Logger()
-	base, err := repository.NewBase(nc, mongoDB, log)
+	base, err := repository.NewBase(eventTransport, store, log)
 	if err != nil {
 		return
 	}
 
 	repo = &ReadRepository{
-		Base: base,
-		log:  log,
-		nc:   nc,
+		Base:           base,
+		log:            log,
+		eventTransport: eventTransport,
 
 		restaurantsCollection: mongoDB.Collection("restaurants"),
-		eventsCollection:      mongoDB.Collection("events"),
 	}
 
-	err = repo.restaurantsCollection.Drop(context.Background())
+	err = projections.Register(projection.Projection{
+		Name:       "restaurants",
+		Categories: []string{"restaurant"},
+		Apply:      repo.handleEvent,
+		Reset:      repo.restaurantsCollection.Drop,
+	})
 	if err != nil {
-		return
-	}
-
-	err = repo.loadFromEventsStore()
-	if err != nil {
-		err = fmt.Errorf("cannot retrieve events from event store: %w", err)
-		return
-	}
-
-	_, err = nc.Subscribe(repo.GetTopic(&EventCreated{}), repo.handleEventCreated)
-	if err != nil {
-		err = fmt.Errorf("cannot create subscription for EventCreated: %w", err)
-		return
-	}
-	_, err = nc.Subscribe(repo.GetTopic(&EventUpdated{}), repo.handleEventUpdated)
-	if err != nil {
-		err = fmt.Errorf("cannot create subscription for EventUpdated: %w", err)
-		return
-	}
-	_, err = nc.Subscribe(repo.GetTopic(&EventDeleted{}), repo.handleEventDeleted)
-	if err != nil {
-		err = fmt.Errorf("cannot create subscription for EventDeleted: %w", err)
-		return
-	}
-
-	_, err = nc.Subscribe(repo.GetTopic(&EventMenuCategoryCreated{}), repo.handleEventMenuCategoryCreated)
-	if err != nil {
-		err = fmt.Errorf("cannot create subscription for EventMenuCategoryCreated: %w", err)
-		return
-	}
-	_, err = nc.Subscribe(repo.GetTopic(&EventMenuCategoryUpdated{}), repo.handleEventMenuCategoryUpdated)
-	if err != nil {
-		err = fmt.Errorf("cannot create subscription for EventMenuCategoryUpdated: %w", err)
-		return
-	}
-	_, err = nc.Subscribe(repo.GetTopic(&EventMenuCategoryDeleted{}), repo.handleEventMenuCategoryDeleted)
-	if err != nil {
-		err = fmt.Errorf("cannot create subscription for EventMenuCategoryDeleted: %w", err)
-		return
-	}
-
-	_, err = nc.Subscribe(repo.GetTopic(&EventMenuItemCreated{}), repo.handleEventMenuItemCreated)
-	if err != nil {
-		err = fmt.Errorf("cannot create subscription for EventMenuItemCreated: %w", err)
-		return
-	}
-	_, err = nc.Subscribe(repo.GetTopic(&EventMenuItemUpdated{}), repo.handleEventMenuItemUpdated)
-	if err != nil {
-		err = fmt.Errorf("cannot create subscription for EventMenuItemUpdated: %w", err)
-		return
-	}
-	_, err = nc.Subscribe(repo.GetTopic(&EventMenuItemDeleted{}), repo.handleEventMenuItemDeleted)
-	if err != nil {
-		err = fmt.Errorf("cannot create subscription for EventMenuItemDeleted: %w", err)
+		err = fmt.Errorf("cannot register restaurants projection: %w", err)
 		return
 	}
 
 	return
 }
 
-func (repo *ReadRepository) applyEvent(event events.Event) (err error) {
+func (repo *ReadRepository) applyEvent(ctx context.Context, event events.Event, version int) (err error) {
 	repo.log.Trace().
 		Str("event", event.EventType()).
 		Str("id", event.AggregateID()).
 		Interface("data", event.Data()).
 		Msg("applying event")
 	defer func() {
-		if err != nil {
+		if err != nil && !errors.Is(err, projection.ErrAlreadyApplied) {
 			repo.log.Error().
 				Err(err).
 				Str("event", event.EventType()).
@@ -121,27 +71,14 @@ func (repo *ReadRepository) applyEvent(event events.Event) (err error) {
 		}
 	}()
 
-	switch e := event.(type) {
-	case *EventCreated:
-		err = repo.applyEventCreated(e)
-	case *EventUpdated:
-		err = repo.applyEventUpdated(e)
+	switch event.(type) {
 	case *EventDeleted:
-		err = repo.applyEventDeleted(e)
-
-	case *EventMenuCategoryCreated:
-		err = repo.applyEventMenuCategoryCreated(e)
-	case *EventMenuCategoryUpdated:
-		err = repo.applyEventMenuCategoryUpdated(e)
-	case *EventMenuCategoryDeleted:
-		err = repo.applyEventMenuCategoryDeleted(e)
-
-	case *EventMenuItemCreated:
-		err = repo.applyEventMenuItemCreated(e)
-	case *EventMenuItemUpdated:
-		err = repo.applyEventMenuItemUpdated(e)
-	case *EventMenuItemDeleted:
-		err = repo.applyEventMenuItemDeleted(e)
+		err = projection.Delete(ctx, repo.restaurantsCollection, event.AggregateID(), version)
+
+	case *EventCreated, *EventUpdated,
+		*EventMenuCategoryCreated, *EventMenuCategoryUpdated, *EventMenuCategoryDeleted,
+		*EventMenuItemCreated, *EventMenuItemUpdated, *EventMenuItemPriceChanged, *EventMenuItemDeleted:
+		err = repo.applyEventToRestaurant(ctx, event.AggregateID(), version, event)
 
 	default:
 		err = errors.New("event not supported")
@@ -150,292 +87,99 @@ func (repo *ReadRepository) applyEvent(event events.Event) (err error) {
 	return
 }
 
-func (repo *ReadRepository) loadFromEventsStore() (err error) {
-	var aggregates []events.AggregateDB
-	{
-		var cursor *mongo.Cursor
-		cursor, err = repo.eventsCollection.Find(context.Background(), bson.M{"category": "restaurant"})
-		if err != nil {
-			err = fmt.Errorf("cannot get events from store: %w", err)
-			return
-		}
-		cursor.All(context.Background(), &aggregates)
-	}
-
-	repo.log.Trace().Interface("events", aggregates).Msg("loaded events from store")
-
-	for _, aggregate := range aggregates {
-		for _, eventDB := range aggregate.Events {
-			var event events.Event
-
-			switch eventDB.Type {
-			case "created":
-				event = EventCreatedFromData(eventDB.Data)
-			case "updated":
-				event = EventUpdatedFromData(eventDB.Data)
-			case "deleted":
-				event = EventDeletedFromData(eventDB.Data)
-
-			case "menucategorycreated":
-				event = EventMenuCategoryCreatedFromData(eventDB.Data)
-			case "menucategoryupdated":
-				event = EventMenuCategoryUpdatedFromData(eventDB.Data)
-			case "menucategorydeleted":
-				event = EventMenuCategoryDeletedFromData(eventDB.Data)
-
-			case "menuitemcreated":
-				event = EventMenuItemCreatedFromData(eventDB.Data)
-			case "menuitemupdated":
-				event = EventMenuItemUpdatedFromData(eventDB.Data)
-			case "menuitemdeleted":
-				event = EventMenuItemDeletedFromData(eventDB.Data)
-			default:
-				err = fmt.Errorf("unknown event for restaurant: %v", eventDB.Type)
-				return
-			}
-
-			err = repo.applyEvent(event)
-			if err != nil {
-				break
-			}
-		}
-	}
-
-	return
-}
-
-func (repo *ReadRepository) handleEventCreated(eventPb *restaurant_pb.RestaurantCreated) error {
-	return repo.applyEventCreated(EventCreatedFromProto(eventPb))
+func (repo *ReadRepository) handleEvent(ctx context.Context, event events.Event, version int) error {
+	return repo.applyEvent(ctx, event, version)
 }
 
-func (repo *ReadRepository) applyEventCreated(event *EventCreated) (err error) {
+func (repo *ReadRepository) applyEventToRestaurant(ctx context.Context, id string, version int, event events.Event) (err error) {
 	r := &Restaurant{}
-	r.ApplyEvent(event)
-
-	_, err = repo.restaurantsCollection.InsertOne(context.Background(), r)
-	if err != nil {
-		return
-	}
-
-	return
-}
-
-func (repo *ReadRepository) handleEventUpdated(eventPb *restaurant_pb.RestaurantUpdated) error {
-	return repo.applyEventUpdated(EventUpdatedFromProto(eventPb))
-
-}
-
-func (repo *ReadRepository) applyEventUpdated(event *EventUpdated) (err error) {
-	res := repo.restaurantsCollection.FindOne(context.Background(), bson.M{"_id": event.ID})
-	if res.Err() != nil {
-		err = res.Err()
+	err = repo.restaurantsCollection.FindOne(ctx, bson.M{"_id": id}).Decode(r)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
 		return
 	}
 
-	r := &Restaurant{}
-	res.Decode(&r)
 	r.ApplyEvent(event)
 
-	res = repo.restaurantsCollection.FindOneAndReplace(context.Background(), bson.M{"_id": event.ID}, r)
-	if res.Err() != nil {
-		err = res.Err()
-		return
-	}
-
-	return
+	return projection.Save(ctx, repo.restaurantsCollection, id, version, r)
 }
 
-func (repo *ReadRepository) handleEventDeleted(eventPb *restaurant_pb.RestaurantDeleted) error {
-	return repo.applyEventDeleted(EventDeletedFromProto(eventPb))
-}
-
-func (repo *ReadRepository) applyEventDeleted(event *EventDeleted) (err error) {
-	res := repo.restaurantsCollection.FindOne(context.Background(), bson.M{"_id": event.ID})
-	if res.Err() != nil {
-		err = res.Err()
-		return
-	}
-
-	_, err = repo.restaurantsCollection.DeleteOne(context.Background(), bson.M{"_id": event.ID})
+func (repo *ReadRepository) GetAll(ctx context.Context) (restaurants []*Restaurant, err error) {
+	cur, err := repo.restaurantsCollection.Find(ctx, bson.D{})
 	if err != nil {
+		err = fmt.Errorf("query failed: %w", err)
 		return
 	}
 
-	return
-}
-
-func (repo *ReadRepository) handleEventMenuCategoryCreated(eventPb *restaurant_pb.MenuCategoryCreated) error {
-	return repo.applyEventMenuCategoryCreated(EventMenuCategoryCreatedFromProto(eventPb))
-}
-
-func (repo *ReadRepository) applyEventMenuCategoryCreated(event *EventMenuCategoryCreated) (err error) {
-	res := repo.restaurantsCollection.FindOne(context.Background(), bson.M{"_id": event.RestaurantID})
-	if res.Err() != nil {
-		err = res.Err()
-		return
-	}
-
-	r := &Restaurant{}
-	res.Decode(&r)
-	r.ApplyEvent(event)
-
-	res = repo.restaurantsCollection.FindOneAndReplace(context.Background(), bson.M{"_id": event.RestaurantID}, r)
-	if res.Err() != nil {
-		err = res.Err()
-		return
-	}
-
-	return
-}
-
-func (repo *ReadRepository) handleEventMenuCategoryUpdated(eventPb *restaurant_pb.MenuCategoryUpdated) error {
-	return repo.applyEventMenuCategoryUpdated(EventMenuCategoryUpdatedFromProto(eventPb))
-}
-
-func (repo *ReadRepository) applyEventMenuCategoryUpdated(event *EventMenuCategoryUpdated) (err error) {
-	res := repo.restaurantsCollection.FindOne(context.Background(), bson.M{"_id": event.RestaurantID})
-	if res.Err() != nil {
-		err = res.Err()
-		return
-	}
-
-	r := &Restaurant{}
-	res.Decode(&r)
-	r.ApplyEvent(event)
-
-	res = repo.restaurantsCollection.FindOneAndReplace(context.Background(), bson.M{"_id": event.RestaurantID}, r)
-	if res.Err() != nil {
-		err = res.Err()
+	err = cur.All(ctx, &restaurants)
+	if err != nil {
+		err = fmt.Errorf("cursor decode failed: %w", err)
 		return
 	}
 
 	return
 }
 
-func (repo *ReadRepository) handleEventMenuCategoryDeleted(eventPb *restaurant_pb.MenuCategoryDeleted) error {
-	return repo.applyEventMenuCategoryDeleted(EventMenuCategoryDeletedFromProto(eventPb))
-}
-
-func (repo *ReadRepository) applyEventMenuCategoryDeleted(event *EventMenuCategoryDeleted) (err error) {
-	res := repo.restaurantsCollection.FindOne(context.Background(), bson.M{"_id": event.RestaurantID})
+func (repo *ReadRepository) Get(ctx context.Context, id string) (restaurant *Restaurant, err error) {
+	res := repo.restaurantsCollection.FindOne(ctx, bson.M{"_id": id})
 	if res.Err() != nil {
-		err = res.Err()
+		err = fmt.Errorf("query failed: %w", res.Err())
 		return
 	}
 
-	r := &Restaurant{}
-	res.Decode(&r)
-	r.ApplyEvent(event)
-
-	res = repo.restaurantsCollection.FindOneAndReplace(context.Background(), bson.M{"_id": event.RestaurantID}, r)
-	if res.Err() != nil {
-		err = res.Err()
+	restaurant = &Restaurant{}
+	err = res.Decode(restaurant)
+	if err != nil {
+		err = fmt.Errorf("decode failed: %w", err)
 		return
 	}
 
 	return
 }
 
-func (repo *ReadRepository) handleEventMenuItemCreated(eventPb *restaurant_pb.MenuItemCreated) error {
-	return repo.applyEventMenuItemCreated(EventMenuItemCreatedFromProto(eventPb))
-}
-
-func (repo *ReadRepository) applyEventMenuItemCreated(event *EventMenuItemCreated) (err error) {
-	res := repo.restaurantsCollection.FindOne(context.Background(), bson.M{"_id": event.RestaurantID})
-	if res.Err() != nil {
-		err = res.Err()
+// GetByMenuItem returns the restaurant whose menu contains the item
+func (repo *ReadRepository) GetByMenuItem(ctx context.Context, itemID string) (restaurant *Restaurant, err error) {
+	res := repo.restaurantsCollection.FindOne(ctx, bson.M{"menu_categories.items._id": itemID})
+	if errors.Is(res.Err(), mongo.ErrNoDocuments) {
+		err = fmt.Errorf("restaurant of menu item %s: %w", itemID, repository.ErrNotFound)
 		return
 	}
-
-	r := &Restaurant{}
-	res.Decode(&r)
-	r.ApplyEvent(event)
-
-	res = repo.restaurantsCollection.FindOneAndReplace(context.Background(), bson.M{"_id": event.RestaurantID}, r)
 	if res.Err() != nil {
-		err = res.Err()
-		return
-	}
-
-	return
-}
-
-func (repo *ReadRepository) handleEventMenuItemUpdated(eventPb *restaurant_pb.MenuItemUpdated) error {
-	return repo.applyEventMenuItemUpdated(EventMenuItemUpdatedFromProto(eventPb))
-}
-
-func (repo *ReadRepository) applyEventMenuItemUpdated(event *EventMenuItemUpdated) (err error) {
-	res := repo.restaurantsCollection.FindOne(context.Background(), bson.M{"_id": event.RestaurantID})
-	if res.Err() != nil {
-		err = res.Err()
+		err = fmt.Errorf("query failed: %w", res.Err())
 		return
 	}
 
-	r := &Restaurant{}
-	res.Decode(&r)
-	r.ApplyEvent(event)
-
-	res = repo.restaurantsCollection.FindOneAndReplace(context.Background(), bson.M{"_id": event.RestaurantID}, r)
-	if res.Err() != nil {
-		err = res.Err()
+	restaurant = &Restaurant{}
+	err = res.Decode(restaurant)
+	if err != nil {
+		err = fmt.Errorf("decode failed: %w", err)
 		return
 	}
 
 	return
 }
 
-func (repo *ReadRepository) handleEventMenuItemDeleted(eventPb *restaurant_pb.MenuItemDeleted) error {
-	return repo.applyEventMenuItemDeleted(EventMenuItemDeletedFromProto(eventPb))
-}
-
-func (repo *ReadRepository) applyEventMenuItemDeleted(event *EventMenuItemDeleted) (err error) {
-	res := repo.restaurantsCollection.FindOne(context.Background(), bson.M{"_id": event.RestaurantID})
-	if res.Err() != nil {
-		err = res.Err()
+// GetAsOf folds the restaurant from its events up to the point given by asOf
+func (repo *ReadRepository) GetAsOf(ctx context.Context, id string, asOf repository.AsOf) (restaurant *Restaurant, err error) {
+	aggregate, err := repo.LoadAggregateAsOf("restaurant", id, asOf)
+	if err != nil {
 		return
 	}
-
-	r := &Restaurant{}
-	res.Decode(&r)
-	repo.log.Trace().Interface("event", event).Interface("restaurant", r).Msg("pre-apply menuitemdeleted event")
-	r.ApplyEvent(event)
-
-	res = repo.restaurantsCollection.FindOneAndReplace(context.Background(), bson.M{"_id": event.RestaurantID}, r)
-	if res.Err() != nil {
-		err = res.Err()
+	if aggregate.Version == 0 {
+		err = fmt.Errorf("restaurant %s: %w", id, repository.ErrNotFound)
 		return
 	}
 
-	return
-}
-
-func (repo *ReadRepository) GetAll(ctx context.Context) (restaurants []*Restaurant, err error) {
-	cur, err := repo.restaurantsCollection.Find(ctx, bson.D{})
+	aggregateEvents, err := repo.DecodeEvents(aggregate)
 	if err != nil {
-		err = fmt.Errorf("query failed: %w", err)
 		return
 	}
 
-	err = cur.All(ctx, &restaurants)
+	restaurant, err = NewRestaurantFromEvents(aggregateEvents)
 	if err != nil {
-		err = fmt.Errorf("cursor decode failed: %w", err)
 		return
 	}
-
-	return
-}
-
-func (repo *ReadRepository) Get(ctx context.Context, id string) (restaurant *Restaurant, err error) {
-	res := repo.restaurantsCollection.FindOne(ctx, bson.M{"_id": id})
-	if res.Err() != nil {
-		err = fmt.Errorf("query failed: %w", res.Err())
-		return
-	}
-
-	restaurant = &Restaurant{}
-	err = res.Decode(restaurant)
-	if err != nil {
-		err = fmt.Errorf("decode failed: %w", err)
+	if !restaurant.DeletedAt.IsZero() {
+		err = fmt.Errorf("restaurant %s was deleted: %w", id, repository.ErrNotFound)
 		return
 	}
 
@@ -23,8 +23,16 @@ func NewRestaurantFromProto(r *restaurant_pb.Restaurant) *Restaurant {
 }
 
 func NewRestaurantFromEvents(events []events.Event) (r *Restaurant, err error) {
-	r = &Restaurant{
+	return NewRestaurantFromSnapshot(&Restaurant{
 		MenuCategories: []MenuCategory{},
+	}, events)
+}
+
+// NewRestaurantFromSnapshot applies the events which follow the snapshot to its state
+func NewRestaurantFromSnapshot(snapshot *Restaurant, events []events.Event) (r *Restaurant, err error) {
+	r = snapshot
+	if r.MenuCategories == nil {
+		r.MenuCategories = []MenuCategory{}
 	}
 
 	for _, event := range events {
@@ -58,16 +66,12 @@ func (r *Restaurant) ApplyEvent(event events.Event) {
 			}
 		}
 	case *EventMenuCategoryDeleted:
-		var (
-			i        int
-			category MenuCategory
-		)
-		for i, category = range r.MenuCategories {
+		for i, category := range r.MenuCategories {
 			if category.ID == e.ID {
+				r.MenuCategories = append(r.MenuCategories[:i], r.MenuCategories[i+1:]...)
 				break
 			}
 		}
-		r.MenuCategories = append(r.MenuCategories[:i], r.MenuCategories[i+1:]...)
 
 	case *EventMenuItemCreated:
 		for i, category := range r.MenuCategories {
@@ -77,6 +81,7 @@ func (r *Restaurant) ApplyEvent(event events.Event) {
 					MenuCategoryID: e.CategoryID,
 					Name:           e.Name,
 					Description:    e.Description,
+					Price:          e.Price,
 				})
 				break
 			}
@@ -95,6 +100,10 @@ func (r *Restaurant) ApplyEvent(event events.Event) {
 				}
 			}
 		}
+	case *EventMenuItemPriceChanged:
+		if item := r.MenuItem(e.CategoryID, e.ID); item != nil {
+			item.Price = e.Price
+		}
 	case *EventMenuItemDeleted:
 	outerD:
 		for i, category := range r.MenuCategories {
@@ -110,6 +119,22 @@ func (r *Restaurant) ApplyEvent(event events.Event) {
 	}
 }
 
+// MenuItem returns the item of the menu category, nil if there is no such item
+func (r *Restaurant) MenuItem(categoryID, id string) *MenuItem {
+	for i, category := range r.MenuCategories {
+		if category.ID != categoryID {
+			continue
+		}
+		for j, item := range category.Items {
+			if item.ID == id {
+				return &r.MenuCategories[i].Items[j]
+			}
+		}
+	}
+
+	return nil
+}
+
 func (r *Restaurant) ToProto() *restaurant_pb.Restaurant {
 	categories := make([]*restaurant_pb.MenuCategory, len(r.MenuCategories))
 	for i, c := range r.MenuCategories {
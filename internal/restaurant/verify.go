@@ -0,0 +1,161 @@
+package restaurant
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"github.com/sveatlo/night_snack/internal/events"
+)
+
+// Mismatch describes a difference between the write model and the folded event stream of a restaurant
+type Mismatch struct {
+	RestaurantID string
+	Field        string
+	WriteModel   string
+	Events       string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s: %s: write model %q, events %q", m.RestaurantID, m.Field, m.WriteModel, m.Events)
+}
+
+// Verify compares the write model of every restaurant with its folded event stream.
+// The restaurants stored in the write model without any events are reported as well.
+func (repo *WriteRepository) Verify() (mismatches []Mismatch, err error) {
+	seen := map[string]bool{}
+	err = repo.StreamAggregates("restaurant", func(aggregate events.AggregateDB) (err error) {
+		seen[aggregate.ID] = true
+
+		restaurantMismatches, err := repo.verifyAggregate(aggregate)
+		if err != nil {
+			return
+		}
+		mismatches = append(mismatches, restaurantMismatches...)
+
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	var ids []string
+	err = repo.db.Model(&Restaurant{}).Pluck("id", &ids).Error
+	if err != nil {
+		err = fmt.Errorf("cannot list persistent records: %w", err)
+		return
+	}
+	for _, id := range ids {
+		if !seen[id] {
+			mismatches = append(mismatches, Mismatch{RestaurantID: id, Field: "restaurant", WriteModel: "exists", Events: "missing"})
+		}
+	}
+
+	return
+}
+
+// VerifyRestaurant compares the write model of a single restaurant with its folded event stream
+func (repo *WriteRepository) VerifyRestaurant(id string) (mismatches []Mismatch, err error) {
+	aggregate, err := repo.LoadAggregate(id)
+	if err != nil {
+		return
+	}
+	if aggregate.Version == 0 {
+		err = fmt.Errorf("no such aggregate")
+		return
+	}
+
+	return repo.verifyAggregate(aggregate)
+}
+
+func (repo *WriteRepository) verifyAggregate(aggregate events.AggregateDB) (mismatches []Mismatch, err error) {
+	aggregateEvents, err := repo.DecodeEvents(aggregate)
+	if err != nil {
+		return
+	}
+	folded, err := NewRestaurantFromEvents(aggregateEvents)
+	if err != nil {
+		return
+	}
+
+	stored := &Restaurant{}
+	res := repo.db.Preload("MenuCategories.Items").First(stored, "id = ?", aggregate.ID)
+	found := res.Error == nil
+	if res.Error != nil && !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		err = fmt.Errorf("cannot load persistent record: %w", res.Error)
+		return
+	}
+
+	deleted := !folded.DeletedAt.IsZero()
+	switch {
+	case deleted && found:
+		mismatches = append(mismatches, Mismatch{RestaurantID: aggregate.ID, Field: "restaurant", WriteModel: "exists", Events: "deleted"})
+		return
+	case deleted:
+		return
+	case !found:
+		mismatches = append(mismatches, Mismatch{RestaurantID: aggregate.ID, Field: "restaurant", WriteModel: "missing", Events: "exists"})
+		return
+	}
+
+	return compareRestaurants(stored, folded), nil
+}
+
+func compareRestaurants(stored, folded *Restaurant) (mismatches []Mismatch) {
+	mismatch := func(field, writeModel, events string) {
+		mismatches = append(mismatches, Mismatch{RestaurantID: folded.ID, Field: field, WriteModel: writeModel, Events: events})
+	}
+
+	if stored.Name != folded.Name {
+		mismatch("name", stored.Name, folded.Name)
+	}
+
+	storedCategories := map[string]MenuCategory{}
+	for _, category := range stored.MenuCategories {
+		storedCategories[category.ID] = category
+	}
+	for _, category := range folded.MenuCategories {
+		storedCategory, ok := storedCategories[category.ID]
+		delete(storedCategories, category.ID)
+		field := "category " + category.ID
+		if !ok {
+			mismatch(field, "missing", category.Name)
+			continue
+		}
+		if storedCategory.Name != category.Name {
+			mismatch(field+" name", storedCategory.Name, category.Name)
+		}
+
+		storedItems := map[string]MenuItem{}
+		for _, item := range storedCategory.Items {
+			storedItems[item.ID] = item
+		}
+		for _, item := range category.Items {
+			storedItem, ok := storedItems[item.ID]
+			delete(storedItems, item.ID)
+			field := "item " + item.ID
+			if !ok {
+				mismatch(field, "missing", item.Name)
+				continue
+			}
+			if storedItem.Name != item.Name {
+				mismatch(field+" name", storedItem.Name, item.Name)
+			}
+			if storedItem.Description != item.Description {
+				mismatch(field+" description", storedItem.Description, item.Description)
+			}
+			if storedItem.Price != item.Price {
+				mismatch(field+" price", storedItem.Price.String(), item.Price.String())
+			}
+		}
+		for id, item := range storedItems {
+			mismatch("item "+id, item.Name, "missing")
+		}
+	}
+	for id, category := range storedCategories {
+		mismatch("category "+id, category.Name, "missing")
+	}
+
+	return
+}
@@ -6,36 +6,45 @@ import (
 	"time"
 
 	"github.com/gofrs/uuid"
-	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
-	"go.mongodb.org/mongo-driver/mongo"
 	"gorm.io/gorm"
 
 	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/money"
+	"github.com/sveatlo/night_snack/internal/outbox"
 	"github.com/sveatlo/night_snack/internal/repository"
+	"github.com/sveatlo/night_snack/internal/transport"
 )
 
 type WriteRepository struct {
 	*repository.Base
 
-	log zerolog.Logger
-	nc  *nats.EncodedConn
-	db  *gorm.DB
+	log            zerolog.Logger
+	eventTransport transport.Transport
+	db             *gorm.DB
 }
 
-func NewWriteRepository(nc *nats.EncodedConn, db *gorm.DB, mongo *mongo.Database, log zerolog.Logger) (repo *WriteRepository, err error) {
+func NewWriteRepository(eventTransport transport.Transport, db *gorm.DB, store events.Store, relay *outbox.Relay, snapshotEvery int, log zerolog.Logger) (repo *WriteRepository, err error) {
 	log = log.With().Str("component", "restaurant/write_repository").Logger()
-	base, err := repository.NewBase(nc, mongo, log)
+	// the events of a rolled back transaction would be published otherwise
+	if relay != nil && !events.SharesTransactions(store, db) {
+		err = fmt.Errorf("outbox requires the events to be appended within the write model transactions, use the postgres event store")
+		return
+	}
+
+	base, err := repository.NewBase(eventTransport, store, log)
 	if err != nil {
 		return
 	}
+	base.EnableOutbox(relay)
+	base.EnableSnapshots(snapshotEvery)
 
 	repo = &WriteRepository{
 		Base: base,
 
-		log: log,
-		nc:  nc,
-		db:  db,
+		log:            log,
+		eventTransport: eventTransport,
+		db:             db,
 	}
 
 	err = db.AutoMigrate(&Restaurant{}, &MenuCategory{}, &MenuItem{})
@@ -47,14 +56,86 @@ func NewWriteRepository(nc *nats.EncodedConn, db *gorm.DB, mongo *mongo.Database
 	return
 }
 
-func (repo *WriteRepository) SaveEvents(aggregateID string, aggregateEvents []events.Event, originalVersion int) (err error) {
-	return repo.Base.SaveEvents("restaurant", aggregateID, aggregateEvents, originalVersion)
+// OpenWriteRepository returns the repository for reading the write model as it is, e.g. to verify it against the events.
+// Unlike NewWriteRepository it doesn't migrate the database and the events are not published.
+func OpenWriteRepository(db *gorm.DB, store events.Store, log zerolog.Logger) (repo *WriteRepository, err error) {
+	log = log.With().Str("component", "restaurant/write_repository").Logger()
+	base, err := repository.NewBase(nil, store, log)
+	if err != nil {
+		return
+	}
+
+	repo = &WriteRepository{
+		Base: base,
+
+		log: log,
+		db:  db,
+	}
+
+	return
+}
+
+func (repo *WriteRepository) SaveEvents(ctx context.Context, aggregateID string, aggregateEvents []events.Event, originalVersion int) (err error) {
+	return repo.Base.SaveEvents(ctx, "restaurant", aggregateID, aggregateEvents, originalVersion)
 }
 
 func (repo *WriteRepository) LoadAggregate(id string) (aggregate events.AggregateDB, err error) {
 	return repo.Base.LoadAggregate("restaurant", id)
 }
 
+// Snapshot takes a snapshot of the restaurant regardless of the number of events since the last one
+func (repo *WriteRepository) Snapshot(ctx context.Context, id string) (err error) {
+	r, aggregate, err := repo.loadRestaurant(id)
+	if err != nil {
+		return
+	}
+	if aggregate.Version == 0 {
+		err = fmt.Errorf("no such aggregate")
+		return
+	}
+
+	return repo.SaveSnapshot("restaurant", id, aggregate.Version, r)
+}
+
+// loadRestaurant folds the restaurant from its latest snapshot and the events which follow it
+func (repo *WriteRepository) loadRestaurant(id string) (r *Restaurant, aggregate events.AggregateDB, err error) {
+	snapshot := &Restaurant{}
+	aggregate, err = repo.LoadAggregateSnapshot("restaurant", id, snapshot)
+	if err != nil {
+		return
+	}
+
+	aggregateEvents, err := repo.DecodeEvents(aggregate)
+	if err != nil {
+		return
+	}
+
+	r, err = NewRestaurantFromSnapshot(snapshot, aggregateEvents)
+	return
+}
+
+// transaction runs fn within a database transaction. The events saved with the context passed to fn
+// are appended within the same transaction if the event store shares the database.
+func (repo *WriteRepository) transaction(ctx context.Context, fn func(ctx context.Context, tx *gorm.DB) error) (err error) {
+	err = repo.db.Transaction(func(tx *gorm.DB) error {
+		return fn(events.WithTransaction(ctx, tx), tx)
+	})
+	if err != nil {
+		return
+	}
+
+	repo.NotifyOutbox()
+
+	return
+}
+
+// snapshotIfDue applies the just saved event to the loaded restaurant and snapshots it if needed.
+// It is called once the transaction is committed, a snapshot of a rolled back event would be ahead of the stream.
+func (repo *WriteRepository) snapshotIfDue(r *Restaurant, aggregate events.AggregateDB, event events.Event) {
+	r.ApplyEvent(event)
+	repo.SnapshotIfDue("restaurant", aggregate.ID, aggregate.Version+1, aggregate.SnapshotVersion, r)
+}
+
 func (repo *WriteRepository) Create(ctx context.Context, name string) (event *EventCreated, err error) {
 	id, err := uuid.NewV4()
 	if err != nil {
@@ -67,7 +148,7 @@ func (repo *WriteRepository) Create(ctx context.Context, name string) (event *Ev
 		return
 	}
 
-	err = repo.db.Transaction(func(tx *gorm.DB) (err error) {
+	err = repo.transaction(ctx, func(ctx context.Context, tx *gorm.DB) (err error) {
 		res := tx.Create(&Restaurant{
 			ID:   id.String(),
 			Name: name,
@@ -83,12 +164,7 @@ func (repo *WriteRepository) Create(ctx context.Context, name string) (event *Ev
 			Name: name,
 		}
 
-		err = repo.SaveEvents(aggregate.ID, []events.Event{event}, aggregate.Version)
-		if err != nil {
-			return
-		}
-
-		err = repo.Publish(event)
+		err = repo.SaveEvents(ctx, aggregate.ID, []events.Event{event}, aggregate.Version)
 		if err != nil {
 			return
 		}
@@ -100,132 +176,144 @@ func (repo *WriteRepository) Create(ctx context.Context, name string) (event *Ev
 }
 
 func (repo *WriteRepository) Update(ctx context.Context, id, name string) (event *EventUpdated, err error) {
-	aggregate, err := repo.LoadAggregate(id)
-	if err != nil {
-		return
-	}
+	err = repository.RetryOnConflict(func() error {
+		r, aggregate, err := repo.loadRestaurant(id)
+		if err != nil {
+			return err
+		}
+
+		err = repo.transaction(ctx, func(ctx context.Context, tx *gorm.DB) (err error) {
+			res := tx.First(&Restaurant{}, "id = ?", id)
+			if res.Error != nil {
+				err = fmt.Errorf("cannot find persistent record with such ID: %w", res.Error)
+				return
+			}
+
+			res = tx.Save(&Restaurant{
+				ID:   id,
+				Name: name,
+			})
+			if res.Error != nil {
+				err = fmt.Errorf("cannot create persistent record: %w", res.Error)
+				return
+			}
+
+			event = &EventUpdated{
+				ID:   id,
+				Name: name,
+			}
+
+			err = repo.SaveEvents(ctx, id, []events.Event{event}, aggregate.Version)
+			if err != nil {
+				err = fmt.Errorf("cannot create event record: %w", err)
+				return
+			}
 
-	err = repo.db.Transaction(func(tx *gorm.DB) (err error) {
-		res := tx.First(&Restaurant{}, "id = ?", id)
-		if res.Error != nil {
-			err = fmt.Errorf("cannot find persistent record with such ID: %w", res.Error)
 			return
-		}
-
-		res = tx.Save(&Restaurant{
-			ID:   id,
-			Name: name,
 		})
-		if res.Error != nil {
-			err = fmt.Errorf("cannot create persistent record: %w", res.Error)
-			return
-		}
-
-		event = &EventUpdated{
-			ID:   id,
-			Name: name,
-		}
-
-		err = repo.SaveEvents(id, []events.Event{event}, aggregate.Version)
 		if err != nil {
-			err = fmt.Errorf("cannot create event record: %w", err)
-			return
+			return err
 		}
 
-		err = repo.Publish(event)
-		if err != nil {
-			return
-		}
+		repo.snapshotIfDue(r, aggregate, event)
 
-		return
+		return nil
 	})
 
 	return
 }
 
 func (repo *WriteRepository) Delete(ctx context.Context, id string) (event *EventDeleted, err error) {
-	aggregate, err := repo.LoadAggregate(id)
-	if err != nil {
-		return
-	}
+	err = repository.RetryOnConflict(func() error {
+		r, aggregate, err := repo.loadRestaurant(id)
+		if err != nil {
+			return err
+		}
+
+		err = repo.transaction(ctx, func(ctx context.Context, tx *gorm.DB) (err error) {
+			res := tx.First(&Restaurant{}, "id = ?", id)
+			if res.Error != nil {
+				err = fmt.Errorf("cannot find persistent record with such ID: %w", res.Error)
+				return
+			}
+
+			res = tx.Delete(&Restaurant{
+				ID: id,
+			})
+			if res.Error != nil {
+				err = fmt.Errorf("cannot create persistent record: %w", res.Error)
+				return
+			}
+
+			event = &EventDeleted{
+				ID:        id,
+				DeletedAt: time.Now(),
+			}
+
+			err = repo.SaveEvents(ctx, id, []events.Event{event}, aggregate.Version)
+			if err != nil {
+				err = fmt.Errorf("cannot create event record: %w", err)
+				return
+			}
 
-	err = repo.db.Transaction(func(tx *gorm.DB) (err error) {
-		res := tx.First(&Restaurant{}, "id = ?", id)
-		if res.Error != nil {
-			err = fmt.Errorf("cannot find persistent record with such ID: %w", res.Error)
 			return
-		}
-
-		res = tx.Delete(&Restaurant{
-			ID: id,
 		})
-		if res.Error != nil {
-			err = fmt.Errorf("cannot create persistent record: %w", res.Error)
-			return
-		}
-
-		event = &EventDeleted{
-			ID:        id,
-			DeletedAt: time.Now(),
-		}
-
-		err = repo.SaveEvents(id, []events.Event{event}, aggregate.Version)
 		if err != nil {
-			err = fmt.Errorf("cannot create event record: %w", err)
-			return
+			return err
 		}
 
-		err = repo.Publish(event)
-		if err != nil {
-			return
-		}
+		repo.snapshotIfDue(r, aggregate, event)
 
-		return
+		return nil
 	})
 
 	return
 }
 
 func (repo *WriteRepository) CreateMenuCategory(ctx context.Context, restaurantID, name string) (event *EventMenuCategoryCreated, err error) {
-	aggregate, err := repo.LoadAggregate(restaurantID)
-	if err != nil {
-		return
-	}
-
 	id, err := uuid.NewV4()
 	if err != nil {
 		err = fmt.Errorf("cannot generate UUID: %w", err)
 		return
 	}
-	err = repo.db.Transaction(func(tx *gorm.DB) (err error) {
-		res := tx.Create(&MenuCategory{
-			ID:           id.String(),
-			RestaurantID: restaurantID,
-			Name:         name,
-		})
-		err = res.Error
+	err = repository.RetryOnConflict(func() error {
+		r, aggregate, err := repo.loadRestaurant(restaurantID)
 		if err != nil {
-			err = fmt.Errorf("cannot create persistent record: %w", err)
-			return
-		}
-
-		event = &EventMenuCategoryCreated{
-			ID:           id.String(),
-			RestaurantID: restaurantID,
-			Name:         name,
-		}
+			return err
+		}
+
+		err = repo.transaction(ctx, func(ctx context.Context, tx *gorm.DB) (err error) {
+			res := tx.Create(&MenuCategory{
+				ID:           id.String(),
+				RestaurantID: restaurantID,
+				Name:         name,
+			})
+			err = res.Error
+			if err != nil {
+				err = fmt.Errorf("cannot create persistent record: %w", err)
+				return
+			}
+
+			event = &EventMenuCategoryCreated{
+				ID:           id.String(),
+				RestaurantID: restaurantID,
+				Name:         name,
+			}
+
+			err = repo.SaveEvents(ctx, aggregate.ID, []events.Event{event}, aggregate.Version)
+			if err != nil {
+				return
+			}
 
-		err = repo.SaveEvents(aggregate.ID, []events.Event{event}, aggregate.Version)
-		if err != nil {
 			return
-		}
-
-		err = repo.Publish(event)
+		})
 		if err != nil {
-			return
+			return err
 		}
 
-		return
+		repo.snapshotIfDue(r, aggregate, event)
+
+		return nil
 	})
 
 	return
@@ -240,36 +328,40 @@ func (repo *WriteRepository) UpdateMenuCategory(ctx context.Context, id, name st
 	}
 	menuCategory.Name = name
 
-	aggregate, err := repo.LoadAggregate(menuCategory.RestaurantID)
-	if err != nil {
-		return
-	}
-
-	err = repo.db.Transaction(func(tx *gorm.DB) (err error) {
-		res := tx.Save(&menuCategory)
-		err = res.Error
+	err = repository.RetryOnConflict(func() error {
+		r, aggregate, err := repo.loadRestaurant(menuCategory.RestaurantID)
 		if err != nil {
-			err = fmt.Errorf("cannot create persistent record: %w", err)
-			return
+			return err
 		}
 
-		event = &EventMenuCategoryUpdated{
-			ID:           id,
-			RestaurantID: menuCategory.RestaurantID,
-			Name:         name,
-		}
+		err = repo.transaction(ctx, func(ctx context.Context, tx *gorm.DB) (err error) {
+			res := tx.Save(&menuCategory)
+			err = res.Error
+			if err != nil {
+				err = fmt.Errorf("cannot create persistent record: %w", err)
+				return
+			}
 
-		err = repo.SaveEvents(aggregate.ID, []events.Event{event}, aggregate.Version)
-		if err != nil {
-			return
-		}
+			event = &EventMenuCategoryUpdated{
+				ID:           id,
+				RestaurantID: menuCategory.RestaurantID,
+				Name:         name,
+			}
+
+			err = repo.SaveEvents(ctx, aggregate.ID, []events.Event{event}, aggregate.Version)
+			if err != nil {
+				return
+			}
 
-		err = repo.Publish(event)
-		if err != nil {
 			return
+		})
+		if err != nil {
+			return err
 		}
 
-		return
+		repo.snapshotIfDue(r, aggregate, event)
+
+		return nil
 	})
 
 	return
@@ -283,83 +375,93 @@ func (repo *WriteRepository) DeleteMenuCategory(ctx context.Context, id string)
 		return
 	}
 
-	aggregate, err := repo.LoadAggregate(menuCategory.RestaurantID)
-	if err != nil {
-		return
-	}
-
-	err = repo.db.Transaction(func(tx *gorm.DB) (err error) {
-		res := tx.Delete(&menuCategory)
-		err = res.Error
+	err = repository.RetryOnConflict(func() error {
+		r, aggregate, err := repo.loadRestaurant(menuCategory.RestaurantID)
 		if err != nil {
-			err = fmt.Errorf("cannot create persistent record: %w", err)
-			return
+			return err
 		}
 
-		event = &EventMenuCategoryDeleted{
-			ID:           id,
-			RestaurantID: menuCategory.RestaurantID,
-		}
+		err = repo.transaction(ctx, func(ctx context.Context, tx *gorm.DB) (err error) {
+			res := tx.Delete(&menuCategory)
+			err = res.Error
+			if err != nil {
+				err = fmt.Errorf("cannot create persistent record: %w", err)
+				return
+			}
 
-		err = repo.SaveEvents(aggregate.ID, []events.Event{event}, aggregate.Version)
-		if err != nil {
-			return
-		}
+			event = &EventMenuCategoryDeleted{
+				ID:           id,
+				RestaurantID: menuCategory.RestaurantID,
+			}
+
+			err = repo.SaveEvents(ctx, aggregate.ID, []events.Event{event}, aggregate.Version)
+			if err != nil {
+				return
+			}
 
-		err = repo.Publish(event)
-		if err != nil {
 			return
+		})
+		if err != nil {
+			return err
 		}
 
-		return
+		repo.snapshotIfDue(r, aggregate, event)
+
+		return nil
 	})
 
 	return
 }
 
-func (repo *WriteRepository) CreateMenuItem(ctx context.Context, restaurantID, categoryID, name, description string) (event *EventMenuItemCreated, err error) {
-	aggregate, err := repo.LoadAggregate(restaurantID)
-	if err != nil {
-		return
-	}
-
+func (repo *WriteRepository) CreateMenuItem(ctx context.Context, restaurantID, categoryID, name, description string, price money.Money) (event *EventMenuItemCreated, err error) {
 	id, err := uuid.NewV4()
 	if err != nil {
 		err = fmt.Errorf("cannot generate UUID: %w", err)
 		return
 	}
-	err = repo.db.Transaction(func(tx *gorm.DB) (err error) {
-		res := tx.Create(&MenuItem{
-			ID:             id.String(),
-			MenuCategoryID: categoryID,
-			Name:           name,
-			Description:    description,
-		})
-		err = res.Error
+	err = repository.RetryOnConflict(func() error {
+		r, aggregate, err := repo.loadRestaurant(restaurantID)
 		if err != nil {
-			err = fmt.Errorf("cannot create persistent record: %w", err)
-			return
-		}
-
-		event = &EventMenuItemCreated{
-			ID:           id.String(),
-			RestaurantID: restaurantID,
-			CategoryID:   categoryID,
-			Name:         name,
-			Description:  description,
-		}
+			return err
+		}
+
+		err = repo.transaction(ctx, func(ctx context.Context, tx *gorm.DB) (err error) {
+			res := tx.Create(&MenuItem{
+				ID:             id.String(),
+				MenuCategoryID: categoryID,
+				Name:           name,
+				Description:    description,
+				Price:          price,
+			})
+			err = res.Error
+			if err != nil {
+				err = fmt.Errorf("cannot create persistent record: %w", err)
+				return
+			}
+
+			event = &EventMenuItemCreated{
+				ID:           id.String(),
+				RestaurantID: restaurantID,
+				CategoryID:   categoryID,
+				Name:         name,
+				Description:  description,
+				Price:        price,
+			}
+
+			err = repo.SaveEvents(ctx, aggregate.ID, []events.Event{event}, aggregate.Version)
+			if err != nil {
+				return
+			}
 
-		err = repo.SaveEvents(aggregate.ID, []events.Event{event}, aggregate.Version)
-		if err != nil {
 			return
-		}
-
-		err = repo.Publish(event)
+		})
 		if err != nil {
-			return
+			return err
 		}
 
-		return
+		repo.snapshotIfDue(r, aggregate, event)
+
+		return nil
 	})
 
 	return
@@ -375,38 +477,92 @@ func (repo *WriteRepository) UpdateMenuItem(ctx context.Context, restaurantID, c
 	menuItem.Name = name
 	menuItem.Description = description
 
-	aggregate, err := repo.LoadAggregate(restaurantID)
-	if err != nil {
-		return
-	}
-
-	err = repo.db.Transaction(func(tx *gorm.DB) (err error) {
-		res := tx.Save(&menuItem)
-		err = res.Error
+	err = repository.RetryOnConflict(func() error {
+		r, aggregate, err := repo.loadRestaurant(restaurantID)
 		if err != nil {
-			err = fmt.Errorf("cannot create persistent record: %w", err)
+			return err
+		}
+
+		err = repo.transaction(ctx, func(ctx context.Context, tx *gorm.DB) (err error) {
+			res := tx.Save(&menuItem)
+			err = res.Error
+			if err != nil {
+				err = fmt.Errorf("cannot create persistent record: %w", err)
+				return
+			}
+
+			event = &EventMenuItemUpdated{
+				ID:           id,
+				RestaurantID: restaurantID,
+				CategoryID:   categoryID,
+				Name:         name,
+				Description:  description,
+			}
+
+			err = repo.SaveEvents(ctx, aggregate.ID, []events.Event{event}, aggregate.Version)
+			if err != nil {
+				return
+			}
+
 			return
+		})
+		if err != nil {
+			return err
 		}
 
-		event = &EventMenuItemUpdated{
-			ID:           id,
-			RestaurantID: restaurantID,
-			CategoryID:   categoryID,
-			Name:         name,
-			Description:  description,
-		}
+		repo.snapshotIfDue(r, aggregate, event)
 
-		err = repo.SaveEvents(aggregate.ID, []events.Event{event}, aggregate.Version)
-		if err != nil {
-			return
-		}
+		return nil
+	})
 
-		err = repo.Publish(event)
+	return
+}
+
+// ChangeMenuItemPrice changes the price of the menu item, the orders keep the prices at the time they were placed
+func (repo *WriteRepository) ChangeMenuItemPrice(ctx context.Context, restaurantID, categoryID, id string, price money.Money) (event *EventMenuItemPriceChanged, err error) {
+	err = repository.RetryOnConflict(func() error {
+		r, aggregate, err := repo.loadRestaurant(restaurantID)
 		if err != nil {
+			return err
+		}
+		item := r.MenuItem(categoryID, id)
+		if item == nil {
+			return fmt.Errorf("menu item %s in category %s: %w", id, categoryID, repository.ErrNotFound)
+		}
+
+		err = repo.transaction(ctx, func(ctx context.Context, tx *gorm.DB) (err error) {
+			res := tx.Model(&MenuItem{}).Where("id = ?", id).Updates(map[string]interface{}{
+				"price_amount":   price.Amount,
+				"price_currency": price.Currency,
+			})
+			err = res.Error
+			if err != nil {
+				err = fmt.Errorf("cannot update persistent record: %w", err)
+				return
+			}
+
+			event = &EventMenuItemPriceChanged{
+				ID:            id,
+				RestaurantID:  restaurantID,
+				CategoryID:    categoryID,
+				Price:         price,
+				PreviousPrice: item.Price,
+			}
+
+			err = repo.SaveEvents(ctx, aggregate.ID, []events.Event{event}, aggregate.Version)
+			if err != nil {
+				return
+			}
+
 			return
+		})
+		if err != nil {
+			return err
 		}
 
-		return
+		repo.snapshotIfDue(r, aggregate, event)
+
+		return nil
 	})
 
 	return
@@ -420,36 +576,40 @@ func (repo *WriteRepository) DeleteMenuItem(ctx context.Context, restaurantID, i
 		return
 	}
 
-	aggregate, err := repo.LoadAggregate(restaurantID)
-	if err != nil {
-		return
-	}
-
-	err = repo.db.Transaction(func(tx *gorm.DB) (err error) {
-		res := tx.Delete(&MenuItem{}, "id = ?", id)
-		err = res.Error
+	err = repository.RetryOnConflict(func() error {
+		r, aggregate, err := repo.loadRestaurant(restaurantID)
 		if err != nil {
-			err = fmt.Errorf("cannot create persistent record: %w", err)
-			return
+			return err
 		}
 
-		event = &EventMenuItemDeleted{
-			ID:           id,
-			RestaurantID: restaurantID,
-			CategoryID:   menuItem.MenuCategoryID,
-		}
+		err = repo.transaction(ctx, func(ctx context.Context, tx *gorm.DB) (err error) {
+			res := tx.Delete(&MenuItem{}, "id = ?", id)
+			err = res.Error
+			if err != nil {
+				err = fmt.Errorf("cannot create persistent record: %w", err)
+				return
+			}
 
-		err = repo.SaveEvents(aggregate.ID, []events.Event{event}, aggregate.Version)
-		if err != nil {
-			return
-		}
+			event = &EventMenuItemDeleted{
+				ID:           id,
+				RestaurantID: restaurantID,
+				CategoryID:   menuItem.MenuCategoryID,
+			}
+
+			err = repo.SaveEvents(ctx, aggregate.ID, []events.Event{event}, aggregate.Version)
+			if err != nil {
+				return
+			}
 
-		err = repo.Publish(event)
-		if err != nil {
 			return
+		})
+		if err != nil {
+			return err
 		}
 
-		return
+		repo.snapshotIfDue(r, aggregate, event)
+
+		return nil
 	})
 
 	return
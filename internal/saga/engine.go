@@ -0,0 +1,390 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/outbox"
+	"github.com/sveatlo/night_snack/internal/repository"
+	"github.com/sveatlo/night_snack/internal/transport"
+)
+
+var (
+	// ErrUnknownSaga is returned for sagas whose definition was not registered
+	ErrUnknownSaga = errors.New("unknown saga")
+	// ErrAlreadyRunning is returned when starting a saga which is being run by this process
+	ErrAlreadyRunning = errors.New("saga already running")
+)
+
+// Engine runs the sagas and persists their progress as events.
+//
+// Every step is recorded once it completes or fails, so a saga interrupted by a crash is resumed
+// from the step it was at when the engine starts. The unfinished sagas are found by the index. A failed step and all the steps before it are
+// compensated in the reverse order. If a compensation fails, the saga is retried periodically.
+// Two runners of the same saga are excluded by the optimistic concurrency of the event store.
+type Engine struct {
+	log   zerolog.Logger
+	repo  *repository.Base
+	index Index
+
+	// retryInterval is the interval of resuming the sagas whose run failed
+	retryInterval time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu          sync.Mutex
+	definitions map[string]Definition
+	// running holds the IDs of the sagas currently run by this process
+	running map[string]bool
+	// stalled holds the IDs of the sagas to be resumed on the next retry
+	stalled map[string]bool
+}
+
+func NewEngine(eventTransport transport.Transport, store events.Store, relay *outbox.Relay, index Index, retryInterval time.Duration, log zerolog.Logger) (e *Engine, err error) {
+	log = log.With().Str("component", "saga/engine").Logger()
+	repo, err := repository.NewBase(eventTransport, store, log)
+	if err != nil {
+		return
+	}
+	repo.EnableOutbox(relay)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e = &Engine{
+		log:   log,
+		repo:  repo,
+		index: index,
+
+		retryInterval: retryInterval,
+
+		ctx:    ctx,
+		cancel: cancel,
+
+		definitions: map[string]Definition{},
+		running:     map[string]bool{},
+		stalled:     map[string]bool{},
+	}
+
+	return
+}
+
+// Register adds the saga definition. The sagas have to be registered before the engine is started.
+func (e *Engine) Register(definition Definition) (err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.definitions[definition.Name]; ok {
+		err = fmt.Errorf("saga %s already registered", definition.Name)
+		return
+	}
+	e.definitions[definition.Name] = definition
+
+	return
+}
+
+// Start resumes the unfinished sagas in the background and keeps retrying the stalled ones
+func (e *Engine) Start() {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+
+		err := e.ResumeAll(e.ctx)
+		if err != nil {
+			e.log.Error().Err(err).Msg("cannot resume sagas")
+		}
+
+		ticker := time.NewTicker(e.retryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-ticker.C:
+				e.retryStalled()
+			}
+		}
+	}()
+}
+
+// Close stops resuming the sagas and waits for the running ones
+func (e *Engine) Close() {
+	e.cancel()
+	e.wg.Wait()
+}
+
+// Run starts a new saga with the state and runs it to the end.
+// The saga is not interrupted when ctx is cancelled, only the metadata of ctx are used.
+// If a step fails, the steps are compensated and an *AbortedError is returned.
+// If the saga cannot be finished now, it is resumed later and the error is returned.
+func (e *Engine) Run(ctx context.Context, name, id string, state interface{}) (s *Saga, err error) {
+	definition, err := e.definition(name)
+	if err != nil {
+		return
+	}
+	encoded, err := bson.Marshal(state)
+	if err != nil {
+		err = fmt.Errorf("cannot encode state of saga %s %s: %w", name, id, err)
+		return
+	}
+
+	if !e.acquire(id) {
+		err = fmt.Errorf("%w: %s %s", ErrAlreadyRunning, name, id)
+		return
+	}
+	defer e.release(id)
+
+	e.wg.Add(1)
+	defer e.wg.Done()
+
+	ctx = events.ContextWithMetadata(e.ctx, events.MetadataFromContext(ctx))
+	err = e.index.Add(ctx, id)
+	if err != nil {
+		return
+	}
+	s = &Saga{ID: id, State: state}
+	err = e.save(ctx, s, &EventStarted{
+		ID:    id,
+		Saga:  name,
+		State: encoded,
+	})
+	if err != nil {
+		return
+	}
+
+	err = e.run(ctx, definition, s)
+
+	return
+}
+
+// ResumeAll runs all the unfinished sagas of the index
+func (e *Engine) ResumeAll(ctx context.Context) (err error) {
+	unfinished, err := e.index.List(ctx)
+	if err != nil {
+		return
+	}
+
+	if len(unfinished) > 0 {
+		e.log.Info().Int("sagas", len(unfinished)).Msg("resuming unfinished sagas")
+	}
+	for _, id := range unfinished {
+		if ctx.Err() != nil {
+			return
+		}
+		e.resume(id)
+	}
+
+	return
+}
+
+func (e *Engine) retryStalled() {
+	e.mu.Lock()
+	ids := make([]string, 0, len(e.stalled))
+	for id := range e.stalled {
+		ids = append(ids, id)
+	}
+	e.mu.Unlock()
+
+	for _, id := range ids {
+		if e.ctx.Err() != nil {
+			return
+		}
+		e.resume(id)
+	}
+}
+
+// resume loads the saga and runs it from the step it was at. Failures are logged and the saga is retried later.
+func (e *Engine) resume(id string) {
+	if !e.acquire(id) {
+		return
+	}
+	defer e.release(id)
+
+	err := func() (err error) {
+		aggregate, err := e.repo.LoadAggregate(Category, id)
+		if err != nil {
+			return
+		}
+		aggregateEvents, err := e.repo.DecodeEvents(aggregate)
+		if err != nil {
+			return
+		}
+		s := NewFromEvents(aggregateEvents)
+		if aggregate.Version == 0 || s.Finished() {
+			// the saga was not started or was interrupted after it had finished
+			e.unindex(id)
+			return
+		}
+
+		definition, err := e.definition(s.Name)
+		if err != nil {
+			return
+		}
+		s.State = definition.NewState()
+		err = bson.Unmarshal(s.state, s.State)
+		if err != nil {
+			err = fmt.Errorf("cannot decode state: %w", err)
+			return
+		}
+
+		e.log.Info().Str("saga", s.Name).Str("id", id).Str("status", string(s.Status)).Int("step", s.Step).Msg("resuming saga")
+
+		return e.run(events.CausedBy(e.ctx, s.metadata), definition, s)
+	}()
+
+	var abortedErr *AbortedError
+	switch {
+	case err == nil:
+		e.log.Info().Str("id", id).Msg("resumed saga completed")
+	case errors.As(err, &abortedErr):
+		e.log.Info().Err(err).Str("id", id).Msg("resumed saga aborted")
+	default:
+		e.log.Error().Err(err).Str("id", id).Msg("cannot resume saga")
+	}
+}
+
+// run runs the steps of the saga, or compensates them, until it is finished
+func (e *Engine) run(ctx context.Context, definition Definition, s *Saga) (err error) {
+	defer func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		var abortedErr *AbortedError
+		if err != nil && !errors.As(err, &abortedErr) {
+			e.stalled[s.ID] = true
+		} else {
+			delete(e.stalled, s.ID)
+		}
+	}()
+
+	// failure is the error of the step failed by this run
+	var failure error
+	for {
+		switch {
+		case s.Status == StatusCompleted:
+			e.unindex(s.ID)
+			return
+		case s.Status == StatusAborted:
+			e.unindex(s.ID)
+			if failure == nil {
+				failure = errors.New(s.Error)
+			}
+			err = &AbortedError{Saga: definition.Name, ID: s.ID, Err: failure}
+			return
+
+		case s.Status == StatusRunning && s.Step >= len(definition.Steps):
+			err = e.save(ctx, s, &EventCompleted{ID: s.ID})
+		case s.Status == StatusRunning:
+			step := definition.Steps[s.Step]
+			actionErr := step.Action(ctx, s)
+			if actionErr != nil {
+				e.log.Debug().Err(actionErr).Str("saga", definition.Name).Str("id", s.ID).Str("step", step.Name).Msg("saga step failed")
+				failure = actionErr
+				err = e.save(ctx, s, &EventStepFailed{
+					ID:    s.ID,
+					Step:  int32(s.Step),
+					Name:  step.Name,
+					Error: actionErr.Error(),
+				})
+				break
+			}
+
+			var state []byte
+			state, err = bson.Marshal(s.State)
+			if err != nil {
+				err = fmt.Errorf("cannot encode state: %w", err)
+				return
+			}
+			err = e.save(ctx, s, &EventStepCompleted{
+				ID:    s.ID,
+				Step:  int32(s.Step),
+				Name:  step.Name,
+				State: state,
+			})
+
+		case s.Status == StatusCompensating && s.Step < 0:
+			err = e.save(ctx, s, &EventAborted{ID: s.ID, Error: s.Error})
+		case s.Status == StatusCompensating:
+			step := definition.Steps[s.Step]
+			if step.Compensate == nil {
+				s.Step--
+				break
+			}
+			err = step.Compensate(ctx, s)
+			if err != nil {
+				err = fmt.Errorf("cannot compensate step %s of saga %s %s: %w", step.Name, definition.Name, s.ID, err)
+				return
+			}
+			err = e.save(ctx, s, &EventStepCompensated{
+				ID:   s.ID,
+				Step: int32(s.Step),
+				Name: step.Name,
+			})
+
+		default:
+			err = fmt.Errorf("saga %s %s has unknown status %q", definition.Name, s.ID, s.Status)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// unindex removes the finished saga from the index. A failure is only logged, the saga is removed when it is resumed.
+func (e *Engine) unindex(id string) {
+	err := e.index.Remove(e.ctx, id)
+	if err != nil {
+		e.log.Warn().Err(err).Str("id", id).Msg("cannot remove finished saga from index")
+	}
+}
+
+// save stores the event of the saga and applies it
+func (e *Engine) save(ctx context.Context, s *Saga, event events.Event) (err error) {
+	err = e.repo.SaveEvents(ctx, Category, s.ID, []events.Event{event}, s.version)
+	if err != nil {
+		err = fmt.Errorf("cannot save saga %s: %w", s.ID, err)
+		return
+	}
+	s.version++
+	s.ApplyEvent(event)
+
+	return
+}
+
+func (e *Engine) definition(name string) (definition Definition, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	definition, ok := e.definitions[name]
+	if !ok {
+		err = fmt.Errorf("%w: %s", ErrUnknownSaga, name)
+	}
+
+	return
+}
+
+func (e *Engine) acquire(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.running[id] {
+		return false
+	}
+	e.running[id] = true
+
+	return true
+}
+
+func (e *Engine) release(id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.running, id)
+}
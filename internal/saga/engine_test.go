@@ -0,0 +1,265 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/sveatlo/night_snack/internal/events"
+)
+
+type testState struct {
+	Done []string `bson:"done"`
+}
+
+// testSteps records the calls of the steps of the test saga and fails the ones set as failing
+type testSteps struct {
+	mu      sync.Mutex
+	calls   []string
+	failing map[string]bool
+}
+
+func (ts *testSteps) call(name string) func(ctx context.Context, s *Saga) error {
+	return func(ctx context.Context, s *Saga) error {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+
+		ts.calls = append(ts.calls, name)
+		if ts.failing[name] {
+			return fmt.Errorf("%s failed", name)
+		}
+		if state, ok := s.State.(*testState); ok {
+			state.Done = append(state.Done, name)
+		}
+
+		return nil
+	}
+}
+
+func (ts *testSteps) setFailing(name string, failing bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.failing[name] = failing
+}
+
+func (ts *testSteps) takeCalls() []string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	calls := ts.calls
+	ts.calls = nil
+
+	return calls
+}
+
+func (ts *testSteps) definition() Definition {
+	return Definition{
+		Name: "test",
+		Steps: []Step{
+			{Name: "a", Action: ts.call("a"), Compensate: ts.call("undo a")},
+			{Name: "b", Action: ts.call("b")},
+			{Name: "c", Action: ts.call("c"), Compensate: ts.call("undo c")},
+		},
+		NewState: func() interface{} { return &testState{} },
+	}
+}
+
+func newTestEngine(t *testing.T, store events.Store, index Index) (*Engine, *testSteps) {
+	e, err := NewEngine(nil, store, nil, index, time.Hour, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("cannot create engine: %v", err)
+	}
+	t.Cleanup(e.Close)
+
+	steps := &testSteps{failing: map[string]bool{}}
+	err = e.Register(steps.definition())
+	if err != nil {
+		t.Fatalf("cannot register saga: %v", err)
+	}
+
+	return e, steps
+}
+
+func loadSaga(t *testing.T, e *Engine, id string) *Saga {
+	aggregate, err := e.repo.LoadAggregate(Category, id)
+	if err != nil {
+		t.Fatalf("cannot load saga: %v", err)
+	}
+	aggregateEvents, err := e.repo.DecodeEvents(aggregate)
+	if err != nil {
+		t.Fatalf("cannot decode saga: %v", err)
+	}
+
+	return NewFromEvents(aggregateEvents)
+}
+
+func assertIndexed(t *testing.T, index Index, expected ...string) {
+	ids, err := index.List(context.Background())
+	if err != nil {
+		t.Fatalf("cannot list index: %v", err)
+	}
+	if len(ids) != len(expected) || len(ids) > 0 && !reflect.DeepEqual(ids, expected) {
+		t.Errorf("index holds %v, expected %v", ids, expected)
+	}
+}
+
+func TestEngineRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		failing []string
+		calls   []string
+		status  Status
+		// aborted is true when the run is expected to fail with *AbortedError
+		aborted bool
+	}{
+		{"completed", nil, []string{"a", "b", "c"}, StatusCompleted, false},
+		// the failed step is compensated as well as the completed ones, the steps without compensation are skipped
+		{"last step failed", []string{"c"}, []string{"a", "b", "c", "undo c", "undo a"}, StatusAborted, true},
+		{"first step failed", []string{"a"}, []string{"a", "undo a"}, StatusAborted, true},
+		// the saga is left compensating and it is retried later
+		{"compensation failed", []string{"c", "undo a"}, []string{"a", "b", "c", "undo c", "undo a"}, StatusCompensating, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			index := NewMemoryIndex()
+			e, steps := newTestEngine(t, events.NewMemoryStore(), index)
+			for _, name := range test.failing {
+				steps.setFailing(name, true)
+			}
+
+			s, err := e.Run(context.Background(), "test", "saga", &testState{})
+			var abortedErr *AbortedError
+			switch {
+			case test.aborted && !errors.As(err, &abortedErr):
+				t.Errorf("got %v, expected the saga to be aborted", err)
+			case test.aborted && abortedErr.Err.Error() != test.failing[0]+" failed":
+				t.Errorf("saga aborted by %v, expected the failure of %s", abortedErr.Err, test.failing[0])
+			case !test.aborted && test.status == StatusCompleted && err != nil:
+				t.Errorf("saga failed: %v", err)
+			case test.status == StatusCompensating && (err == nil || errors.As(err, &abortedErr)):
+				t.Errorf("got %v, expected the compensation failure", err)
+			}
+
+			if calls := steps.takeCalls(); !reflect.DeepEqual(calls, test.calls) {
+				t.Errorf("steps called %v, expected %v", calls, test.calls)
+			}
+			if s.Status != test.status {
+				t.Errorf("saga is %s, expected %s", s.Status, test.status)
+			}
+			if stored := loadSaga(t, e, "saga"); stored.Status != test.status {
+				t.Errorf("stored saga is %s, expected %s", stored.Status, test.status)
+			}
+			if test.status == StatusCompensating {
+				assertIndexed(t, index, "saga")
+			} else {
+				assertIndexed(t, index)
+			}
+		})
+	}
+}
+
+func TestEngineRunAlreadyStarted(t *testing.T) {
+	e, _ := newTestEngine(t, events.NewMemoryStore(), NewMemoryIndex())
+
+	_, err := e.Run(context.Background(), "test", "saga", &testState{})
+	if err != nil {
+		t.Fatalf("saga failed: %v", err)
+	}
+	_, err = e.Run(context.Background(), "test", "saga", &testState{})
+	if err == nil {
+		t.Error("saga with the same ID started twice")
+	}
+
+	_, err = e.Run(context.Background(), "other", "other", &testState{})
+	if !errors.Is(err, ErrUnknownSaga) {
+		t.Errorf("got %v for an unknown saga, expected %v", err, ErrUnknownSaga)
+	}
+}
+
+func TestEngineResume(t *testing.T) {
+	store := events.NewMemoryStore()
+	index := NewMemoryIndex()
+	ctx := context.Background()
+	first, firstSteps := newTestEngine(t, store, index)
+
+	// a saga interrupted after its first step, as if the process crashed
+	state, err := bson.Marshal(&testState{Done: []string{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = index.Add(ctx, "interrupted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = first.repo.SaveEvents(ctx, Category, "interrupted", []events.Event{
+		&EventStarted{ID: "interrupted", Saga: "test", State: state},
+		&EventStepCompleted{ID: "interrupted", Step: 0, Name: "a", State: state},
+	}, 0)
+	if err != nil {
+		t.Fatalf("cannot save saga: %v", err)
+	}
+
+	// a saga whose compensation failed
+	firstSteps.setFailing("c", true)
+	firstSteps.setFailing("undo a", true)
+	_, err = first.Run(ctx, "test", "stalled", &testState{})
+	if err == nil {
+		t.Fatal("saga with failing compensation finished")
+	}
+
+	// a saga which finished but the process crashed before it was removed from the index
+	firstSteps.setFailing("c", false)
+	_, err = first.Run(ctx, "test", "finished", &testState{})
+	if err != nil {
+		t.Fatalf("saga failed: %v", err)
+	}
+	err = index.Add(ctx, "finished")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a saga which was added to the index but never started
+	err = index.Add(ctx, "not-started")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIndexed(t, index, "interrupted", "stalled", "finished", "not-started")
+
+	second, secondSteps := newTestEngine(t, store, index)
+	err = second.ResumeAll(ctx)
+	if err != nil {
+		t.Fatalf("cannot resume sagas: %v", err)
+	}
+
+	// the interrupted saga continues with its second step, the stalled one compensates the rest of the steps
+	calls := secondSteps.takeCalls()
+	expected := []string{"b", "c", "undo a"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("steps called %v, expected %v", calls, expected)
+	}
+
+	resumed := loadSaga(t, second, "interrupted")
+	if resumed.Status != StatusCompleted {
+		t.Errorf("interrupted saga is %s, expected %s", resumed.Status, StatusCompleted)
+	}
+	resumedState := &testState{}
+	err = bson.Unmarshal(resumed.state, resumedState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resumedState.Done, []string{"a", "b", "c"}) {
+		t.Errorf("resumed saga state is %v, expected to continue from the stored state", resumedState.Done)
+	}
+	if s := loadSaga(t, second, "stalled"); s.Status != StatusAborted {
+		t.Errorf("stalled saga is %s, expected %s", s.Status, StatusAborted)
+	}
+	assertIndexed(t, index)
+}
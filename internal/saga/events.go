@@ -0,0 +1,351 @@
+package saga
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/sveatlo/night_snack/internal/events"
+	saga_pb "github.com/sveatlo/night_snack/proto/saga"
+)
+
+// Category is the event category of the sagas
+const Category = "saga"
+
+var (
+	_ events.Event = &EventStarted{}
+	_ events.Event = &EventStepCompleted{}
+	_ events.Event = &EventStepFailed{}
+	_ events.Event = &EventStepCompensated{}
+	_ events.Event = &EventCompleted{}
+	_ events.Event = &EventAborted{}
+)
+
+func init() {
+	events.Register(
+		events.EventType{
+			Event:     &EventStarted{},
+			Proto:     &saga_pb.Started{},
+			FromData:  func(data bson.M) (events.Event, error) { return EventStartedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event { return EventStartedFromProto(msg.(*saga_pb.Started)) },
+		},
+		events.EventType{
+			Event:    &EventStepCompleted{},
+			Proto:    &saga_pb.StepCompleted{},
+			FromData: func(data bson.M) (events.Event, error) { return EventStepCompletedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventStepCompletedFromProto(msg.(*saga_pb.StepCompleted))
+			},
+		},
+		events.EventType{
+			Event:    &EventStepFailed{},
+			Proto:    &saga_pb.StepFailed{},
+			FromData: func(data bson.M) (events.Event, error) { return EventStepFailedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventStepFailedFromProto(msg.(*saga_pb.StepFailed))
+			},
+		},
+		events.EventType{
+			Event:    &EventStepCompensated{},
+			Proto:    &saga_pb.StepCompensated{},
+			FromData: func(data bson.M) (events.Event, error) { return EventStepCompensatedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventStepCompensatedFromProto(msg.(*saga_pb.StepCompensated))
+			},
+		},
+		events.EventType{
+			Event:     &EventCompleted{},
+			Proto:     &saga_pb.Completed{},
+			FromData:  func(data bson.M) (events.Event, error) { return EventCompletedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event { return EventCompletedFromProto(msg.(*saga_pb.Completed)) },
+		},
+		events.EventType{
+			Event:     &EventAborted{},
+			Proto:     &saga_pb.Aborted{},
+			FromData:  func(data bson.M) (events.Event, error) { return EventAbortedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event { return EventAbortedFromProto(msg.(*saga_pb.Aborted)) },
+		},
+	)
+}
+
+type EventStarted struct {
+	events.Envelope `bson:"-" json:"-"`
+
+	ID    string
+	Saga  string
+	State bson.Raw
+}
+
+func EventStartedFromProto(cmd *saga_pb.Started) *EventStarted {
+	return &EventStarted{
+		ID:    cmd.Id,
+		Saga:  cmd.Saga,
+		State: cmd.State,
+	}
+}
+
+func EventStartedFromData(data bson.M) (e *EventStarted, err error) {
+	r := events.NewDataReader(data)
+	e = &EventStarted{
+		ID:    r.String("id"),
+		Saga:  r.String("saga"),
+		State: stateFromData(r.Value("state")),
+	}
+	err = r.Err()
+
+	return
+}
+
+func (e *EventStarted) EventCategory() string { return Category }
+func (e *EventStarted) EventType() string     { return "started" }
+func (e *EventStarted) AggregateID() string   { return e.ID }
+func (e *EventStarted) Data() bson.M {
+	return bson.M{
+		"id":    e.ID,
+		"saga":  e.Saga,
+		"state": e.State,
+	}
+}
+func (e *EventStarted) ToProto() proto.Message {
+	return &saga_pb.Started{
+		Id:    e.ID,
+		Saga:  e.Saga,
+		State: e.State,
+	}
+}
+
+type EventStepCompleted struct {
+	events.Envelope `bson:"-" json:"-"`
+
+	ID   string
+	Step int32
+	Name string
+	// State is the state of the saga after the step
+	State bson.Raw
+}
+
+func EventStepCompletedFromProto(cmd *saga_pb.StepCompleted) *EventStepCompleted {
+	return &EventStepCompleted{
+		ID:    cmd.Id,
+		Step:  cmd.Step,
+		Name:  cmd.Name,
+		State: cmd.State,
+	}
+}
+
+func EventStepCompletedFromData(data bson.M) (e *EventStepCompleted, err error) {
+	r := events.NewDataReader(data)
+	e = &EventStepCompleted{
+		ID:    r.String("id"),
+		Step:  r.Int32("step"),
+		Name:  r.String("name"),
+		State: stateFromData(r.Value("state")),
+	}
+	err = r.Err()
+
+	return
+}
+
+func (e *EventStepCompleted) EventCategory() string { return Category }
+func (e *EventStepCompleted) EventType() string     { return "stepcompleted" }
+func (e *EventStepCompleted) AggregateID() string   { return e.ID }
+func (e *EventStepCompleted) Data() bson.M {
+	return bson.M{
+		"id":    e.ID,
+		"step":  e.Step,
+		"name":  e.Name,
+		"state": e.State,
+	}
+}
+func (e *EventStepCompleted) ToProto() proto.Message {
+	return &saga_pb.StepCompleted{
+		Id:    e.ID,
+		Step:  e.Step,
+		Name:  e.Name,
+		State: e.State,
+	}
+}
+
+type EventStepFailed struct {
+	events.Envelope `bson:"-" json:"-"`
+
+	ID    string
+	Step  int32
+	Name  string
+	Error string
+}
+
+func EventStepFailedFromProto(cmd *saga_pb.StepFailed) *EventStepFailed {
+	return &EventStepFailed{
+		ID:    cmd.Id,
+		Step:  cmd.Step,
+		Name:  cmd.Name,
+		Error: cmd.Error,
+	}
+}
+
+func EventStepFailedFromData(data bson.M) (e *EventStepFailed, err error) {
+	r := events.NewDataReader(data)
+	e = &EventStepFailed{
+		ID:    r.String("id"),
+		Step:  r.Int32("step"),
+		Name:  r.String("name"),
+		Error: r.String("error"),
+	}
+	err = r.Err()
+
+	return
+}
+
+func (e *EventStepFailed) EventCategory() string { return Category }
+func (e *EventStepFailed) EventType() string     { return "stepfailed" }
+func (e *EventStepFailed) AggregateID() string   { return e.ID }
+func (e *EventStepFailed) Data() bson.M {
+	return bson.M{
+		"id":    e.ID,
+		"step":  e.Step,
+		"name":  e.Name,
+		"error": e.Error,
+	}
+}
+func (e *EventStepFailed) ToProto() proto.Message {
+	return &saga_pb.StepFailed{
+		Id:    e.ID,
+		Step:  e.Step,
+		Name:  e.Name,
+		Error: e.Error,
+	}
+}
+
+type EventStepCompensated struct {
+	events.Envelope `bson:"-" json:"-"`
+
+	ID   string
+	Step int32
+	Name string
+}
+
+func EventStepCompensatedFromProto(cmd *saga_pb.StepCompensated) *EventStepCompensated {
+	return &EventStepCompensated{
+		ID:   cmd.Id,
+		Step: cmd.Step,
+		Name: cmd.Name,
+	}
+}
+
+func EventStepCompensatedFromData(data bson.M) (e *EventStepCompensated, err error) {
+	r := events.NewDataReader(data)
+	e = &EventStepCompensated{
+		ID:   r.String("id"),
+		Step: r.Int32("step"),
+		Name: r.String("name"),
+	}
+	err = r.Err()
+
+	return
+}
+
+func (e *EventStepCompensated) EventCategory() string { return Category }
+func (e *EventStepCompensated) EventType() string     { return "stepcompensated" }
+func (e *EventStepCompensated) AggregateID() string   { return e.ID }
+func (e *EventStepCompensated) Data() bson.M {
+	return bson.M{
+		"id":   e.ID,
+		"step": e.Step,
+		"name": e.Name,
+	}
+}
+func (e *EventStepCompensated) ToProto() proto.Message {
+	return &saga_pb.StepCompensated{
+		Id:   e.ID,
+		Step: e.Step,
+		Name: e.Name,
+	}
+}
+
+type EventCompleted struct {
+	events.Envelope `bson:"-" json:"-"`
+
+	ID string
+}
+
+func EventCompletedFromProto(cmd *saga_pb.Completed) *EventCompleted {
+	return &EventCompleted{
+		ID: cmd.Id,
+	}
+}
+
+func EventCompletedFromData(data bson.M) (e *EventCompleted, err error) {
+	r := events.NewDataReader(data)
+	e = &EventCompleted{
+		ID: r.String("id"),
+	}
+	err = r.Err()
+
+	return
+}
+
+func (e *EventCompleted) EventCategory() string { return Category }
+func (e *EventCompleted) EventType() string     { return "completed" }
+func (e *EventCompleted) AggregateID() string   { return e.ID }
+func (e *EventCompleted) Data() bson.M {
+	return bson.M{
+		"id": e.ID,
+	}
+}
+func (e *EventCompleted) ToProto() proto.Message {
+	return &saga_pb.Completed{
+		Id: e.ID,
+	}
+}
+
+type EventAborted struct {
+	events.Envelope `bson:"-" json:"-"`
+
+	ID    string
+	Error string
+}
+
+func EventAbortedFromProto(cmd *saga_pb.Aborted) *EventAborted {
+	return &EventAborted{
+		ID:    cmd.Id,
+		Error: cmd.Error,
+	}
+}
+
+func EventAbortedFromData(data bson.M) (e *EventAborted, err error) {
+	r := events.NewDataReader(data)
+	e = &EventAborted{
+		ID:    r.String("id"),
+		Error: r.String("error"),
+	}
+	err = r.Err()
+
+	return
+}
+
+func (e *EventAborted) EventCategory() string { return Category }
+func (e *EventAborted) EventType() string     { return "aborted" }
+func (e *EventAborted) AggregateID() string   { return e.ID }
+func (e *EventAborted) Data() bson.M {
+	return bson.M{
+		"id":    e.ID,
+		"error": e.Error,
+	}
+}
+func (e *EventAborted) ToProto() proto.Message {
+	return &saga_pb.Aborted{
+		Id:    e.ID,
+		Error: e.Error,
+	}
+}
+
+// stateFromData re-encodes the state decoded from the stored data as an embedded document
+func stateFromData(data interface{}) (state bson.Raw) {
+	if raw, ok := data.(bson.Raw); ok {
+		return raw
+	}
+
+	state, _ = bson.Marshal(data)
+
+	return
+}
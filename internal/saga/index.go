@@ -0,0 +1,137 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	_ Index = &MongoIndex{}
+	_ Index = &MemoryIndex{}
+)
+
+// Index keeps the IDs of the unfinished sagas, so that they are resumed without reading the history of all the sagas.
+// A saga is added before it is started and removed once it has finished, so an interrupted saga is never missing
+// from the index. A saga left in the index by a crash after it had finished is removed when it is resumed.
+type Index interface {
+	Add(ctx context.Context, id string) error
+	Remove(ctx context.Context, id string) error
+	// List returns the IDs of the unfinished sagas in the order they were added
+	List(ctx context.Context) (ids []string, err error)
+}
+
+// MongoIndex keeps the IDs of the unfinished sagas in the sagas_unfinished collection
+type MongoIndex struct {
+	unfinishedCollection *mongo.Collection
+}
+
+type unfinishedSaga struct {
+	ID      string    `bson:"_id"`
+	AddedAt time.Time `bson:"added_at"`
+}
+
+func NewMongoIndex(mongoDB *mongo.Database) (i *MongoIndex) {
+	return &MongoIndex{
+		unfinishedCollection: mongoDB.Collection("sagas_unfinished"),
+	}
+}
+
+func (i *MongoIndex) Add(ctx context.Context, id string) (err error) {
+	_, err = i.unfinishedCollection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$setOnInsert": unfinishedSaga{ID: id, AddedAt: time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		err = fmt.Errorf("cannot add saga %s to index: %w", id, err)
+		return
+	}
+
+	return
+}
+
+func (i *MongoIndex) Remove(ctx context.Context, id string) (err error) {
+	_, err = i.unfinishedCollection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		err = fmt.Errorf("cannot remove saga %s from index: %w", id, err)
+		return
+	}
+
+	return
+}
+
+func (i *MongoIndex) List(ctx context.Context) (ids []string, err error) {
+	cursor, err := i.unfinishedCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "added_at", Value: 1}}))
+	if err != nil {
+		err = fmt.Errorf("cannot list unfinished sagas: %w", err)
+		return
+	}
+
+	var sagas []unfinishedSaga
+	err = cursor.All(ctx, &sagas)
+	if err != nil {
+		err = fmt.Errorf("cannot decode unfinished sagas: %w", err)
+		return
+	}
+
+	ids = make([]string, len(sagas))
+	for j, s := range sagas {
+		ids[j] = s.ID
+	}
+
+	return
+}
+
+// MemoryIndex keeps the IDs of the unfinished sagas in memory of a single process
+type MemoryIndex struct {
+	mu      sync.Mutex
+	added   map[string]int
+	counter int
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		added: map[string]int{},
+	}
+}
+
+func (i *MemoryIndex) Add(ctx context.Context, id string) (err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.added[id]; !ok {
+		i.counter++
+		i.added[id] = i.counter
+	}
+
+	return
+}
+
+func (i *MemoryIndex) Remove(ctx context.Context, id string) (err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.added, id)
+
+	return
+}
+
+func (i *MemoryIndex) List(ctx context.Context) (ids []string, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	ids = make([]string, 0, len(i.added))
+	for id := range i.added {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return i.added[ids[a]] < i.added[ids[b]] })
+
+	return
+}
@@ -0,0 +1,58 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	mongo_options "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const envTestMongoURI = "SNACK_TEST_MONGO_URI"
+
+func TestMemoryIndex(t *testing.T) {
+	testIndex(t, NewMemoryIndex())
+}
+
+func TestMongoIndex(t *testing.T) {
+	uri := os.Getenv(envTestMongoURI)
+	if uri == "" {
+		t.Skipf("%s not set", envTestMongoURI)
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, mongo_options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("cannot connect to mongo: %v", err)
+	}
+	defer client.Disconnect(ctx)
+	db := client.Database(fmt.Sprintf("night_snack_test_%d", time.Now().UnixNano()))
+	defer db.Drop(ctx)
+
+	testIndex(t, NewMongoIndex(db))
+}
+
+func testIndex(t *testing.T, index Index) {
+	ctx := context.Background()
+
+	for _, id := range []string{"a", "b", "c", "a"} {
+		err := index.Add(ctx, id)
+		if err != nil {
+			t.Fatalf("cannot add %s: %v", id, err)
+		}
+		// the Mongo index orders the sagas by the time they were added
+		time.Sleep(time.Millisecond)
+	}
+	assertIndexed(t, index, "a", "b", "c")
+
+	for _, id := range []string{"b", "unknown"} {
+		err := index.Remove(ctx, id)
+		if err != nil {
+			t.Fatalf("cannot remove %s: %v", id, err)
+		}
+	}
+	assertIndexed(t, index, "a", "c")
+}
@@ -0,0 +1,131 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/sveatlo/night_snack/internal/events"
+)
+
+type Status string
+
+const (
+	StatusRunning      Status = "running"
+	StatusCompensating Status = "compensating"
+	StatusCompleted    Status = "completed"
+	StatusAborted      Status = "aborted"
+)
+
+// Step is a single step of a saga
+type Step struct {
+	Name string
+	// Action performs the step. It is run again when the saga is resumed before the step was recorded
+	// as completed, so it has to be idempotent, e.g. by using the operation IDs of the saga.
+	Action func(ctx context.Context, s *Saga) error
+	// Compensate undoes the step. It is run for the completed steps as well as for the failed one,
+	// so it has to cope with an action which was applied only partially or not at all.
+	// It is nil when there is nothing to undo.
+	Compensate func(ctx context.Context, s *Saga) error
+}
+
+// Definition describes the steps of a saga
+type Definition struct {
+	Name  string
+	Steps []Step
+	// NewState returns a pointer to an empty state of the saga, the stored state is decoded into it
+	NewState func() interface{}
+}
+
+// Saga is a running or finished instance of a saga folded from its events
+type Saga struct {
+	ID     string
+	Name   string
+	Status Status
+	// Step is the index of the next step to run or, while compensating, to compensate
+	Step int
+	// State is shared by the steps of the saga. It is stored after every completed step.
+	State interface{}
+	// Error is the error of the failed step
+	Error string
+
+	state    bson.Raw
+	version  int
+	metadata events.Metadata
+}
+
+func NewFromEvents(events []events.Event) (s *Saga) {
+	s = &Saga{}
+
+	for _, event := range events {
+		s.ApplyEvent(event)
+	}
+	s.version = len(events)
+
+	return
+}
+
+func (s *Saga) ApplyEvent(event events.Event) {
+	switch e := event.(type) {
+	case *EventStarted:
+		s.ID = e.ID
+		s.Name = e.Saga
+		s.Status = StatusRunning
+		s.Step = 0
+		s.state = e.State
+		s.metadata = e.Metadata()
+	case *EventStepCompleted:
+		s.Step = int(e.Step) + 1
+		s.state = e.State
+	case *EventStepFailed:
+		s.Status = StatusCompensating
+		s.Step = int(e.Step)
+		s.Error = e.Error
+	case *EventStepCompensated:
+		s.Step = int(e.Step) - 1
+	case *EventCompleted:
+		s.Status = StatusCompleted
+	case *EventAborted:
+		s.Status = StatusAborted
+	}
+}
+
+// Finished reports whether the saga has completed or has been aborted
+func (s *Saga) Finished() bool {
+	return s.Status == StatusCompleted || s.Status == StatusAborted
+}
+
+// OperationID returns the ID of an operation performed by the saga.
+// The ID stays the same when the saga is resumed, so it can be used to make the steps idempotent.
+func (s *Saga) OperationID(parts ...interface{}) string {
+	return OperationID(s.ID, parts...)
+}
+
+// OperationID returns the ID of an operation performed by the saga with the ID,
+// so that the operations can be referred to after the saga has finished
+func OperationID(sagaID string, parts ...interface{}) string {
+	id := []string{sagaID}
+	for _, part := range parts {
+		id = append(id, fmt.Sprint(part))
+	}
+
+	return strings.Join(id, "/")
+}
+
+// AbortedError is returned when a step of the saga failed and all the steps were compensated
+type AbortedError struct {
+	Saga string
+	ID   string
+	// Err is the failure of the step, only its message is kept when the saga was resumed
+	Err error
+}
+
+func (e *AbortedError) Error() string {
+	return fmt.Sprintf("saga %s %s aborted: %s", e.Saga, e.ID, e.Err)
+}
+
+func (e *AbortedError) Unwrap() error {
+	return e.Err
+}
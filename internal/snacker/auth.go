@@ -0,0 +1,147 @@
+package snacker
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moderntv/cadre/http/responses"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpc_status "google.golang.org/grpc/status"
+
+	"github.com/sveatlo/night_snack/internal/auth"
+)
+
+const (
+	// APIKeyHeader carries the API key of a machine client, the users send their JWT in the Authorization header
+	APIKeyHeader = "X-API-Key"
+	// AccessTokenQueryParameter carries the JWT of the clients which cannot set the headers, e.g. of the browser WebSockets
+	AccessTokenQueryParameter = "access_token"
+	// AuthorizationMetadataKey carries the JWT of the gRPC calls as "Bearer <token>"
+	AuthorizationMetadataKey = "authorization"
+	// APIKeyMetadataKey carries the API key of the gRPC calls
+	APIKeyMetadataKey = "x-api-key"
+)
+
+// unauthenticatedMethodPrefixes are the gRPC services which are open to everyone, e.g. for the health checks of the orchestrator
+var unauthenticatedMethodPrefixes = []string{
+	"/grpc.health.v1.",
+	"/grpc.reflection.",
+}
+
+// authenticate puts the principal identified by the request credentials into the request context,
+// the requests which are not authenticated are rejected. All the requests pass when the authentication is disabled.
+func (gw *HTTPGateway) authenticate(c *gin.Context) {
+	if gw.authenticator == nil {
+		c.Next()
+		return
+	}
+
+	credentials := auth.Credentials{
+		BearerToken: bearerToken(c.GetHeader("Authorization")),
+		APIKey:      c.GetHeader(APIKeyHeader),
+	}
+	if credentials.BearerToken == "" {
+		credentials.BearerToken = c.Query(AccessTokenQueryParameter)
+	}
+
+	principal, err := gw.authenticator.Authenticate(c.Request.Context(), credentials)
+	if err != nil {
+		gw.log.Debug().Err(err).Str("path", c.Request.URL.Path).Msg("request not authenticated")
+		c.Header("WWW-Authenticate", "Bearer")
+		responses.Unauthorized(c, responses.NewError(err))
+		return
+	}
+
+	c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), principal))
+
+	c.Next()
+}
+
+// UnaryAuthInterceptor puts the principal identified by the call credentials into the call context,
+// the calls which are not authenticated are rejected
+func UnaryAuthInterceptor(authenticator auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !requiresAuthentication(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		ctx, err := authenticateCall(ctx, authenticator)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthInterceptor puts the principal identified by the call credentials into the stream context,
+// the calls which are not authenticated are rejected
+func StreamAuthInterceptor(authenticator auth.Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !requiresAuthentication(info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		ctx, err := authenticateCall(ss.Context(), authenticator)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func authenticateCall(ctx context.Context, authenticator auth.Authenticator) (authenticatedCtx context.Context, err error) {
+	credentials := auth.Credentials{}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(AuthorizationMetadataKey); len(values) > 0 {
+			credentials.BearerToken = bearerToken(values[0])
+		}
+		if values := md.Get(APIKeyMetadataKey); len(values) > 0 {
+			credentials.APIKey = values[0]
+		}
+	}
+
+	principal, err := authenticator.Authenticate(ctx, credentials)
+	if err != nil {
+		err = grpc_status.Error(codes.Unauthenticated, err.Error())
+		return
+	}
+
+	authenticatedCtx = auth.WithPrincipal(ctx, principal)
+
+	return
+}
+
+func requiresAuthentication(method string) bool {
+	for _, prefix := range unauthenticatedMethodPrefixes {
+		if strings.HasPrefix(method, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// bearerToken returns the token of the "Bearer <token>" authorization, empty for the other schemes
+func bearerToken(authorization string) string {
+	const scheme = "bearer "
+	if len(authorization) <= len(scheme) || !strings.EqualFold(authorization[:len(scheme)], scheme) {
+		return ""
+	}
+
+	return strings.TrimSpace(authorization[len(scheme):])
+}
+
+// authenticatedStream replaces the context of the stream by the one carrying the principal
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,170 @@
+package snacker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpc_status "google.golang.org/grpc/status"
+
+	"github.com/sveatlo/night_snack/internal/auth"
+)
+
+// testAuthenticator accepts the bearer token "user-token" and the API key "service-key"
+type testAuthenticator struct{}
+
+func (testAuthenticator) Authenticate(ctx context.Context, credentials auth.Credentials) (*auth.Principal, error) {
+	switch {
+	case credentials.BearerToken == "user-token":
+		return &auth.Principal{Kind: auth.KindUser, Subject: "alice"}, nil
+	case credentials.APIKey == "service-key":
+		return &auth.Principal{Kind: auth.KindService, Subject: "courier_app"}, nil
+	}
+
+	return nil, auth.ErrUnauthenticated
+}
+
+func TestAuthenticateHTTP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		actor   string
+	}{
+		{"bearer token", "/", map[string]string{"Authorization": "Bearer user-token"}, "user:alice"},
+		{"lowercase scheme", "/", map[string]string{"Authorization": "bearer user-token"}, "user:alice"},
+		{"API key", "/", map[string]string{APIKeyHeader: "service-key"}, "service:courier_app"},
+		{"query token", "/?" + AccessTokenQueryParameter + "=user-token", nil, "user:alice"},
+		{"invalid token", "/", map[string]string{"Authorization": "Bearer other"}, ""},
+		{"other scheme", "/", map[string]string{"Authorization": "Basic user-token"}, ""},
+		{"no credentials", "/", nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gw := &HTTPGateway{log: zerolog.Nop(), authenticator: testAuthenticator{}}
+			actor := ""
+			router := gin.New()
+			router.Use(gw.authenticate)
+			router.GET("/", func(c *gin.Context) {
+				actor = auth.PrincipalFromContext(c.Request.Context()).Actor()
+				c.Status(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, test.target, nil)
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			if test.actor == "" {
+				if res.Code != http.StatusUnauthorized || res.Header().Get("WWW-Authenticate") != "Bearer" {
+					t.Errorf("got %d, expected %d with a challenge", res.Code, http.StatusUnauthorized)
+				}
+				return
+			}
+			if res.Code != http.StatusNoContent {
+				t.Fatalf("got %d, expected %d", res.Code, http.StatusNoContent)
+			}
+			if actor != test.actor {
+				t.Errorf("request authenticated as %q, expected %q", actor, test.actor)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		gw := &HTTPGateway{log: zerolog.Nop()}
+		router := gin.New()
+		router.Use(gw.authenticate)
+		router.GET("/", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+		if res.Code != http.StatusNoContent {
+			t.Errorf("got %d with the authentication disabled, expected %d", res.Code, http.StatusNoContent)
+		}
+	})
+}
+
+// testStream is a server stream of the context
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s testStream) Context() context.Context { return s.ctx }
+
+func TestAuthInterceptors(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		md     metadata.MD
+		// actor is empty when the call is expected to be rejected, "-" when it passes without a principal
+		actor string
+	}{
+		{"bearer token", "/orders.OrdersService/Create", metadata.Pairs(AuthorizationMetadataKey, "Bearer user-token"), "user:alice"},
+		{"API key", "/orders.OrdersService/Create", metadata.Pairs(APIKeyMetadataKey, "service-key"), "service:courier_app"},
+		{"invalid API key", "/orders.OrdersService/Create", metadata.Pairs(APIKeyMetadataKey, "other"), ""},
+		{"token without scheme", "/orders.OrdersService/Create", metadata.Pairs(AuthorizationMetadataKey, "user-token"), ""},
+		{"no credentials", "/orders.OrdersService/Create", nil, ""},
+		{"health check", "/grpc.health.v1.Health/Check", nil, "-"},
+		{"reflection", "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", nil, "-"},
+	}
+
+	actorOf := func(ctx context.Context) string {
+		principal := auth.PrincipalFromContext(ctx)
+		if principal == nil {
+			return "-"
+		}
+		return principal.Actor()
+	}
+	check := func(t *testing.T, expected, actor string, err error) {
+		if expected == "" {
+			if grpc_status.Code(err) != codes.Unauthenticated {
+				t.Errorf("got %v, expected %s", err, codes.Unauthenticated)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("call rejected: %v", err)
+		}
+		if actor != expected {
+			t.Errorf("call authenticated as %q, expected %q", actor, expected)
+		}
+	}
+
+	for _, test := range tests {
+		ctx := context.Background()
+		if test.md != nil {
+			ctx = metadata.NewIncomingContext(ctx, test.md)
+		}
+
+		t.Run("Unary/"+test.name, func(t *testing.T) {
+			actor := ""
+			interceptor := UnaryAuthInterceptor(testAuthenticator{})
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: test.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				actor = actorOf(ctx)
+				return nil, nil
+			})
+			check(t, test.actor, actor, err)
+		})
+
+		t.Run("Stream/"+test.name, func(t *testing.T) {
+			actor := ""
+			interceptor := StreamAuthInterceptor(testAuthenticator{})
+			err := interceptor(nil, testStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: test.method}, func(srv interface{}, ss grpc.ServerStream) error {
+				actor = actorOf(ss.Context())
+				return nil
+			})
+			check(t, test.actor, actor, err)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package snacker
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/sveatlo/night_snack/internal/events"
+)
+
+const (
+	// CorrelationIDHeader is the HTTP header identifying the request in the stored events
+	CorrelationIDHeader = "X-Correlation-ID"
+	// CorrelationIDMetadataKey is the gRPC metadata key identifying the request in the stored events
+	CorrelationIDMetadataKey = "x-correlation-id"
+	// AnonymousActor stores the events of the calls which are neither authenticated nor come from a known address
+	AnonymousActor = "anonymous"
+)
+
+// correlate puts the correlation ID sent by the client or a new one into the request context
+// and sends it back in the response headers. The events are stored on behalf of the client address
+// until the request is authenticated, the actor is never taken from the request.
+func (gw *HTTPGateway) correlate(c *gin.Context) {
+	correlationID := c.GetHeader(CorrelationIDHeader)
+	if correlationID == "" {
+		correlationID = newCorrelationID()
+	}
+	actor := AnonymousActor
+	if ip := c.ClientIP(); ip != "" {
+		actor = "ip:" + ip
+	}
+
+	ctx := events.WithCorrelationID(c.Request.Context(), correlationID)
+	c.Request = c.Request.WithContext(events.WithActor(ctx, actor))
+	c.Header(CorrelationIDHeader, correlationID)
+
+	c.Next()
+}
+
+// UnaryCorrelationInterceptor puts the correlation ID sent by the client or a new one into the call context
+// and sends it back in the response headers. The events are stored on behalf of the address of the peer
+// until the call is authenticated, the actor is never taken from the call metadata.
+func UnaryCorrelationInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	correlationID := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(CorrelationIDMetadataKey); len(values) > 0 {
+			correlationID = values[0]
+		}
+	}
+	if correlationID == "" {
+		correlationID = newCorrelationID()
+	}
+	actor := AnonymousActor
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		actor = "peer:" + p.Addr.String()
+	}
+
+	_ = grpc.SetHeader(ctx, metadata.Pairs(CorrelationIDMetadataKey, correlationID))
+
+	ctx = events.WithCorrelationID(ctx, correlationID)
+
+	return handler(events.WithActor(ctx, actor), req)
+}
+
+func newCorrelationID() string {
+	id, err := uuid.NewV4()
+	if err != nil {
+		// the events get their own correlation ID when the context has none
+		return ""
+	}
+
+	return id.String()
+}
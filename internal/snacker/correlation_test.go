@@ -0,0 +1,114 @@
+package snacker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/sveatlo/night_snack/internal/events"
+)
+
+func TestCorrelateHTTP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		authenticate bool
+		actor        string
+	}{
+		{"client address", nil, false, "ip:192.0.2.1"},
+		{"actor header ignored", map[string]string{"X-Actor": "user:admin"}, false, "ip:192.0.2.1"},
+		{"principal", map[string]string{"Authorization": "Bearer user-token"}, true, "user:alice"},
+		{"actor header of principal ignored", map[string]string{"Authorization": "Bearer user-token", "X-Actor": "user:admin"}, true, "user:alice"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gw := &HTTPGateway{log: zerolog.Nop()}
+			if test.authenticate {
+				gw.authenticator = testAuthenticator{}
+			}
+			stored := events.Metadata{}
+			router := gin.New()
+			router.Use(gw.correlate, gw.authenticate)
+			router.GET("/", func(c *gin.Context) {
+				stored = events.MetadataFromContext(c.Request.Context())
+				c.Status(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			if res.Code != http.StatusNoContent {
+				t.Fatalf("got %d, expected %d", res.Code, http.StatusNoContent)
+			}
+			if stored.Actor != test.actor {
+				t.Errorf("events stored on behalf of %q, expected %q", stored.Actor, test.actor)
+			}
+			if stored.CorrelationID == "" || res.Header().Get(CorrelationIDHeader) != stored.CorrelationID {
+				t.Errorf("correlation ID %q sent back as %q", stored.CorrelationID, res.Header().Get(CorrelationIDHeader))
+			}
+		})
+	}
+}
+
+func TestUnaryCorrelationInterceptor(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1234}
+
+	tests := []struct {
+		name         string
+		peer         bool
+		md           metadata.MD
+		authenticate bool
+		actor        string
+	}{
+		{"peer address", true, nil, false, "peer:192.0.2.1:1234"},
+		{"unknown peer", false, nil, false, AnonymousActor},
+		{"actor metadata ignored", true, metadata.Pairs("x-actor", "user:admin"), false, "peer:192.0.2.1:1234"},
+		{"principal", true, metadata.Pairs("authorization", "Bearer user-token", "x-actor", "user:admin"), true, "user:alice"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.peer {
+				ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+			}
+			if test.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, test.md)
+			}
+
+			actor := ""
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				actor = events.MetadataFromContext(ctx).Actor
+				return nil, nil
+			}
+			info := &grpc.UnaryServerInfo{FullMethod: "/snacker.Snacker/CreateOrder"}
+			if test.authenticate {
+				authenticated := handler
+				handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+					return UnaryAuthInterceptor(testAuthenticator{})(ctx, req, info, authenticated)
+				}
+			}
+
+			_, err := UnaryCorrelationInterceptor(ctx, nil, info, handler)
+			if err != nil {
+				t.Fatalf("call failed: %v", err)
+			}
+			if actor != test.actor {
+				t.Errorf("events stored on behalf of %q, expected %q", actor, test.actor)
+			}
+		})
+	}
+}
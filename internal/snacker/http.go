@@ -1,16 +1,31 @@
 package snacker
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	cadre_http "github.com/moderntv/cadre/http"
 	"github.com/moderntv/cadre/http/responses"
 	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
 	_ "google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/sveatlo/night_snack/internal/auth"
+	"github.com/sveatlo/night_snack/internal/customer"
+	"github.com/sveatlo/night_snack/internal/idempotency"
 	"github.com/sveatlo/night_snack/internal/orders"
+	"github.com/sveatlo/night_snack/internal/projection"
 	"github.com/sveatlo/night_snack/internal/restaurant"
 	"github.com/sveatlo/night_snack/internal/stock"
+	"github.com/sveatlo/night_snack/internal/stream"
+	customer_pb "github.com/sveatlo/night_snack/proto/customer"
 	orders_pb "github.com/sveatlo/night_snack/proto/orders"
+	projection_pb "github.com/sveatlo/night_snack/proto/projection"
 	restaurant_pb "github.com/sveatlo/night_snack/proto/restaurant"
 	stock_pb "github.com/sveatlo/night_snack/proto/stock"
 )
@@ -21,19 +36,34 @@ type HTTPGateway struct {
 	snackerSvc           *SnackerSvc
 	restaurantCommandSvc *restaurant.CommandService
 	restaurantQuerySvc   *restaurant.QueryService
+	customerSvc          *customer.Service
 	stockSvc             *stock.Service
 	ordersSvc            *orders.Service
+	ordersQuerySvc       *orders.QueryService
+	projections          *projection.Manager
+	projectionSvc        *projection.Service
+	streamSvc            *stream.Service
+	idempotencyKeys      *idempotency.Keys
+	// authenticator is nil when the authentication is disabled
+	authenticator auth.Authenticator
 }
 
-func NewHTTP(snackerSvc *SnackerSvc, restaurantCommandSvc *restaurant.CommandService, restaurantQuerySvc *restaurant.QueryService, stockSvc *stock.Service, ordersSvc *orders.Service, log zerolog.Logger) (g *HTTPGateway, err error) {
+func NewHTTP(snackerSvc *SnackerSvc, restaurantCommandSvc *restaurant.CommandService, restaurantQuerySvc *restaurant.QueryService, customerSvc *customer.Service, stockSvc *stock.Service, ordersSvc *orders.Service, ordersQuerySvc *orders.QueryService, projections *projection.Manager, projectionSvc *projection.Service, streamSvc *stream.Service, idempotencyKeys *idempotency.Keys, authenticator auth.Authenticator, log zerolog.Logger) (g *HTTPGateway, err error) {
 	g = &HTTPGateway{
 		log: log.With().Str("component", "http").Logger(),
 
 		snackerSvc:           snackerSvc,
 		restaurantCommandSvc: restaurantCommandSvc,
 		restaurantQuerySvc:   restaurantQuerySvc,
+		customerSvc:          customerSvc,
 		stockSvc:             stockSvc,
 		ordersSvc:            ordersSvc,
+		ordersQuerySvc:       ordersQuerySvc,
+		projections:          projections,
+		projectionSvc:        projectionSvc,
+		streamSvc:            streamSvc,
+		idempotencyKeys:      idempotencyKeys,
+		authenticator:        authenticator,
 	}
 
 	return
@@ -42,7 +72,7 @@ func NewHTTP(snackerSvc *SnackerSvc, restaurantCommandSvc *restaurant.CommandSer
 func (gw *HTTPGateway) GetRoutes() cadre_http.RoutingGroup {
 	return cadre_http.RoutingGroup{
 		Base:       "",
-		Middleware: []gin.HandlerFunc{},
+		Middleware: []gin.HandlerFunc{gw.correlate, gw.authenticate},
 		Routes:     map[string]map[string][]gin.HandlerFunc{},
 		Groups: []cadre_http.RoutingGroup{
 			{
@@ -60,6 +90,9 @@ func (gw *HTTPGateway) GetRoutes() cadre_http.RoutingGroup {
 						"PUT":    {gw.updateRestaurant},
 						"DELETE": {gw.deleteRestaurant},
 					},
+					"/:restaurant_id/as_of": {
+						"GET": {gw.getRestaurantAsOf},
+					},
 				},
 				Groups: []cadre_http.RoutingGroup{
 					{
@@ -84,16 +117,22 @@ func (gw *HTTPGateway) GetRoutes() cadre_http.RoutingGroup {
 										"PUT":    {gw.updateMenuItem},
 										"DELETE": {gw.deleteMenuItem},
 									},
+									"/:menu_item_id/price": {
+										"PUT": {gw.changeMenuItemPrice},
+									},
 								},
 								Groups: []cadre_http.RoutingGroup{
 									{
 										Base: "/:menu_item_id",
 										Routes: map[string]map[string][]gin.HandlerFunc{
 											"/stock/increase": {
-												"POST": {gw.increaseStock},
+												"POST": {gw.idempotent, gw.increaseStock},
 											},
 											"/stock/decrease": {
-												"POST": {gw.decreaseStock},
+												"POST": {gw.idempotent, gw.decreaseStock},
+											},
+											"/stock/as_of": {
+												"GET": {gw.getStockAsOf},
 											},
 										},
 									},
@@ -103,22 +142,137 @@ func (gw *HTTPGateway) GetRoutes() cadre_http.RoutingGroup {
 					},
 				},
 			},
+			{
+				Base:       "/customers",
+				Middleware: []gin.HandlerFunc{},
+				Routes: map[string]map[string][]gin.HandlerFunc{
+					"/": {
+						"GET":  {gw.getCustomers},
+						"POST": {gw.createCustomer},
+					},
+					"/:customer_id": {
+						"GET":    {gw.getCustomer},
+						"PUT":    {gw.updateCustomer},
+						"DELETE": {gw.deleteCustomer},
+					},
+				},
+				Groups: []cadre_http.RoutingGroup{
+					{
+						Base: "/:customer_id/addresses",
+						Routes: map[string]map[string][]gin.HandlerFunc{
+							"/": {
+								"POST": {gw.createAddress},
+							},
+							"/:address_id": {
+								"GET":    {gw.getAddress},
+								"PUT":    {gw.updateAddress},
+								"DELETE": {gw.deleteAddress},
+							},
+						},
+					},
+				},
+			},
 			{
 				Base:       "/orders",
 				Middleware: []gin.HandlerFunc{},
 				Routes: map[string]map[string][]gin.HandlerFunc{
 					"/": {
-						"POST": {gw.createOrder},
+						"GET":  {gw.getOrders},
+						"POST": {gw.idempotent, gw.createOrder},
 					},
 					":order_id": {
+						"GET": {gw.getOrder},
 						"PUT": {gw.updateOrderStatus},
 					},
+					":order_id/cancel": {
+						"POST": {gw.cancelOrder},
+					},
+					":order_id/as_of": {
+						"GET": {gw.getOrderAsOf},
+					},
+					":order_id/events": {
+						"GET": {gw.streamOrderEvents},
+					},
+					":order_id/events/ws": {
+						"GET": {gw.streamOrderEventsWebSocket},
+					},
+				},
+			},
+			{
+				Base:       "/projections",
+				Middleware: []gin.HandlerFunc{},
+				Routes: map[string]map[string][]gin.HandlerFunc{
+					"/": {
+						"GET": {gw.getProjections},
+					},
+					"/:projection_name/rebuild": {
+						"POST": {gw.rebuildProjection},
+					},
+					"/:projection_name/replay/:category": {
+						"POST": {gw.replayProjection},
+					},
+				},
+			},
+			{
+				Base:       "/dead_letters",
+				Middleware: []gin.HandlerFunc{},
+				Routes: map[string]map[string][]gin.HandlerFunc{
+					"/": {
+						"GET": {gw.getDeadLetters},
+					},
+					"/:dead_letter_id": {
+						"GET": {gw.getDeadLetter},
+					},
+					"/:dead_letter_id/redrive": {
+						"POST": {gw.redriveDeadLetter},
+					},
 				},
 			},
 		},
 	}
 }
 
+// respondError writes err as an HTTP error response based on its gRPC status code
+func (gw *HTTPGateway) respondError(c *gin.Context, err error) {
+	switch grpc_status.Code(err) {
+	case codes.Aborted:
+		responses.Conflict(c, responses.NewError(err))
+	case codes.NotFound:
+		responses.NotFound(c, responses.NewError(err))
+	case codes.FailedPrecondition:
+		responses.Conflict(c, responses.NewError(err))
+	case codes.InvalidArgument:
+		responses.BadRequest(c, responses.NewError(err))
+	default:
+		responses.InternalError(c, responses.NewError(err))
+	}
+}
+
+// asOfFromQuery parses the point in history from the timestamp (RFC 3339) and version query parameters
+func asOfFromQuery(c *gin.Context) (timestamp *timestamppb.Timestamp, version int32, err error) {
+	if t := c.Query("timestamp"); t != "" {
+		var at time.Time
+		at, err = time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			err = fmt.Errorf("invalid timestamp: %w", err)
+			return
+		}
+		timestamp = timestamppb.New(at)
+	}
+
+	if v := c.Query("version"); v != "" {
+		var n int64
+		n, err = strconv.ParseInt(v, 10, 32)
+		if err != nil || n < 0 {
+			err = fmt.Errorf("invalid version %q", v)
+			return
+		}
+		version = int32(n)
+	}
+
+	return
+}
+
 // getRestaurants
 // @Summary Gets restaurants
 // @Description Get all restaurants
@@ -129,7 +283,7 @@ func (gw *HTTPGateway) GetRoutes() cadre_http.RoutingGroup {
 func (gw *HTTPGateway) getRestaurants(c *gin.Context) {
 	restaurants, err := gw.restaurantQuerySvc.GetAll(c.Request.Context(), &restaurant_pb.GetRestaurants{})
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
 		return
 	}
 
@@ -148,7 +302,36 @@ func (gw *HTTPGateway) getRestaurant(c *gin.Context) {
 
 	restaurant, err := gw.restaurantQuerySvc.Get(c.Request.Context(), &restaurant_pb.GetRestaurant{Id: id})
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, restaurant)
+}
+
+// getRestaurantAsOf
+// @Summary Gets restaurant history
+// @Description Get the restaurant as it was at the given time and/or version, folded from its events
+// @ID restaurant_get_as_of
+// @Router /restaurant/{restaurant_id}/as_of [get]
+// @Param   timestamp query string false "Point in time (RFC 3339)"
+// @Param   version   query int    false "Aggregate version"
+// @Success 200      {object} responses.SuccessResponse{data=restaurant_pb.Restaurant}
+// @Failure 400,404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) getRestaurantAsOf(c *gin.Context) {
+	timestamp, version, err := asOfFromQuery(c)
+	if err != nil {
+		responses.BadRequest(c, responses.NewError(err))
+		return
+	}
+
+	restaurant, err := gw.restaurantQuerySvc.GetAsOf(c.Request.Context(), &restaurant_pb.GetRestaurantAsOf{
+		Id:        c.Param("restaurant_id"),
+		Timestamp: timestamp,
+		Version:   version,
+	})
+	if err != nil {
+		gw.respondError(c, err)
 		return
 	}
 
@@ -172,7 +355,7 @@ func (gw *HTTPGateway) createRestaurant(c *gin.Context) {
 
 	res, err := gw.restaurantCommandSvc.Create(c.Request.Context(), createRestaurantCmd)
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
 		return
 	}
 
@@ -200,7 +383,7 @@ func (gw *HTTPGateway) updateRestaurant(c *gin.Context) {
 
 	res, err := gw.restaurantCommandSvc.Update(c.Request.Context(), updateRestaurantCmd)
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
 		return
 	}
 
@@ -228,7 +411,7 @@ func (gw *HTTPGateway) deleteRestaurant(c *gin.Context) {
 
 	res, err := gw.restaurantCommandSvc.Delete(c.Request.Context(), deleteRestaurantCmd)
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
 		return
 	}
 
@@ -256,7 +439,7 @@ func (gw *HTTPGateway) createMenuCategory(c *gin.Context) {
 
 	res, err := gw.restaurantCommandSvc.CreateMenuCategory(c.Request.Context(), createMenuCategoryCmd)
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
 		return
 	}
 
@@ -285,7 +468,7 @@ func (gw *HTTPGateway) updateMenuCategory(c *gin.Context) {
 
 	res, err := gw.restaurantCommandSvc.UpdateMenuCategory(c.Request.Context(), updateMenuCategoryCmd)
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
 		return
 	}
 
@@ -313,7 +496,7 @@ func (gw *HTTPGateway) deleteMenuCategory(c *gin.Context) {
 
 	res, err := gw.restaurantCommandSvc.DeleteMenuCategory(c.Request.Context(), deleteMenuCategoryCmd)
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
 		return
 	}
 
@@ -322,7 +505,7 @@ func (gw *HTTPGateway) deleteMenuCategory(c *gin.Context) {
 
 // createMenuItem
 // @Summary Create menu item
-// @Description Creates menu item in restaurant
+// @Description Creates menu item in restaurant. The price is in minor units of its ISO 4217 currency, e.g. cents, and defaults to zero in EUR.
 // @ID menu_item_create
 // @Router /restaurant/{restaurant_id}/menu_categories [post]
 // @Param   cmd body restaurant_pb.CmdMenuItemCreate true "Command data"
@@ -345,7 +528,7 @@ func (gw *HTTPGateway) createMenuItem(c *gin.Context) {
 
 	res, err := gw.restaurantCommandSvc.CreateMenuItem(c.Request.Context(), createMenuItemCmd)
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
 		return
 	}
 
@@ -354,7 +537,7 @@ func (gw *HTTPGateway) createMenuItem(c *gin.Context) {
 
 // updateMenuItem
 // @Summary Update menu item
-// @Description Update menu item in restaurant
+// @Description Update menu item in restaurant. The price is changed separately.
 // @ID menu_item_update
 // @Router /restaurant/{restaurant_id}/menu_categories/{menu_item_id} [put]
 // @Param   cmd body restaurant_pb.CmdMenuItemUpdate true "Command data"
@@ -381,7 +564,35 @@ func (gw *HTTPGateway) updateMenuItem(c *gin.Context) {
 
 	res, err := gw.restaurantCommandSvc.UpdateMenuItem(c.Request.Context(), updateMenuItemCmd)
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res)
+}
+
+// changeMenuItemPrice
+// @Summary Change menu item price
+// @Description Change the price of the menu item. The price is in minor units of its ISO 4217 currency, e.g. cents.
+// @Description The already placed orders keep the prices at the time they were placed.
+// @ID menu_item_change_price
+// @Router /restaurant/{restaurant_id}/menu_categories/{menu_category_id}/items/{menu_item_id}/price [put]
+// @Param   cmd body restaurant_pb.CmdMenuItemChangePrice true "Command data"
+// @Success 200      {object} responses.SuccessResponse{data=restaurant_pb.MenuItemPriceChanged}
+// @Failure 400,404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) changeMenuItemPrice(c *gin.Context) {
+	changePriceCmd := &restaurant_pb.CmdMenuItemChangePrice{}
+	if err := c.Bind(&changePriceCmd); err != nil {
+		responses.BadRequest(c, responses.NewError(err))
+		return
+	}
+	changePriceCmd.RestaurantId = c.Param("restaurant_id")
+	changePriceCmd.CategoryId = c.Param("menu_category_id")
+	changePriceCmd.Id = c.Param("menu_item_id")
+
+	res, err := gw.restaurantCommandSvc.ChangeMenuItemPrice(c.Request.Context(), changePriceCmd)
+	if err != nil {
+		gw.respondError(c, err)
 		return
 	}
 
@@ -413,7 +624,7 @@ func (gw *HTTPGateway) deleteMenuItem(c *gin.Context) {
 
 	res, err := gw.restaurantCommandSvc.DeleteMenuItem(c.Request.Context(), deleteMenuItemCmd)
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
 		return
 	}
 
@@ -426,8 +637,9 @@ func (gw *HTTPGateway) deleteMenuItem(c *gin.Context) {
 // @ID stock_increase
 // @Router /restaurant/{restaurant_id}/menu_categories/{menu_item_id}/stock/increase [post]
 // @Param   cmd body stock_pb.CmdIncreaseStock true "Command data"
+// @Param   Idempotency-Key header string false "Key identifying repeated requests, their original response is replayed"
 // @Success 200      {object} responses.SuccessResponse{data=stock_pb.StockIncreased}
-// @Failure 400,500  {object} responses.ErrorResponse
+// @Failure 400,409,422,500  {object} responses.ErrorResponse
 func (gw *HTTPGateway) increaseStock(c *gin.Context) {
 	increaseStockCmd := &stock_pb.CmdIncreaseStock{}
 	if err := c.Bind(&increaseStockCmd); err != nil {
@@ -441,7 +653,7 @@ func (gw *HTTPGateway) increaseStock(c *gin.Context) {
 
 	res, err := gw.stockSvc.IncreaseStock(c.Request.Context(), increaseStockCmd)
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
 		return
 	}
 
@@ -454,8 +666,9 @@ func (gw *HTTPGateway) increaseStock(c *gin.Context) {
 // @ID stock_decrease
 // @Router /restaurant/{restaurant_id}/menu_categories/{menu_item_id}/stock/decrease [post]
 // @Param   cmd body stock_pb.CmdDecreaseStock true "Command data"
+// @Param   Idempotency-Key header string false "Key identifying repeated requests, their original response is replayed"
 // @Success 200      {object} responses.SuccessResponse{data=stock_pb.StockDecreased}
-// @Failure 400,500  {object} responses.ErrorResponse
+// @Failure 400,409,422,500  {object} responses.ErrorResponse
 func (gw *HTTPGateway) decreaseStock(c *gin.Context) {
 	decreaseStockCmd := &stock_pb.CmdDecreaseStock{}
 	if err := c.Bind(&decreaseStockCmd); err != nil {
@@ -469,7 +682,333 @@ func (gw *HTTPGateway) decreaseStock(c *gin.Context) {
 
 	res, err := gw.stockSvc.DecreaseStock(c.Request.Context(), decreaseStockCmd)
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res)
+}
+
+// getStockAsOf
+// @Summary Gets stock history
+// @Description Get the item stock as it was at the given time and/or version, folded from its events
+// @ID stock_get_as_of
+// @Router /restaurant/{restaurant_id}/menu_categories/{menu_category_id}/items/{menu_item_id}/stock/as_of [get]
+// @Param   timestamp query string false "Point in time (RFC 3339)"
+// @Param   version   query int    false "Aggregate version"
+// @Success 200      {object} responses.SuccessResponse{data=stock_pb.Stock}
+// @Failure 400,404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) getStockAsOf(c *gin.Context) {
+	timestamp, version, err := asOfFromQuery(c)
+	if err != nil {
+		responses.BadRequest(c, responses.NewError(err))
+		return
+	}
+
+	res, err := gw.stockSvc.GetAsOf(c.Request.Context(), &stock_pb.GetStockAsOf{
+		ItemId:    c.Param("menu_item_id"),
+		Timestamp: timestamp,
+		Version:   version,
+	})
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res)
+}
+
+// getOrders
+// @Summary Gets orders
+// @Description Get a page of the orders matching all the given filters. The next page is requested with the next_cursor of the previous page and the same sort.
+// @ID orders_get
+// @Router /orders/ [get]
+// @Param   restaurant_id query string false "Restaurant ID"
+// @Param   status        query []string false "Order statuses" collectionFormat(multi)
+// @Param   customer_id   query string false "Customer ID"
+// @Param   created_from  query string false "Created at or after (RFC 3339)"
+// @Param   created_until query string false "Created before (RFC 3339)"
+// @Param   sort          query string false "Sort" Enums(CREATED_AT_DESC, CREATED_AT_ASC, UPDATED_AT_DESC, UPDATED_AT_ASC)
+// @Param   limit         query int    false "Maximum number of orders, defaults to 50, at most 200"
+// @Param   cursor        query string false "Cursor of the next page"
+// @Success 200      {object} responses.SuccessResponse{data=orders_pb.Orders}
+// @Failure 400,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) getOrders(c *gin.Context) {
+	query, err := listOrdersFromQuery(c)
+	if err != nil {
+		responses.BadRequest(c, responses.NewError(err))
+		return
+	}
+
+	res, err := gw.ordersQuerySvc.List(c.Request.Context(), query)
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res)
+}
+
+// listOrdersFromQuery parses the orders filter, sort and page from the query parameters
+func listOrdersFromQuery(c *gin.Context) (query *orders_pb.ListOrders, err error) {
+	query = &orders_pb.ListOrders{
+		RestaurantId: c.Query("restaurant_id"),
+		CustomerId:   c.Query("customer_id"),
+		Cursor:       c.Query("cursor"),
+	}
+
+	for _, s := range c.QueryArray("status") {
+		status, ok := orders_pb.OrderStatus_value[s]
+		if !ok {
+			err = fmt.Errorf("invalid status %q", s)
+			return
+		}
+		query.Statuses = append(query.Statuses, orders_pb.OrderStatus(status))
+	}
+
+	if s := c.Query("sort"); s != "" {
+		sort, ok := orders_pb.OrderSort_value[s]
+		if !ok {
+			err = fmt.Errorf("invalid sort %q", s)
+			return
+		}
+		query.Sort = orders_pb.OrderSort(sort)
+	}
+
+	if l := c.Query("limit"); l != "" {
+		var n int64
+		n, err = strconv.ParseInt(l, 10, 32)
+		if err != nil || n < 0 {
+			err = fmt.Errorf("invalid limit %q", l)
+			return
+		}
+		query.Limit = int32(n)
+	}
+
+	for param, field := range map[string]**timestamppb.Timestamp{
+		"created_from":  &query.CreatedFrom,
+		"created_until": &query.CreatedUntil,
+	} {
+		t := c.Query(param)
+		if t == "" {
+			continue
+		}
+		var at time.Time
+		at, err = time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			err = fmt.Errorf("invalid %s: %w", param, err)
+			return
+		}
+		*field = timestamppb.New(at)
+	}
+
+	return
+}
+
+// getOrder
+// @Summary Gets order
+// @Description Get the order from the read model
+// @ID order_get
+// @Router /orders/{order_id} [get]
+// @Success 200      {object} responses.SuccessResponse{data=orders_pb.Order}
+// @Failure 404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) getOrder(c *gin.Context) {
+	res, err := gw.ordersQuerySvc.Get(c.Request.Context(), &orders_pb.GetOrder{
+		Id: c.Param("order_id"),
+	})
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res)
+}
+
+// getCustomers
+// @Summary Gets customers
+// @Description Get all customers along with their addresses
+// @ID customers_get
+// @Router /customers/ [get]
+// @Success 200      {object} responses.SuccessResponse{data=[]customer_pb.Customer}
+// @Failure 500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) getCustomers(c *gin.Context) {
+	customers, err := gw.customerSvc.GetAll(c.Request.Context(), &customer_pb.GetCustomers{})
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, customers.Customers)
+}
+
+// getCustomer
+// @Summary Gets customer
+// @Description Get the customer along with the addresses
+// @ID customer_get
+// @Router /customers/{customer_id} [get]
+// @Success 200      {object} responses.SuccessResponse{data=customer_pb.Customer}
+// @Failure 404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) getCustomer(c *gin.Context) {
+	customer, err := gw.customerSvc.Get(c.Request.Context(), &customer_pb.GetCustomer{Id: c.Param("customer_id")})
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, customer)
+}
+
+// createCustomer
+// @Summary Creates customer
+// @Description Create new customer
+// @ID customer_create
+// @Router /customers/ [post]
+// @Param   cmd body customer_pb.CmdCustomerCreate true "Command data"
+// @Success 200      {object} responses.SuccessResponse{data=customer_pb.Customer}
+// @Failure 400,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) createCustomer(c *gin.Context) {
+	createCustomerCmd := &customer_pb.CmdCustomerCreate{}
+	if err := c.Bind(&createCustomerCmd); err != nil {
+		responses.BadRequest(c, responses.NewError(err))
+		return
+	}
+
+	res, err := gw.customerSvc.Create(c.Request.Context(), createCustomerCmd)
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res)
+}
+
+// updateCustomer
+// @Summary Updates customer
+// @Description Update the name of an existing customer
+// @ID customer_update
+// @Router /customers/{customer_id} [put]
+// @Param   cmd body customer_pb.CmdCustomerUpdate true "Command data"
+// @Success 200      {object} responses.SuccessResponse{data=customer_pb.Customer}
+// @Failure 400,404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) updateCustomer(c *gin.Context) {
+	updateCustomerCmd := &customer_pb.CmdCustomerUpdate{}
+	if err := c.Bind(&updateCustomerCmd); err != nil {
+		responses.BadRequest(c, responses.NewError(err))
+		return
+	}
+	updateCustomerCmd.Id = c.Param("customer_id")
+
+	res, err := gw.customerSvc.Update(c.Request.Context(), updateCustomerCmd)
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res)
+}
+
+// deleteCustomer
+// @Summary Deletes customer
+// @Description Delete an existing customer along with the addresses, the orders keep the addresses they were delivered to
+// @ID customer_delete
+// @Router /customers/{customer_id} [delete]
+// @Success 200      {object} responses.SuccessResponse{data=customer_pb.CustomerDeleted}
+// @Failure 404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) deleteCustomer(c *gin.Context) {
+	res, err := gw.customerSvc.Delete(c.Request.Context(), &customer_pb.CmdCustomerDelete{Id: c.Param("customer_id")})
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res)
+}
+
+// getAddress
+// @Summary Gets address
+// @Description Get the delivery address of the customer
+// @ID address_get
+// @Router /customers/{customer_id}/addresses/{address_id} [get]
+// @Success 200      {object} responses.SuccessResponse{data=customer_pb.Address}
+// @Failure 404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) getAddress(c *gin.Context) {
+	address, err := gw.customerSvc.GetAddress(c.Request.Context(), &customer_pb.GetAddress{
+		Id:         c.Param("address_id"),
+		CustomerId: c.Param("customer_id"),
+	})
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, address)
+}
+
+// createAddress
+// @Summary Creates address
+// @Description Create new delivery address of the customer
+// @ID address_create
+// @Router /customers/{customer_id}/addresses/ [post]
+// @Param   cmd body customer_pb.CmdAddressCreate true "Command data"
+// @Success 200      {object} responses.SuccessResponse{data=customer_pb.Address}
+// @Failure 400,404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) createAddress(c *gin.Context) {
+	createAddressCmd := &customer_pb.CmdAddressCreate{}
+	if err := c.Bind(&createAddressCmd); err != nil {
+		responses.BadRequest(c, responses.NewError(err))
+		return
+	}
+	createAddressCmd.CustomerId = c.Param("customer_id")
+
+	res, err := gw.customerSvc.CreateAddress(c.Request.Context(), createAddressCmd)
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res)
+}
+
+// updateAddress
+// @Summary Updates address
+// @Description Update the delivery address of the customer, the already placed orders keep the previous address
+// @ID address_update
+// @Router /customers/{customer_id}/addresses/{address_id} [put]
+// @Param   cmd body customer_pb.CmdAddressUpdate true "Command data"
+// @Success 200      {object} responses.SuccessResponse{data=customer_pb.Address}
+// @Failure 400,404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) updateAddress(c *gin.Context) {
+	updateAddressCmd := &customer_pb.CmdAddressUpdate{}
+	if err := c.Bind(&updateAddressCmd); err != nil {
+		responses.BadRequest(c, responses.NewError(err))
+		return
+	}
+	updateAddressCmd.Id = c.Param("address_id")
+	updateAddressCmd.CustomerId = c.Param("customer_id")
+
+	res, err := gw.customerSvc.UpdateAddress(c.Request.Context(), updateAddressCmd)
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res)
+}
+
+// deleteAddress
+// @Summary Deletes address
+// @Description Delete the delivery address of the customer
+// @ID address_delete
+// @Router /customers/{customer_id}/addresses/{address_id} [delete]
+// @Success 200      {object} responses.SuccessResponse{data=customer_pb.AddressDeleted}
+// @Failure 404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) deleteAddress(c *gin.Context) {
+	res, err := gw.customerSvc.DeleteAddress(c.Request.Context(), &customer_pb.CmdAddressDelete{
+		Id:         c.Param("address_id"),
+		CustomerId: c.Param("customer_id"),
+	})
+	if err != nil {
+		gw.respondError(c, err)
 		return
 	}
 
@@ -478,12 +1017,16 @@ func (gw *HTTPGateway) decreaseStock(c *gin.Context) {
 
 // createOrder
 // @Summary Create order
-// @Description Creates new order
+// @Description Creates new order from its lines. The quantity of every line is reserved in the stock before the order is created.
+// @Description The items have to be on the current menu of the restaurant, the order keeps them with their names and prices at the time of ordering.
+// @Description The subtotal, tax, delivery fee and total are computed when the order is created, all the items have to be priced in the same currency.
+// @Description The delivery address has to be one of the addresses of the customer, the order keeps it as it is at the time of ordering.
 // @ID order_create
 // @Router /orders/ [post]
 // @Param   cmd body orders_pb.CmdCreateOrder true "Command data"
+// @Param   Idempotency-Key header string false "Key identifying repeated requests, their original response is replayed"
 // @Success 200      {object} responses.SuccessResponse{data=orders_pb.OrderCreated}
-// @Failure 400,500  {object} responses.ErrorResponse
+// @Failure 400,404,409,422,500  {object} responses.ErrorResponse
 func (gw *HTTPGateway) createOrder(c *gin.Context) {
 	createOrderCmd := &orders_pb.CmdCreateOrder{}
 	if err := c.Bind(&createOrderCmd); err != nil {
@@ -493,7 +1036,7 @@ func (gw *HTTPGateway) createOrder(c *gin.Context) {
 
 	res, err := gw.ordersSvc.Create(c.Request.Context(), createOrderCmd)
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
 		return
 	}
 
@@ -507,7 +1050,7 @@ func (gw *HTTPGateway) createOrder(c *gin.Context) {
 // @Router /orders/{order_id} [put]
 // @Param   cmd body orders_pb.CmdUpdateStatus true "Command data"
 // @Success 200      {object} responses.SuccessResponse{data=orders_pb.StatusUpdated}
-// @Failure 400,500  {object} responses.ErrorResponse
+// @Failure 400,404,409,500  {object} responses.ErrorResponse
 func (gw *HTTPGateway) updateOrderStatus(c *gin.Context) {
 	updateStatusCmd := &orders_pb.CmdUpdateStatus{}
 	if err := c.Bind(&updateStatusCmd); err != nil {
@@ -521,7 +1064,189 @@ func (gw *HTTPGateway) updateOrderStatus(c *gin.Context) {
 
 	res, err := gw.ordersSvc.UpdateStatus(c.Request.Context(), updateStatusCmd)
 	if err != nil {
-		responses.InternalError(c, responses.NewError(err))
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res)
+}
+
+// cancelOrder
+// @Summary Cancel order
+// @Description Cancels the order and returns its items to the stock. Orders can be cancelled until they are out for delivery.
+// @Description Cancelling an already cancelled order returns the original cancellation without returning the items again.
+// @ID order_cancel
+// @Router /orders/{order_id}/cancel [post]
+// @Param   cmd body orders_pb.CmdCancelOrder true "Command data"
+// @Success 200      {object} responses.SuccessResponse{data=orders_pb.OrderCancelled}
+// @Failure 400,404,409,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) cancelOrder(c *gin.Context) {
+	cancelCmd := &orders_pb.CmdCancelOrder{}
+	if err := c.Bind(&cancelCmd); err != nil {
+		responses.BadRequest(c, responses.NewError(err))
+		return
+	}
+	cancelCmd.Id = c.Param("order_id")
+
+	res, err := gw.ordersSvc.Cancel(c.Request.Context(), cancelCmd)
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res)
+}
+
+// getOrderAsOf
+// @Summary Gets order history
+// @Description Get the order as it was at the given time and/or version, folded from its events.
+// @Description The created_at of the order can be used to get the restaurant menu at the time the order was placed.
+// @ID order_get_as_of
+// @Router /orders/{order_id}/as_of [get]
+// @Param   timestamp query string false "Point in time (RFC 3339)"
+// @Param   version   query int    false "Aggregate version"
+// @Success 200      {object} responses.SuccessResponse{data=orders_pb.Order}
+// @Failure 400,404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) getOrderAsOf(c *gin.Context) {
+	timestamp, version, err := asOfFromQuery(c)
+	if err != nil {
+		responses.BadRequest(c, responses.NewError(err))
+		return
+	}
+
+	res, err := gw.ordersSvc.GetAsOf(c.Request.Context(), &orders_pb.GetOrderAsOf{
+		Id:        c.Param("order_id"),
+		Timestamp: timestamp,
+		Version:   version,
+	})
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res)
+}
+
+// getProjections
+// @Summary Gets projections
+// @Description Get the checkpoints of all read-model projections
+// @ID projections_get
+// @Router /projections/ [get]
+// @Success 200      {object} responses.SuccessResponse{data=[]projection.Checkpoint}
+// @Failure 400,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) getProjections(c *gin.Context) {
+	checkpoints, err := gw.projections.Checkpoints(c.Request.Context())
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, checkpoints)
+}
+
+// rebuildProjection
+// @Summary Rebuild projection
+// @Description Drop the read model of the projection and apply all the stored events again
+// @ID projection_rebuild
+// @Router /projections/{projection_name}/rebuild [post]
+// @Success 200      {object} responses.SuccessResponse{data=[]projection.Checkpoint}
+// @Failure 400,404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) rebuildProjection(c *gin.Context) {
+	name := c.Param("projection_name")
+
+	err := gw.projections.Rebuild(c.Request.Context(), name)
+	if errors.Is(err, projection.ErrUnknownProjection) {
+		responses.NotFound(c, responses.NewError(err))
+		return
+	}
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	gw.getProjections(c)
+}
+
+// replayProjection
+// @Summary Replay category into projection
+// @Description Apply the stored events of the category which the projection has not applied yet
+// @ID projection_replay
+// @Router /projections/{projection_name}/replay/{category} [post]
+// @Success 200      {object} responses.SuccessResponse{data=map[string]int}
+// @Failure 400,404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) replayProjection(c *gin.Context) {
+	name := c.Param("projection_name")
+	category := c.Param("category")
+
+	replayed, err := gw.projections.Replay(c.Request.Context(), name, category)
+	if errors.Is(err, projection.ErrUnknownProjection) {
+		responses.NotFound(c, responses.NewError(err))
+		return
+	}
+	if errors.Is(err, projection.ErrUnhandledCategory) {
+		responses.BadRequest(c, responses.NewError(err))
+		return
+	}
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, map[string]int{"replayed": replayed})
+}
+
+// getDeadLetters
+// @Summary Gets dead letters
+// @Description Get the events which could not be applied to the projections
+// @ID dead_letters_get
+// @Router /dead_letters/ [get]
+// @Param   projection query string false "Projection name"
+// @Success 200      {object} responses.SuccessResponse{data=[]projection_pb.DeadLetter}
+// @Failure 400,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) getDeadLetters(c *gin.Context) {
+	res, err := gw.projectionSvc.GetDeadLetters(c.Request.Context(), &projection_pb.GetDeadLetters{
+		Projection: c.Query("projection"),
+	})
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res.DeadLetters)
+}
+
+// getDeadLetter
+// @Summary Gets dead letter
+// @Description Get a single event which could not be applied to a projection
+// @ID dead_letter_get
+// @Router /dead_letters/{dead_letter_id} [get]
+// @Success 200      {object} responses.SuccessResponse{data=projection_pb.DeadLetter}
+// @Failure 400,404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) getDeadLetter(c *gin.Context) {
+	res, err := gw.projectionSvc.GetDeadLetter(c.Request.Context(), &projection_pb.GetDeadLetter{
+		Id: c.Param("dead_letter_id"),
+	})
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	responses.Ok(c, res)
+}
+
+// redriveDeadLetter
+// @Summary Redrive dead letter
+// @Description Apply the event of the dead letter to its projection again and remove the dead letter on success
+// @ID dead_letter_redrive
+// @Router /dead_letters/{dead_letter_id}/redrive [post]
+// @Success 200      {object} responses.SuccessResponse{data=projection_pb.DeadLetterRedriven}
+// @Failure 400,404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) redriveDeadLetter(c *gin.Context) {
+	res, err := gw.projectionSvc.RedriveDeadLetter(c.Request.Context(), &projection_pb.CmdRedriveDeadLetter{
+		Id: c.Param("dead_letter_id"),
+	})
+	if err != nil {
+		gw.respondError(c, err)
 		return
 	}
 
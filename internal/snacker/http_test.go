@@ -0,0 +1,42 @@
+package snacker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+
+	"github.com/sveatlo/night_snack/internal/repository"
+)
+
+func TestRespondError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"illegal transition", fmt.Errorf("illegal status transition: %w", repository.ErrFailedPrecondition), http.StatusConflict},
+		{"concurrency conflict", repository.ErrConcurrencyConflict, http.StatusConflict},
+		{"not found", repository.ErrNotFound, http.StatusNotFound},
+		{"unknown", errors.New("failed"), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gw := &HTTPGateway{log: zerolog.Nop()}
+			res := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(res)
+
+			gw.respondError(c, repository.StatusFromError(test.err))
+
+			if res.Code != test.code {
+				t.Errorf("got %d, expected %d", res.Code, test.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package snacker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moderntv/cadre/http/responses"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpc_status "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/sveatlo/night_snack/internal/auth"
+	"github.com/sveatlo/night_snack/internal/idempotency"
+)
+
+const (
+	// IdempotencyKeyHeader identifies the HTTP requests which are processed only once
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotencyKeyMetadataKey identifies the gRPC calls which are processed only once
+	IdempotencyKeyMetadataKey = "idempotency-key"
+	// IdempotentReplayedHeader is set on the responses replayed for a repeated request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// idempotent processes the requests with the same idempotency key and body only once and replays
+// the response of the first one to the others. The requests without the key are processed as usual.
+func (gw *HTTPGateway) idempotent(c *gin.Context) {
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if key == "" || gw.idempotencyKeys == nil {
+		c.Next()
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		responses.BadRequest(c, responses.NewError(err))
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	ctx := c.Request.Context()
+	scope := idempotencyScope(ctx, c.Request.Method+" "+c.FullPath())
+	fingerprint := idempotency.Fingerprint([]byte(c.Request.URL.Path), body)
+	reservation, completed, err := gw.idempotencyKeys.Begin(ctx, scope, key, fingerprint)
+	switch {
+	case errors.Is(err, idempotency.ErrKeyReused):
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, responses.ErrorResponse{
+			Message: "The idempotency key was used for a different request",
+			Errors:  []responses.Error{responses.NewError(err)},
+		})
+		return
+	case errors.Is(err, idempotency.ErrInProgress):
+		responses.Conflict(c, responses.NewError(err))
+		return
+	case err != nil:
+		responses.InternalError(c, responses.NewError(err))
+		return
+	case completed != nil:
+		c.Header(IdempotentReplayedHeader, "true")
+		c.Data(completed.StatusCode, "application/json; charset=utf-8", completed.Response)
+		c.Abort()
+		return
+	}
+
+	w := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+
+	// the failed requests can be retried with the same key
+	if w.Status() < 200 || w.Status() >= 300 {
+		err = gw.idempotencyKeys.Release(ctx, reservation)
+	} else {
+		err = gw.idempotencyKeys.Complete(ctx, reservation, w.Status(), w.body.Bytes())
+	}
+	if err != nil {
+		gw.log.Error().Err(err).Str("key", key).Msg("cannot finish request with idempotency key")
+	}
+}
+
+// recordingWriter keeps a copy of the response body
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// UnaryIdempotencyInterceptor processes the calls of the methods with the same idempotency key and request only once
+// and returns the response of the first call to the others. The calls without the key are processed as usual.
+func UnaryIdempotencyInterceptor(keys *idempotency.Keys, log zerolog.Logger, methods ...string) grpc.UnaryServerInterceptor {
+	log = log.With().Str("component", "grpc/idempotency").Logger()
+	idempotentMethods := map[string]bool{}
+	for _, method := range methods {
+		idempotentMethods[method] = true
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res interface{}, err error) {
+		if !idempotentMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		key := ""
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(IdempotencyKeyMetadataKey); len(values) > 0 {
+				key = values[0]
+			}
+		}
+		msg, ok := req.(proto.Message)
+		if key == "" || !ok {
+			return handler(ctx, req)
+		}
+
+		encoded, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+		if err != nil {
+			return
+		}
+		scope := idempotencyScope(ctx, info.FullMethod)
+		reservation, completed, err := keys.Begin(ctx, scope, key, idempotency.Fingerprint(encoded))
+		switch {
+		case errors.Is(err, idempotency.ErrKeyReused):
+			err = grpc_status.Error(codes.InvalidArgument, err.Error())
+			return
+		case errors.Is(err, idempotency.ErrInProgress):
+			err = grpc_status.Error(codes.Aborted, err.Error())
+			return
+		case err != nil:
+			return
+		case completed != nil:
+			stored := &anypb.Any{}
+			err = proto.Unmarshal(completed.Response, stored)
+			if err != nil {
+				return
+			}
+			return stored.UnmarshalNew()
+		}
+
+		res, err = handler(ctx, req)
+		if err != nil {
+			// the failed calls can be retried with the same key
+			if releaseErr := keys.Release(ctx, reservation); releaseErr != nil {
+				log.Error().Err(releaseErr).Str("key", key).Msg("cannot release idempotency key")
+			}
+			return
+		}
+
+		// the call has succeeded, so its response is returned even if it cannot be stored
+		completeErr := complete(ctx, keys, reservation, res)
+		if completeErr != nil {
+			log.Error().Err(completeErr).Str("key", key).Msg("cannot store response of call with idempotency key")
+		}
+
+		return
+	}
+}
+
+// idempotencyScope separates the keys of the operation by the principals, so that the clients cannot replay each other's responses
+func idempotencyScope(ctx context.Context, operation string) string {
+	if principal := auth.PrincipalFromContext(ctx); principal != nil {
+		return principal.Actor() + " " + operation
+	}
+
+	return operation
+}
+
+// complete stores the response of the call so that it can be decoded without knowing its type
+func complete(ctx context.Context, keys *idempotency.Keys, reservation idempotency.Reservation, res interface{}) (err error) {
+	msg, ok := res.(proto.Message)
+	if !ok {
+		return fmt.Errorf("response of %s is not a protobuf message", reservation.Scope)
+	}
+	stored, err := anypb.New(msg)
+	if err != nil {
+		return
+	}
+	response, err := proto.Marshal(stored)
+	if err != nil {
+		return
+	}
+
+	return keys.Complete(ctx, reservation, int(codes.OK), response)
+}
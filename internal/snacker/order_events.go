@@ -0,0 +1,216 @@
+package snacker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/moderntv/cadre/http/responses"
+
+	"github.com/sveatlo/night_snack/internal/stream"
+	orders_pb "github.com/sveatlo/night_snack/proto/orders"
+)
+
+const (
+	// heartbeatInterval is the time between the heartbeats of the event streams
+	heartbeatInterval = 15 * time.Second
+	// writeTimeout limits writing a single WebSocket message
+	writeTimeout = 10 * time.Second
+)
+
+var upgrader = websocket.Upgrader{}
+
+// orderEvent is a pushed order event, the ID is its position in the event store
+type orderEvent struct {
+	ID    string      `json:"id"`
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+// streamOrderEvents
+// @Summary Stream order status
+// @Description Push the status updates of the order as Server-Sent Events. A comment is sent as a heartbeat every 15 seconds.
+// @Description The event IDs are positions in the event store, a reconnecting client gets the missed events after the one in the Last-Event-ID header.
+// @ID order_events
+// @Router /orders/{order_id}/events [get]
+// @Produce text/event-stream
+// @Param   Last-Event-ID header string false "ID of the last received event"
+// @Param   last_event_id query  string false "ID of the last received event, used when the header cannot be set"
+// @Success 200      {object} orders_pb.StatusUpdated
+// @Failure 400,404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) streamOrderEvents(c *gin.Context) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	orderEvents, listenErr, ok := gw.listenOrderEvents(ctx, c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(200)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case err = <-listenErr:
+			gw.logStreamEnd(c, err)
+			return
+		case event := <-orderEvents:
+			var data []byte
+			data, err = json.Marshal(event.Data)
+			if err != nil {
+				gw.log.Error().Err(err).Msg("cannot encode order event")
+				return
+			}
+			_, err = fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Event, data)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(c.Writer, ": heartbeat\n\n")
+		}
+		if err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// streamOrderEventsWebSocket
+// @Summary Stream order status over WebSocket
+// @Description Push the status updates of the order as JSON messages with the id, event and data fields. The server pings the client every 15 seconds.
+// @Description The IDs are positions in the event store, a reconnecting client gets the missed events after the one in last_event_id.
+// @ID order_events_ws
+// @Router /orders/{order_id}/events/ws [get]
+// @Param   last_event_id query  string false "ID of the last received event"
+// @Success 101
+// @Failure 400,404,500  {object} responses.ErrorResponse
+func (gw *HTTPGateway) streamOrderEventsWebSocket(c *gin.Context) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	orderEvents, listenErr, ok := gw.listenOrderEvents(ctx, c)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has already responded
+		gw.log.Debug().Err(err).Msg("websocket upgrade failed")
+		return
+	}
+	defer conn.Close()
+
+	// the client messages are discarded, reading is needed to process the pongs and the close
+	go func() {
+		defer cancel()
+
+		conn.SetReadDeadline(time.Now().Add(2 * heartbeatInterval))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * heartbeatInterval))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err = <-listenErr:
+			gw.logStreamEnd(c, err)
+			closeCode := websocket.CloseNormalClosure
+			if err != nil {
+				closeCode = websocket.CloseTryAgainLater
+			}
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, ""), time.Now().Add(writeTimeout))
+			return
+		case event := <-orderEvents:
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err = conn.WriteJSON(event)
+		case <-heartbeat.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// listenOrderEvents starts listening to the status updates of the order requested by c.
+// It responds with an error and returns false if the order doesn't exist or the request is invalid.
+// The listening stops when ctx is done.
+func (gw *HTTPGateway) listenOrderEvents(ctx context.Context, c *gin.Context) (orderEvents <-chan orderEvent, listenErr <-chan error, ok bool) {
+	orderID := c.Param("order_id")
+
+	fromPosition := int64(0)
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	if lastEventID != "" {
+		position, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || position < 0 {
+			responses.BadRequest(c, responses.NewError(fmt.Errorf("invalid last event ID %q", lastEventID)))
+			return
+		}
+		fromPosition = position + 1
+	}
+
+	_, err := gw.ordersSvc.GetAsOf(ctx, &orders_pb.GetOrderAsOf{Id: orderID})
+	if err != nil {
+		gw.respondError(c, err)
+		return
+	}
+
+	var (
+		eventsCh = make(chan orderEvent)
+		errCh    = make(chan error, 1)
+	)
+	go func() {
+		errCh <- gw.streamSvc.Listen(ctx, stream.Filter{
+			Categories:   []string{"order"},
+			Types:        []string{"statusupdated", "cancelled"},
+			AggregateID:  orderID,
+			FromPosition: fromPosition,
+		}, func(event stream.Event) error {
+			select {
+			case eventsCh <- orderEvent{
+				ID:    strconv.FormatInt(event.Position, 10),
+				Event: event.Event.EventType(),
+				Data:  event.Event.ToProto(),
+			}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	return eventsCh, errCh, true
+}
+
+func (gw *HTTPGateway) logStreamEnd(c *gin.Context, err error) {
+	if err != nil && !errors.Is(err, context.Canceled) {
+		gw.log.Warn().Err(err).Str("path", c.Request.URL.Path).Msg("event stream ended")
+	}
+}
@@ -13,72 +13,129 @@ var (
 	_ events.Event = &EventStockDecreased{}
 )
 
+func init() {
+	events.Register(
+		events.EventType{
+			Event:    &EventStockIncreased{},
+			Proto:    &stock_pb.StockIncreased{},
+			FromData: func(data bson.M) (events.Event, error) { return EventStockIncreasedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventStockIncreasedFromProto(msg.(*stock_pb.StockIncreased))
+			},
+		},
+		events.EventType{
+			Event:    &EventStockDecreased{},
+			Proto:    &stock_pb.StockDecreased{},
+			FromData: func(data bson.M) (events.Event, error) { return EventStockDecreasedFromData(data) },
+			FromProto: func(msg proto.Message) events.Event {
+				return EventStockDecreasedFromProto(msg.(*stock_pb.StockDecreased))
+			},
+		},
+	)
+}
+
 type EventStockIncreased struct {
+	events.Envelope `bson:"-" json:"-"`
+
 	ItemID string `bson:"item_id,omitempty" json:"item_id,omitempty"`
 	N      int32  `bson:"n,omitempty" json:"n,omitempty"`
+	// OperationID is set for the commands with an operation ID, which are applied only once
+	OperationID string `bson:"operation_id,omitempty" json:"operation_id,omitempty"`
 }
 
 func EventStockIncreasedFromProto(cmd *stock_pb.StockIncreased) *EventStockIncreased {
 	return &EventStockIncreased{
-		ItemID: cmd.GetItemId(),
-		N:      cmd.GetN(),
+		ItemID:      cmd.GetItemId(),
+		N:           cmd.GetN(),
+		OperationID: cmd.GetOperationId(),
 	}
 }
 
-func EventStockIncreasedFromData(data bson.M) *EventStockIncreased {
-	return &EventStockIncreased{
-		ItemID: data["item_id"].(string),
-		N:      data["n"].(int32),
+func EventStockIncreasedFromData(data bson.M) (e *EventStockIncreased, err error) {
+	r := events.NewDataReader(data)
+	e = &EventStockIncreased{
+		ItemID: r.String("item_id"),
+		N:      r.Int32("n"),
 	}
+	if r.Has("operation_id") {
+		e.OperationID = r.String("operation_id")
+	}
+	err = r.Err()
+
+	return
 }
 
 func (e *EventStockIncreased) EventCategory() string { return "stock" }
 func (e *EventStockIncreased) EventType() string     { return "increased" }
 func (e *EventStockIncreased) AggregateID() string   { return e.ItemID }
 func (e *EventStockIncreased) Data() bson.M {
-	return bson.M{
+	data := bson.M{
 		"item_id": e.ItemID,
 		"n":       e.N,
 	}
+	if e.OperationID != "" {
+		data["operation_id"] = e.OperationID
+	}
+
+	return data
 }
 func (e *EventStockIncreased) ToProto() proto.Message {
 	return &stock_pb.StockIncreased{
-		ItemId: e.ItemID,
-		N:      e.N,
+		ItemId:      e.ItemID,
+		N:           e.N,
+		OperationId: e.OperationID,
 	}
 }
 
 type EventStockDecreased struct {
+	events.Envelope `bson:"-" json:"-"`
+
 	ItemID string `bson:"item_id,omitempty" json:"item_id,omitempty"`
 	N      int32  `bson:"n,omitempty" json:"n,omitempty"`
+	// OperationID is set for the commands with an operation ID, which are applied only once
+	OperationID string `bson:"operation_id,omitempty" json:"operation_id,omitempty"`
 }
 
 func EventStockDecreasedFromProto(cmd *stock_pb.StockDecreased) *EventStockDecreased {
 	return &EventStockDecreased{
-		ItemID: cmd.GetItemId(),
-		N:      cmd.GetN(),
+		ItemID:      cmd.GetItemId(),
+		N:           cmd.GetN(),
+		OperationID: cmd.GetOperationId(),
 	}
 }
 
-func EventStockDecreasedFromData(data bson.M) *EventStockDecreased {
-	return &EventStockDecreased{
-		ItemID: data["item_id"].(string),
-		N:      data["n"].(int32),
+func EventStockDecreasedFromData(data bson.M) (e *EventStockDecreased, err error) {
+	r := events.NewDataReader(data)
+	e = &EventStockDecreased{
+		ItemID: r.String("item_id"),
+		N:      r.Int32("n"),
+	}
+	if r.Has("operation_id") {
+		e.OperationID = r.String("operation_id")
 	}
+	err = r.Err()
+
+	return
 }
 
 func (e *EventStockDecreased) EventCategory() string { return "stock" }
 func (e *EventStockDecreased) EventType() string     { return "decreased" }
 func (e *EventStockDecreased) AggregateID() string   { return e.ItemID }
 func (e *EventStockDecreased) Data() bson.M {
-	return bson.M{
+	data := bson.M{
 		"item_id": e.ItemID,
 		"n":       e.N,
 	}
+	if e.OperationID != "" {
+		data["operation_id"] = e.OperationID
+	}
+
+	return data
 }
 func (e *EventStockDecreased) ToProto() proto.Message {
 	return &stock_pb.StockDecreased{
-		ItemId: e.ItemID,
-		N:      e.N,
+		ItemId:      e.ItemID,
+		N:           e.N,
+		OperationId: e.OperationID,
 	}
 }
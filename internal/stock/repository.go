@@ -5,178 +5,252 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/outbox"
+	"github.com/sveatlo/night_snack/internal/projection"
 	"github.com/sveatlo/night_snack/internal/repository"
-	stock_pb "github.com/sveatlo/night_snack/proto/stock"
+	"github.com/sveatlo/night_snack/internal/transport"
 )
 
 type Repository struct {
 	*repository.Base
 
-	log              zerolog.Logger
-	eventsCollection *mongo.Collection
-	stockCollection  *mongo.Collection
+	log             zerolog.Logger
+	stockCollection *mongo.Collection
 }
 
-func NewRepository(nc *nats.EncodedConn, mongoDB *mongo.Database, log zerolog.Logger) (repo *Repository, err error) {
+func NewRepository(eventTransport transport.Transport, store events.Store, relay *outbox.Relay, snapshotEvery int, mongoDB *mongo.Database, projections *projection.Manager, log zerolog.Logger) (repo *Repository, err error) {
 	log = log.With().Str("component", "stock/repository").Logger()
-	base, err := repository.NewBase(nc, mongoDB, log)
+	base, err := repository.NewBase(eventTransport, store, log)
 	if err != nil {
 		return
 	}
+	base.EnableOutbox(relay)
+	base.EnableSnapshots(snapshotEvery)
 
 	repo = &Repository{
 		Base: base,
 
-		log:              log,
-		stockCollection:  mongoDB.Collection("stock"),
-		eventsCollection: mongoDB.Collection("events"),
+		log:             log,
+		stockCollection: mongoDB.Collection("stock"),
 	}
 
-	err = repo.stockCollection.Drop(context.Background())
+	err = projections.Register(projection.Projection{
+		Name:       "stock",
+		Categories: []string{"stock"},
+		Apply:      repo.handleEvent,
+		Reset:      repo.stockCollection.Drop,
+	})
 	if err != nil {
-		return
-	}
-
-	err = repo.loadFromEventsStore()
-	if err != nil {
-		err = fmt.Errorf("cannot retrieve events from event store: %w", err)
-		return
-	}
-
-	_, err = nc.Subscribe(repo.GetTopic(&EventStockIncreased{}), repo.handleEventStockIncreased)
-	if err != nil {
-		err = fmt.Errorf("cannot create subscription for EventStockIncreased: %w", err)
-		return
-	}
-	_, err = nc.Subscribe(repo.GetTopic(&EventStockDecreased{}), repo.handleEventStockDecreased)
-	if err != nil {
-		err = fmt.Errorf("cannot create subscription for EventStockDecreased: %w", err)
+		err = fmt.Errorf("cannot register stock projection: %w", err)
 		return
 	}
 
 	return
 }
 
-func (repo *Repository) SaveEvents(aggregateID string, aggregateEvents []events.Event, originalVersion int) (err error) {
-	return repo.Base.SaveEvents("stock", aggregateID, aggregateEvents, originalVersion)
+func (repo *Repository) SaveEvents(ctx context.Context, aggregateID string, aggregateEvents []events.Event, originalVersion int) (err error) {
+	return repo.Base.SaveEvents(ctx, "stock", aggregateID, aggregateEvents, originalVersion)
 }
 
 func (repo *Repository) LoadAggregate(id string) (aggregate events.AggregateDB, err error) {
 	return repo.Base.LoadAggregate("stock", id)
 }
 
-func (repo *Repository) IncreaseStock(ctx context.Context, itemID string, n int32) (event *EventStockIncreased, err error) {
-	aggregate, err := repo.LoadAggregate(itemID)
-	if err != nil {
-		return
-	}
+// IncreaseStock adds n to the item stock.
+// With an operation ID, the increase is stored only once and repeating it returns the stored event.
+func (repo *Repository) IncreaseStock(ctx context.Context, itemID string, n int32, operationID string) (event *EventStockIncreased, err error) {
+	ctx = withOperationID(ctx, operationID)
+	err = repository.RetryOnConflict(func() (err error) {
+		stock, aggregate, err := repo.loadStock(itemID)
+		if err != nil {
+			return
+		}
+		if applied := stock.Operation(operationID); applied != nil {
+			var ok bool
+			if event, ok = applied.(*EventStockIncreased); !ok {
+				err = fmt.Errorf("operation %s was applied as %s", operationID, applied.EventType())
+			}
+			return
+		}
 
-	event = &EventStockIncreased{
-		ItemID: itemID,
-		N:      n,
-	}
+		event = &EventStockIncreased{
+			ItemID:      itemID,
+			N:           n,
+			OperationID: operationID,
+		}
+
+		err = repo.SaveEvents(ctx, aggregate.ID, []events.Event{event}, aggregate.Version)
+		if err != nil {
+			return
+		}
+
+		stock.ApplyEvent(event)
+		repo.SnapshotIfDue("stock", itemID, aggregate.Version+1, aggregate.SnapshotVersion, stock)
 
-	err = repo.SaveEvents(aggregate.ID, []events.Event{event}, aggregate.Version)
-	if err != nil {
 		return
-	}
+	})
+
+	return
+}
+
+// DecreaseStock takes n from the item stock, failing if there is not enough in stock.
+// With an operation ID, the decrease is stored only once and repeating it returns the stored event.
+func (repo *Repository) DecreaseStock(ctx context.Context, itemID string, n int32, operationID string) (event *EventStockDecreased, err error) {
+	ctx = withOperationID(ctx, operationID)
+	err = repository.RetryOnConflict(func() (err error) {
+		// the check is done against the event stream, not the read model,
+		// so that the version it was made at is the one the event is saved at
+		stock, aggregate, err := repo.loadStock(itemID)
+		if err != nil {
+			return
+		}
+		if applied := stock.Operation(operationID); applied != nil {
+			var ok bool
+			if event, ok = applied.(*EventStockDecreased); !ok {
+				err = fmt.Errorf("operation %s was applied as %s", operationID, applied.EventType())
+			}
+			return
+		}
+		if stock.N < n {
+			err = fmt.Errorf("not enough in stock")
+			return
+		}
+
+		event = &EventStockDecreased{
+			ItemID:      itemID,
+			N:           n,
+			OperationID: operationID,
+		}
+
+		err = repo.SaveEvents(ctx, aggregate.ID, []events.Event{event}, aggregate.Version)
+		if err != nil {
+			return
+		}
+
+		stock.ApplyEvent(event)
+		repo.SnapshotIfDue("stock", itemID, aggregate.Version+1, aggregate.SnapshotVersion, stock)
 
-	err = repo.Publish(event)
-	if err != nil {
 		return
-	}
+	})
 
 	return
 }
 
-func (repo *Repository) DecreaseStock(ctx context.Context, itemID string, n int32) (event *EventStockDecreased, err error) {
-	aggregate, err := repo.LoadAggregate(itemID)
-	if err != nil {
+// ReleaseStock returns the quantity taken by the decrease with the operation ID back to the stock.
+// It returns nil if there is no such decrease, including the one which was already released.
+func (repo *Repository) ReleaseStock(ctx context.Context, itemID, operationID string) (event *EventStockIncreased, err error) {
+	if operationID == "" {
+		err = fmt.Errorf("operation ID of the decrease is required")
 		return
 	}
 
-	res := repo.stockCollection.FindOne(ctx, bson.M{"_id": itemID})
-	err = res.Err()
+	ctx = withOperationID(ctx, operationID+releaseSuffix)
+	err = repository.RetryOnConflict(func() (err error) {
+		// the decrease is removed from the folded stock once released,
+		// the release is saved at the version it was found at, so it is not saved twice
+		event = nil
+		stock, aggregate, err := repo.loadStock(itemID)
+		if err != nil {
+			return
+		}
+		applied := stock.Operation(operationID)
+		if applied == nil {
+			return
+		}
+		decrease, ok := applied.(*EventStockDecreased)
+		if !ok {
+			err = fmt.Errorf("operation %s is not a decrease: %s", operationID, applied.EventType())
+			return
+		}
+
+		event = &EventStockIncreased{
+			ItemID:      itemID,
+			N:           decrease.N,
+			OperationID: operationID + releaseSuffix,
+		}
+
+		err = repo.SaveEvents(ctx, aggregate.ID, []events.Event{event}, aggregate.Version)
+		if err != nil {
+			return
+		}
+
+		stock.ApplyEvent(event)
+		repo.SnapshotIfDue("stock", itemID, aggregate.Version+1, aggregate.SnapshotVersion, stock)
+
+		return
+	})
+
+	return
+}
+
+// Snapshot takes a snapshot of the item stock regardless of the number of events since the last one
+func (repo *Repository) Snapshot(ctx context.Context, itemID string) (err error) {
+	stock, aggregate, err := repo.loadStock(itemID)
 	if err != nil {
 		return
 	}
-	stock := &Stock{}
-	res.Decode(stock)
-	if stock.N < n {
-		err = fmt.Errorf("not enough in stock")
+	if aggregate.Version == 0 {
+		err = fmt.Errorf("no such aggregate")
 		return
 	}
 
-	event = &EventStockDecreased{
-		ItemID: itemID,
-		N:      n,
-	}
+	return repo.SaveSnapshot("stock", itemID, aggregate.Version, stock)
+}
 
-	err = repo.SaveEvents(aggregate.ID, []events.Event{event}, aggregate.Version)
+// loadStock folds the item stock from its latest snapshot and the events which follow it
+func (repo *Repository) loadStock(itemID string) (stock *Stock, aggregate events.AggregateDB, err error) {
+	snapshot := &Stock{}
+	aggregate, err = repo.LoadAggregateSnapshot("stock", itemID, snapshot)
 	if err != nil {
 		return
 	}
 
-	err = repo.Publish(event)
+	aggregateEvents, err := repo.DecodeEvents(aggregate)
 	if err != nil {
 		return
 	}
+	stock = NewFromSnapshot(snapshot, aggregateEvents)
 
 	return
 }
 
-func (repo *Repository) loadFromEventsStore() (err error) {
-	var aggregates []events.AggregateDB
-	{
-		var cursor *mongo.Cursor
-		cursor, err = repo.eventsCollection.Find(context.Background(), bson.M{"category": "stock"})
-		if err != nil {
-			err = fmt.Errorf("cannot get events from store: %w", err)
-			return
-		}
-		cursor.All(context.Background(), &aggregates)
+// GetAsOf folds the item stock from its events up to the point given by asOf
+func (repo *Repository) GetAsOf(ctx context.Context, itemID string, asOf repository.AsOf) (stock *Stock, err error) {
+	aggregate, err := repo.LoadAggregateAsOf("stock", itemID, asOf)
+	if err != nil {
+		return
+	}
+	if aggregate.Version == 0 {
+		err = fmt.Errorf("stock of %s: %w", itemID, repository.ErrNotFound)
+		return
 	}
 
-	repo.log.Trace().Interface("events", aggregates).Msg("loaded events from store")
-
-	for _, aggregate := range aggregates {
-		for _, eventDB := range aggregate.Events {
-			var event events.Event
-
-			switch eventDB.Type {
-			case "increased":
-				event = EventStockIncreasedFromData(eventDB.Data)
-			case "decreased":
-				event = EventStockDecreasedFromData(eventDB.Data)
-			default:
-				err = fmt.Errorf("unknown event for stock: %v", eventDB.Type)
-				return
-			}
-
-			err = repo.applyEvent(event)
-			if err != nil {
-				break
-			}
-		}
+	aggregateEvents, err := repo.DecodeEvents(aggregate)
+	if err != nil {
+		return
 	}
+	stock = NewFromEvents(aggregateEvents)
 
 	return
 }
-func (repo *Repository) applyEvent(event events.Event) (err error) {
+
+func (repo *Repository) handleEvent(ctx context.Context, event events.Event, version int) error {
+	return repo.applyEvent(ctx, event, version)
+}
+
+func (repo *Repository) applyEvent(ctx context.Context, event events.Event, version int) (err error) {
 	repo.log.Trace().
 		Str("event", event.EventType()).
 		Str("id", event.AggregateID()).
 		Interface("data", event.Data()).
 		Msg("applying event")
 	defer func() {
-		if err != nil {
+		if err != nil && !errors.Is(err, projection.ErrAlreadyApplied) {
 			repo.log.Error().
 				Err(err).
 				Str("event", event.EventType()).
@@ -188,9 +262,9 @@ func (repo *Repository) applyEvent(event events.Event) (err error) {
 
 	switch e := event.(type) {
 	case *EventStockIncreased:
-		err = repo.applyEventStockIncreased(e)
+		err = repo.applyEventToStock(ctx, e.ItemID, version, e)
 	case *EventStockDecreased:
-		err = repo.applyEventStockDecreased(e)
+		err = repo.applyEventToStock(ctx, e.ItemID, version, e)
 
 	default:
 		err = errors.New("event not supported")
@@ -199,53 +273,23 @@ func (repo *Repository) applyEvent(event events.Event) (err error) {
 	return
 }
 
-func (repo *Repository) handleEventStockIncreased(eventPb *stock_pb.StockIncreased) error {
-	return repo.applyEventStockIncreased(EventStockIncreasedFromProto(eventPb))
-}
-
-func (repo *Repository) applyEventStockIncreased(event *EventStockIncreased) (err error) {
+func (repo *Repository) applyEventToStock(ctx context.Context, itemID string, version int, event events.Event) (err error) {
 	s := &Stock{}
-	res := repo.stockCollection.FindOne(context.Background(), bson.M{"_id": event.ItemID})
-	err = res.Err()
-	if err != nil && err != mongo.ErrNoDocuments {
+	err = repo.stockCollection.FindOne(ctx, bson.M{"_id": itemID}).Decode(s)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
 		return
-	} else if err == nil {
-		res.Decode(&s)
 	}
 
 	s.ApplyEvent(event)
 
-	_, err = repo.stockCollection.UpdateOne(context.Background(), bson.M{"_id": event.ItemID}, bson.M{"$set": s}, options.Update().SetUpsert(true))
-	if err != nil {
-		return
-	}
-
-	return
-}
-
-func (repo *Repository) handleEventStockDecreased(eventPb *stock_pb.StockDecreased) error {
-	return repo.applyEventStockDecreased(EventStockDecreasedFromProto(eventPb))
+	return projection.Save(ctx, repo.stockCollection, itemID, version, s)
 }
 
-func (repo *Repository) applyEventStockDecreased(event *EventStockDecreased) (err error) {
-	s := &Stock{}
-	res := repo.stockCollection.FindOne(context.Background(), bson.M{"_id": event.ItemID})
-	err = res.Err()
-	if err != nil && err != mongo.ErrNoDocuments {
-		return
-	} else if err == nil {
-		res.Decode(&s)
-	}
-
-	s.ApplyEvent(event)
-
-	_, err = repo.stockCollection.UpdateOne(context.Background(), bson.M{"_id": event.ItemID}, bson.M{"$set": s}, options.Update().SetUpsert(true))
-	if err != nil {
-		return
-	}
-	if err != nil {
-		return
+// withOperationID makes the events stored within ctx caused by the operation
+func withOperationID(ctx context.Context, operationID string) context.Context {
+	if operationID == "" {
+		return ctx
 	}
 
-	return
+	return events.WithCausationID(ctx, operationID)
 }
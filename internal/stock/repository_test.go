@@ -0,0 +1,163 @@
+package stock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/repository"
+)
+
+func newTestRepository(t *testing.T) (*Repository, events.Store) {
+	store := events.NewMemoryStore()
+	base, err := repository.NewBase(nil, store, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("cannot create repository: %v", err)
+	}
+	base.EnableSnapshots(1)
+
+	return &Repository{Base: base, log: zerolog.Nop()}, store
+}
+
+func TestOperationsAppliedOnce(t *testing.T) {
+	repo, store := newTestRepository(t)
+	ctx := context.Background()
+
+	_, err := repo.IncreaseStock(ctx, "item", 5, "")
+	if err != nil {
+		t.Fatalf("cannot increase stock: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		event, err := repo.DecreaseStock(ctx, "item", 2, "order/reserve/0")
+		if err != nil {
+			t.Fatalf("cannot decrease stock: %v", err)
+		}
+		if event.N != 2 || event.OperationID != "order/reserve/0" {
+			t.Errorf("decrease returned %+v, expected the stored one", event)
+		}
+		// the operations are found in the snapshot, not only in the events which follow it
+		err = repo.Snapshot(ctx, "item")
+		if err != nil {
+			t.Fatalf("cannot snapshot stock: %v", err)
+		}
+	}
+	event, err := repo.ReleaseStock(ctx, "item", "order/reserve/0")
+	if err != nil {
+		t.Fatalf("cannot release stock: %v", err)
+	}
+	if event == nil || event.N != 2 {
+		t.Errorf("release returned %+v, expected an increase by 2", event)
+	}
+
+	for _, operationID := range []string{"order/reserve/0", "order/reserve/1"} {
+		event, err = repo.ReleaseStock(ctx, "item", operationID)
+		if err != nil || event != nil {
+			t.Errorf("release of %s returned %+v, %v, expected nothing", operationID, event, err)
+		}
+	}
+
+	aggregate, err := store.Load(ctx, "stock", "item")
+	if err != nil {
+		t.Fatalf("cannot load aggregate: %v", err)
+	}
+	if aggregate.Version != 3 {
+		t.Errorf("stored %d events, expected 3", aggregate.Version)
+	}
+	stock, _, err := repo.loadStock("item")
+	if err != nil {
+		t.Fatalf("cannot load stock: %v", err)
+	}
+	if stock.N != 5 {
+		t.Errorf("stock is %d, expected 5", stock.N)
+	}
+	if len(stock.Operations) != 0 {
+		t.Errorf("released decrease is kept in operations %v", stock.Operations)
+	}
+}
+
+func TestOperationKindMismatch(t *testing.T) {
+	repo, _ := newTestRepository(t)
+	ctx := context.Background()
+
+	_, err := repo.IncreaseStock(ctx, "item", 5, "restock/1")
+	if err != nil {
+		t.Fatalf("cannot increase stock: %v", err)
+	}
+
+	_, err = repo.DecreaseStock(ctx, "item", 1, "restock/1")
+	if err == nil {
+		t.Error("decrease with the operation ID of an increase succeeded")
+	}
+	_, err = repo.ReleaseStock(ctx, "item", "restock/1")
+	if err == nil {
+		t.Error("release of an increase succeeded")
+	}
+}
+
+func TestReadModelDoesNotTrackOperations(t *testing.T) {
+	s := &Stock{}
+	s.ApplyEvent(&EventStockDecreased{ItemID: "item", N: 1, OperationID: "order/reserve/0"})
+
+	if s.Operations != nil {
+		t.Errorf("read model tracks operations %v", s.Operations)
+	}
+}
+
+func TestOperationsPruned(t *testing.T) {
+	at := func(event events.Event, t time.Time) events.Event {
+		event.SetMetadata(events.Metadata{OccurredAt: t})
+		return event
+	}
+	start := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		events     []events.Event
+		operations []string
+	}{
+		{
+			"released decrease",
+			[]events.Event{
+				at(&EventStockDecreased{ItemID: "item", N: 1, OperationID: "a/reserve/0"}, start),
+				at(&EventStockDecreased{ItemID: "item", N: 1, OperationID: "b/reserve/0"}, start),
+				at(&EventStockIncreased{ItemID: "item", N: 1, OperationID: "a/reserve/0" + releaseSuffix}, start),
+			},
+			[]string{"b/reserve/0"},
+		},
+		{
+			// only decreases are released, the other operations are kept
+			"release of an increase",
+			[]events.Event{
+				at(&EventStockIncreased{ItemID: "item", N: 1, OperationID: "restock"}, start),
+				at(&EventStockIncreased{ItemID: "item", N: 1, OperationID: "restock" + releaseSuffix}, start),
+			},
+			[]string{"restock", "restock" + releaseSuffix},
+		},
+		{
+			"expired",
+			[]events.Event{
+				at(&EventStockDecreased{ItemID: "item", N: 1, OperationID: "a/reserve/0"}, start),
+				at(&EventStockDecreased{ItemID: "item", N: 1, OperationID: "b/reserve/0"}, start.Add(time.Hour)),
+				at(&EventStockDecreased{ItemID: "item", N: 1, OperationID: "c/reserve/0"}, start.Add(OperationRetention+time.Minute)),
+			},
+			[]string{"b/reserve/0", "c/reserve/0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stock := NewFromEvents(test.events)
+
+			if len(stock.Operations) != len(test.operations) {
+				t.Errorf("got operations %v, expected %v", stock.Operations, test.operations)
+			}
+			for _, operationID := range test.operations {
+				if stock.Operation(operationID) == nil {
+					t.Errorf("operation %s is missing in %v", operationID, stock.Operations)
+				}
+			}
+		})
+	}
+}
@@ -6,10 +6,14 @@ import (
 
 	"github.com/moderntv/cadre/metrics"
 	"github.com/moderntv/cadre/status"
-	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
 	"go.mongodb.org/mongo-driver/mongo"
 
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/outbox"
+	"github.com/sveatlo/night_snack/internal/projection"
+	"github.com/sveatlo/night_snack/internal/repository"
+	"github.com/sveatlo/night_snack/internal/transport"
 	stock_pb "github.com/sveatlo/night_snack/proto/stock"
 )
 
@@ -22,13 +26,13 @@ type Service struct {
 	repo *Repository
 }
 
-func NewService(nec *nats.EncodedConn, mongo *mongo.Database, metricsRegistry *metrics.Registry, appStatus *status.Status, log zerolog.Logger) (c *Service, err error) {
+func NewService(eventTransport transport.Transport, store events.Store, relay *outbox.Relay, snapshotEvery int, mongo *mongo.Database, projections *projection.Manager, metricsRegistry *metrics.Registry, appStatus *status.Status, log zerolog.Logger) (c *Service, err error) {
 	cs, err := appStatus.Register("stock/command_svc")
 	if err != nil {
 		return
 	}
 
-	repo, err := NewRepository(nec, mongo, log)
+	repo, err := NewRepository(eventTransport, store, relay, snapshotEvery, mongo, projections, log)
 	if err != nil {
 		err = fmt.Errorf("cannot create stock repository: %w", err)
 	}
@@ -46,9 +50,9 @@ func NewService(nec *nats.EncodedConn, mongo *mongo.Database, metricsRegistry *m
 func (s *Service) Close() {}
 
 func (s *Service) IncreaseStock(ctx context.Context, cmd *stock_pb.CmdIncreaseStock) (res *stock_pb.StockIncreased, err error) {
-	event, err := s.repo.IncreaseStock(ctx, cmd.GetItemId(), cmd.GetN())
+	event, err := s.repo.IncreaseStock(ctx, cmd.GetItemId(), cmd.GetN(), cmd.GetOperationId())
 	if err != nil {
-		err = fmt.Errorf("incrase failed: %w", err)
+		err = repository.StatusFromError(fmt.Errorf("incrase failed: %w", err))
 		return
 	}
 
@@ -58,10 +62,10 @@ func (s *Service) IncreaseStock(ctx context.Context, cmd *stock_pb.CmdIncreaseSt
 }
 
 func (s *Service) DecreaseStock(ctx context.Context, cmd *stock_pb.CmdDecreaseStock) (res *stock_pb.StockDecreased, err error) {
-	event, err := s.repo.DecreaseStock(ctx, cmd.GetItemId(), cmd.GetN())
+	event, err := s.repo.DecreaseStock(ctx, cmd.GetItemId(), cmd.GetN(), cmd.GetOperationId())
 	s.log.Debug().Interface("event", event).Err(err).Msg("check")
 	if err != nil {
-		err = fmt.Errorf("decrease failed: %w", err)
+		err = repository.StatusFromError(fmt.Errorf("decrease failed: %w", err))
 		return
 	}
 
@@ -69,3 +73,44 @@ func (s *Service) DecreaseStock(ctx context.Context, cmd *stock_pb.CmdDecreaseSt
 
 	return
 }
+
+// ReleaseStock returns the quantity taken by the decrease with the operation ID back to the stock.
+// Nothing is returned if there was no such decrease, which is reported as an increase by 0.
+func (s *Service) ReleaseStock(ctx context.Context, cmd *stock_pb.CmdReleaseStock) (res *stock_pb.StockIncreased, err error) {
+	event, err := s.repo.ReleaseStock(ctx, cmd.GetItemId(), cmd.GetOperationId())
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("release failed: %w", err))
+		return
+	}
+	if event == nil {
+		res = &stock_pb.StockIncreased{ItemId: cmd.GetItemId()}
+		return
+	}
+
+	res = event.ToProto().(*stock_pb.StockIncreased)
+
+	return
+}
+
+// GetAsOf returns the item stock as it was at the given time and/or version
+func (s *Service) GetAsOf(ctx context.Context, cmd *stock_pb.GetStockAsOf) (res *stock_pb.Stock, err error) {
+	stock, err := s.repo.GetAsOf(ctx, cmd.GetItemId(), repository.NewAsOf(cmd.GetTimestamp(), cmd.GetVersion()))
+	if err != nil {
+		err = repository.StatusFromError(fmt.Errorf("stock history query failed: %w", err))
+		return
+	}
+
+	res = stock.ToProto()
+
+	return
+}
+
+// Snapshot takes an on-demand snapshot of the stock aggregate
+func (s *Service) Snapshot(ctx context.Context, itemID string) (err error) {
+	err = s.repo.Snapshot(ctx, itemID)
+	if err != nil {
+		err = fmt.Errorf("snapshot failed: %w", err)
+	}
+
+	return
+}
@@ -1,23 +1,58 @@
 package stock
 
 import (
+	"strings"
+	"time"
+
 	"github.com/sveatlo/night_snack/internal/events"
+	stock_pb "github.com/sveatlo/night_snack/proto/stock"
 )
 
+// OperationRetention is the time the operations are kept in the folded stock for, counted from the latest one.
+// The commands repeated later are not recognized and are applied again and the decreases are not released anymore.
+const OperationRetention = 7 * 24 * time.Hour
+
+// releaseSuffix is appended to the operation ID of a decrease to get the ID of its release
+const releaseSuffix = "/release"
+
 type Stock struct {
 	ItemID string `bson:"_id,omitempty" json:"item_id,omitempty"`
 	N      int32  `bson:"n" json:"n"`
+	// Operations are the changes made by the commands with operation IDs, by the IDs.
+	// They are tracked only by the stock folded from the events, so that they are kept in the snapshots
+	// and the operations are found without reading the whole stream. The read model doesn't keep them.
+	// A released decrease is removed together with its release and the rest after OperationRetention,
+	// so that the snapshots don't grow with every operation ever applied.
+	Operations map[string]Operation `bson:"operations,omitempty" json:"-"`
+}
+
+// Operation is a change of the stock made by a command with an operation ID
+type Operation struct {
+	// Type is the type of the event stored by the command
+	Type string `bson:"type"`
+	N    int32  `bson:"n"`
+	// At is the time the event was stored at
+	At time.Time `bson:"at"`
 }
 
 func NewFromEvents(events []events.Event) (s *Stock) {
-	s = &Stock{
+	return NewFromSnapshot(&Stock{
 		ItemID: "",
 		N:      0,
+	}, events)
+}
+
+// NewFromSnapshot applies the events which follow the snapshot to its state
+func NewFromSnapshot(snapshot *Stock, events []events.Event) (s *Stock) {
+	s = snapshot
+	if s.Operations == nil {
+		s.Operations = map[string]Operation{}
 	}
 
 	for _, event := range events {
 		s.ApplyEvent(event)
 	}
+	s.pruneOperations()
 
 	return
 }
@@ -27,8 +62,77 @@ func (s *Stock) ApplyEvent(event events.Event) {
 	case *EventStockIncreased:
 		s.ItemID = e.ItemID
 		s.N += e.N
+		if !s.releaseOperation(e.OperationID) {
+			s.recordOperation(e.OperationID, e.EventType(), e.N, e.Metadata().OccurredAt)
+		}
 	case *EventStockDecreased:
 		s.ItemID = e.ItemID
 		s.N -= e.N
+		s.recordOperation(e.OperationID, e.EventType(), e.N, e.Metadata().OccurredAt)
+	}
+}
+
+// Operation returns the event stored by the command with the operation ID, nil if there is none
+func (s *Stock) Operation(operationID string) events.Event {
+	operation, ok := s.Operations[operationID]
+	if !ok {
+		return nil
+	}
+
+	switch operation.Type {
+	case (&EventStockIncreased{}).EventType():
+		return &EventStockIncreased{ItemID: s.ItemID, N: operation.N, OperationID: operationID}
+	case (&EventStockDecreased{}).EventType():
+		return &EventStockDecreased{ItemID: s.ItemID, N: operation.N, OperationID: operationID}
+	}
+
+	return nil
+}
+
+func (s *Stock) recordOperation(operationID, eventType string, n int32, at time.Time) {
+	if operationID == "" || s.Operations == nil {
+		return
+	}
+
+	s.Operations[operationID] = Operation{Type: eventType, N: n, At: at}
+}
+
+// releaseOperation removes the decrease released by the operation, it reports whether the operation was a release
+func (s *Stock) releaseOperation(operationID string) bool {
+	released := strings.TrimSuffix(operationID, releaseSuffix)
+	if released == operationID {
+		return false
+	}
+	operation, ok := s.Operations[released]
+	if !ok || operation.Type != (&EventStockDecreased{}).EventType() {
+		return false
+	}
+
+	delete(s.Operations, released)
+
+	return true
+}
+
+// pruneOperations removes the operations older than OperationRetention before the latest one.
+// The latest operation is used instead of the current time, so that the folded state depends only on the events.
+func (s *Stock) pruneOperations() {
+	var latest time.Time
+	for _, operation := range s.Operations {
+		if operation.At.After(latest) {
+			latest = operation.At
+		}
+	}
+
+	for operationID, operation := range s.Operations {
+		if operation.At.Before(latest.Add(-OperationRetention)) {
+			delete(s.Operations, operationID)
+		}
+	}
+}
+
+func (s *Stock) ToProto() *stock_pb.Stock {
+	return &stock_pb.Stock{
+		ItemId: s.ItemID,
+		N:      s.N,
 	}
 }
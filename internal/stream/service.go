@@ -0,0 +1,91 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/moderntv/cadre/metrics"
+	"github.com/moderntv/cadre/status"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
+
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/orders"
+	"github.com/sveatlo/night_snack/internal/restaurant"
+	"github.com/sveatlo/night_snack/internal/transport"
+	stream_pb "github.com/sveatlo/night_snack/proto/stream"
+)
+
+// Service streams the published events to external consumers
+type Service struct {
+	stream_pb.UnimplementedStreamServiceServer
+
+	log    zerolog.Logger
+	status *status.ComponentStatus
+
+	transport          transport.Transport
+	store              events.Store
+	registry           *events.Registry
+	restaurantQuerySvc *restaurant.QueryService
+	ordersSvc          *orders.Service
+}
+
+func NewService(eventTransport transport.Transport, store events.Store, restaurantQuerySvc *restaurant.QueryService, ordersSvc *orders.Service, metricsRegistry *metrics.Registry, appStatus *status.Status, log zerolog.Logger) (s *Service, err error) {
+	cs, err := appStatus.Register("stream/svc")
+	if err != nil {
+		return
+	}
+
+	s = &Service{
+		log:    log.With().Str("component", "stream/svc").Logger(),
+		status: cs,
+
+		transport:          eventTransport,
+		store:              store,
+		registry:           events.DefaultRegistry,
+		restaurantQuerySvc: restaurantQuerySvc,
+		ordersSvc:          ordersSvc,
+	}
+
+	return
+}
+
+func (s *Service) Close() {}
+
+func (s *Service) Subscribe(cmd *stream_pb.Subscribe, srv stream_pb.StreamService_SubscribeServer) (err error) {
+	filter := Filter{
+		Categories:   cmd.GetCategories(),
+		Types:        cmd.GetTypes(),
+		AggregateID:  cmd.GetAggregateId(),
+		RestaurantID: cmd.GetRestaurantId(),
+		FromPosition: cmd.GetFromPosition(),
+	}
+
+	err = s.Listen(srv.Context(), filter, func(event Event) (err error) {
+		msg, err := event.ToProto()
+		if err != nil {
+			return
+		}
+
+		return srv.Send(msg)
+	})
+	if err != nil {
+		err = statusFromError(fmt.Errorf("subscription failed: %w", err))
+		return
+	}
+
+	return
+}
+
+// statusFromError converts the stream errors to gRPC status errors
+func statusFromError(err error) error {
+	switch {
+	case errors.Is(err, ErrUnknownCategory), errors.Is(err, ErrUnknownEventType):
+		return grpc_status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrSlowConsumer):
+		return grpc_status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	return err
+}
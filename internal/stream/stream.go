@@ -0,0 +1,279 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sveatlo/night_snack/internal/events"
+	"github.com/sveatlo/night_snack/internal/orders"
+	"github.com/sveatlo/night_snack/internal/stock"
+	orders_pb "github.com/sveatlo/night_snack/proto/orders"
+	stream_pb "github.com/sveatlo/night_snack/proto/stream"
+)
+
+const (
+	// bufferSize is the number of live events a subscriber can fall behind by before it is disconnected
+	bufferSize = 256
+)
+
+var (
+	// ErrUnknownCategory is returned when subscribing to a category without any registered event types
+	ErrUnknownCategory = errors.New("unknown category")
+	// ErrUnknownEventType is returned when subscribing to event types not registered for the categories
+	ErrUnknownEventType = errors.New("unknown event type")
+	// ErrSlowConsumer is returned when the subscriber doesn't keep up with the live events.
+	// It can resume from the position following the last received event.
+	ErrSlowConsumer = errors.New("subscriber fell behind the live events")
+)
+
+// Filter selects the events delivered to a subscriber
+type Filter struct {
+	// Categories limits the events to the given categories, all the registered ones are used when empty
+	Categories []string
+	// Types limits the events to the given event types of the categories, e.g. statusupdated
+	Types []string
+	// AggregateID limits the events to a single aggregate
+	AggregateID string
+	// RestaurantID limits the events to the ones of the restaurant, its menu items' stock and its orders
+	RestaurantID string
+	// FromPosition replays the stored events with this or a higher position before the live ones,
+	// 0 delivers only the live events
+	FromPosition int64
+}
+
+// Event is a published event along with its place in the event store
+type Event struct {
+	Position int64
+	Version  int
+	Event    events.Event
+}
+
+func (e *Event) ToProto() (msg *stream_pb.Event, err error) {
+	data, err := anypb.New(e.Event.ToProto())
+	if err != nil {
+		err = fmt.Errorf("cannot encode event data: %w", err)
+		return
+	}
+
+	metadata := e.Event.Metadata()
+	msg = &stream_pb.Event{
+		Position:      e.Position,
+		Category:      e.Event.EventCategory(),
+		Type:          e.Event.EventType(),
+		AggregateId:   e.Event.AggregateID(),
+		Version:       int32(e.Version),
+		EventId:       metadata.EventID,
+		OccurredAt:    timestamppb.New(metadata.OccurredAt),
+		CorrelationId: metadata.CorrelationID,
+		Data:          data,
+	}
+
+	return
+}
+
+// Listen calls fn for every event matching the filter until ctx is done or fn fails.
+// The live events are received from the NATS topics of the categories. When resuming,
+// the live events are buffered while the stored ones are replayed and the overlap is skipped by position.
+func (s *Service) Listen(ctx context.Context, filter Filter, fn func(Event) error) (err error) {
+	categories := filter.Categories
+	if len(categories) == 0 {
+		categories = s.registry.Categories()
+	}
+	categorySet := map[string]bool{}
+	for _, category := range categories {
+		if len(s.registry.Types(category)) == 0 {
+			err = fmt.Errorf("%w: %s", ErrUnknownCategory, category)
+			return
+		}
+		categorySet[category] = true
+	}
+	subjects, err := s.subjects(categorySet, filter.Types)
+	if err != nil {
+		return
+	}
+
+	l := &listener{
+		Service:     s,
+		filter:      filter,
+		fn:          fn,
+		restaurants: map[string]string{},
+	}
+
+	var (
+		live         = make(chan *nats.Msg, bufferSize)
+		overflow     = make(chan struct{})
+		overflowOnce sync.Once
+	)
+	for subject := range subjects {
+		var unsubscribe func() error
+		unsubscribe, err = s.transport.Listen(subject, func(msg *nats.Msg) {
+			select {
+			case live <- msg:
+			default:
+				overflowOnce.Do(func() { close(overflow) })
+			}
+		})
+		if err != nil {
+			return
+		}
+		defer unsubscribe()
+	}
+
+	var last int64
+	if filter.FromPosition > 0 {
+		err = s.store.ReadAll(ctx, filter.FromPosition-1, func(eventDB events.EventDB) (err error) {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			if !subjects[eventDB.Category+".*"] && !subjects[eventDB.Category+"."+eventDB.Type] {
+				return
+			}
+
+			event, err := s.registry.Decode(eventDB)
+			if err != nil {
+				return
+			}
+			last = eventDB.Position
+
+			return l.deliver(ctx, Event{Position: eventDB.Position, Version: eventDB.Version, Event: event})
+		})
+		if errors.Is(err, context.Canceled) {
+			err = nil
+			return
+		}
+		if err != nil {
+			err = fmt.Errorf("cannot replay stored events: %w", err)
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-overflow:
+			err = ErrSlowConsumer
+			return
+		case msg := <-live:
+			position, version := events.PositionFromHeader(msg.Header)
+			if position != 0 && position <= last {
+				continue
+			}
+
+			event, decodeErr := s.registry.DecodeMessage(msg)
+			if decodeErr != nil {
+				s.log.Error().Err(decodeErr).Str("subject", msg.Subject).Msg("cannot decode published event")
+				continue
+			}
+			if position > last {
+				last = position
+			}
+
+			err = l.deliver(ctx, Event{Position: position, Version: version, Event: event})
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// subjects returns the NATS topics of the event types within the categories, all the types are used when empty
+func (s *Service) subjects(categories map[string]bool, types []string) (subjects map[string]bool, err error) {
+	subjects = map[string]bool{}
+	for category := range categories {
+		if len(types) == 0 {
+			subjects[category+".*"] = true
+			continue
+		}
+
+		found := false
+		for _, name := range types {
+			eventType, lookupErr := s.registry.Lookup(category, name)
+			if lookupErr != nil {
+				continue
+			}
+			subjects[eventType.Topic()] = true
+			found = true
+		}
+		if !found {
+			err = fmt.Errorf("%w: none of %v in %s", ErrUnknownEventType, types, category)
+			return
+		}
+	}
+
+	return
+}
+
+// listener holds the state of a single subscription
+type listener struct {
+	*Service
+
+	filter Filter
+	fn     func(Event) error
+
+	// restaurants caches the restaurant IDs of the aggregates which are not restaurants
+	restaurants map[string]string
+}
+
+func (l *listener) deliver(ctx context.Context, event Event) (err error) {
+	if l.filter.AggregateID != "" && event.Event.AggregateID() != l.filter.AggregateID {
+		return
+	}
+	if l.filter.RestaurantID != "" {
+		var restaurantID string
+		restaurantID, err = l.restaurantOf(ctx, event.Event)
+		if err != nil {
+			return
+		}
+		if restaurantID != l.filter.RestaurantID {
+			return
+		}
+	}
+
+	return l.fn(event)
+}
+
+// restaurantOf returns the ID of the restaurant the event belongs to, empty if it cannot be found
+func (l *listener) restaurantOf(ctx context.Context, event events.Event) (restaurantID string, err error) {
+	key := event.EventCategory() + "/" + event.AggregateID()
+	if restaurantID, ok := l.restaurants[key]; ok {
+		return restaurantID, nil
+	}
+
+	switch e := event.(type) {
+	case *orders.EventOrderCreated:
+		if e.Restaurant != nil {
+			restaurantID = e.Restaurant.ID
+		}
+	case *orders.EventStatusUpdated, *orders.EventOrderCancelled:
+		var order *orders_pb.Order
+		order, err = l.ordersSvc.GetAsOf(ctx, &orders_pb.GetOrderAsOf{Id: e.AggregateID()})
+		if err != nil {
+			err = fmt.Errorf("cannot find restaurant of order %s: %w", e.AggregateID(), err)
+			return
+		}
+		restaurantID = order.GetRestaurant().GetId()
+	case *stock.EventStockIncreased, *stock.EventStockDecreased:
+		r, lookupErr := l.restaurantQuerySvc.GetByMenuItem(ctx, event.AggregateID())
+		if lookupErr != nil {
+			// the stock of an item which is not on any menu (yet) belongs to no restaurant
+			l.log.Debug().Err(lookupErr).Str("item_id", event.AggregateID()).Msg("cannot find restaurant of menu item")
+			return
+		}
+		restaurantID = r.GetId()
+	default:
+		if event.EventCategory() == "restaurant" {
+			return event.AggregateID(), nil
+		}
+	}
+
+	l.restaurants[key] = restaurantID
+
+	return
+}
@@ -0,0 +1,59 @@
+package transport
+
+import (
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+)
+
+var (
+	_ Transport = &Core{}
+)
+
+// Core is a transport using core NATS. The messages published while a subscriber
+// is disconnected are lost for it, so it is meant only for local development.
+type Core struct {
+	log zerolog.Logger
+	nc  *nats.Conn
+}
+
+func NewCore(nc *nats.Conn, log zerolog.Logger) (t *Core, err error) {
+	t = &Core{
+		log: log.With().Str("component", "transport/core").Logger(),
+		nc:  nc,
+	}
+
+	return
+}
+
+func (t *Core) Publish(msg *nats.Msg) error {
+	return t.nc.PublishMsg(msg)
+}
+
+func (t *Core) Listen(subject string, handler func(msg *nats.Msg)) (unsubscribe func() error, err error) {
+	return listen(t.nc, subject, handler)
+}
+
+// Subscribe ignores the durable name, failed messages are only logged
+func (t *Core) Subscribe(subject, durable string, handler Handler) (err error) {
+	_, err = t.nc.Subscribe(subject, func(msg *nats.Msg) {
+		if err := handler(msg); err != nil {
+			t.log.Error().Err(err).Str("subject", msg.Subject).Str("subscriber", durable).Msg("message handling failed")
+		}
+	})
+
+	return
+}
+
+// listen subscribes to subject with core NATS, the messages published to JetStream are delivered as well
+func listen(nc *nats.Conn, subject string, handler func(msg *nats.Msg)) (unsubscribe func() error, err error) {
+	sub, err := nc.Subscribe(subject, handler)
+	if err != nil {
+		err = fmt.Errorf("cannot subscribe to %s: %w", subject, err)
+		return
+	}
+
+	unsubscribe = sub.Unsubscribe
+	return
+}
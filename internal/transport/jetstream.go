@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+)
+
+var (
+	_ Transport = &JetStream{}
+)
+
+// JetStream is a transport keeping the messages in a JetStream stream per category.
+// The category is the first token of the subject, e.g. stock for stock.increased.
+//
+// Subscribers use durable consumers with explicit acks. A message is acknowledged when
+// its handler succeeds and redelivered otherwise, at most MaxDeliver times.
+// The consumers are shared by all the instances of a subscriber, each message is delivered to only one of them.
+type JetStream struct {
+	log    zerolog.Logger
+	nc     *nats.Conn
+	js     nats.JetStreamContext
+	config JetStreamConfig
+
+	mu      sync.Mutex
+	streams map[string]bool
+}
+
+func NewJetStream(nc *nats.Conn, config JetStreamConfig, log zerolog.Logger) (t *JetStream, err error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		err = fmt.Errorf("cannot create jetstream context: %w", err)
+		return
+	}
+
+	t = &JetStream{
+		log:    log.With().Str("component", "transport/jetstream").Logger(),
+		nc:     nc,
+		js:     js,
+		config: config,
+
+		streams: map[string]bool{},
+	}
+
+	return
+}
+
+// Publish waits until the message is stored in the stream.
+// Messages with an already stored Nats-Msg-Id header are deduplicated by the server.
+func (t *JetStream) Publish(msg *nats.Msg) (err error) {
+	stream, err := t.ensureStream(msg.Subject)
+	if err != nil {
+		return
+	}
+
+	_, err = t.js.PublishMsg(msg)
+	if err != nil {
+		err = fmt.Errorf("cannot publish to stream %s: %w", stream, err)
+		return
+	}
+
+	return
+}
+
+func (t *JetStream) Subscribe(subject, durable string, handler Handler) (err error) {
+	stream, err := t.ensureStream(subject)
+	if err != nil {
+		return
+	}
+
+	_, err = t.js.QueueSubscribe(
+		subject,
+		durable,
+		func(msg *nats.Msg) {
+			log := t.log.With().Str("subject", msg.Subject).Str("consumer", durable).Logger()
+
+			if err := handler(msg); err != nil {
+				log.Error().Err(err).Msg("message handling failed, requesting redelivery")
+				if err := msg.Nak(); err != nil {
+					log.Error().Err(err).Msg("cannot nak message")
+				}
+				return
+			}
+
+			if err := msg.Ack(); err != nil {
+				log.Error().Err(err).Msg("cannot ack message")
+			}
+		},
+		nats.BindStream(stream),
+		nats.Durable(durable),
+		nats.DeliverAll(),
+		nats.ManualAck(),
+		nats.AckExplicit(),
+		nats.AckWait(t.config.AckWait),
+		nats.MaxDeliver(t.config.MaxDeliver),
+	)
+	if err != nil {
+		err = fmt.Errorf("cannot subscribe %s to stream %s: %w", durable, stream, err)
+		return
+	}
+
+	return
+}
+
+// ensureStream creates the stream of the subject's category unless it already exists
+func (t *JetStream) ensureStream(subject string) (stream string, err error) {
+	category := strings.SplitN(subject, ".", 2)[0]
+	stream = fmt.Sprintf("events_%s", category)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.streams[stream] {
+		return
+	}
+
+	_, err = t.js.StreamInfo(stream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = t.js.AddStream(&nats.StreamConfig{
+			Name:     stream,
+			Subjects: []string{fmt.Sprintf("%s.*", category)},
+			Storage:  nats.FileStorage,
+			MaxAge:   t.config.MaxAge,
+			Replicas: t.config.Replicas,
+		})
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot create stream %s: %w", stream, err)
+		return
+	}
+
+	t.streams[stream] = true
+
+	return
+}
+
+// Listen doesn't create any consumer, the messages are delivered as they are stored in the stream
+func (t *JetStream) Listen(subject string, handler func(msg *nats.Msg)) (unsubscribe func() error, err error) {
+	return listen(t.nc, subject, handler)
+}
@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+)
+
+// Handler processes a single delivered message.
+// Returning an error asks for a redelivery if the transport supports it.
+type Handler func(msg *nats.Msg) error
+
+// Transport publishes the events and delivers them to the subscribers
+type Transport interface {
+	// Publish sends the message to its subject
+	Publish(msg *nats.Msg) error
+	// Subscribe delivers the messages published to subject to handler.
+	// The durable name identifies the subscriber across restarts;
+	// it must be unique for every subject and must not contain dots.
+	Subscribe(subject, durable string, handler Handler) error
+	// Listen delivers the messages published to subject from now on until unsubscribe is called.
+	// Nothing is acknowledged or redelivered, it is meant for short-lived subscribers such as client streams.
+	Listen(subject string, handler func(msg *nats.Msg)) (unsubscribe func() error, err error)
+}
+
+// JetStreamConfig configures the JetStream streams and consumers
+type JetStreamConfig struct {
+	// MaxDeliver is the maximum number of delivery attempts of a single message
+	MaxDeliver int
+	// AckWait is the time after which an unacknowledged message is redelivered
+	AckWait time.Duration
+	// MaxAge is the time the messages are kept in the streams, 0 keeps them forever
+	MaxAge time.Duration
+	// Replicas is the number of replicas of every stream
+	Replicas int
+}
+
+// New creates the transport of transportType, which is either jetstream or core
+func New(transportType string, nc *nats.Conn, config JetStreamConfig, log zerolog.Logger) (t Transport, err error) {
+	switch transportType {
+	case "jetstream":
+		t, err = NewJetStream(nc, config, log)
+	case "core":
+		t, err = NewCore(nc, log)
+	default:
+		err = fmt.Errorf("unknown transport type: %s", transportType)
+	}
+
+	return
+}